@@ -0,0 +1,358 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// FsyncPolicy picks how often the AOF is flushed to disk, trading durability for speed.
+type FsyncPolicy int
+
+const (
+	// FsyncEverySec flushes the AOF once a second, losing at most a second of writes on a crash.
+	FsyncEverySec FsyncPolicy = iota
+
+	// FsyncAlways flushes the AOF before every reply, so an acknowledged write is never lost.
+	FsyncAlways
+
+	// FsyncNo leaves flushing to the operating system.
+	FsyncNo
+)
+
+// String returns the name of the policy, as used by the appendfsync setting.
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncAlways:
+		return "always"
+	case FsyncNo:
+		return "no"
+	}
+
+	return "everysec"
+}
+
+// ParseFsyncPolicy returns the policy named name, false if there's no such policy.
+func ParseFsyncPolicy(name string) (FsyncPolicy, bool) {
+	for _, policy := range []FsyncPolicy{FsyncEverySec, FsyncAlways, FsyncNo} {
+		if policy.String() == name {
+			return policy, true
+		}
+	}
+
+	return 0, false
+}
+
+// appendOnlyFile logs every write command in RESP, to be replayed on startup.
+type appendOnlyFile struct {
+	file   *os.File
+	policy FsyncPolicy
+	logger *slog.Logger
+
+	// guards the file against being closed while a command is appended
+	mutex sync.Mutex
+
+	// done stops the routine flushing the file every second, which closes stopped once it has returned
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// openAppendOnlyFile opens the AOF at path for appending, creating it if needed.
+func openAppendOnlyFile(path string, policy FsyncPolicy, logger *slog.Logger) (*appendOnlyFile, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aof := &appendOnlyFile{
+		file:    file,
+		policy:  policy,
+		logger:  logger,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if policy == FsyncEverySec {
+		go aof.runFsyncRoutine()
+	} else {
+		close(aof.stopped)
+	}
+
+	return aof, nil
+}
+
+// runFsyncRoutine flushes the file to disk every second until the AOF is closed.
+func (aof *appendOnlyFile) runFsyncRoutine() {
+	defer close(aof.stopped)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-aof.done:
+			return
+		case <-ticker.C:
+			if err := aof.file.Sync(); err != nil {
+				aof.logger.Error("Failed to fsync the AOF", "error", err)
+			}
+		}
+	}
+}
+
+// write appends a command to the file.
+func (aof *appendOnlyFile) write(args []string) {
+	aof.mutex.Lock()
+	defer aof.mutex.Unlock()
+
+	if _, err := aof.file.WriteString(newBulkStringArray(args).ToString()); err != nil {
+		aof.logger.Error("Failed to append to the AOF", "error", err)
+		return
+	}
+
+	if aof.policy == FsyncAlways {
+		if err := aof.file.Sync(); err != nil {
+			aof.logger.Error("Failed to fsync the AOF", "error", err)
+		}
+	}
+}
+
+// close flushes the file to disk and closes it.
+func (aof *appendOnlyFile) close() error {
+	close(aof.done)
+	<-aof.stopped
+
+	aof.mutex.Lock()
+	defer aof.mutex.Unlock()
+
+	if err := aof.file.Sync(); err != nil {
+		aof.file.Close()
+		return err
+	}
+
+	return aof.file.Close()
+}
+
+// run runs a command handler, propagating the command to the AOF and the replicas
+// if it's a write which succeeded.
+func run(client *Client, command Command, handler CommandHandler, args []string, kv *store.KVStore) resp.Response {
+	instance := client.instance
+
+	if !writeCommands[command] {
+		return handler(client, args, kv)
+	}
+
+	// a blocking command can't hold every other write off while it waits,
+	// so it's only propagated once it's done, to any replica added meanwhile too
+	if unlockedCommands[command] {
+		response := handler(client, args, kv)
+
+		if !instance.propagating() {
+			return response
+		}
+
+		if propagated := propagatedCommand(command, args, response, kv); propagated != nil {
+			instance.writeMutex.Lock()
+			instance.propagate(propagated)
+			instance.writeMutex.Unlock()
+		}
+
+		return response
+	}
+
+	// a replica can't be added while the command runs, see HandlePSyncCommand
+	if !instance.propagating() {
+		return handler(client, args, kv)
+	}
+
+	instance.writeMutex.Lock()
+	defer instance.writeMutex.Unlock()
+
+	response := handler(client, args, kv)
+
+	if propagated := propagatedCommand(command, args, response, kv); propagated != nil {
+		instance.propagate(propagated)
+	}
+
+	return response
+}
+
+// propagating reports whether writes have anywhere to be propagated to.
+func (i *Instance) propagating() bool {
+	return i.aof != nil || i.replicas.count() > 0
+}
+
+// propagate appends a write to the AOF and sends it to the replicas.
+// the caller must hold the write mutex.
+func (i *Instance) propagate(args []string) {
+	if i.aof != nil {
+		i.aof.write(args)
+	}
+
+	i.replicas.feed(args)
+}
+
+// propagatedCommand returns the command to log for a write command which ran,
+// rewritten so that replaying it has the same effect later on, or nil if it had no effect.
+// kv is the store it ran against, for the commands whose effect can't be told from their arguments.
+func propagatedCommand(command Command, args []string, response resp.Response, kv *store.KVStore) []string {
+	if resp.IsError(response) {
+		return nil
+	}
+
+	switch command {
+	case ExpireCommand:
+		if response.(resp.Integer).Value == 0 {
+			return nil
+		}
+
+		return propagatedExpiry(kv, args[0])
+
+	case GetExCommand:
+		// only the TTL may have changed, and not at all if the key is missing
+		if _, isNull := response.(resp.NullBulkString); isNull {
+			return nil
+		}
+
+		getEx, _ := parseGetExArgs(args)
+
+		switch {
+		case getEx.persist:
+			return []string{PersistCommand, getEx.key}
+		case !getEx.expiry.IsZero() || getEx.ttl > 0:
+			return propagatedExpiry(kv, getEx.key)
+		}
+
+		return nil
+
+	case RestoreCommand:
+		restore, _ := parseRestoreArgs(args)
+
+		if restore.ttl == 0 || restore.absTTL {
+			break
+		}
+
+		propagated := append([]string{RestoreCommand, restore.key}, args[1:]...)
+		propagated[2] = strconv.FormatInt(restore.expiry().UnixMilli(), 10)
+
+		return append(propagated, "ABSTTL")
+
+	case MigrateCommand:
+		// the keys migrated away are deleted and propagated by the handler itself, see removeMigrated
+		return nil
+
+	case BLPopCommand, BRPopCommand:
+		popped, ok := response.(resp.Array)
+
+		if !ok || popped.Elements == nil {
+			return nil
+		}
+
+		key := popped.Elements[0].(resp.BulkString).Value
+
+		if command == BLPopCommand {
+			return []string{LPopCommand, key}
+		}
+
+		return []string{RPopCommand, key}
+	}
+
+	return append([]string{command}, args...)
+}
+
+// propagatedExpiry returns the command giving key the TTL it was just set: the time it expires at,
+// as a relative TTL would start over on replay and be jittered differently, or deleting the key
+// if it expired straight away.
+func propagatedExpiry(kv *store.KVStore, key string) []string {
+	expiry, exists := kv.Expiry(key)
+
+	if !exists {
+		return []string{DelCommand, key}
+	}
+
+	return []string{PExpireAtCommand, key, strconv.FormatInt(expiry.UnixMilli(), 10)}
+}
+
+// newReplayClient returns a client for replaying the AOF or applying the writes of the leader,
+// which isn't connected to anything.
+func newReplayClient(instance *Instance) *Client {
+	return &Client{
+		instance:  instance,
+		logger:    instance.logger,
+		writer:    bufio.NewWriter(io.Discard),
+		createdAt: time.Now(),
+		ctx:       context.Background(),
+		channels:  make(map[string]struct{}),
+		patterns:  make(map[string]struct{}),
+		watched:   make(map[string]uint64),
+	}
+}
+
+// replayAppendOnlyFile runs every command logged in the AOF at path against kv, through the
+// usual handlers. A command cut short by a crash at the end of the file is dropped,
+// truncating the file so new commands aren't appended to it.
+func (i *Instance) replayAppendOnlyFile(path string, kv *store.KVStore) error {
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	client := newReplayClient(i)
+	pos, count := 0, 0
+
+	for pos < len(data) {
+		args, n, err := resp.ParseRequest(data[pos:])
+
+		if err != nil {
+			return fmt.Errorf("bad command at offset %d: %w", pos, err)
+		}
+
+		if n == 0 {
+			i.logger.Warn("The AOF ends with an incomplete command, truncating it", "size", pos)
+
+			if err := os.Truncate(path, int64(pos)); err != nil {
+				return err
+			}
+
+			break
+		}
+
+		pos += n
+
+		if len(args) == 0 {
+			continue
+		}
+
+		command := strings.ToLower(args[0])
+		handler, exists := handlers[command]
+
+		if !exists {
+			return fmt.Errorf("unknown command '%s' at offset %d", args[0], pos-n)
+		}
+
+		handler(client, args[1:], kv)
+		count++
+	}
+
+	i.logger.Info("Replayed the AOF", "path", path, "commands", count)
+
+	return nil
+}
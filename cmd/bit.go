@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// maxBitOffset is the highest bit offset of a string, which Redis caps at 512MB
+const maxBitOffset = 1<<32 - 1
+
+// parseBitOffset parses the bit offset of SETBIT and GETBIT.
+func parseBitOffset(arg string) (int, bool) {
+	offset, err := strconv.Atoi(arg)
+
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		return 0, false
+	}
+
+	return offset, true
+}
+
+var HandleSetBitCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 3 {
+		return resp.NewError("wrong number of arguments for 'setbit' command")
+	}
+
+	offset, ok := parseBitOffset(args[1])
+
+	if !ok {
+		return resp.NewError("bit offset is not an integer or out of range")
+	}
+
+	if args[2] != "0" && args[2] != "1" {
+		return resp.NewError("bit is not an integer or out of range")
+	}
+
+	old, err := kv.SetBit(args[0], offset, int(args[2][0]-'0'))
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	client.instance.notify(notifyString, "setbit", args[0])
+
+	return resp.NewInteger(old)
+}
+
+var HandleGetBitCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'getbit' command")
+	}
+
+	offset, ok := parseBitOffset(args[1])
+
+	if !ok {
+		return resp.NewError("bit offset is not an integer or out of range")
+	}
+
+	bit, err := kv.GetBit(args[0], offset)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return resp.NewInteger(bit)
+}
+
+var HandleBitCountCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 1 {
+		return resp.NewError("wrong number of arguments for 'bitcount' command")
+	}
+
+	// the whole string, unless a range of bytes is given
+	start, stop := 0, -1
+
+	switch len(args) {
+	case 1:
+	case 3:
+		var startErr, stopErr error
+		start, startErr = strconv.Atoi(args[1])
+		stop, stopErr = strconv.Atoi(args[2])
+
+		if startErr != nil || stopErr != nil {
+			return resp.NewError("value is not an integer or out of range")
+		}
+	default:
+		return resp.NewError("syntax error")
+	}
+
+	count, err := kv.BitCount(args[0], start, stop)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return resp.NewInteger(count)
+}
+
+var HandleBitPosCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 2 || len(args) > 4 {
+		return resp.NewError("wrong number of arguments for 'bitpos' command")
+	}
+
+	if args[1] != "0" && args[1] != "1" {
+		return resp.NewError("The bit argument must be 1 or 0.")
+	}
+
+	// the whole string, unless a range of bytes is given
+	start, stop := 0, -1
+
+	var startErr, stopErr error
+
+	if len(args) >= 3 {
+		start, startErr = strconv.Atoi(args[2])
+	}
+
+	if len(args) == 4 {
+		stop, stopErr = strconv.Atoi(args[3])
+	}
+
+	if startErr != nil || stopErr != nil {
+		return resp.NewError("value is not an integer or out of range")
+	}
+
+	position, err := kv.BitPos(args[0], int(args[1][0]-'0'), start, stop, len(args) == 4)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return resp.NewInteger(position)
+}
+
+var HandleBitOpCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 3 {
+		return resp.NewError("wrong number of arguments for 'bitop' command")
+	}
+
+	dst, keys := args[1], args[2:]
+
+	var bitOp func(dst string, keys ...string) (int, error)
+
+	switch strings.ToLower(args[0]) {
+	case "and":
+		bitOp = kv.BitOpAnd
+	case "or":
+		bitOp = kv.BitOpOr
+	case "xor":
+		bitOp = kv.BitOpXor
+	case "not":
+		if len(keys) != 1 {
+			return resp.NewError("BITOP NOT must be called with a single source key.")
+		}
+
+		bitOp = func(dst string, keys ...string) (int, error) {
+			return kv.BitOpNot(dst, keys[0])
+		}
+	default:
+		return resp.NewError("syntax error")
+	}
+
+	existed := kv.Has(dst)
+	length, err := bitOp(dst, keys...)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// an empty result deletes the destination rather than storing an empty string
+	switch {
+	case length > 0:
+		client.instance.notify(notifyString, "set", dst)
+	case existed:
+		client.instance.notify(notifyGeneric, "del", dst)
+	}
+
+	return resp.NewInteger(length)
+}
@@ -0,0 +1,473 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+const (
+	// maxPushedBytes bounds the messages pushed to a client which are still waiting to be sent,
+	// past which the client is disconnected, like the pubsub class of Redis's client-output-buffer-limit.
+	maxPushedBytes = 32 * 1024 * 1024
+
+	// maxReplicaPushedBytes is maxPushedBytes for replicas, which are pushed every write,
+	// like the replica class of client-output-buffer-limit.
+	maxReplicaPushedBytes = 256 * 1024 * 1024
+)
+
+// Client holds the state of a single client connection.
+type Client struct {
+	conn     net.Conn
+	instance *Instance
+
+	// logs of the instance, with the client's address and id attached
+	logger *slog.Logger
+
+	// id is unique to the connection, assigned in order of connection
+	id        int64
+	createdAt time.Time
+
+	// name set by CLIENT SETNAME, read by other clients through CLIENT LIST
+	name      string
+	nameMutex sync.Mutex
+
+	// ctx is cancelled once the connection is closed,
+	// which releases any command still blocked on behalf of the client
+	ctx context.Context
+
+	// replies and pushed messages may be written from different goroutines,
+	// so every write goes through the mutex to keep frames whole
+	writeMutex sync.Mutex
+	writer     *bufio.Writer
+
+	// messages pushed to the client, like published messages, wait here to be sent by a goroutine
+	// of their own, so a client slow to read them doesn't hold up the one pushing them.
+	// pushedBytes counts them until they're written, pushing is set while the goroutine runs
+	// and pushClosed once the client is dropped for falling behind or the connection fails
+	pushMutex   sync.Mutex
+	pushed      []string
+	pushedBytes int
+	pushing     bool
+	pushClosed  bool
+
+	// channels and patterns the client is subscribed to
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	// transaction state between MULTI and EXEC/DISCARD
+	inMulti     bool
+	multiFailed bool
+	queued      []queuedCommand
+
+	// keys watched by WATCH, along with their version at the time
+	watched map[string]uint64
+
+	// executing is set while EXEC runs the queued commands
+	executing bool
+
+	// closeAfterReply is set by a command disconnecting its own client
+	closeAfterReply bool
+
+	// set while a command is being handled, while subscribed, in MONITOR mode and once
+	// synced as a replica, when the client is waiting on the server rather than idle.
+	// read by the connection's reader
+	handling    atomic.Bool
+	subscribed  atomic.Bool
+	monitoring  atomic.Bool
+	replicating atomic.Bool
+}
+
+// NewClient wraps an accepted connection. ctx must be cancelled once the connection is closed.
+func NewClient(ctx context.Context, conn net.Conn, instance *Instance) *Client {
+	client := &Client{
+		conn:      conn,
+		writer:    bufio.NewWriter(conn),
+		instance:  instance,
+		createdAt: time.Now(),
+		ctx:       ctx,
+		channels:  make(map[string]struct{}),
+		patterns:  make(map[string]struct{}),
+		watched:   make(map[string]uint64),
+	}
+
+	instance.register(client)
+	instance.totalConnections.Add(1)
+
+	client.logger = instance.logger.With("remote_addr", conn.RemoteAddr().String(), "client_id", client.id)
+
+	return client
+}
+
+// Logger returns the logger of the client, which adds the client's address and id to every record.
+func (c *Client) Logger() *slog.Logger {
+	return c.logger
+}
+
+// Write sends a response to the client straight away, along with any buffered reply.
+func (c *Client) Write(response resp.Response) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if _, err := c.writer.WriteString(response.ToString()); err != nil {
+		return err
+	}
+
+	return c.writer.Flush()
+}
+
+// Push queues a message for the client, sent in the background without waiting on the client
+// to read it. A client letting more than its pushLimit pile up is disconnected.
+func (c *Client) Push(response resp.Response) {
+	frame := response.ToString()
+
+	c.pushMutex.Lock()
+	defer c.pushMutex.Unlock()
+
+	if c.pushClosed {
+		return
+	}
+
+	if c.pushedBytes+len(frame) > c.pushLimit() {
+		c.logger.Warn("Closing connection, too many messages waiting to be sent", "pending_bytes", c.pushedBytes)
+
+		c.closePushes()
+		c.kill()
+		return
+	}
+
+	c.pushed = append(c.pushed, frame)
+	c.pushedBytes += len(frame)
+
+	if !c.pushing {
+		c.pushing = true
+		go c.sendPushed()
+	}
+}
+
+// pushLimit returns how many bytes of pushed messages may wait to be sent to the client.
+func (c *Client) pushLimit() int {
+	if c.replicating.Load() {
+		return maxReplicaPushedBytes
+	}
+
+	return maxPushedBytes
+}
+
+// sendPushed writes the pushed messages until there are none left.
+func (c *Client) sendPushed() {
+	for {
+		c.pushMutex.Lock()
+		frames := c.pushed
+		c.pushed = nil
+
+		if len(frames) == 0 || c.pushClosed {
+			c.pushing = false
+			c.pushMutex.Unlock()
+			return
+		}
+
+		c.pushMutex.Unlock()
+
+		written, err := c.writeFrames(frames)
+
+		c.pushMutex.Lock()
+		c.pushedBytes -= written
+
+		// the connection is gone, the messages left have nowhere to go
+		if err != nil {
+			c.closePushes()
+		}
+
+		c.pushMutex.Unlock()
+	}
+}
+
+// closePushes drops the pushed messages still waiting, and any pushed later.
+// pushMutex must be held.
+func (c *Client) closePushes() {
+	c.pushClosed = true
+	c.pushed = nil
+	c.pushedBytes = 0
+}
+
+// writeFrames sends frames along with any buffered reply, returning how many bytes of them it sent.
+func (c *Client) writeFrames(frames []string) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	written := 0
+
+	for _, frame := range frames {
+		if _, err := c.writer.WriteString(frame); err != nil {
+			return written, err
+		}
+
+		written += len(frame)
+	}
+
+	return written, c.writer.Flush()
+}
+
+// bufferReply queues the reply to a command until the next Flush,
+// so the replies to pipelined commands are sent together.
+func (c *Client) bufferReply(response resp.Response) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	_, err := c.writer.WriteString(response.ToString())
+	return err
+}
+
+// Flush sends the buffered replies.
+// It must be called once there are no more commands to handle right away.
+func (c *Client) Flush() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	return c.writer.Flush()
+}
+
+// Close releases the client state once the connection is gone.
+func (c *Client) Close(kv *store.KVStore) {
+	c.unwatchAll(kv)
+	c.unsubscribeAll()
+	c.stopMonitoring()
+	c.instance.replicas.remove(c)
+
+	c.instance.unregister(c)
+}
+
+// unsubscribeAll unsubscribes the client from every channel and pattern.
+func (c *Client) unsubscribeAll() {
+	for channel := range c.channels {
+		c.instance.broker.Unsubscribe(c, channel)
+	}
+
+	for pattern := range c.patterns {
+		c.instance.broker.PUnsubscribe(c, pattern)
+	}
+
+	clear(c.channels)
+	clear(c.patterns)
+	c.subscribed.Store(false)
+}
+
+// stopMonitoring takes the client out of MONITOR mode.
+func (c *Client) stopMonitoring() {
+	c.instance.monitors.remove(c)
+	c.monitoring.Store(false)
+}
+
+// reset returns the client to the state of a new connection, as if it had just connected.
+func (c *Client) reset(kv *store.KVStore) {
+	c.resetTransaction()
+	c.unwatchAll(kv)
+	c.unsubscribeAll()
+	c.stopMonitoring()
+	c.SetName("")
+}
+
+// kill closes the connection, which ends the client once its reader notices.
+func (c *Client) kill() {
+	c.conn.Close()
+}
+
+// Name returns the name set by CLIENT SETNAME, empty if none is.
+func (c *Client) Name() string {
+	c.nameMutex.Lock()
+	defer c.nameMutex.Unlock()
+
+	return c.name
+}
+
+// SetName names the client, an empty name removing it.
+func (c *Client) SetName(name string) {
+	c.nameMutex.Lock()
+	defer c.nameMutex.Unlock()
+
+	c.name = name
+}
+
+// Idle reports whether the client is neither waiting on a command, subscribed, monitoring
+// nor a replica, in which case it should be sending commands.
+func (c *Client) Idle() bool {
+	return !c.handling.Load() && !c.subscribed.Load() && !c.monitoring.Load() && !c.replicating.Load()
+}
+
+// addr returns the address the client is connected from.
+func (c *Client) addr() string {
+	return c.conn.RemoteAddr().String()
+}
+
+// subscriptionCount returns the number of channels and patterns the client is subscribed to.
+func (c *Client) subscriptionCount() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// listEntry describes the client in the format of CLIENT LIST.
+func (c *Client) listEntry() string {
+	return fmt.Sprintf("id=%d addr=%s name=%s age=%d",
+		c.id,
+		c.addr(),
+		c.Name(),
+		int(time.Since(c.createdAt).Seconds()),
+	)
+}
+
+var HandleClientCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 1 {
+		return resp.NewError("wrong number of arguments for 'client' command")
+	}
+
+	subcommand := strings.ToLower(args[0])
+
+	switch {
+	case subcommand == "id" && len(args) == 1:
+		return resp.NewInteger(int(client.id))
+
+	case subcommand == "getname" && len(args) == 1:
+		name := client.Name()
+
+		if name == "" {
+			return resp.NewNullBulkString()
+		}
+
+		return resp.NewBulkString(name)
+
+	case subcommand == "setname" && len(args) == 2:
+		name := args[1]
+
+		// names are listed space separated, one client per line
+		if strings.ContainsFunc(name, func(r rune) bool { return r <= ' ' || r > '~' }) {
+			return resp.NewError("Client names cannot contain spaces, newlines or special characters.")
+		}
+
+		client.SetName(name)
+		return resp.NewOKResponse()
+
+	case subcommand == "list" && len(args) == 1:
+		var list strings.Builder
+
+		for _, c := range client.instance.connectedClients() {
+			list.WriteString(c.listEntry() + "\n")
+		}
+
+		return resp.NewBulkString(list.String())
+
+	case subcommand == "kill" && len(args) == 2:
+		// the legacy form, killing the single client at an address
+		killed := killClients(client, clientFilter{addr: args[1]})
+
+		if killed == 0 {
+			return resp.NewError("No such client")
+		}
+
+		return resp.NewOKResponse()
+
+	case subcommand == "kill" && len(args) >= 3 && len(args[1:])%2 == 0:
+		filter, err := parseClientFilter(args[1:])
+
+		if err != nil {
+			return resp.NewError(err.Error())
+		}
+
+		return resp.NewInteger(killClients(client, filter))
+	}
+
+	return resp.NewError(
+		fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'", args[0]),
+	)
+}
+
+// clientFilter picks the clients killed by CLIENT KILL, an empty field matching any client.
+type clientFilter struct {
+	id     int64
+	addr   string
+	skipMe bool
+}
+
+func (f clientFilter) matches(self, c *Client) bool {
+	if f.skipMe && c == self {
+		return false
+	}
+
+	if f.id != 0 && c.id != f.id {
+		return false
+	}
+
+	if f.addr != "" && c.addr() != f.addr {
+		return false
+	}
+
+	return true
+}
+
+// parseClientFilter parses the filter/value pairs of CLIENT KILL.
+// the calling client is skipped unless SKIPME no is given.
+func parseClientFilter(args []string) (clientFilter, error) {
+	filter := clientFilter{skipMe: true}
+
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+
+		switch strings.ToLower(args[i]) {
+		case "id":
+			id, err := strconv.ParseInt(value, 10, 64)
+
+			if err != nil || id <= 0 {
+				return filter, errors.New("client-id should be greater than 0")
+			}
+
+			filter.id = id
+		case "addr":
+			filter.addr = value
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				return filter, errors.New("syntax error")
+			}
+		default:
+			return filter, errors.New("syntax error")
+		}
+	}
+
+	return filter, nil
+}
+
+// killClients disconnects every client matching filter and returns how many there were.
+// the calling client is only disconnected once it has been sent the reply.
+func killClients(self *Client, filter clientFilter) int {
+	killed := 0
+
+	for _, c := range self.instance.connectedClients() {
+		if !filter.matches(self, c) {
+			continue
+		}
+
+		if c == self {
+			self.closeAfterReply = true
+		} else {
+			c.kill()
+		}
+
+		killed++
+	}
+
+	return killed
+}
@@ -0,0 +1,2331 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// testTimeout bounds every wait of a test, so a hang fails it rather than the whole run.
+const testTimeout = 5 * time.Second
+
+// newTestInstance starts an instance saving nothing, along with the store it serves.
+func newTestInstance(t *testing.T, options ...store.Option) (*Instance, *store.KVStore) {
+	t.Helper()
+
+	kv := store.NewKVStore(options...)
+	t.Cleanup(kv.Close)
+
+	return startTestInstance(t, Persistence{}, kv), kv
+}
+
+// startTestInstance starts an instance serving kv, saving its data as set by persistence.
+// It's closed once the test is done.
+func startTestInstance(t *testing.T, persistence Persistence, kv *store.KVStore) *Instance {
+	t.Helper()
+
+	instance := NewInstance(persistence, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	if err := instance.Start(kv); err != nil {
+		t.Fatalf("failed to start the instance: %v", err)
+	}
+
+	t.Cleanup(func() { instance.Close() })
+
+	return instance
+}
+
+// testClient is a client of an instance connected through a pipe, the test holding the other end.
+type testClient struct {
+	t      *testing.T
+	client *Client
+	kv     *store.KVStore
+	peer   net.Conn
+	reader *bufio.Reader
+}
+
+func newTestClient(t *testing.T, instance *Instance, kv *store.KVStore) *testClient {
+	t.Helper()
+
+	conn, peer := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	client := NewClient(ctx, conn, instance)
+
+	// the client is left registered, as a command stuck on it would hold Close up
+	t.Cleanup(func() {
+		cancel()
+		conn.Close()
+		peer.Close()
+	})
+
+	return &testClient{t: t, client: client, kv: kv, peer: peer, reader: bufio.NewReader(peer)}
+}
+
+// send runs a command without reading its reply, which is only buffered.
+func (c *testClient) send(args ...string) {
+	c.t.Helper()
+
+	if err := HandleMessage(c.client, args, c.kv); err != nil {
+		c.t.Fatalf("%s: %v", args[0], err)
+	}
+}
+
+// do runs a command and reads back its reply, which must be a single frame.
+func (c *testClient) do(args ...string) resp.Response {
+	c.t.Helper()
+
+	c.send(args...)
+
+	return c.reply()
+}
+
+// reply sends the buffered reply and reads it back.
+func (c *testClient) reply() resp.Response {
+	c.t.Helper()
+
+	flushed := make(chan error, 1)
+
+	go func() {
+		flushed <- c.client.Flush()
+	}()
+
+	reply := c.read()
+
+	if err := <-flushed; err != nil {
+		c.t.Fatalf("failed to send a reply: %v", err)
+	}
+
+	return reply
+}
+
+// read reads the next frame sent to the client.
+func (c *testClient) read() resp.Response {
+	c.t.Helper()
+
+	c.peer.SetReadDeadline(time.Now().Add(testTimeout))
+
+	reply, err := resp.Parse(c.reader)
+
+	if err != nil {
+		c.t.Fatalf("failed to read a reply: %v", err)
+	}
+
+	return reply
+}
+
+// expect runs a command and checks its reply is want, in RESP.
+func (c *testClient) expect(want string, args ...string) {
+	c.t.Helper()
+
+	if got := c.do(args...).ToString(); got != want {
+		c.t.Errorf("%s: got %q, want %q", strings.Join(args, " "), got, want)
+	}
+}
+
+// expectClosed checks the connection is closed on the client's side, whatever is left to read.
+func (c *testClient) expectClosed() {
+	c.t.Helper()
+
+	c.peer.SetReadDeadline(time.Now().Add(testTimeout))
+
+	for {
+		_, err := c.reader.ReadByte()
+
+		if errors.Is(err, io.EOF) {
+			return
+		}
+
+		if err != nil {
+			c.t.Fatalf("expected the connection to be closed, got %v", err)
+		}
+	}
+}
+
+// bulk returns s as a RESP bulk string.
+func bulk(s string) string {
+	return resp.NewBulkString(s).ToString()
+}
+
+// bulks returns values as a RESP array of bulk strings.
+func bulks(values ...string) string {
+	return newBulkStringArray(values).ToString()
+}
+
+// nilBulk is the reply for a missing value.
+const nilBulk = "$-1\r\n"
+
+// within fails the test unless fn returns before testTimeout. fn runs on a goroutine of its own,
+// so it must not fail the test itself.
+func within(t *testing.T, what string, fn func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatalf("%s didn't return in time", what)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	instance, kv := newTestInstance(t)
+
+	subscriber := newTestClient(t, instance, kv)
+	publisher := newTestClient(t, instance, kv)
+
+	subscriber.expect("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n", "SUBSCRIBE", "news")
+	subscriber.expect("*3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:2\r\n", "PSUBSCRIBE", "n*")
+
+	publisher.expect(":2\r\n", "PUBLISH", "news", "hello")
+
+	want := []string{
+		"*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n",
+		"*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$5\r\nhello\r\n",
+	}
+
+	for _, frame := range want {
+		if got := subscriber.read().ToString(); got != frame {
+			t.Errorf("got %q, want %q", got, frame)
+		}
+	}
+
+	publisher.expect(":0\r\n", "PUBLISH", "other", "hello")
+}
+
+func TestPublishToStalledSubscriber(t *testing.T) {
+	instance, kv := newTestInstance(t)
+
+	// the subscriber never reads, not even the reply to SUBSCRIBE
+	stalled := newTestClient(t, instance, kv)
+	stalled.send("SUBSCRIBE", "news")
+
+	reader := newTestClient(t, instance, kv)
+	reader.expect("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n", "SUBSCRIBE", "news")
+
+	publisher := newTestClient(t, instance, kv)
+	message := strings.Repeat("x", 1024*1024)
+
+	publish := func() {
+		within(t, "PUBLISH", func() {
+			HandleMessage(publisher.client, []string{"PUBLISH", "news", message}, kv)
+		})
+	}
+
+	publish()
+
+	if got := publisher.reply().ToString(); got != ":2\r\n" {
+		t.Errorf("PUBLISH: got %q, want :2", got)
+	}
+
+	want := newBulkStringArray([]string{"message", "news", message}).ToString()
+
+	if reader.read().ToString() != want {
+		t.Errorf("the reading subscriber didn't get the message")
+	}
+
+	// the stalled subscriber is dropped once too much piles up for it, the reading one isn't
+	for range maxPushedBytes / len(message) {
+		publish()
+		publisher.reply()
+
+		if reader.read().ToString() != want {
+			t.Errorf("the reading subscriber didn't get the message")
+		}
+	}
+
+	stalled.expectClosed()
+}
+
+func TestKeyspaceEventsToStalledSubscriber(t *testing.T) {
+	instance, kv := newTestInstance(t)
+
+	writer := newTestClient(t, instance, kv)
+	writer.expect("+OK\r\n", "CONFIG", "SET", "notify-keyspace-events", "KEA")
+
+	stalled := newTestClient(t, instance, kv)
+	stalled.send("PSUBSCRIBE", "*")
+
+	other := newTestClient(t, instance, kv)
+
+	// every SET publishes the key twice, long keys make the events pile up quickly
+	key := strings.Repeat("k", 64*1024)
+
+	for i := range maxPushedBytes/len(key)/2 + 1 {
+		for _, client := range []*testClient{writer, other} {
+			within(t, "SET", func() {
+				HandleMessage(client.client, []string{"SET", key + strconv.Itoa(i), "v"}, kv)
+			})
+
+			if got := client.reply().ToString(); got != "+OK\r\n" {
+				t.Fatalf("SET: got %q, want OK", got)
+			}
+		}
+	}
+
+	stalled.expectClosed()
+}
+
+func TestMaxKeysCollections(t *testing.T) {
+	instance, kv := newTestInstance(t, store.WithMaxKeys(1))
+	client := newTestClient(t, instance, kv)
+
+	oom := resp.NewOOMError().ToString()
+
+	client.expect("+OK\r\n", "SET", "a", "1")
+	client.expect(oom, "SET", "b", "1")
+	client.expect(oom, "LPUSH", "b", "1")
+	client.expect(oom, "HSET", "b", "f", "1")
+	client.expect(oom, "SADD", "b", "1")
+	client.expect(oom, "ZADD", "b", "1", "m")
+	client.expect(oom, "INCR", "b")
+	client.expect(":0\r\n", "EXISTS", "b")
+}
+
+// serveTestInstance serves instance over TCP until the test is done, returning the address to reach it.
+func serveTestInstance(t *testing.T, instance *Instance, kv *store.KVStore) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go serveTestConn(instance, kv, conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// serveTestConn runs the commands read from conn one after the other, until it's closed.
+func serveTestConn(instance *Instance, kv *store.KVStore, conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer conn.Close()
+
+	client := NewClient(ctx, conn, instance)
+	defer client.Close(kv)
+
+	reader := bufio.NewReader(conn)
+
+	var pending []byte
+
+	for {
+		args, n, err := resp.ParseRequest(pending)
+
+		if err != nil {
+			return
+		}
+
+		if n == 0 {
+			b, err := reader.ReadByte()
+
+			if err != nil {
+				return
+			}
+
+			pending = append(pending, b)
+			continue
+		}
+
+		pending = pending[n:]
+
+		if HandleMessage(client, args, kv) != nil || client.Flush() != nil {
+			return
+		}
+	}
+}
+
+func TestAppendOnlyEvictions(t *testing.T) {
+	persistence := Persistence{
+		AppendOnly:  true,
+		AppendPath:  t.TempDir() + "/appendonly.aof",
+		AppendFsync: FsyncAlways,
+	}
+
+	kv := store.NewKVStore(store.WithMaxKeys(2), store.WithEvictionPolicy(store.AllKeysLRU))
+	t.Cleanup(kv.Close)
+
+	instance := startTestInstance(t, persistence, kv)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "a", "1")
+	client.expect("+OK\r\n", "SET", "b", "1")
+	client.expect("+OK\r\n", "SET", "c", "1")
+
+	// the AOF is replayed into a store without a limit, which must not bring a back
+	replayed := store.NewKVStore()
+	t.Cleanup(replayed.Close)
+
+	startTestInstance(t, persistence, replayed)
+
+	if keys := replayed.Keys(); len(keys) != 2 || replayed.Has("a") {
+		t.Errorf("got keys %q after replaying the AOF, want b and c", keys)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	target, targetKV := newTestInstance(t)
+
+	host, port, _ := net.SplitHostPort(serveTestInstance(t, target, targetKV))
+
+	client := newTestClient(t, instance, kv)
+	client.expect("+OK\r\n", "SET", "a", "1")
+	client.expect(":2\r\n", "RPUSH", "b", "x", "y")
+
+	client.expect("+OK\r\n", "MIGRATE", host, port, "", "0", "1000", "KEYS", "a", "b", "missing")
+
+	if kv.Has("a") || kv.Has("b") {
+		t.Errorf("the keys migrated are still there")
+	}
+
+	if value, _, _ := targetKV.Get("a"); value != "1" {
+		t.Errorf("got a = %q on the target, want 1", value)
+	}
+
+	if list, _ := targetKV.LRange("b", 0, -1); !slices.Equal(list, []string{"x", "y"}) {
+		t.Errorf("got b = %q on the target, want x y", list)
+	}
+
+	client.expect("+NOKEY\r\n", "MIGRATE", host, port, "a", "0", "1000")
+}
+
+func TestMigrateCopyReplace(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	target, targetKV := newTestInstance(t)
+
+	host, port, _ := net.SplitHostPort(serveTestInstance(t, target, targetKV))
+
+	client := newTestClient(t, instance, kv)
+	client.expect("+OK\r\n", "SET", "key", "new")
+	client.expect(":1\r\n", "EXPIRE", "key", "100")
+	targetKV.Set("key", "old")
+
+	// a key on the target is only overwritten with REPLACE
+	if got := client.do("MIGRATE", host, port, "key", "0", "1000", "COPY"); !resp.IsError(got) {
+		t.Errorf("got %q migrating onto a key of the target, want an error", got.ToString())
+	}
+
+	client.expect("+OK\r\n", "MIGRATE", host, port, "key", "0", "1000", "COPY", "REPLACE")
+
+	// COPY leaves the key here, and the TTL goes along with it
+	if !kv.Has("key") {
+		t.Errorf("the key copied is gone")
+	}
+
+	if value, _, _ := targetKV.Get("key"); value != "new" {
+		t.Errorf("got key = %q on the target, want new", value)
+	}
+
+	if expiry, _ := targetKV.Expiry("key"); expiry.IsZero() || time.Until(expiry) > 100*time.Second {
+		t.Errorf("got the key expiring at %v on the target, want in 100s at most", expiry)
+	}
+}
+
+func TestMigrateDoesNotBlockWrites(t *testing.T) {
+	persistence := Persistence{AppendOnly: true, AppendPath: t.TempDir() + "/appendonly.aof"}
+
+	kv := store.NewKVStore()
+	t.Cleanup(kv.Close)
+
+	instance := startTestInstance(t, persistence, kv)
+
+	// a target which never replies, until the connection to it is cut
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	accepted := make(chan net.Conn, 1)
+
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	migrating := newTestClient(t, instance, kv)
+	migrating.expect("+OK\r\n", "SET", "a", "1")
+
+	go func() {
+		HandleMessage(migrating.client, []string{"MIGRATE", host, port, "a", "0", "60000"}, kv)
+		migrating.client.Flush()
+	}()
+
+	var conn net.Conn
+
+	select {
+	case conn = <-accepted:
+	case <-time.After(testTimeout):
+		t.Fatalf("MIGRATE didn't connect to the target")
+	}
+
+	// MIGRATE is waiting on the target once it sent RESTORE, which must not hold other writes off
+	conn.SetReadDeadline(time.Now().Add(testTimeout))
+
+	if _, err := resp.Parse(bufio.NewReader(conn)); err != nil {
+		t.Fatalf("failed to read RESTORE: %v", err)
+	}
+
+	writer := newTestClient(t, instance, kv)
+
+	within(t, "SET", func() {
+		HandleMessage(writer.client, []string{"SET", "b", "1"}, kv)
+	})
+
+	writer.reply()
+
+	conn.Close()
+
+	if got := migrating.read(); !resp.IsError(got) {
+		t.Errorf("MIGRATE: got %q, want an error", got.ToString())
+	}
+
+	if !kv.Has("a") {
+		t.Errorf("a was deleted although it couldn't be migrated")
+	}
+}
+
+// syncTestReplica makes client a replica of its instance, reading the snapshot it's sent.
+func syncTestReplica(t *testing.T, client *testClient) {
+	t.Helper()
+
+	go HandleMessage(client.client, []string{"PSYNC", "?", "-1"}, client.kv)
+
+	if got := client.read().ToString(); !strings.HasPrefix(got, "+FULLRESYNC ") {
+		t.Fatalf("PSYNC: got %q, want FULLRESYNC", got)
+	}
+
+	if _, isBulk := client.read().(resp.BulkString); !isBulk {
+		t.Fatalf("PSYNC: the snapshot isn't a bulk string")
+	}
+}
+
+func TestReplicaEvictions(t *testing.T) {
+	instance, kv := newTestInstance(t, store.WithMaxKeys(2), store.WithEvictionPolicy(store.AllKeysLRU))
+
+	replica := newTestClient(t, instance, kv)
+	syncTestReplica(t, replica)
+
+	client := newTestClient(t, instance, kv)
+
+	for _, key := range []string{"a", "b", "c"} {
+		client.expect("+OK\r\n", "SET", key, "1")
+	}
+
+	// a is evicted to make room for c, before c is set
+	want := [][]string{{"set", "a", "1"}, {"set", "b", "1"}, {"del", "a"}, {"set", "c", "1"}}
+
+	for _, command := range want {
+		if got, want := replica.read().ToString(), newBulkStringArray(command).ToString(); got != want {
+			t.Errorf("the replica got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestStalledReplica(t *testing.T) {
+	instance, kv := newTestInstance(t)
+
+	// the replica never reads, not even the snapshot
+	stalled := newTestClient(t, instance, kv)
+	go HandleMessage(stalled.client, []string{"PSYNC", "?", "-1"}, kv)
+
+	for instance.replicas.count() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	writer := newTestClient(t, instance, kv)
+	value := strings.Repeat("x", 1024*1024)
+
+	// the replica is dropped once too many writes pile up for it, which doesn't hold the writes off
+	for range maxReplicaPushedBytes/len(value) + 1 {
+		within(t, "SET", func() {
+			HandleMessage(writer.client, []string{"SET", "key", value}, kv)
+		})
+
+		writer.reply()
+	}
+
+	stalled.expectClosed()
+}
+
+func TestSetDropsTTL(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "key", "1")
+	client.expect(":1\r\n", "EXPIRE", "key", "100")
+	client.expect("+OK\r\n", "SET", "key", "2")
+	client.expect(":-1\r\n", "TTL", "key")
+}
+
+func TestLRange(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":3\r\n", "RPUSH", "list", "a", "b", "c")
+
+	client.expect(bulks("a", "b", "c"), "LRANGE", "list", "0", "-1")
+	client.expect(bulks("b", "c"), "LRANGE", "list", "-2", "-1")
+	client.expect(bulks("a", "b", "c"), "LRANGE", "list", "-100", "100")
+	client.expect(bulks(), "LRANGE", "list", "2", "1")
+	client.expect(bulks(), "LRANGE", "list", "5", "10")
+	client.expect(bulks(), "LRANGE", "missing", "0", "-1")
+
+	client.expect(":3\r\n", "LLEN", "list")
+	client.expect(":0\r\n", "LLEN", "missing")
+}
+
+func TestLInsertLSet(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	// there's nothing to insert into, and the list isn't created
+	client.expect(":0\r\n", "LINSERT", "list", "BEFORE", "a", "b")
+	client.expect(":0\r\n", "EXISTS", "list")
+
+	client.expect(":2\r\n", "RPUSH", "list", "a", "c")
+	client.expect(":3\r\n", "LINSERT", "list", "AFTER", "a", "b")
+	client.expect(":4\r\n", "LINSERT", "list", "BEFORE", "a", "first")
+	client.expect(":-1\r\n", "LINSERT", "list", "BEFORE", "missing", "x")
+	client.expect(bulks("first", "a", "b", "c"), "LRANGE", "list", "0", "-1")
+
+	client.expect("+OK\r\n", "LSET", "list", "-1", "last")
+	client.expect(bulk("last"), "LINDEX", "list", "3")
+	client.expect(bulk("first"), "LINDEX", "list", "-4")
+	client.expect(nilBulk, "LINDEX", "list", "4")
+
+	client.expect(errorResponse(store.ErrIndexOutOfRange).ToString(), "LSET", "list", "4", "x")
+	client.expect(errorResponse(store.ErrIndexOutOfRange).ToString(), "LSET", "list", "-5", "x")
+	client.expect(errorResponse(store.ErrNoSuchKey).ToString(), "LSET", "missing", "0", "x")
+}
+
+func TestLRemLTrim(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":6\r\n", "RPUSH", "list", "x", "a", "x", "b", "x", "x")
+
+	// a positive count removes from the head, a negative one from the tail
+	client.expect(":1\r\n", "LREM", "list", "1", "x")
+	client.expect(bulks("a", "x", "b", "x", "x"), "LRANGE", "list", "0", "-1")
+	client.expect(":2\r\n", "LREM", "list", "-2", "x")
+	client.expect(bulks("a", "x", "b"), "LRANGE", "list", "0", "-1")
+	client.expect(":1\r\n", "LREM", "list", "0", "x")
+	client.expect(bulks("a", "b"), "LRANGE", "list", "0", "-1")
+	client.expect(":0\r\n", "LREM", "missing", "0", "x")
+
+	client.expect(":4\r\n", "RPUSH", "list", "c", "d")
+	client.expect("+OK\r\n", "LTRIM", "list", "1", "-2")
+	client.expect(bulks("b", "c"), "LRANGE", "list", "0", "-1")
+
+	// trimming to an empty range removes the key
+	client.expect("+OK\r\n", "LTRIM", "list", "5", "10")
+	client.expect(":0\r\n", "EXISTS", "list")
+
+	client.expect(":1\r\n", "RPUSH", "list", "x")
+	client.expect(":1\r\n", "LREM", "list", "0", "x")
+	client.expect(":0\r\n", "EXISTS", "list")
+}
+
+func TestLMove(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":3\r\n", "RPUSH", "list", "a", "b", "c")
+
+	// moving within a list rotates it
+	client.expect(bulk("c"), "RPOPLPUSH", "list", "list")
+	client.expect(bulks("c", "a", "b"), "LRANGE", "list", "0", "-1")
+	client.expect(bulk("c"), "LMOVE", "list", "list", "LEFT", "RIGHT")
+	client.expect(bulks("a", "b", "c"), "LRANGE", "list", "0", "-1")
+
+	// a missing destination is created
+	client.expect(bulk("a"), "LMOVE", "list", "other", "LEFT", "LEFT")
+	client.expect(bulk("c"), "RPOPLPUSH", "list", "other")
+	client.expect(bulks("c", "a"), "LRANGE", "other", "0", "-1")
+	client.expect(bulks("b"), "LRANGE", "list", "0", "-1")
+
+	// the source is removed once emptied, and a missing one moves nothing
+	client.expect(bulk("b"), "LMOVE", "list", "other", "RIGHT", "RIGHT")
+	client.expect(":0\r\n", "EXISTS", "list")
+	client.expect(nilBulk, "RPOPLPUSH", "list", "other")
+	client.expect(bulks("c", "a", "b"), "LRANGE", "other", "0", "-1")
+
+	client.expect("+OK\r\n", "SET", "string", "x")
+	client.expect(resp.NewWrongTypeError().ToString(), "LMOVE", "other", "string", "LEFT", "LEFT")
+	client.expect(":3\r\n", "LLEN", "other")
+}
+
+func TestBLPopWakesOnPush(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	blocked := newTestClient(t, instance, kv)
+	pusher := newTestClient(t, instance, kv)
+
+	popped := make(chan struct{})
+
+	go func() {
+		defer close(popped)
+		HandleMessage(blocked.client, []string{"BLPOP", "other", "list", "0"}, kv)
+	}()
+
+	// gives BLPOP the time to start waiting, although pushing first would pop the same
+	time.Sleep(50 * time.Millisecond)
+
+	pusher.expect(":1\r\n", "LPUSH", "list", "x")
+
+	select {
+	case <-popped:
+	case <-time.After(testTimeout):
+		t.Fatalf("BLPOP wasn't woken up by LPUSH")
+	}
+
+	if got, want := blocked.reply().ToString(), bulks("list", "x"); got != want {
+		t.Errorf("BLPOP: got %q, want %q", got, want)
+	}
+
+	pusher.expect(":0\r\n", "EXISTS", "list")
+
+	// without a push, BLPOP gives up once the timeout is over
+	pusher.expect("*-1\r\n", "BLPOP", "list", "0.01")
+}
+
+func TestBLPopTimeoutRange(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	blocked := newTestClient(t, instance, kv)
+	pusher := newTestClient(t, instance, kv)
+
+	for _, timeout := range []string{"nan", "inf", "-inf", "1e400"} {
+		blocked.expect(resp.NewError("timeout is not a float or out of range").ToString(), "BLPOP", "list", timeout)
+	}
+
+	// a timeout too long for a time.Duration waits for a push like 0 does, rather than giving up at once
+	popped := make(chan struct{})
+
+	go func() {
+		defer close(popped)
+		HandleMessage(blocked.client, []string{"BLPOP", "list", "1e300"}, kv)
+	}()
+
+	select {
+	case <-popped:
+		t.Fatalf("BLPOP returned before anything was pushed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pusher.expect(":1\r\n", "LPUSH", "list", "x")
+
+	select {
+	case <-popped:
+	case <-time.After(testTimeout):
+		t.Fatalf("BLPOP wasn't woken up by LPUSH")
+	}
+
+	if got, want := blocked.reply().ToString(), bulks("list", "x"); got != want {
+		t.Errorf("BLPOP: got %q, want %q", got, want)
+	}
+}
+
+func TestHSet(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":2\r\n", "HSET", "hash", "a", "1", "b", "2")
+
+	// overwriting a field doesn't count as adding one
+	client.expect(":0\r\n", "HSET", "hash", "a", "3")
+	client.expect(bulk("3"), "HGET", "hash", "a")
+	client.expect(nilBulk, "HGET", "hash", "missing")
+
+	client.expect(":1\r\n", "HDEL", "hash", "b", "missing")
+	client.expect(bulks("a", "3"), "HGETALL", "hash")
+	client.expect(bulks(), "HGETALL", "missing")
+
+	// deleting the last field removes the key
+	client.expect(":1\r\n", "HDEL", "hash", "a")
+	client.expect(":0\r\n", "EXISTS", "hash")
+}
+
+func TestHashFields(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":1\r\n", "HSET", "hash", "a", "1")
+	client.expect("+OK\r\n", "SET", "string", "x")
+
+	client.expect(bulks("a"), "HKEYS", "hash")
+	client.expect(bulks("1"), "HVALS", "hash")
+	client.expect(":1\r\n", "HLEN", "hash")
+	client.expect(":1\r\n", "HEXISTS", "hash", "a")
+	client.expect(":0\r\n", "HEXISTS", "hash", "b")
+
+	// a missing key is an empty hash, a key of another type an error
+	missing := [][]string{
+		{bulks(), "HKEYS"},
+		{bulks(), "HVALS"},
+		{":0\r\n", "HLEN"},
+		{":0\r\n", "HEXISTS", "a"},
+	}
+
+	for _, test := range missing {
+		want, command, args := test[0], test[1], test[2:]
+
+		client.expect(want, append([]string{command, "missing"}, args...)...)
+		client.expect(resp.NewWrongTypeError().ToString(), append([]string{command, "string"}, args...)...)
+	}
+}
+
+func TestHMGetHSetNX(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":1\r\n", "HSETNX", "hash", "a", "1")
+
+	// an existing field is left as it is
+	client.expect(":0\r\n", "HSETNX", "hash", "a", "2")
+	client.expect(bulk("1"), "HGET", "hash", "a")
+
+	client.expect("*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n1\r\n", "HMGET", "hash", "a", "missing", "a")
+	client.expect("*2\r\n$-1\r\n$-1\r\n", "HMGET", "missing", "a", "b")
+}
+
+// members runs a command replying with an array of bulk strings and returns them sorted,
+// for replies listing the members of a set in no particular order.
+func (c *testClient) members(args ...string) []string {
+	c.t.Helper()
+
+	array, ok := c.do(args...).(resp.Array)
+
+	if !ok {
+		c.t.Fatalf("%s: the reply isn't an array", strings.Join(args, " "))
+	}
+
+	members := make([]string, 0, len(array.Elements))
+
+	for _, element := range array.Elements {
+		members = append(members, element.(resp.BulkString).Value)
+	}
+
+	slices.Sort(members)
+
+	return members
+}
+
+func TestSAdd(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":2\r\n", "SADD", "set", "a", "b", "a")
+
+	// members already in the set aren't counted
+	client.expect(":1\r\n", "SADD", "set", "a", "c")
+	client.expect(":3\r\n", "SCARD", "set")
+	client.expect(":0\r\n", "SCARD", "missing")
+
+	if got := client.members("SMEMBERS", "set"); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("SMEMBERS: got %q", got)
+	}
+
+	client.expect(":1\r\n", "SISMEMBER", "set", "a")
+	client.expect(":0\r\n", "SISMEMBER", "set", "d")
+	client.expect(":0\r\n", "SISMEMBER", "missing", "a")
+
+	client.expect(":2\r\n", "SREM", "set", "a", "b", "d")
+	client.expect(":1\r\n", "SREM", "set", "c")
+	client.expect(":0\r\n", "EXISTS", "set")
+	client.expect(bulks(), "SMEMBERS", "set")
+}
+
+func TestSetOperations(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":3\r\n", "SADD", "a", "1", "2", "3")
+	client.expect(":3\r\n", "SADD", "b", "4", "3", "2")
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"SINTER", "a", "b"}, []string{"2", "3"}},
+		{[]string{"SINTER", "a", "b", "missing"}, []string{}},
+		{[]string{"SUNION", "a", "b", "missing"}, []string{"1", "2", "3", "4"}},
+		// the first set is the one taken from, whatever order the members were added in
+		{[]string{"SDIFF", "a", "b"}, []string{"1"}},
+		{[]string{"SDIFF", "b", "a"}, []string{"4"}},
+		{[]string{"SDIFF", "a", "missing"}, []string{"1", "2", "3"}},
+		{[]string{"SDIFF", "missing", "a"}, []string{}},
+	}
+
+	for _, test := range tests {
+		if got := client.members(test.args...); !slices.Equal(got, test.want) {
+			t.Errorf("%s: got %q, want %q", strings.Join(test.args, " "), got, test.want)
+		}
+	}
+
+	// storing replaces whatever the destination held, whatever its type
+	client.expect("+OK\r\n", "SET", "dst", "x")
+	client.expect(":4\r\n", "SUNIONSTORE", "dst", "a", "b")
+
+	if got := client.members("SMEMBERS", "dst"); !slices.Equal(got, []string{"1", "2", "3", "4"}) {
+		t.Errorf("SUNIONSTORE stored %q", got)
+	}
+
+	client.expect(":1\r\n", "SDIFFSTORE", "dst", "b", "a")
+	client.expect(bulks("4"), "SMEMBERS", "dst")
+
+	// an empty result removes the destination
+	client.expect(":0\r\n", "SINTERSTORE", "dst", "a", "missing")
+	client.expect(":0\r\n", "EXISTS", "dst")
+}
+
+func TestSMove(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":2\r\n", "SADD", "src", "a", "b")
+	client.expect(":1\r\n", "SADD", "dst", "a")
+
+	client.expect("*3\r\n:1\r\n:0\r\n:1\r\n", "SMISMEMBER", "src", "a", "c", "b")
+	client.expect("*1\r\n:0\r\n", "SMISMEMBER", "missing", "a")
+
+	// a member already in the destination is still taken out of the source
+	client.expect(":1\r\n", "SMOVE", "src", "dst", "a")
+	client.expect(bulks("b"), "SMEMBERS", "src")
+	client.expect(bulks("a"), "SMEMBERS", "dst")
+
+	client.expect(":0\r\n", "SMOVE", "src", "dst", "missing")
+	client.expect(":1\r\n", "SMOVE", "src", "new", "b")
+	client.expect(":0\r\n", "EXISTS", "src")
+	client.expect(bulks("b"), "SMEMBERS", "new")
+}
+
+func TestZAdd(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":2\r\n", "ZADD", "zset", "1", "a", "2", "b")
+
+	// re-adding a member updates its score without counting it
+	client.expect(":0\r\n", "ZADD", "zset", "3.5", "a")
+	client.expect(bulk("3.5"), "ZSCORE", "zset", "a")
+	client.expect(nilBulk, "ZSCORE", "zset", "missing")
+	client.expect(":2\r\n", "ZCARD", "zset")
+	client.expect(":0\r\n", "ZCARD", "missing")
+	client.expect(bulks("b", "a"), "ZRANGE", "zset", "0", "-1")
+
+	// removing the last member removes the key
+	client.expect(":1\r\n", "ZREM", "zset", "a", "missing")
+	client.expect(":1\r\n", "ZREM", "zset", "b")
+	client.expect(":0\r\n", "EXISTS", "zset")
+}
+
+func TestZRange(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":3\r\n", "ZADD", "zset", "1", "a", "2.5", "b", "3", "c")
+
+	client.expect(bulks("a", "b", "c"), "ZRANGE", "zset", "0", "-1")
+	client.expect(bulks("a", "1", "b", "2.5"), "ZRANGE", "zset", "0", "1", "WITHSCORES")
+	client.expect(bulks("c", "b", "a"), "ZREVRANGE", "zset", "0", "-1")
+	client.expect(bulks("c", "3", "b", "2.5"), "ZREVRANGE", "zset", "0", "1", "WITHSCORES")
+	client.expect(bulks(), "ZRANGE", "zset", "5", "10")
+	client.expect(bulks(), "ZRANGE", "missing", "0", "-1")
+}
+
+func TestZRangeByScore(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":4\r\n", "ZADD", "zset", "1", "a", "2", "b", "3", "c", "4", "d")
+
+	tests := []struct {
+		min, max string
+		want     []string
+	}{
+		{"2", "3", []string{"b", "c"}},
+		{"(2", "3", []string{"c"}},
+		{"2", "(3", []string{"b"}},
+		{"(2", "(3", []string{}},
+		{"-inf", "+inf", []string{"a", "b", "c", "d"}},
+		{"(1", "inf", []string{"b", "c", "d"}},
+		{"3", "2", []string{}},
+	}
+
+	for _, test := range tests {
+		client.expect(bulks(test.want...), "ZRANGEBYSCORE", "zset", test.min, test.max)
+		client.expect(":"+strconv.Itoa(len(test.want))+"\r\n", "ZCOUNT", "zset", test.min, test.max)
+	}
+
+	client.expect(bulks("b", "c"), "ZRANGEBYSCORE", "zset", "-inf", "+inf", "LIMIT", "1", "2")
+	client.expect(bulks("c", "3", "d", "4"), "ZRANGEBYSCORE", "zset", "(1", "+inf", "WITHSCORES", "LIMIT", "1", "-1")
+	client.expect(bulks(), "ZRANGEBYSCORE", "zset", "-inf", "+inf", "LIMIT", "10", "1")
+	client.expect(resp.NewError("min or max is not a float").ToString(), "ZCOUNT", "zset", "(x", "1")
+}
+
+func TestZRankZPop(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	// members with the same score are ordered lexicographically
+	client.expect(":3\r\n", "ZADD", "zset", "1", "c", "1", "a", "1", "b")
+	client.expect(":0\r\n", "ZRANK", "zset", "a")
+	client.expect(":2\r\n", "ZRANK", "zset", "c")
+	client.expect(nilBulk, "ZRANK", "zset", "missing")
+
+	client.expect(bulk("2"), "ZINCRBY", "zset", "1", "a")
+	client.expect(":2\r\n", "ZRANK", "zset", "a")
+	client.expect(bulk("-1.5"), "ZINCRBY", "zset", "-1.5", "new")
+	client.expect(":0\r\n", "ZRANK", "zset", "new")
+
+	client.expect(bulks("new", "-1.5"), "ZPOPMIN", "zset")
+	client.expect(bulks("a", "2"), "ZPOPMAX", "zset")
+
+	// popping more than there is pops everything and removes the key
+	client.expect(bulks("b", "1", "c", "1"), "ZPOPMIN", "zset", "10")
+	client.expect(":0\r\n", "EXISTS", "zset")
+	client.expect(bulks(), "ZPOPMAX", "zset", "10")
+}
+
+func TestPublishToTwoSubscribers(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	publisher := newTestClient(t, instance, kv)
+
+	subscribers := []*testClient{newTestClient(t, instance, kv), newTestClient(t, instance, kv)}
+
+	for _, subscriber := range subscribers {
+		subscriber.expect("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n", "SUBSCRIBE", "news")
+	}
+
+	publisher.expect(":2\r\n", "PUBLISH", "news", "hello")
+
+	for _, subscriber := range subscribers {
+		if got, want := subscriber.read().ToString(), bulks("message", "news", "hello"); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestPSubscribe(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	subscriber := newTestClient(t, instance, kv)
+	publisher := newTestClient(t, instance, kv)
+
+	subscriber.expect("*3\r\n$10\r\npsubscribe\r\n$6\r\nnews.*\r\n:1\r\n", "PSUBSCRIBE", "news.*")
+
+	publisher.expect(":0\r\n", "PUBLISH", "news", "ignored")
+	publisher.expect(":1\r\n", "PUBLISH", "news.sports", "goal")
+
+	if got, want := subscriber.read().ToString(), bulks("pmessage", "news.*", "news.sports", "goal"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSubscribeRepliedBeforeMessages(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	subscriber := newTestClient(t, instance, kv)
+
+	var got []string
+
+	// a message is published the moment each subscription is made, and whatever it pushes out read
+	add := func(client *Client, channel string) {
+		instance.broker.Subscribe(client, channel)
+		instance.broker.Publish(channel, "hello")
+
+		got = append(got, subscriber.read().ToString())
+	}
+
+	// the confirmations are already on their way, nothing is left for HandleMessage to reply with
+	if response := subscribe(subscriber.client, "subscribe", subscriber.client.channels, add, []string{"news", "sports"}); response.ToString() != "" {
+		t.Errorf("SUBSCRIBE returned %q", response.ToString())
+	}
+
+	got = append(got, subscriber.read().ToString(), subscriber.read().ToString())
+
+	want := []string{
+		"*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n",
+		"*3\r\n$9\r\nsubscribe\r\n$6\r\nsports\r\n:2\r\n",
+		bulks("message", "news", "hello"),
+		bulks("message", "sports", "hello"),
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// inside EXEC the confirmation is part of its reply
+	client := newTestClient(t, instance, kv)
+	client.expect("+OK\r\n", "MULTI")
+	client.expect("+QUEUED\r\n", "PSUBSCRIBE", "n*")
+	client.expect("*1\r\n*3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:1\r\n", "EXEC")
+}
+
+func TestPubSubIntrospection(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	subscriber := newTestClient(t, instance, kv)
+	client := newTestClient(t, instance, kv)
+
+	subscriber.expect("*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n", "SUBSCRIBE", "a")
+	subscriber.expect("*3\r\n$9\r\nsubscribe\r\n$1\r\nb\r\n:2\r\n", "SUBSCRIBE", "b")
+	subscriber.expect("*3\r\n$11\r\nunsubscribe\r\n$1\r\nb\r\n:1\r\n", "UNSUBSCRIBE", "b")
+	subscriber.expect("*3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:2\r\n", "PSUBSCRIBE", "n*")
+
+	// b has no subscriber left, so isn't listed
+	client.expect(bulks("a"), "PUBSUB", "CHANNELS")
+	client.expect(bulks(), "PUBSUB", "CHANNELS", "b*")
+	client.expect("*4\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n:0\r\n", "PUBSUB", "NUMSUB", "a", "b")
+	client.expect(":1\r\n", "PUBSUB", "NUMPAT")
+}
+
+func TestMulti(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "MULTI")
+	client.expect("+QUEUED\r\n", "SET", "key", "v")
+	client.expect("+QUEUED\r\n", "GET", "key")
+	client.expect("*2\r\n+OK\r\n$1\r\nv\r\n", "EXEC")
+
+	// discarded commands aren't run
+	client.expect("+OK\r\n", "MULTI")
+	client.expect("+QUEUED\r\n", "SET", "key", "discarded")
+	client.expect("+OK\r\n", "DISCARD")
+	client.expect(bulk("v"), "GET", "key")
+
+	client.expect(resp.NewError("EXEC without MULTI").ToString(), "EXEC")
+}
+
+func TestWatch(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+	other := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "WATCH", "key")
+	other.expect("+OK\r\n", "SET", "key", "other")
+
+	client.expect("+OK\r\n", "MULTI")
+	client.expect("+QUEUED\r\n", "SET", "key", "mine")
+	client.expect("*-1\r\n", "EXEC")
+	client.expect(bulk("other"), "GET", "key")
+
+	// EXEC unwatches the keys, as does UNWATCH
+	client.expect("+OK\r\n", "WATCH", "key")
+	client.expect("+OK\r\n", "UNWATCH")
+	other.expect("+OK\r\n", "SET", "key", "other")
+
+	client.expect("+OK\r\n", "MULTI")
+	client.expect("+QUEUED\r\n", "SET", "key", "mine")
+	client.expect("*1\r\n+OK\r\n", "EXEC")
+	client.expect(bulk("mine"), "GET", "key")
+}
+
+func TestExecAbortUnwatches(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+	other := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "WATCH", "key")
+	client.expect("+OK\r\n", "MULTI")
+	client.expect("-ERR unknown command 'UNKNOWN'\r\n", "UNKNOWN")
+	client.expect("-EXECABORT Transaction discarded because of previous errors.\r\n", "EXEC")
+
+	// the key isn't watched anymore, so changing it doesn't fail the next transaction
+	other.expect("+OK\r\n", "SET", "key", "other")
+
+	client.expect("+OK\r\n", "MULTI")
+	client.expect("+QUEUED\r\n", "SET", "key", "mine")
+	client.expect("*1\r\n+OK\r\n", "EXEC")
+}
+
+func TestGetEmptyString(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "key", "")
+	client.expect("$0\r\n\r\n", "GET", "key")
+	client.expect(nilBulk, "GET", "missing")
+}
+
+func TestNullReplies(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	// a missing value is a null bulk string, told apart from an empty one
+	for name, handler := range map[string]CommandHandler{"GET": HandleGetCommand, "GETDEL": HandleGetDelCommand, "LPOP": HandleLPopCommand} {
+		reply := handler(client.client, []string{"missing"}, kv)
+
+		if _, isNull := reply.(resp.NullBulkString); !isNull || reply.ToString() != nilBulk {
+			t.Errorf("%s: got %q, want a null bulk string", name, reply.ToString())
+		}
+	}
+}
+
+// expectInline runs an inline command, parsed the way the server parses it, and checks its reply is want.
+func (c *testClient) expectInline(want string, line string) {
+	c.t.Helper()
+
+	args, n, err := resp.ParseRequest([]byte(line + "\r\n"))
+
+	if err != nil || n == 0 {
+		c.t.Fatalf("failed to parse %q: %v", line, err)
+	}
+
+	c.expect(want, args...)
+}
+
+func TestEcho(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("$0\r\n\r\n", "ECHO", "")
+	client.expect(bulk("hello"), "ECHO", "hello")
+	client.expectInline(bulk("hello world"), `ECHO "hello world"`)
+	client.expectInline("$0\r\n\r\n", `ECHO ""`)
+}
+
+func TestTime(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	reply := client.do("TIME")
+	array, ok := reply.(resp.Array)
+
+	if !ok || len(array.Elements) != 2 {
+		t.Fatalf("TIME: got %q, want two values", reply.ToString())
+	}
+
+	seconds, err := strconv.ParseInt(array.Elements[0].(resp.BulkString).Value, 10, 64)
+
+	if err != nil || time.Since(time.Unix(seconds, 0)).Abs() > time.Minute {
+		t.Errorf("TIME: got %q seconds, want about now", array.Elements[0].ToString())
+	}
+
+	micros, err := strconv.Atoi(array.Elements[1].(resp.BulkString).Value)
+
+	if err != nil || micros < 0 || micros > 999999 {
+		t.Errorf("TIME: got %q microseconds, want a number in [0, 999999]", array.Elements[1].ToString())
+	}
+}
+
+// info runs INFO with args and returns the fields of its reply, failing the test if a line isn't
+// a section header or a field.
+func (c *testClient) info(args ...string) map[string]string {
+	c.t.Helper()
+
+	reply, ok := c.do(append([]string{"INFO"}, args...)...).(resp.BulkString)
+
+	if !ok {
+		c.t.Fatalf("INFO: the reply isn't a bulk string")
+	}
+
+	fields := make(map[string]string)
+
+	for line := range strings.Lines(reply.Value) {
+		line = strings.TrimSuffix(line, "\r\n")
+
+		if line == "" || strings.HasPrefix(line, "# ") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+
+		if !ok {
+			c.t.Fatalf("INFO: %q isn't a field", line)
+		}
+
+		fields[name] = value
+	}
+
+	return fields
+}
+
+func TestInfo(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "a", "1")
+	client.expect("+OK\r\n", "SET", "b", "1")
+	client.expect(":1\r\n", "EXPIRE", "b", "100")
+
+	fields := client.info()
+
+	// the keyspace line is itself made of key=value pairs
+	db := make(map[string]string)
+
+	for pair := range strings.SplitSeq(fields["db0"], ",") {
+		key, value, _ := strings.Cut(pair, "=")
+		db[key] = value
+	}
+
+	if db["keys"] != "2" || db["expires"] != "1" {
+		t.Errorf("got db0:%s, want 2 keys of which 1 expires", fields["db0"])
+	}
+
+	if fields["connected_clients"] != "1" {
+		t.Errorf("got connected_clients:%s, want 1", fields["connected_clients"])
+	}
+
+	// a section only has its own fields
+	if fields := client.info("keyspace"); len(fields) != 1 {
+		t.Errorf("INFO keyspace: got %d fields, want db0 only", len(fields))
+	}
+}
+
+func TestConfig(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(bulks("maxkeys", "0", "maxkeys-policy", "noeviction"), "CONFIG", "GET", "maxkeys*")
+
+	client.expect("+OK\r\n", "CONFIG", "SET", "maxkeys-policy", "allkeys-lru")
+	client.expect(bulks("maxkeys-policy", "allkeys-lru"), "CONFIG", "GET", "maxkeys-policy")
+
+	if got := kv.EvictionPolicy(); got != store.AllKeysLRU {
+		t.Errorf("the store's policy is %s, want allkeys-lru", got)
+	}
+
+	client.expect(bulks(), "CONFIG", "GET", "missing")
+
+	if reply := client.do("CONFIG", "SET", "maxkeys-policy", "unknown"); !resp.IsError(reply) {
+		t.Errorf("CONFIG SET of an unknown policy: got %q, want an error", reply.ToString())
+	}
+
+	client.expect(bulks("maxkeys-policy", "allkeys-lru"), "CONFIG", "GET", "maxkeys-policy")
+}
+
+func TestClientName(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+	other := newTestClient(t, instance, kv)
+
+	client.expect(nilBulk, "CLIENT", "GETNAME")
+	client.expect("+OK\r\n", "CLIENT", "SETNAME", "worker")
+	client.expect(bulk("worker"), "CLIENT", "GETNAME")
+
+	id := client.do("CLIENT", "ID").(resp.Integer).Value
+
+	if otherID := other.do("CLIENT", "ID").(resp.Integer).Value; otherID == id {
+		t.Errorf("two clients share the id %d", id)
+	}
+
+	list := other.do("CLIENT", "LIST").(resp.BulkString).Value
+	want := "id=" + strconv.Itoa(id) + " addr=pipe name=worker "
+
+	if !strings.Contains(list, want) {
+		t.Errorf("CLIENT LIST got %q, want a line starting with %q", list, want)
+	}
+
+	if lines := strings.Count(list, "\n"); lines != 2 {
+		t.Errorf("CLIENT LIST got %d lines, want 2", lines)
+	}
+
+	if reply := client.do("CLIENT", "SETNAME", "has space"); !resp.IsError(reply) {
+		t.Errorf("CLIENT SETNAME with a space: got %q, want an error", reply.ToString())
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	victim := newTestClient(t, instance, kv)
+	killer := newTestClient(t, instance, kv)
+
+	id := strconv.Itoa(victim.do("CLIENT", "ID").(resp.Integer).Value)
+
+	killer.expect(":1\r\n", "CLIENT", "KILL", "ID", id)
+	victim.expectClosed()
+
+	killer.expect(resp.NewError("No such client").ToString(), "CLIENT", "KILL", "127.0.0.1:1")
+}
+
+func TestAppendOnlyReplay(t *testing.T) {
+	persistence := Persistence{
+		AppendOnly:  true,
+		AppendPath:  t.TempDir() + "/appendonly.aof",
+		AppendFsync: FsyncAlways,
+	}
+
+	kv := store.NewKVStore()
+	t.Cleanup(kv.Close)
+
+	instance := startTestInstance(t, persistence, kv)
+	client := newTestClient(t, instance, kv)
+
+	soon := strconv.FormatInt(time.Now().Add(50*time.Millisecond).UnixMilli(), 10)
+
+	client.expect("+OK\r\n", "SET", "kept", "1")
+	client.expect(":2\r\n", "RPUSH", "list", "a", "b")
+	client.expect("+OK\r\n", "SET", "expiring", "1")
+	client.expect(":1\r\n", "EXPIRE", "expiring", "100")
+	client.expect("+OK\r\n", "SET", "expired", "1")
+	client.expect(":1\r\n", "PEXPIREAT", "expired", soon)
+	client.expect("+OK\r\n", "SET", "deleted", "1")
+	client.expect(":1\r\n", "DEL", "deleted")
+
+	time.Sleep(100 * time.Millisecond)
+
+	// replaying the AOF on startup brings back the keys, but not the one which expired meanwhile
+	replayed := store.NewKVStore()
+	t.Cleanup(replayed.Close)
+
+	startTestInstance(t, persistence, replayed)
+
+	if got := replayed.Keys(); len(got) != 3 || !replayed.Has("kept") || !replayed.Has("list") || !replayed.Has("expiring") {
+		t.Errorf("got keys %q after replaying the AOF, want kept, list and expiring", got)
+	}
+
+	if got, _ := replayed.LRange("list", 0, -1); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("got list %q after replaying the AOF", got)
+	}
+
+	// the TTL is logged as an absolute time, so it doesn't start over
+	if ttl := replayed.TTL("expiring"); ttl < 90 || ttl > 100 {
+		t.Errorf("got a TTL of %d after replaying the AOF, want about 100", ttl)
+	}
+}
+
+func TestLastSave(t *testing.T) {
+	kv := store.NewKVStore()
+	t.Cleanup(kv.Close)
+
+	instance := startTestInstance(t, Persistence{SnapshotPath: t.TempDir() + "/dump.redig"}, kv)
+	client := newTestClient(t, instance, kv)
+
+	// as if the last save was a minute ago, rather than waiting for LASTSAVE to tick over
+	before := time.Now().Add(-time.Minute).Unix()
+	instance.lastSave.Store(before)
+
+	client.expect(":"+strconv.FormatInt(before, 10)+"\r\n", "LASTSAVE")
+	client.expect("+OK\r\n", "SAVE")
+
+	if got := client.do("LASTSAVE").(resp.Integer).Value; int64(got) <= before {
+		t.Errorf("LASTSAVE got %d after SAVE, want later than %d", got, before)
+	}
+}
+
+func TestObjectEncoding(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "int", "12345")
+	client.expect("+OK\r\n", "SET", "short", "hello")
+	client.expect("+OK\r\n", "SET", "long", strings.Repeat("x", 100))
+
+	client.expect(bulk("int"), "OBJECT", "ENCODING", "int")
+	client.expect(bulk("embstr"), "OBJECT", "ENCODING", "short")
+	client.expect(bulk("raw"), "OBJECT", "ENCODING", "long")
+	client.expect(errorResponse(store.ErrNoSuchKey).ToString(), "OBJECT", "ENCODING", "missing")
+
+	client.expect(":1\r\n", "OBJECT", "REFCOUNT", "int")
+	client.expect(":0\r\n", "OBJECT", "IDLETIME", "int")
+}
+
+func TestMemoryUsage(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "short", "x")
+	client.expect("+OK\r\n", "SET", "long", strings.Repeat("x", 1000))
+
+	short := client.do("MEMORY", "USAGE", "short").(resp.Integer).Value
+	long := client.do("MEMORY", "USAGE", "long").(resp.Integer).Value
+
+	if short <= 0 || long <= short {
+		t.Errorf("MEMORY USAGE got %d bytes for a short value and %d for a long one", short, long)
+	}
+
+	client.expect(nilBulk, "MEMORY", "USAGE", "missing")
+}
+
+func TestMemoryUsageAfterSetBit(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "key", "x")
+	before := client.do("MEMORY", "USAGE", "key").(resp.Integer).Value
+
+	// the size is read off the value as it is, so an edit in place is counted without a delta to report
+	client.expect(":0\r\n", "SETBIT", "key", strconv.Itoa(8000*8), "1")
+
+	if after := client.do("MEMORY", "USAGE", "key").(resp.Integer).Value; after-before < 8000 {
+		t.Errorf("MEMORY USAGE went from %d to %d bytes as SETBIT grew the value by 8000", before, after)
+	}
+}
+
+func TestWaitWithoutReplicas(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":0\r\n", "WAIT", "0", "100")
+	client.expect(":0\r\n", "WAIT", "1", "10")
+	client.expect(resp.NewError("value is not an integer or out of range").ToString(), "WAIT", "x", "100")
+	client.expect(resp.NewError("timeout is negative").ToString(), "WAIT", "0", "-1")
+}
+
+func TestReset(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+	publisher := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "MULTI")
+	client.expect("+QUEUED\r\n", "SET", "key", "v")
+	client.expect("+RESET\r\n", "RESET")
+
+	client.expect("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n", "SUBSCRIBE", "news")
+	client.expect("+RESET\r\n", "RESET")
+
+	// the transaction was dropped and the subscription with it, so commands run as usual
+	publisher.expect(":0\r\n", "PUBLISH", "news", "hello")
+	client.expect(nilBulk, "GET", "key")
+	client.expect("+OK\r\n", "SET", "key", "v")
+	client.expect(resp.NewError("EXEC without MULTI").ToString(), "EXEC")
+}
+
+func TestWrongTypeReplies(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "string", "x")
+	client.expect(":1\r\n", "LPUSH", "list", "x")
+	client.expect(":1\r\n", "HSET", "hash", "f", "x")
+	client.expect(":1\r\n", "SADD", "set", "x")
+	client.expect(":1\r\n", "ZADD", "zset", "1", "x")
+
+	// a command of each type run on a key of another
+	commands := [][]string{
+		{"GET", "list"},
+		{"INCR", "hash"},
+		{"SETBIT", "set", "0", "1"},
+		{"LPUSH", "string", "x"},
+		{"LRANGE", "hash", "0", "-1"},
+		{"HSET", "list", "f", "x"},
+		{"HGET", "zset", "f"},
+		{"SADD", "hash", "x"},
+		{"SMEMBERS", "string"},
+		{"ZADD", "set", "1", "x"},
+		{"ZSCORE", "list", "x"},
+	}
+
+	want := resp.NewWrongTypeError().ToString()
+
+	for _, command := range commands {
+		if got := client.do(command...).ToString(); got != want {
+			t.Errorf("%s: got %q, want %q", strings.Join(command, " "), got, want)
+		}
+	}
+}
+
+func TestExists(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "a", "1")
+	client.expect(":1\r\n", "RPUSH", "b", "1")
+
+	// a key named twice is counted twice
+	client.expect(":3\r\n", "EXISTS", "a", "a", "b")
+	client.expect(":1\r\n", "EXISTS", "a", "missing")
+	client.expect(":0\r\n", "EXISTS", "missing")
+}
+
+func TestDel(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "a", "1")
+	client.expect(":1\r\n", "RPUSH", "b", "1")
+
+	// missing keys, and keys named twice, are only counted once deleted
+	client.expect(":2\r\n", "DEL", "a", "missing", "b", "a")
+	client.expect(":0\r\n", "EXISTS", "a", "b")
+	client.expect(":0\r\n", "DEL", "a")
+}
+
+func TestKeys(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	for _, key := range []string{"hello", "hallo", "hillo", "a/b"} {
+		client.expect("+OK\r\n", "SET", key, "1")
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"h[ae]llo", []string{"hallo", "hello"}},
+		{"h?llo", []string{"hallo", "hello", "hillo"}},
+		{"*", []string{"a/b", "hallo", "hello", "hillo"}},
+		{"a*", []string{"a/b"}},
+		{"missing*", []string{}},
+	}
+
+	for _, test := range tests {
+		if got := client.members("KEYS", test.pattern); !slices.Equal(got, test.want) {
+			t.Errorf("KEYS %s: got %q, want %q", test.pattern, got, test.want)
+		}
+	}
+
+	// COUNT stops at that many keys, whichever they are
+	if got := client.members("KEYS", "h*", "COUNT", "2"); len(got) != 2 || slices.Contains(got, "a/b") {
+		t.Errorf("KEYS h* COUNT 2: got %q, want 2 keys starting with h", got)
+	}
+
+	if got := client.members("KEYS", "h*", "count", "10"); !slices.Equal(got, []string{"hallo", "hello", "hillo"}) {
+		t.Errorf("KEYS h* COUNT 10: got %q, want every key starting with h", got)
+	}
+
+	client.expect("-ERR syntax error\r\n", "KEYS", "*", "COUNT", "0")
+	client.expect("-ERR syntax error\r\n", "KEYS", "*", "LIMIT", "2")
+	client.expect("-ERR value is not an integer or out of range\r\n", "KEYS", "*", "COUNT", "x")
+	client.expect("-ERR wrong number of arguments for 'keys' command\r\n", "KEYS", "*", "COUNT")
+}
+
+// logBuffer holds what an instance logs as JSON, a record per line.
+type logBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.buf.Write(p)
+}
+
+// records returns the records logged so far with the message msg.
+func (b *logBuffer) records(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var records []map[string]any
+
+	for line := range strings.Lines(b.buf.String()) {
+		var record map[string]any
+
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("failed to decode the record %q: %v", line, err)
+		}
+
+		if record[slog.MessageKey] == msg {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// newLoggedTestInstance starts an instance like newTestInstance, logging records of level and above.
+func newLoggedTestInstance(t *testing.T, level slog.Level) (*Instance, *store.KVStore, *logBuffer) {
+	t.Helper()
+
+	kv := store.NewKVStore()
+	t.Cleanup(kv.Close)
+
+	logs := &logBuffer{}
+	instance := NewInstance(Persistence{}, slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: level})))
+
+	if err := instance.Start(kv); err != nil {
+		t.Fatalf("failed to start the instance: %v", err)
+	}
+
+	t.Cleanup(func() { instance.Close() })
+
+	return instance, kv, logs
+}
+
+func TestCommandsNotLoggedAtInfo(t *testing.T) {
+	instance, kv, logs := newLoggedTestInstance(t, slog.LevelInfo)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "key", "secret")
+
+	if records := logs.records(t, "Command handled"); len(records) != 0 {
+		t.Errorf("got %d commands logged at info level, want none", len(records))
+	}
+}
+
+func TestCommandLogRecord(t *testing.T) {
+	instance, kv, logs := newLoggedTestInstance(t, slog.LevelDebug)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "key", "value")
+
+	records := logs.records(t, "Command handled")
+
+	if len(records) != 1 {
+		t.Fatalf("got %d commands logged, want 1", len(records))
+	}
+
+	record := records[0]
+
+	if record["command"] != "set" || record["remote_addr"] != "pipe" || record["client_id"] != float64(client.client.id) {
+		t.Errorf("got record %v, want the command and the client it came from", record)
+	}
+
+	if args, _ := record["args"].([]any); len(args) != 2 || args[0] != "key" || args[1] != "value" {
+		t.Errorf("got args %v, want key and value", record["args"])
+	}
+
+	if _, ok := record["duration_ms"].(float64); !ok {
+		t.Errorf("got duration_ms %v, want a number", record["duration_ms"])
+	}
+}
+
+func TestCommandStats(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	for range 3 {
+		client.expect(nilBulk, "GET", "key")
+	}
+
+	client.do("GET")
+
+	stats := client.info("commandstats")["cmdstat_get"]
+
+	if !strings.HasPrefix(stats, "calls=4,") || !strings.Contains(stats, ",failed_calls=1") {
+		t.Errorf("got cmdstat_get:%s, want 4 calls of which 1 failed", stats)
+	}
+
+	if _, ok := client.info("commandstats")["cmdstat_set"]; ok {
+		t.Errorf("SET was never called, yet has stats")
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	monitor := newTestClient(t, instance, kv)
+	client := newTestClient(t, instance, kv)
+
+	monitor.expect("+OK\r\n", "MONITOR")
+	client.expect("+OK\r\n", "SET", "key", "a value")
+
+	line, ok := monitor.read().(resp.SimpleString)
+
+	if !ok || !strings.HasSuffix(line.Value, ` [0 pipe] "SET" "key" "a value"`) {
+		t.Errorf("MONITOR got %q, want the SET", line)
+	}
+}
+
+func TestKeyspaceEvents(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+	subscriber := newTestClient(t, instance, kv)
+
+	subscriber.expect("*3\r\n$10\r\npsubscribe\r\n$12\r\n__key*@0__:*\r\n:1\r\n", "PSUBSCRIBE", "__key*@0__:*")
+
+	// nothing is published until notify-keyspace-events asks for it
+	client.expect("+OK\r\n", "SET", "key", "value")
+
+	client.expect("+OK\r\n", "CONFIG", "SET", "notify-keyspace-events", "E$")
+	client.expect(bulks("notify-keyspace-events", "$E"), "CONFIG", "GET", "notify-keyspace-events")
+
+	// DEL is a generic event, which wasn't asked for, so the first event is that of the second SET
+	client.expect(":1\r\n", "DEL", "key")
+	client.expect("+OK\r\n", "SET", "key", "value")
+
+	if got, want := subscriber.read().ToString(), bulks("pmessage", "__key*@0__:*", "__keyevent@0__:set", "key"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// K publishes the event to a channel named after the key
+	client.expect("+OK\r\n", "CONFIG", "SET", "notify-keyspace-events", "K$")
+	client.expect("+OK\r\n", "SET", "key", "value")
+
+	if got, want := subscriber.read().ToString(), bulks("pmessage", "__key*@0__:*", "__keyspace@0__:key", "set"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := client.do("CONFIG", "SET", "notify-keyspace-events", "Q"); !resp.IsError(got) {
+		t.Errorf("got %q for an unknown flag, want an error", got.ToString())
+	}
+}
+
+func TestExpiredEvent(t *testing.T) {
+	instance, kv := newTestInstance(t, store.WithGCInterval(time.Millisecond))
+	client := newTestClient(t, instance, kv)
+	subscriber := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "CONFIG", "SET", "notify-keyspace-events", "Ex")
+	subscriber.expect("*3\r\n$9\r\nsubscribe\r\n$22\r\n__keyevent@0__:expired\r\n:1\r\n", "SUBSCRIBE", "__keyevent@0__:expired")
+
+	// the key is never accessed again, it's the GC removing it that publishes the event
+	client.expect("+OK\r\n", "SET", "key", "value")
+	client.expect(":1\r\n", "PEXPIREAT", "key", strconv.FormatInt(time.Now().Add(20*time.Millisecond).UnixMilli(), 10))
+
+	if got, want := subscriber.read().ToString(), bulks("message", "__keyevent@0__:expired", "key"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "key", "value")
+	client.expect(":1\r\n", "EXPIRE", "key", "100")
+
+	dump, ok := client.do("DUMP", "key").(resp.BulkString)
+
+	if !ok {
+		t.Fatalf("DUMP: got %T, want a bulk string", dump)
+	}
+
+	client.expect("+OK\r\n", "RESTORE", "copy", "50000", dump.Value)
+	client.expect(bulk("value"), "GET", "copy")
+
+	if ttl := client.do("TTL", "copy").ToString(); ttl != ":50\r\n" && ttl != ":49\r\n" {
+		t.Errorf("got TTL %q, want the 50s given to RESTORE", ttl)
+	}
+
+	// the key isn't overwritten without REPLACE, which also drops the TTL given a TTL of 0
+	client.expect(errorResponse(store.ErrBusyKey).ToString(), "RESTORE", "copy", "0", dump.Value)
+	client.expect("+OK\r\n", "RESTORE", "copy", "0", dump.Value, "REPLACE")
+	client.expect(":-1\r\n", "TTL", "copy")
+
+	client.expect(nilBulk, "DUMP", "missing")
+
+	corrupted := dump.Value[:len(dump.Value)-1] + string(dump.Value[len(dump.Value)-1]^1)
+	client.expect(errorResponse(store.ErrBadDump).ToString(), "RESTORE", "other", "0", corrupted)
+}
+
+func TestSort(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":5\r\n", "RPUSH", "numbers", "10", "9", "2.5", "-1", "100")
+	client.expect(":3\r\n", "SADD", "words", "pear", "apple", "fig")
+
+	// numbers sort by value, and as strings with ALPHA
+	client.expect(bulks("-1", "2.5", "9", "10", "100"), "SORT", "numbers")
+	client.expect(bulks("-1", "10", "100", "2.5", "9"), "SORT", "numbers", "ALPHA")
+	client.expect(bulks("100", "10", "9", "2.5", "-1"), "SORT", "numbers", "DESC")
+
+	// LIMIT picks a window of the sorted elements, running short at the end
+	client.expect(bulks("2.5", "9"), "SORT", "numbers", "LIMIT", "1", "2")
+	client.expect(bulks("10", "100"), "SORT", "numbers", "LIMIT", "3", "10")
+	client.expect(bulks(), "SORT", "numbers", "LIMIT", "10", "1")
+
+	// words aren't numbers
+	client.expect("-ERR One or more scores can't be converted into double\r\n", "SORT", "words")
+	client.expect(bulks("pear", "fig"), "SORT", "words", "ALPHA", "DESC", "LIMIT", "0", "2")
+
+	// the list itself is left as is
+	client.expect(bulks("10", "9", "2.5", "-1", "100"), "LRANGE", "numbers", "0", "-1")
+	client.expect(bulks(), "SORT", "missing")
+}
+
+func TestSetBitBitCount(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	// the value grows with zero bytes up to the bit set, the old bit being replied with
+	client.expect(":0\r\n", "SETBIT", "bits", "100000", "1")
+
+	if value, _, _ := kv.Get("bits"); len(value) != 12501 || strings.Count(value, "\x00") != 12500 {
+		t.Errorf("got a value of %d bytes, want 12501 of which all but the last are zero", len(value))
+	}
+
+	client.expect(":1\r\n", "GETBIT", "bits", "100000")
+	client.expect(":0\r\n", "GETBIT", "bits", "99999")
+	client.expect(":0\r\n", "GETBIT", "bits", "1000000")
+	client.expect(":1\r\n", "SETBIT", "bits", "100000", "0")
+	client.expect(":0\r\n", "BITCOUNT", "bits")
+
+	client.expect("-ERR bit offset is not an integer or out of range\r\n", "SETBIT", "bits", "-1", "1")
+	client.expect("-ERR bit is not an integer or out of range\r\n", "SETBIT", "bits", "0", "2")
+
+	// the range of BITCOUNT is in bytes, negative indexes counting from the end
+	client.expect("+OK\r\n", "SET", "key", "foobar")
+	client.expect(":26\r\n", "BITCOUNT", "key")
+	client.expect(":4\r\n", "BITCOUNT", "key", "0", "0")
+	client.expect(":6\r\n", "BITCOUNT", "key", "1", "1")
+	client.expect(":7\r\n", "BITCOUNT", "key", "-2", "-1")
+	client.expect(":0\r\n", "BITCOUNT", "key", "5", "2")
+	client.expect(":0\r\n", "BITCOUNT", "missing")
+}
+
+func TestHRandField(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	values := map[string]string{"a": "1", "b": "2"}
+	client.expect(":2\r\n", "HSET", "hash", "a", "1", "b", "2")
+
+	// a negative count repeats fields to make up the count
+	fields := client.members("HRANDFIELD", "hash", "-10")
+
+	if len(fields) != 10 || fields[0] < "a" || fields[9] > "b" {
+		t.Errorf("got %q, want 10 fields of a and b", fields)
+	}
+
+	// a positive one replies with distinct fields, no more than there are
+	if fields := client.members("HRANDFIELD", "hash", "10"); !slices.Equal(fields, []string{"a", "b"}) {
+		t.Errorf("got %q, want a and b", fields)
+	}
+
+	// WITHVALUES follows each field with its value
+	array, ok := client.do("HRANDFIELD", "hash", "-5", "WITHVALUES").(resp.Array)
+
+	if !ok || len(array.Elements) != 10 {
+		t.Fatalf("got %#v, want 5 fields along with their values", array)
+	}
+
+	for i := 0; i < len(array.Elements); i += 2 {
+		field, value := array.Elements[i].(resp.BulkString).Value, array.Elements[i+1].(resp.BulkString).Value
+
+		if values[field] != value {
+			t.Errorf("got field %q with value %q", field, value)
+		}
+	}
+
+	if field := client.do("HRANDFIELD", "hash").(resp.BulkString).Value; values[field] == "" {
+		t.Errorf("got %q, want a field of the hash", field)
+	}
+
+	client.expect(nilBulk, "HRANDFIELD", "missing")
+	client.expect(bulks(), "HRANDFIELD", "missing", "-3")
+	client.expect(bulks(), "HRANDFIELD", "hash", "0")
+}
+
+func TestHScan(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	args := []string{"HSET", "hash"}
+
+	for i := range 100 {
+		args = append(args, "field"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+
+	client.expect(":100\r\n", args...)
+
+	// scanning with a small COUNT takes several calls, which together return every field
+	seen := map[string]string{}
+	cursor, calls := "0", 0
+
+	for {
+		reply, ok := client.do("HSCAN", "hash", cursor, "COUNT", "10").(resp.Array)
+
+		if !ok || len(reply.Elements) != 2 {
+			t.Fatalf("HSCAN: got %#v, want a cursor and the fields", reply)
+		}
+
+		cursor = reply.Elements[0].(resp.BulkString).Value
+		fieldValues := reply.Elements[1].(resp.Array).Elements
+		calls++
+
+		for i := 0; i < len(fieldValues); i += 2 {
+			seen[fieldValues[i].(resp.BulkString).Value] = fieldValues[i+1].(resp.BulkString).Value
+		}
+
+		if cursor == "0" || calls > 100 {
+			break
+		}
+	}
+
+	if len(seen) != 100 || calls < 2 {
+		t.Errorf("got %d fields in %d calls, want all 100 in several", len(seen), calls)
+	}
+
+	for field, value := range seen {
+		if field != "field"+value {
+			t.Errorf("got field %q with value %q", field, value)
+		}
+	}
+
+	client.expect("*2\r\n$1\r\n0\r\n*0\r\n", "HSCAN", "missing", "0")
+}
+
+// eventually fails the test unless condition holds before testTimeout, checking it every millisecond.
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(testTimeout); !condition(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s didn't happen in time", what)
+		}
+	}
+}
+
+func TestReplicaOf(t *testing.T) {
+	leader, leaderKV := newTestInstance(t)
+	follower, followerKV := newTestInstance(t)
+
+	host, port, _ := net.SplitHostPort(serveTestInstance(t, leader, leaderKV))
+
+	writer := newTestClient(t, leader, leaderKV)
+	writer.expect("+OK\r\n", "SET", "before", "1")
+
+	client := newTestClient(t, follower, followerKV)
+	client.expect("+OK\r\n", "SET", "dropped", "1")
+	client.expect("+OK\r\n", "REPLICAOF", host, port)
+	t.Cleanup(follower.stopReplicating)
+
+	// the snapshot replaces what the replica held, then the writes that follow are streamed to it
+	eventually(t, "the sync", func() bool { return followerKV.Has("before") })
+
+	writer.expect("+OK\r\n", "SET", "after", "2")
+	eventually(t, "the SET reaching the replica", func() bool { return followerKV.Has("after") })
+
+	if followerKV.Has("dropped") {
+		t.Errorf("the replica kept a key the leader doesn't have")
+	}
+
+	// clients can only read from the replica
+	client.expect(bulk("2"), "GET", "after")
+	client.expect(resp.NewReadOnlyError().ToString(), "SET", "key", "value")
+
+	// until it stops replicating, keeping what it synced
+	client.expect("+OK\r\n", "REPLICAOF", "NO", "ONE")
+	client.expect("+OK\r\n", "SET", "key", "value")
+	client.expect(bulk("2"), "GET", "after")
+}
+
+func TestWaitForReplica(t *testing.T) {
+	leader, leaderKV := newTestInstance(t)
+	follower, followerKV := newTestInstance(t)
+
+	host, port, _ := net.SplitHostPort(serveTestInstance(t, leader, leaderKV))
+
+	newTestClient(t, follower, followerKV).expect("+OK\r\n", "REPLICAOF", host, port)
+	t.Cleanup(follower.stopReplicating)
+
+	eventually(t, "the sync", func() bool { return leader.replicas.count() == 1 })
+
+	// WAIT returns once the replica acks the write, which the leader asks it to
+	client := newTestClient(t, leader, leaderKV)
+	client.expect("+OK\r\n", "SET", "key", "value")
+	client.expect(":1\r\n", "WAIT", "1", "100")
+
+	if !followerKV.Has("key") {
+		t.Errorf("WAIT returned before the replica got the write")
+	}
+
+	// there's a single replica to wait for, so asking for two times out, counting the one there is
+	client.expect(":1\r\n", "WAIT", "2", "50")
+}
+
+// debugObject runs DEBUG OBJECT on key, returning the fields of the line replied with.
+func (c *testClient) debugObject(key string) map[string]string {
+	c.t.Helper()
+
+	reply, ok := c.do("DEBUG", "OBJECT", key).(resp.SimpleString)
+
+	if !ok || !strings.HasPrefix(reply.Value, "Value at:") {
+		c.t.Fatalf("DEBUG OBJECT %s: got %q", key, reply.Value)
+	}
+
+	fields := map[string]string{}
+
+	for _, field := range strings.Fields(reply.Value)[1:] {
+		name, value, _ := strings.Cut(field, ":")
+		fields[name] = value
+	}
+
+	return fields
+}
+
+func TestDebugObject(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "string", "value")
+	client.expect(":3\r\n", "RPUSH", "list", "a", "b", "c")
+
+	fields := client.debugObject("string")
+
+	if fields["encoding"] != "embstr" || fields["serializedlength"] == "" {
+		t.Errorf("got %v for a string, want its encoding and serialized length", fields)
+	}
+
+	if _, hasLength := fields["length"]; hasLength {
+		t.Errorf("got a length for a string")
+	}
+
+	fields = client.debugObject("list")
+
+	if fields["encoding"] != "listpack" || fields["ql_nodes"] != "1" || fields["length"] != "3" {
+		t.Errorf("got %v for a list, want a listpack of 3 elements in a node", fields)
+	}
+
+	client.expect(errorResponse(store.ErrNoSuchKey).ToString(), "DEBUG", "OBJECT", "missing")
+}
+
+func TestCommandGetKeys(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(bulks("key"), "COMMAND", "GETKEYS", "SET", "key", "value")
+	client.expect(bulks("key"), "COMMAND", "GETKEYS", "GET", "key")
+	client.expect(bulks("a", "b", "c"), "COMMAND", "GETKEYS", "MGET", "a", "b", "c")
+	client.expect(bulks("src", "dst"), "COMMAND", "GETKEYS", "LMOVE", "src", "dst", "LEFT", "RIGHT")
+
+	// MSET isn't a command of this server
+	client.expect("-ERR Invalid command specified\r\n", "COMMAND", "GETKEYS", "MSET", "a", "1", "b", "2")
+	client.expect("-ERR The command has no key arguments\r\n", "COMMAND", "GETKEYS", "PING")
+	client.expect("-ERR Invalid number of arguments specified for command\r\n", "COMMAND", "GETKEYS", "GET")
+}
+
+func TestInfoRunID(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	fields := client.info("server")
+	runID := fields["run_id"]
+
+	if len(runID) != 40 || strings.Trim(runID, "0123456789abcdef") != "" {
+		t.Errorf("got run_id:%s, want 40 hex characters", runID)
+	}
+
+	// the run ID lasts as long as the process, another instance having one of its own
+	if again := client.info("server")["run_id"]; again != runID {
+		t.Errorf("the run ID changed from %s to %s", runID, again)
+	}
+
+	other, otherKV := newTestInstance(t)
+
+	if otherID := newTestClient(t, other, otherKV).info("server")["run_id"]; otherID == runID {
+		t.Errorf("two instances got the same run ID")
+	}
+
+	// clients compare versions number by number
+	version := strings.Split(fields["redis_version"], ".")
+
+	if len(version) != 3 {
+		t.Fatalf("got redis_version:%s, want major.minor.patch", fields["redis_version"])
+	}
+
+	for _, number := range version {
+		if _, err := strconv.Atoi(number); err != nil {
+			t.Errorf("got redis_version:%s, which doesn't parse", fields["redis_version"])
+		}
+	}
+}
+
+func TestPushX(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	// a missing list isn't created
+	client.expect(":0\r\n", "LPUSHX", "list", "a")
+	client.expect(":0\r\n", "RPUSHX", "list", "a")
+	client.expect(":0\r\n", "EXISTS", "list")
+
+	client.expect(":1\r\n", "RPUSH", "list", "b")
+	client.expect(":3\r\n", "LPUSHX", "list", "a", "z")
+	client.expect(":4\r\n", "RPUSHX", "list", "c")
+	client.expect(bulks("z", "a", "b", "c"), "LRANGE", "list", "0", "-1")
+
+	client.expect("+OK\r\n", "SET", "string", "value")
+	client.expect(errorResponse(store.ErrWrongType).ToString(), "LPUSHX", "string", "a")
+}
+
+func TestLPos(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect(":8\r\n", "RPUSH", "list", "a", "b", "c", "1", "2", "3", "c", "c")
+
+	client.expect(":2\r\n", "LPOS", "list", "c")
+	client.expect(":6\r\n", "LPOS", "list", "c", "RANK", "2")
+	client.expect(nilBulk, "LPOS", "list", "c", "RANK", "4")
+
+	// a negative rank searches from the tail, the index still counting from the head
+	client.expect(":7\r\n", "LPOS", "list", "c", "RANK", "-1")
+	client.expect(":6\r\n", "LPOS", "list", "c", "RANK", "-2")
+
+	// COUNT 0 replies with every match
+	client.expect("*2\r\n:2\r\n:6\r\n", "LPOS", "list", "c", "COUNT", "2")
+	client.expect("*3\r\n:2\r\n:6\r\n:7\r\n", "LPOS", "list", "c", "COUNT", "0")
+	client.expect("*2\r\n:7\r\n:6\r\n", "LPOS", "list", "c", "RANK", "-1", "COUNT", "2")
+	client.expect("*0\r\n", "LPOS", "list", "x", "COUNT", "0")
+
+	// MAXLEN bounds how many elements are compared
+	client.expect(nilBulk, "LPOS", "list", "c", "MAXLEN", "2")
+	client.expect(nilBulk, "LPOS", "missing", "c")
+
+	if got := client.do("LPOS", "list", "c", "RANK", "0"); !resp.IsError(got) {
+		t.Errorf("got %q for RANK 0, want an error", got.ToString())
+	}
+}
+
+func TestBitOpBitPos(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "a", "\xff\xf0")
+	client.expect("+OK\r\n", "SET", "b", "\x0f")
+
+	// the shorter key counts as zero bytes past its end
+	client.expect(":2\r\n", "BITOP", "AND", "dst", "a", "b")
+	client.expect(bulk("\x0f\x00"), "GET", "dst")
+	client.expect(":2\r\n", "BITOP", "OR", "dst", "a", "b")
+	client.expect(bulk("\xff\xf0"), "GET", "dst")
+	client.expect(":2\r\n", "BITOP", "NOT", "dst", "a")
+	client.expect(bulk("\x00\x0f"), "GET", "dst")
+
+	// BITPOS counts from the most significant bit of the first byte
+	client.expect(":4\r\n", "BITPOS", "b", "1")
+	client.expect(":12\r\n", "BITPOS", "a", "0")
+	client.expect(":12\r\n", "BITPOS", "dst", "1", "1")
+
+	// a string of ones has a zero just past its end, unless the range ends it
+	client.expect("+OK\r\n", "SET", "ones", "\xff\xff")
+	client.expect(":16\r\n", "BITPOS", "ones", "0")
+	client.expect(":-1\r\n", "BITPOS", "ones", "0", "0", "-1")
+
+	client.expect(":-1\r\n", "BITPOS", "missing", "1")
+	client.expect(":0\r\n", "BITPOS", "missing", "0")
+}
+
+func TestGetEx(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+OK\r\n", "SET", "key", "value")
+
+	// no option is a plain GET
+	client.expect(bulk("value"), "GETEX", "key")
+	client.expect(":-1\r\n", "TTL", "key")
+
+	client.expect(bulk("value"), "GETEX", "key", "EX", "100")
+
+	if ttl := client.do("TTL", "key").ToString(); ttl != ":100\r\n" && ttl != ":99\r\n" {
+		t.Errorf("got TTL %q after GETEX EX 100, want 100s", ttl)
+	}
+
+	client.expect(bulk("value"), "GETEX", "key", "PERSIST")
+	client.expect(":-1\r\n", "TTL", "key")
+
+	client.expect(bulk("value"), "GETEX", "key", "PXAT", strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10))
+
+	if ttl := client.do("TTL", "key").ToString(); ttl != ":3600\r\n" && ttl != ":3599\r\n" {
+		t.Errorf("got TTL %q after GETEX PXAT in an hour, want 3600s", ttl)
+	}
+
+	client.expect(nilBulk, "GETEX", "missing", "EX", "100")
+	client.expect(":0\r\n", "EXISTS", "missing")
+	client.expect("-ERR invalid expire time in 'getex' command\r\n", "GETEX", "key", "EX", "0")
+}
+
+func TestCommandInfo(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	get := "*6\r\n$3\r\nget\r\n:2\r\n*1\r\n+readonly\r\n:1\r\n:1\r\n:1\r\n"
+	set := "*6\r\n$3\r\nset\r\n:3\r\n*1\r\n+write\r\n:1\r\n:1\r\n:1\r\n"
+
+	client.expect("*1\r\n"+get, "COMMAND", "INFO", "get")
+
+	// names are case insensitive, and an unknown command gets a nil of its own
+	client.expect("*3\r\n"+set+nilBulk+get, "COMMAND", "INFO", "SET", "nosuchcommand", "Get")
+}
+
+func TestPingSubscribed(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+
+	client.expect("+PONG\r\n", "PING")
+	client.expect(bulk("hello"), "PING", "hello")
+
+	client.expect("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n", "SUBSCRIBE", "news")
+
+	// once subscribed, PING replies the way pushed messages are framed
+	client.expect(bulks("pong", ""), "PING")
+	client.expect(bulks("pong", "hello"), "PING", "hello")
+
+	client.expect("-ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n", "GET", "key")
+
+	// leaving subscribe mode brings the plain reply back
+	client.expect("*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:0\r\n", "UNSUBSCRIBE", "news")
+	client.expect("+PONG\r\n", "PING")
+}
+
+func TestUnsubscribe(t *testing.T) {
+	instance, kv := newTestInstance(t)
+	client := newTestClient(t, instance, kv)
+	publisher := newTestClient(t, instance, kv)
+
+	// every channel gets a reply of its own, with the count of subscriptions left
+	client.send("SUBSCRIBE", "a", "b", "c")
+	client.reply()
+	client.read()
+	client.read()
+
+	client.expect("*3\r\n$10\r\npsubscribe\r\n$1\r\n*\r\n:4\r\n", "PSUBSCRIBE", "*")
+	client.expect("*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:3\r\n", "UNSUBSCRIBE", "a")
+
+	// a channel not subscribed to is replied to all the same
+	client.expect("*3\r\n$11\r\nunsubscribe\r\n$1\r\nx\r\n:3\r\n", "UNSUBSCRIBE", "x")
+
+	// no argument unsubscribes from every channel, leaving the pattern
+	client.send("UNSUBSCRIBE")
+
+	channels := []string{}
+
+	for i, reply := range []resp.Response{client.reply(), client.read()} {
+		array := reply.(resp.Array).Elements
+		channels = append(channels, array[1].(resp.BulkString).Value)
+
+		if array[0].(resp.BulkString).Value != "unsubscribe" || array[2].(resp.Integer).Value != 2-i {
+			t.Errorf("got %q, want an unsubscribe reply counting %d subscriptions left", reply.ToString(), 2-i)
+		}
+	}
+
+	slices.Sort(channels)
+
+	if !slices.Equal(channels, []string{"b", "c"}) {
+		t.Errorf("got %q unsubscribed from, want b and c", channels)
+	}
+
+	publisher.expect("*2\r\n$1\r\nb\r\n:0\r\n", "PUBSUB", "NUMSUB", "b")
+
+	client.expect("*3\r\n$12\r\npunsubscribe\r\n$1\r\n*\r\n:0\r\n", "PUNSUBSCRIBE")
+
+	// with nothing left, the client is out of subscribe mode, and unsubscribing replies with no channel
+	client.expect("+PONG\r\n", "PING")
+	client.expect("*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n", "UNSUBSCRIBE")
+}
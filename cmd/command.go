@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// COMMAND looks commands up in handlers: registering it along with the other handlers
+// would make their initialization depend on itself.
+func init() {
+	handlers[CommandCommand] = HandleCommandCommand
+}
+
+// commandFlags returns the flags COMMAND INFO reports for a command.
+func commandFlags(command Command) []string {
+	var flags []string
+
+	switch {
+	case writeCommands[command]:
+		flags = append(flags, "write")
+	case keySpecs[command] != keySpec{}:
+		flags = append(flags, "readonly")
+	}
+
+	switch command {
+	case SubscribeCommand, UnsubscribeCommand, PSubscribeCommand, PUnsubscribeCommand, PublishCommand, PubSubCommand:
+		flags = append(flags, "pubsub")
+	}
+
+	return flags
+}
+
+// newCommandInfo describes a command as COMMAND INFO does: its name, arity, flags and key positions,
+// or nil if there's no such command.
+func newCommandInfo(command Command) resp.Response {
+	if _, exists := handlers[command]; !exists {
+		return resp.NewNullBulkString()
+	}
+
+	flags := commandFlags(command)
+	flagsSlice := make([]resp.Response, len(flags))
+
+	for i, flag := range flags {
+		flagsSlice[i] = resp.NewSimpleString(flag)
+	}
+
+	spec := keySpecs[command]
+
+	return resp.NewArray([]resp.Response{
+		resp.NewBulkString(command),
+		resp.NewInteger(arities[command]),
+		resp.NewArray(flagsSlice),
+		resp.NewInteger(spec.first),
+		resp.NewInteger(spec.last),
+		resp.NewInteger(spec.step),
+	})
+}
+
+var HandleCommandCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 1 {
+		return resp.NewError("wrong number of arguments for 'command' command")
+	}
+
+	subcommand := strings.ToLower(args[0])
+
+	switch {
+	case subcommand == "info":
+		commands := args[1:]
+
+		// without names, every command is described
+		if len(commands) == 0 {
+			commands = slices.Sorted(maps.Keys(handlers))
+		}
+
+		responseSlice := make([]resp.Response, len(commands))
+
+		for i, command := range commands {
+			responseSlice[i] = newCommandInfo(strings.ToLower(command))
+		}
+
+		return resp.NewArray(responseSlice)
+
+	case subcommand == "getkeys" && len(args) >= 2:
+		command := strings.ToLower(args[1])
+
+		if _, exists := handlers[command]; !exists {
+			return resp.NewError("Invalid command specified")
+		}
+
+		spec, hasKeys := keySpecs[command]
+
+		if !hasKeys {
+			return resp.NewError("The command has no key arguments")
+		}
+
+		keys, valid := spec.keys(args[1:])
+
+		if !valid {
+			return resp.NewError("Invalid number of arguments specified for command")
+		}
+
+		return newBulkStringArray(keys)
+	}
+
+	return resp.NewError(
+		fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'", args[0]),
+	)
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// configParameter is a setting exposed through CONFIG GET and CONFIG SET.
+// they are applied to the instance or the store straight away, so changes take effect live.
+type configParameter struct {
+	get func(instance *Instance, kv *store.KVStore) string
+	set func(instance *Instance, kv *store.KVStore, value string) error
+}
+
+// configParameters are named like the command line flags setting them on startup.
+var configParameters = map[string]configParameter{
+	"maxkeys": {
+		get: func(instance *Instance, kv *store.KVStore) string {
+			return strconv.Itoa(kv.MaxKeys())
+		},
+		set: func(instance *Instance, kv *store.KVStore, value string) error {
+			maxKeys, err := strconv.Atoi(value)
+
+			if err != nil || maxKeys < 0 {
+				return errors.New("argument must be a non-negative integer")
+			}
+
+			kv.SetMaxKeys(maxKeys)
+			return nil
+		},
+	},
+	"maxkeys-policy": {
+		get: func(instance *Instance, kv *store.KVStore) string {
+			return kv.EvictionPolicy().String()
+		},
+		set: func(instance *Instance, kv *store.KVStore, value string) error {
+			policy, ok := store.ParseEvictionPolicy(strings.ToLower(value))
+
+			if !ok {
+				return errors.New("argument(s) must be one of the following: noeviction, allkeys-lru, allkeys-random, volatile-ttl, allkeys-lfu, volatile-lfu")
+			}
+
+			kv.SetEvictionPolicy(policy)
+			return nil
+		},
+	},
+	"gc-interval": {
+		get: func(instance *Instance, kv *store.KVStore) string {
+			return kv.GCInterval().String()
+		},
+		set: func(instance *Instance, kv *store.KVStore, value string) error {
+			interval, err := time.ParseDuration(value)
+
+			if err != nil {
+				return errors.New("argument must be a duration, e.g. 500ms")
+			}
+
+			return kv.SetGCInterval(interval)
+		},
+	},
+	"ttl-jitter": {
+		get: func(instance *Instance, kv *store.KVStore) string {
+			return kv.TTLJitter().String()
+		},
+		set: func(instance *Instance, kv *store.KVStore, value string) error {
+			jitter, err := time.ParseDuration(value)
+
+			if err != nil || jitter < 0 {
+				return errors.New("argument must be a non-negative duration, e.g. 500ms")
+			}
+
+			kv.SetTTLJitter(jitter)
+			return nil
+		},
+	},
+	"notify-keyspace-events": {
+		get: func(instance *Instance, kv *store.KVStore) string {
+			return notifyClass(instance.notifyClasses.Load()).String()
+		},
+		set: func(instance *Instance, kv *store.KVStore, value string) error {
+			classes, err := parseNotifyClasses(value)
+
+			if err != nil {
+				return err
+			}
+
+			instance.notifyClasses.Store(int32(classes))
+			return nil
+		},
+	},
+}
+
+var HandleConfigCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 1 {
+		return resp.NewError("wrong number of arguments for 'config' command")
+	}
+
+	// CONFIG SET changing several parameters must not interleave with another one
+	client.instance.configMutex.Lock()
+	defer client.instance.configMutex.Unlock()
+
+	subcommand := strings.ToLower(args[0])
+
+	switch {
+	case subcommand == "get" && len(args) >= 2:
+		return handleConfigGet(client.instance, args[1:], kv)
+
+	case subcommand == "set" && len(args) >= 3 && len(args[1:])%2 == 0:
+		return handleConfigSet(client.instance, args[1:], kv)
+	}
+
+	return resp.NewError(
+		fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'", args[0]),
+	)
+}
+
+// handleConfigGet returns the name and value of every parameter matching one of patterns.
+func handleConfigGet(instance *Instance, patterns []string, kv *store.KVStore) resp.Response {
+	names := make([]string, 0, len(configParameters))
+
+	for name := range configParameters {
+		for _, pattern := range patterns {
+			if store.Match(strings.ToLower(pattern), name) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+
+	slices.Sort(names)
+
+	pairs := make([]string, 0, len(names)*2)
+
+	for _, name := range names {
+		pairs = append(pairs, name, configParameters[name].get(instance, kv))
+	}
+
+	return newBulkStringArray(pairs)
+}
+
+// handleConfigSet sets every parameter of a list of name/value pairs.
+// names are all checked before any is set, so an unknown one leaves the others alone.
+func handleConfigSet(instance *Instance, pairs []string, kv *store.KVStore) resp.Response {
+	for i := 0; i < len(pairs); i += 2 {
+		if _, exists := configParameters[strings.ToLower(pairs[i])]; !exists {
+			return resp.NewError(
+				fmt.Sprintf("Unknown option or number of arguments for CONFIG SET - '%s'", pairs[i]),
+			)
+		}
+	}
+
+	for i := 0; i < len(pairs); i += 2 {
+		name, value := strings.ToLower(pairs[i]), pairs[i+1]
+
+		if err := configParameters[name].set(instance, kv, value); err != nil {
+			return resp.NewError(
+				fmt.Sprintf("CONFIG SET failed (possibly related to argument '%s') - %s", name, err.Error()),
+			)
+		}
+	}
+
+	return resp.NewOKResponse()
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+var HandleDumpCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 1 {
+		return resp.NewError("wrong number of arguments for 'dump' command")
+	}
+
+	data, exists := kv.Dump(args[0])
+
+	if !exists {
+		return resp.NewNullBulkString()
+	}
+
+	return resp.NewBulkString(string(data))
+}
+
+// restoreArgs are the arguments of RESTORE key ttl serialized-value [REPLACE] [ABSTTL].
+type restoreArgs struct {
+	key     string
+	ttl     int64
+	data    string
+	replace bool
+
+	// ttl is a unix time in milliseconds rather than a number of milliseconds from now
+	absTTL bool
+}
+
+func parseRestoreArgs(args []string) (restoreArgs, resp.Response) {
+	if len(args) < 3 {
+		return restoreArgs{}, resp.NewError("wrong number of arguments for 'restore' command")
+	}
+
+	ttl, err := strconv.ParseInt(args[1], 10, 64)
+
+	if err != nil {
+		return restoreArgs{}, resp.NewError("value is not an integer or out of range")
+	}
+
+	if ttl < 0 {
+		return restoreArgs{}, resp.NewError("Invalid TTL value, must be >= 0")
+	}
+
+	parsed := restoreArgs{key: args[0], ttl: ttl, data: args[2]}
+
+	for _, option := range args[3:] {
+		switch strings.ToLower(option) {
+		case "replace":
+			parsed.replace = true
+		case "absttl":
+			parsed.absTTL = true
+		default:
+			return restoreArgs{}, resp.NewError("syntax error")
+		}
+	}
+
+	return parsed, nil
+}
+
+// expiry returns the time the restored key expires at, the zero time for a TTL of 0.
+func (r restoreArgs) expiry() time.Time {
+	switch {
+	case r.ttl == 0:
+		return time.Time{}
+	case r.absTTL:
+		return time.UnixMilli(r.ttl)
+	}
+
+	return time.Now().Add(time.Duration(r.ttl) * time.Millisecond)
+}
+
+var HandleRestoreCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	restore, errResponse := parseRestoreArgs(args)
+
+	if errResponse != nil {
+		return errResponse
+	}
+
+	if err := kv.Restore(restore.key, []byte(restore.data), restore.expiry(), restore.replace); err != nil {
+		return errorResponse(err)
+	}
+
+	client.instance.notify(notifyGeneric, "restore", restore.key)
+
+	return resp.NewOKResponse()
+}
@@ -1,85 +1,734 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"log"
-	"net"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/henilmalaviya/redig/resp"
 	"github.com/henilmalaviya/redig/store"
 )
 
 type Command = string
-type CommandHandler func(conn net.Conn, args []string, kv *store.KVStore) resp.Response
+type CommandHandler func(client *Client, args []string, kv *store.KVStore) resp.Response
 
 const (
-	SetCommand     Command = "set"
-	GetCommand     Command = "get"
-	PingCommand    Command = "ping"
-	DelCommand     Command = "del"
-	ExistsCommand  Command = "exists"
-	IncrCommand    Command = "incr"
-	DecrCommand    Command = "decr"
-	KeysCommand    Command = "keys"
-	ExpireCommand  Command = "expire"
-	TTLCommand     Command = "ttl"
-	PersistCommand Command = "persist"
-	MGetCommand    Command = "mget"
-	GetDelCommand  Command = "getdel"
+	SetCommand       Command = "set"
+	GetCommand       Command = "get"
+	PingCommand      Command = "ping"
+	DelCommand       Command = "del"
+	ExistsCommand    Command = "exists"
+	IncrCommand      Command = "incr"
+	DecrCommand      Command = "decr"
+	KeysCommand      Command = "keys"
+	ExpireCommand    Command = "expire"
+	PExpireAtCommand Command = "pexpireat"
+	TTLCommand       Command = "ttl"
+	PersistCommand   Command = "persist"
+	MGetCommand      Command = "mget"
+	GetDelCommand    Command = "getdel"
+	GetExCommand     Command = "getex"
+	EchoCommand      Command = "echo"
+	QuitCommand      Command = "quit"
+	ObjectCommand    Command = "object"
+	ResetCommand     Command = "reset"
+	MonitorCommand   Command = "monitor"
+	DumpCommand      Command = "dump"
+	RestoreCommand   Command = "restore"
+	MigrateCommand   Command = "migrate"
+	SortCommand      Command = "sort"
+	SetBitCommand    Command = "setbit"
+	GetBitCommand    Command = "getbit"
+	BitCountCommand  Command = "bitcount"
+	BitPosCommand    Command = "bitpos"
+	BitOpCommand     Command = "bitop"
+	PSyncCommand     Command = "psync"
+	ReplConfCommand  Command = "replconf"
+	ReplicaOfCommand Command = "replicaof"
+	SlaveOfCommand   Command = "slaveof"
+	CommandCommand   Command = "command"
+
+	LPushCommand     Command = "lpush"
+	RPushCommand     Command = "rpush"
+	LPushXCommand    Command = "lpushx"
+	RPushXCommand    Command = "rpushx"
+	LPopCommand      Command = "lpop"
+	RPopCommand      Command = "rpop"
+	LRangeCommand    Command = "lrange"
+	LLenCommand      Command = "llen"
+	LIndexCommand    Command = "lindex"
+	LPosCommand      Command = "lpos"
+	LSetCommand      Command = "lset"
+	LInsertCommand   Command = "linsert"
+	LRemCommand      Command = "lrem"
+	LTrimCommand     Command = "ltrim"
+	RPopLPushCommand Command = "rpoplpush"
+	LMoveCommand     Command = "lmove"
+	BLPopCommand     Command = "blpop"
+	BRPopCommand     Command = "brpop"
+
+	HSetCommand       Command = "hset"
+	HGetCommand       Command = "hget"
+	HDelCommand       Command = "hdel"
+	HGetAllCommand    Command = "hgetall"
+	HKeysCommand      Command = "hkeys"
+	HValsCommand      Command = "hvals"
+	HLenCommand       Command = "hlen"
+	HExistsCommand    Command = "hexists"
+	HMGetCommand      Command = "hmget"
+	HSetNXCommand     Command = "hsetnx"
+	HRandFieldCommand Command = "hrandfield"
+	HScanCommand      Command = "hscan"
+
+	SAddCommand        Command = "sadd"
+	SRemCommand        Command = "srem"
+	SMembersCommand    Command = "smembers"
+	SIsMemberCommand   Command = "sismember"
+	SCardCommand       Command = "scard"
+	SInterCommand      Command = "sinter"
+	SUnionCommand      Command = "sunion"
+	SDiffCommand       Command = "sdiff"
+	SInterStoreCommand Command = "sinterstore"
+	SUnionStoreCommand Command = "sunionstore"
+	SDiffStoreCommand  Command = "sdiffstore"
+	SMIsMemberCommand  Command = "smismember"
+	SMoveCommand       Command = "smove"
+	SScanCommand       Command = "sscan"
+
+	ZAddCommand          Command = "zadd"
+	ZScoreCommand        Command = "zscore"
+	ZCardCommand         Command = "zcard"
+	ZRemCommand          Command = "zrem"
+	ZRangeCommand        Command = "zrange"
+	ZRevRangeCommand     Command = "zrevrange"
+	ZRangeByScoreCommand Command = "zrangebyscore"
+	ZCountCommand        Command = "zcount"
+	ZIncrByCommand       Command = "zincrby"
+	ZRankCommand         Command = "zrank"
+	ZPopMinCommand       Command = "zpopmin"
+	ZPopMaxCommand       Command = "zpopmax"
+	ZScanCommand         Command = "zscan"
+
+	SubscribeCommand    Command = "subscribe"
+	UnsubscribeCommand  Command = "unsubscribe"
+	PublishCommand      Command = "publish"
+	PSubscribeCommand   Command = "psubscribe"
+	PUnsubscribeCommand Command = "punsubscribe"
+	PubSubCommand       Command = "pubsub"
+
+	MultiCommand   Command = "multi"
+	ExecCommand    Command = "exec"
+	DiscardCommand Command = "discard"
+	WatchCommand   Command = "watch"
+	UnwatchCommand Command = "unwatch"
+
+	TimeCommand   Command = "time"
+	InfoCommand   Command = "info"
+	ConfigCommand Command = "config"
+	ClientCommand Command = "client"
+	MemoryCommand Command = "memory"
+	DebugCommand  Command = "debug"
+	WaitCommand   Command = "wait"
+
+	ShutdownCommand Command = "shutdown"
+
+	SaveCommand     Command = "save"
+	BgSaveCommand   Command = "bgsave"
+	LastSaveCommand Command = "lastsave"
 )
 
 var handlers = map[string]CommandHandler{
-	SetCommand:     HandleSetCommand,
-	GetCommand:     HandleGetCommand,
-	PingCommand:    HandlePingCommand,
-	DelCommand:     HandleDelCommand,
-	ExistsCommand:  HandleExistsCommand,
-	IncrCommand:    HandleIncrCommand,
-	DecrCommand:    HandleDecrCommand,
-	KeysCommand:    HandleKeysCommand,
-	ExpireCommand:  HandleExpireCommand,
-	TTLCommand:     HandleTTLCommand,
-	PersistCommand: HandlePersistCommand,
-	MGetCommand:    HandleMGetCommand,
-	GetDelCommand:  HandleGetDelCommand,
+	SetCommand:       HandleSetCommand,
+	GetCommand:       HandleGetCommand,
+	PingCommand:      HandlePingCommand,
+	DelCommand:       HandleDelCommand,
+	ExistsCommand:    HandleExistsCommand,
+	IncrCommand:      HandleIncrCommand,
+	DecrCommand:      HandleDecrCommand,
+	KeysCommand:      HandleKeysCommand,
+	ExpireCommand:    HandleExpireCommand,
+	PExpireAtCommand: HandlePExpireAtCommand,
+	TTLCommand:       HandleTTLCommand,
+	PersistCommand:   HandlePersistCommand,
+	MGetCommand:      HandleMGetCommand,
+	GetDelCommand:    HandleGetDelCommand,
+	GetExCommand:     HandleGetExCommand,
+	EchoCommand:      HandleEchoCommand,
+	QuitCommand:      HandleQuitCommand,
+	ObjectCommand:    HandleObjectCommand,
+	ResetCommand:     HandleResetCommand,
+	MonitorCommand:   HandleMonitorCommand,
+	DumpCommand:      HandleDumpCommand,
+	RestoreCommand:   HandleRestoreCommand,
+	MigrateCommand:   HandleMigrateCommand,
+	SortCommand:      HandleSortCommand,
+	SetBitCommand:    HandleSetBitCommand,
+	GetBitCommand:    HandleGetBitCommand,
+	BitCountCommand:  HandleBitCountCommand,
+	BitPosCommand:    HandleBitPosCommand,
+	BitOpCommand:     HandleBitOpCommand,
+	PSyncCommand:     HandlePSyncCommand,
+	ReplConfCommand:  HandleReplConfCommand,
+
+	LPushCommand:     HandleLPushCommand,
+	RPushCommand:     HandleRPushCommand,
+	LPushXCommand:    HandleLPushXCommand,
+	RPushXCommand:    HandleRPushXCommand,
+	LPopCommand:      HandleLPopCommand,
+	RPopCommand:      HandleRPopCommand,
+	LRangeCommand:    HandleLRangeCommand,
+	LLenCommand:      HandleLLenCommand,
+	LIndexCommand:    HandleLIndexCommand,
+	LPosCommand:      HandleLPosCommand,
+	LSetCommand:      HandleLSetCommand,
+	LInsertCommand:   HandleLInsertCommand,
+	LRemCommand:      HandleLRemCommand,
+	LTrimCommand:     HandleLTrimCommand,
+	RPopLPushCommand: HandleRPopLPushCommand,
+	LMoveCommand:     HandleLMoveCommand,
+	BLPopCommand:     HandleBLPopCommand,
+	BRPopCommand:     HandleBRPopCommand,
+
+	HSetCommand:       HandleHSetCommand,
+	HGetCommand:       HandleHGetCommand,
+	HDelCommand:       HandleHDelCommand,
+	HGetAllCommand:    HandleHGetAllCommand,
+	HKeysCommand:      HandleHKeysCommand,
+	HValsCommand:      HandleHValsCommand,
+	HLenCommand:       HandleHLenCommand,
+	HExistsCommand:    HandleHExistsCommand,
+	HMGetCommand:      HandleHMGetCommand,
+	HSetNXCommand:     HandleHSetNXCommand,
+	HRandFieldCommand: HandleHRandFieldCommand,
+	HScanCommand:      HandleHScanCommand,
+
+	SAddCommand:        HandleSAddCommand,
+	SRemCommand:        HandleSRemCommand,
+	SMembersCommand:    HandleSMembersCommand,
+	SIsMemberCommand:   HandleSIsMemberCommand,
+	SCardCommand:       HandleSCardCommand,
+	SInterCommand:      HandleSInterCommand,
+	SUnionCommand:      HandleSUnionCommand,
+	SDiffCommand:       HandleSDiffCommand,
+	SInterStoreCommand: HandleSInterStoreCommand,
+	SUnionStoreCommand: HandleSUnionStoreCommand,
+	SDiffStoreCommand:  HandleSDiffStoreCommand,
+	SMIsMemberCommand:  HandleSMIsMemberCommand,
+	SMoveCommand:       HandleSMoveCommand,
+	SScanCommand:       HandleSScanCommand,
+
+	ZAddCommand:          HandleZAddCommand,
+	ZScoreCommand:        HandleZScoreCommand,
+	ZCardCommand:         HandleZCardCommand,
+	ZRemCommand:          HandleZRemCommand,
+	ZRangeCommand:        HandleZRangeCommand,
+	ZRevRangeCommand:     HandleZRevRangeCommand,
+	ZRangeByScoreCommand: HandleZRangeByScoreCommand,
+	ZCountCommand:        HandleZCountCommand,
+	ZIncrByCommand:       HandleZIncrByCommand,
+	ZRankCommand:         HandleZRankCommand,
+	ZPopMinCommand:       HandleZPopMinCommand,
+	ZPopMaxCommand:       HandleZPopMaxCommand,
+	ZScanCommand:         HandleZScanCommand,
+
+	SubscribeCommand:    HandleSubscribeCommand,
+	UnsubscribeCommand:  HandleUnsubscribeCommand,
+	PublishCommand:      HandlePublishCommand,
+	PSubscribeCommand:   HandlePSubscribeCommand,
+	PUnsubscribeCommand: HandlePUnsubscribeCommand,
+	PubSubCommand:       HandlePubSubCommand,
+
+	MultiCommand:   HandleMultiCommand,
+	ExecCommand:    HandleExecCommand,
+	DiscardCommand: HandleDiscardCommand,
+	WatchCommand:   HandleWatchCommand,
+	UnwatchCommand: HandleUnwatchCommand,
+
+	TimeCommand:   HandleTimeCommand,
+	InfoCommand:   HandleInfoCommand,
+	ConfigCommand: HandleConfigCommand,
+	ClientCommand: HandleClientCommand,
+	MemoryCommand: HandleMemoryCommand,
+	DebugCommand:  HandleDebugCommand,
+	WaitCommand:   HandleWaitCommand,
+
+	ShutdownCommand: HandleShutdownCommand,
+
+	SaveCommand:     HandleSaveCommand,
+	BgSaveCommand:   HandleBgSaveCommand,
+	LastSaveCommand: HandleLastSaveCommand,
 }
 
-func HandleMessage(conn net.Conn, incoming string, kv *store.KVStore) {
-	log.Printf("Message received: %s\n", incoming)
+// transactionCommands run straight away rather than being queued inside MULTI.
+var transactionCommands = map[Command]bool{
+	MultiCommand:   true,
+	ExecCommand:    true,
+	DiscardCommand: true,
+	WatchCommand:   true,
+	QuitCommand:    true,
+	ResetCommand:   true,
+}
+
+// subscribedCommands are the only commands a client subscribed to channels or patterns may run.
+var subscribedCommands = map[Command]bool{
+	SubscribeCommand:    true,
+	UnsubscribeCommand:  true,
+	PSubscribeCommand:   true,
+	PUnsubscribeCommand: true,
+	PingCommand:         true,
+	QuitCommand:         true,
+	ResetCommand:        true,
+}
+
+// writeCommands change the data, and are logged to the AOF.
+var writeCommands = map[Command]bool{
+	SetCommand:       true,
+	DelCommand:       true,
+	IncrCommand:      true,
+	DecrCommand:      true,
+	ExpireCommand:    true,
+	PExpireAtCommand: true,
+	PersistCommand:   true,
+	GetDelCommand:    true,
+	GetExCommand:     true,
+	RestoreCommand:   true,
+	MigrateCommand:   true,
+	SetBitCommand:    true,
+	BitOpCommand:     true,
+
+	LPushCommand:     true,
+	RPushCommand:     true,
+	LPushXCommand:    true,
+	RPushXCommand:    true,
+	LPopCommand:      true,
+	RPopCommand:      true,
+	LSetCommand:      true,
+	LInsertCommand:   true,
+	LRemCommand:      true,
+	LTrimCommand:     true,
+	RPopLPushCommand: true,
+	LMoveCommand:     true,
+	BLPopCommand:     true,
+	BRPopCommand:     true,
+
+	HSetCommand:   true,
+	HDelCommand:   true,
+	HSetNXCommand: true,
+
+	SAddCommand:        true,
+	SRemCommand:        true,
+	SInterStoreCommand: true,
+	SUnionStoreCommand: true,
+	SDiffStoreCommand:  true,
+	SMoveCommand:       true,
+
+	ZAddCommand:    true,
+	ZRemCommand:    true,
+	ZIncrByCommand: true,
+	ZPopMinCommand: true,
+	ZPopMaxCommand: true,
+}
+
+// arities are the number of arguments of every command, counting its name: a negative arity
+// is the least number of arguments the command takes, rather than the exact one.
+var arities = map[Command]int{
+	SetCommand:       3,
+	GetCommand:       2,
+	PingCommand:      -1,
+	DelCommand:       -2,
+	ExistsCommand:    -2,
+	IncrCommand:      2,
+	DecrCommand:      2,
+	KeysCommand:      -2,
+	ExpireCommand:    3,
+	PExpireAtCommand: 3,
+	TTLCommand:       2,
+	PersistCommand:   2,
+	MGetCommand:      -2,
+	GetDelCommand:    2,
+	GetExCommand:     -2,
+	EchoCommand:      2,
+	QuitCommand:      -1,
+	ObjectCommand:    3,
+	ResetCommand:     1,
+	MonitorCommand:   1,
+	DumpCommand:      2,
+	RestoreCommand:   -4,
+	MigrateCommand:   -6,
+	SortCommand:      -2,
+	SetBitCommand:    4,
+	GetBitCommand:    3,
+	BitCountCommand:  -2,
+	BitPosCommand:    -3,
+	BitOpCommand:     -4,
+	PSyncCommand:     3,
+	ReplConfCommand:  -3,
+	ReplicaOfCommand: 3,
+	SlaveOfCommand:   3,
+	CommandCommand:   -2,
+
+	LPushCommand:     -3,
+	RPushCommand:     -3,
+	LPushXCommand:    -3,
+	RPushXCommand:    -3,
+	LPopCommand:      -2,
+	RPopCommand:      -2,
+	LRangeCommand:    4,
+	LLenCommand:      2,
+	LIndexCommand:    3,
+	LPosCommand:      -3,
+	LSetCommand:      4,
+	LInsertCommand:   5,
+	LRemCommand:      4,
+	LTrimCommand:     4,
+	RPopLPushCommand: 3,
+	LMoveCommand:     5,
+	BLPopCommand:     -3,
+	BRPopCommand:     -3,
+
+	HSetCommand:       -4,
+	HGetCommand:       3,
+	HDelCommand:       -3,
+	HGetAllCommand:    2,
+	HKeysCommand:      2,
+	HValsCommand:      2,
+	HLenCommand:       2,
+	HExistsCommand:    3,
+	HMGetCommand:      -3,
+	HSetNXCommand:     4,
+	HRandFieldCommand: -2,
+	HScanCommand:      -3,
+
+	SAddCommand:        -3,
+	SRemCommand:        -3,
+	SMembersCommand:    2,
+	SIsMemberCommand:   3,
+	SCardCommand:       2,
+	SInterCommand:      -2,
+	SUnionCommand:      -2,
+	SDiffCommand:       -2,
+	SInterStoreCommand: -3,
+	SUnionStoreCommand: -3,
+	SDiffStoreCommand:  -3,
+	SMIsMemberCommand:  -3,
+	SMoveCommand:       4,
+	SScanCommand:       -3,
+
+	ZAddCommand:          -4,
+	ZScoreCommand:        3,
+	ZCardCommand:         2,
+	ZRemCommand:          -3,
+	ZRangeCommand:        -4,
+	ZRevRangeCommand:     -4,
+	ZRangeByScoreCommand: -4,
+	ZCountCommand:        4,
+	ZIncrByCommand:       4,
+	ZRankCommand:         3,
+	ZPopMinCommand:       -2,
+	ZPopMaxCommand:       -2,
+	ZScanCommand:         -3,
+
+	SubscribeCommand:    -2,
+	UnsubscribeCommand:  -1,
+	PublishCommand:      3,
+	PSubscribeCommand:   -2,
+	PUnsubscribeCommand: -1,
+	PubSubCommand:       -2,
+
+	MultiCommand:   1,
+	ExecCommand:    1,
+	DiscardCommand: 1,
+	WatchCommand:   -2,
+	UnwatchCommand: 1,
+
+	TimeCommand:   1,
+	InfoCommand:   -1,
+	ConfigCommand: -2,
+	ClientCommand: -2,
+	MemoryCommand: -2,
+	DebugCommand:  -2,
+	WaitCommand:   3,
+
+	ShutdownCommand: -1,
+
+	SaveCommand:     1,
+	BgSaveCommand:   1,
+	LastSaveCommand: 1,
+}
+
+// keySpec tells where the keys are among the arguments of a command, counting its name as position 0:
+// every step-th argument from first to last, a negative last counting back from the final argument.
+type keySpec struct {
+	first int
+	last  int
+	step  int
+}
+
+// keySpecs are the key positions of the commands taking keys.
+var keySpecs = map[Command]keySpec{
+	SetCommand:       {1, 1, 1},
+	GetCommand:       {1, 1, 1},
+	DelCommand:       {1, -1, 1},
+	ExistsCommand:    {1, -1, 1},
+	IncrCommand:      {1, 1, 1},
+	DecrCommand:      {1, 1, 1},
+	ExpireCommand:    {1, 1, 1},
+	PExpireAtCommand: {1, 1, 1},
+	TTLCommand:       {1, 1, 1},
+	PersistCommand:   {1, 1, 1},
+	MGetCommand:      {1, -1, 1},
+	GetDelCommand:    {1, 1, 1},
+	GetExCommand:     {1, 1, 1},
+	ObjectCommand:    {2, 2, 1},
+	DumpCommand:      {1, 1, 1},
+	RestoreCommand:   {1, 1, 1},
+	MigrateCommand:   {3, 3, 1},
+	SortCommand:      {1, 1, 1},
+	SetBitCommand:    {1, 1, 1},
+	GetBitCommand:    {1, 1, 1},
+	BitCountCommand:  {1, 1, 1},
+	BitPosCommand:    {1, 1, 1},
+	BitOpCommand:     {2, -1, 1},
+
+	LPushCommand:     {1, 1, 1},
+	RPushCommand:     {1, 1, 1},
+	LPushXCommand:    {1, 1, 1},
+	RPushXCommand:    {1, 1, 1},
+	LPopCommand:      {1, 1, 1},
+	RPopCommand:      {1, 1, 1},
+	LRangeCommand:    {1, 1, 1},
+	LLenCommand:      {1, 1, 1},
+	LIndexCommand:    {1, 1, 1},
+	LPosCommand:      {1, 1, 1},
+	LSetCommand:      {1, 1, 1},
+	LInsertCommand:   {1, 1, 1},
+	LRemCommand:      {1, 1, 1},
+	LTrimCommand:     {1, 1, 1},
+	RPopLPushCommand: {1, 2, 1},
+	LMoveCommand:     {1, 2, 1},
+	BLPopCommand:     {1, -2, 1},
+	BRPopCommand:     {1, -2, 1},
+
+	HSetCommand:       {1, 1, 1},
+	HGetCommand:       {1, 1, 1},
+	HDelCommand:       {1, 1, 1},
+	HGetAllCommand:    {1, 1, 1},
+	HKeysCommand:      {1, 1, 1},
+	HValsCommand:      {1, 1, 1},
+	HLenCommand:       {1, 1, 1},
+	HExistsCommand:    {1, 1, 1},
+	HMGetCommand:      {1, 1, 1},
+	HSetNXCommand:     {1, 1, 1},
+	HRandFieldCommand: {1, 1, 1},
+	HScanCommand:      {1, 1, 1},
+
+	SAddCommand:        {1, 1, 1},
+	SRemCommand:        {1, 1, 1},
+	SMembersCommand:    {1, 1, 1},
+	SIsMemberCommand:   {1, 1, 1},
+	SCardCommand:       {1, 1, 1},
+	SInterCommand:      {1, -1, 1},
+	SUnionCommand:      {1, -1, 1},
+	SDiffCommand:       {1, -1, 1},
+	SInterStoreCommand: {1, -1, 1},
+	SUnionStoreCommand: {1, -1, 1},
+	SDiffStoreCommand:  {1, -1, 1},
+	SMIsMemberCommand:  {1, 1, 1},
+	SMoveCommand:       {1, 2, 1},
+	SScanCommand:       {1, 1, 1},
+
+	ZAddCommand:          {1, 1, 1},
+	ZScoreCommand:        {1, 1, 1},
+	ZCardCommand:         {1, 1, 1},
+	ZRemCommand:          {1, 1, 1},
+	ZRangeCommand:        {1, 1, 1},
+	ZRevRangeCommand:     {1, 1, 1},
+	ZRangeByScoreCommand: {1, 1, 1},
+	ZCountCommand:        {1, 1, 1},
+	ZIncrByCommand:       {1, 1, 1},
+	ZRankCommand:         {1, 1, 1},
+	ZPopMinCommand:       {1, 1, 1},
+	ZPopMaxCommand:       {1, 1, 1},
+	ZScanCommand:         {1, 1, 1},
+
+	WatchCommand: {1, -1, 1},
+
+	MemoryCommand: {2, 2, 1},
+}
+
+// keys returns the keys among message, the name of the command followed by its arguments,
+// or false if it's too short to hold them.
+func (spec keySpec) keys(message []string) ([]string, bool) {
+	last := spec.last
+
+	if last < 0 {
+		last += len(message)
+	}
+
+	if spec.first >= len(message) || last < spec.first || last >= len(message) {
+		return nil, false
+	}
 
-	strippedIncoming := strings.TrimSpace(incoming)
+	var keys []string
 
-	if strippedIncoming == "" {
-		return
+	for i := spec.first; i <= last; i += spec.step {
+		keys = append(keys, message[i])
 	}
 
-	splitIncoming := strings.Split(strippedIncoming, " ")
+	return keys, true
+}
 
-	log.Printf("Split incoming: %v\n", splitIncoming)
+// unlockedCommands don't run under the transaction lock: blocking commands would
+// hold up every EXEC while they wait, like WAIT and MIGRATE, while EXEC, PSYNC and REPLICAOF take the lock
+// exclusively themselves or wait on the link to the leader which does.
+var unlockedCommands = map[Command]bool{
+	BLPopCommand:     true,
+	BRPopCommand:     true,
+	ExecCommand:      true,
+	MigrateCommand:   true,
+	PSyncCommand:     true,
+	ReplicaOfCommand: true,
+	WaitCommand:      true,
+	SlaveOfCommand:   true,
+}
+
+// HandleMessage runs a single command sent by client, its name followed by its arguments,
+// and writes the reply. It returns the error writing to the connection if the reply couldn't be,
+// in which case the connection is as good as gone.
+func HandleMessage(client *Client, message []string, kv *store.KVStore) error {
+	// commands read behind the one disconnecting the client are dropped
+	if len(message) == 0 || client.closeAfterReply {
+		return nil
+	}
 
-	rootCommand, args := splitIncoming[0], splitIncoming[1:]
+	client.handling.Store(true)
+	defer client.handling.Store(false)
 
-	rootCommand = strings.ToLower(rootCommand)
+	rootCommand, args := strings.ToLower(message[0]), message[1:]
 
 	handler, exists := handlers[rootCommand]
 
 	var response resp.Response
 
-	if exists {
-		response = handler(conn, args, kv)
-	} else {
+	switch {
+	case !exists:
+		response = resp.NewError(
+			fmt.Sprintf("unknown command '%s'", message[0]),
+		)
+
+		// a transaction with a command that can't be queued must not run at all
+		if client.inMulti {
+			client.multiFailed = true
+		}
+	case writeCommands[rootCommand] && client.instance.currentLeader() != nil:
+		// a replica only takes writes from its leader
+		response = resp.NewReadOnlyError()
+
+		if client.inMulti {
+			client.multiFailed = true
+		}
+	case client.subscribed.Load() && !subscribedCommands[rootCommand]:
 		response = resp.NewError(
-			fmt.Sprintf("unknown command '%s'", splitIncoming[0]),
+			fmt.Sprintf("Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", rootCommand),
 		)
+	case client.inMulti && !transactionCommands[rootCommand]:
+		client.queued = append(client.queued, queuedCommand{command: rootCommand, handler: handler, args: args})
+		response = resp.NewSimpleString("QUEUED")
+	default:
+		client.instance.commandsProcessed.Add(1)
+
+		start := time.Now()
+		response = execute(client, rootCommand, handler, args, kv)
+		duration := time.Since(start)
+
+		// fed once run, so the commands of a transaction show up before EXEC like in Redis
+		client.instance.monitors.feed(client, rootCommand, message)
+
+		client.instance.commandStats[rootCommand].record(duration, resp.IsError(response))
+
+		// the arguments hold keys and values, which are only logged when debugging
+		client.logger.Debug("Command handled",
+			"command", rootCommand,
+			"args", args,
+			"duration_ms", float64(duration.Microseconds())/1000,
+		)
+	}
+
+	if err := client.bufferReply(response); err != nil {
+		return err
+	}
+
+	// a client can't be disconnected before it gets the reply of the command doing it
+	if client.closeAfterReply {
+		client.Flush()
+		client.kill()
+	}
+
+	return nil
+}
+
+// execute runs a command handler under the shared side of the transaction lock,
+// so it never runs in the middle of an EXEC.
+func execute(client *Client, command Command, handler CommandHandler, args []string, kv *store.KVStore) resp.Response {
+	if unlockedCommands[command] {
+		return run(client, command, handler, args, kv)
+	}
+
+	client.instance.execMutex.RLock()
+	defer client.instance.execMutex.RUnlock()
+
+	return run(client, command, handler, args, kv)
+}
+
+// errorResponse converts an error returned by the store into a RESP error.
+// every handler must go through it, so that errors with a code of their own like
+// WRONGTYPE are replied with that code rather than ERR.
+func errorResponse(err error) resp.Response {
+	if errors.Is(err, store.ErrWrongType) {
+		return resp.NewWrongTypeError()
+	}
+
+	if errors.Is(err, store.ErrOutOfMemory) {
+		return resp.NewOOMError()
 	}
 
-	conn.Write([]byte(response.ToString()))
+	if errors.Is(err, store.ErrBusyKey) {
+		return resp.NewBusyKeyError()
+	}
+
+	return resp.NewError(err.Error())
 }
 
-var HandleSetCommand CommandHandler = func(conn net.Conn, args []string, kv *store.KVStore) resp.Response {
+// newNullableBulkStringArray wraps each string in a bulk string, nil ones in a nil bulk string.
+func newNullableBulkStringArray(values []*string) resp.Array {
+	responseSlice := make([]resp.Response, len(values))
+
+	for i, value := range values {
+		if value == nil {
+			responseSlice[i] = resp.NewNullBulkString()
+			continue
+		}
+
+		responseSlice[i] = resp.NewBulkString(*value)
+	}
+
+	return resp.NewArray(responseSlice)
+}
+
+// newBulkStringArray wraps each string in a bulk string.
+func newBulkStringArray(values []string) resp.Array {
+	responseSlice := make([]resp.Response, len(values))
+
+	for i, value := range values {
+		responseSlice[i] = resp.NewBulkString(value)
+	}
+
+	return resp.NewArray(responseSlice)
+}
+
+var HandleSetCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
 
 	if len(args) != 2 {
 		return resp.NewError(
@@ -90,12 +739,16 @@ var HandleSetCommand CommandHandler = func(conn net.Conn, args []string, kv *sto
 	key := args[0]
 	value := args[1]
 
-	kv.Set(key, value)
+	if err := kv.Set(key, value); err != nil {
+		return errorResponse(err)
+	}
+
+	client.instance.notify(notifyString, "set", key)
 
 	return resp.NewOKResponse()
 }
 
-var HandleGetCommand CommandHandler = func(conn net.Conn, args []string, kv *store.KVStore) resp.Response {
+var HandleGetCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
 	if len(args) != 1 {
 		return resp.NewError(
 			"wrong number of arguments for 'get' command",
@@ -104,24 +757,37 @@ var HandleGetCommand CommandHandler = func(conn net.Conn, args []string, kv *sto
 
 	key := args[0]
 
-	value, exists := kv.Get(key)
+	value, exists, err := kv.Get(key)
 
-	response := resp.NewBulkString(value)
+	if err != nil {
+		return errorResponse(err)
+	}
 
 	if !exists {
-		response.Value = ""
+		return resp.NewNullBulkString()
 	}
 
-	return response
+	return resp.NewBulkString(value)
 }
 
-var HandlePingCommand CommandHandler = func(conn net.Conn, args []string, kv *store.KVStore) resp.Response {
+var HandlePingCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
 	if len(args) > 1 {
 		return resp.NewError(
 			"wrong number of arguments for 'ping' command",
 		)
 	}
 
+	// a subscribed client gets the PONG in the shape of a pushed message, like Redis does
+	if client.subscribed.Load() {
+		message := ""
+
+		if len(args) == 1 {
+			message = args[0]
+		}
+
+		return newBulkStringArray([]string{"pong", message})
+	}
+
 	if len(args) == 0 {
 		return resp.NewSimpleString("PONG")
 	}
@@ -129,42 +795,71 @@ var HandlePingCommand CommandHandler = func(conn net.Conn, args []string, kv *st
 	return resp.NewBulkString(args[0])
 }
 
-var HandleDelCommand CommandHandler = func(conn net.Conn, args []string, kv *store.KVStore) resp.Response {
+var HandleEchoCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 1 {
+		return resp.NewError(
+			"wrong number of arguments for 'echo' command",
+		)
+	}
+
+	return resp.NewBulkString(args[0])
+}
+
+var HandleQuitCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	// the connection is closed once the reply is written
+	client.closeAfterReply = true
+
+	return resp.NewOKResponse()
+}
+
+var HandleResetCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 0 {
+		return resp.NewError("wrong number of arguments for 'reset' command")
+	}
+
+	// there is only one database and one protocol version, so there's nothing else to go back to
+	client.reset(kv)
+
+	return resp.NewSimpleString("RESET")
+}
+
+var HandleDelCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
 	if len(args) < 1 {
 		return resp.NewError(
 			"wrong number of arguments for 'del' command",
 		)
 	}
 
-	keys := args
-	deleteCount := 0
+	deleted := kv.DeleteMany(args)
 
-	for _, key := range keys {
-		didExist, _ := kv.Delete(key)
-		if didExist {
-			deleteCount++
-		}
+	for _, key := range deleted {
+		client.instance.notify(notifyGeneric, "del", key)
 	}
 
-	return resp.NewInteger(deleteCount)
+	return resp.NewInteger(len(deleted))
 }
 
-var HandleExistsCommand CommandHandler = func(conn net.Conn, args []string, kv *store.KVStore) resp.Response {
+var HandleExistsCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
 
-	if len(args) != 1 {
+	if len(args) < 1 {
 		return resp.NewError(
 			"wrong number of arguments for 'exists' command",
 		)
 	}
 
-	key := args[0]
+	// a key given more than once is counted every time, like Redis does
+	count := 0
 
-	exists := kv.Has(key)
+	for _, key := range args {
+		if kv.Has(key) {
+			count++
+		}
+	}
 
-	return resp.NewIntegerFromBool(exists)
+	return resp.NewInteger(count)
 }
 
-var HandleIncrCommand CommandHandler = func(conn net.Conn, args []string, kv *store.KVStore) resp.Response {
+var HandleIncrCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
 	if len(args) != 1 {
 		return resp.NewError(
 			"wrong number of arguments for 'incr' command",
@@ -176,15 +871,15 @@ var HandleIncrCommand CommandHandler = func(conn net.Conn, args []string, kv *st
 	value, err := kv.Incr(key)
 
 	if err != nil {
-		return resp.NewError(
-			"value is not an integer or out of range",
-		)
+		return errorResponse(err)
 	}
 
-	return resp.NewInteger(value)
+	client.instance.notify(notifyString, "incrby", key)
+
+	return resp.NewInteger(int(value))
 }
 
-var HandleDecrCommand CommandHandler = func(conn net.Conn, args []string, kv *store.KVStore) resp.Response {
+var HandleDecrCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
 	if len(args) != 1 {
 		return resp.NewError(
 			"wrong number of arguments for 'decr' command",
@@ -196,16 +891,16 @@ var HandleDecrCommand CommandHandler = func(conn net.Conn, args []string, kv *st
 	value, err := kv.Decr(key)
 
 	if err != nil {
-		return resp.NewError(
-			"value is not an integer or out of range",
-		)
+		return errorResponse(err)
 	}
 
-	return resp.NewInteger(value)
+	client.instance.notify(notifyString, "decrby", key)
+
+	return resp.NewInteger(int(value))
 }
 
-var HandleKeysCommand CommandHandler = func(conn net.Conn, args []string, kv *store.KVStore) resp.Response {
-	if len(args) != 1 {
+var HandleKeysCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 1 && len(args) != 3 {
 		return resp.NewError(
 			"wrong number of arguments for 'keys' command",
 		)
@@ -213,29 +908,30 @@ var HandleKeysCommand CommandHandler = func(conn net.Conn, args []string, kv *st
 
 	pattern := args[0]
 
-	keys := kv.Keys()
+	// COUNT stops the scan once that many keys are found, rather than going through the whole keyspace
+	count := 0
 
-	responseSlice := make([]resp.Response, 0, len(keys))
-
-	for _, key := range keys {
+	if len(args) == 3 {
+		if !strings.EqualFold(args[1], "count") {
+			return resp.NewError("syntax error")
+		}
 
-		patternMatch, err := filepath.Match(pattern, key)
+		var err error
+		count, err = strconv.Atoi(args[2])
 
 		if err != nil {
-			return resp.NewError("invalid pattern")
+			return resp.NewError("value is not an integer or out of range")
 		}
 
-		if !patternMatch {
-			continue
+		if count < 1 {
+			return resp.NewError("syntax error")
 		}
-
-		responseSlice = append(responseSlice, resp.NewBulkString(key))
 	}
 
-	return resp.NewArray(responseSlice)
+	return newBulkStringArray(kv.KeysMatching(pattern, count))
 }
 
-var HandleExpireCommand CommandHandler = func(conn net.Conn, args []string, kv *store.KVStore) resp.Response {
+var HandleExpireCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
 	if len(args) != 2 {
 		return resp.NewError("wrong number of arguments for 'expire' command")
 	}
@@ -249,10 +945,35 @@ var HandleExpireCommand CommandHandler = func(conn net.Conn, args []string, kv *
 
 	set := kv.Expire(key, ttl)
 
+	if set {
+		client.instance.notify(notifyGeneric, "expire", key)
+	}
+
+	return resp.NewIntegerFromBool(set)
+}
+
+var HandlePExpireAtCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'pexpireat' command")
+	}
+
+	key := args[0]
+	timestamp, err := strconv.ParseInt(args[1], 10, 64)
+
+	if err != nil {
+		return resp.NewError("value is not an integer or out of range")
+	}
+
+	set := kv.ExpireAt(key, time.UnixMilli(timestamp))
+
+	if set {
+		client.instance.notify(notifyGeneric, "expire", key)
+	}
+
 	return resp.NewIntegerFromBool(set)
 }
 
-var HandleTTLCommand CommandHandler = func(conn net.Conn, args []string, kv *store.KVStore) resp.Response {
+var HandleTTLCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
 
 	if len(args) != 1 {
 		return resp.NewError("wrong number of arguments for 'ttl' command")
@@ -264,7 +985,7 @@ var HandleTTLCommand CommandHandler = func(conn net.Conn, args []string, kv *sto
 	return resp.NewInteger(ttl)
 }
 
-var HandlePersistCommand CommandHandler = func(conn net.Conn, args []string, kv *store.KVStore) resp.Response {
+var HandlePersistCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
 
 	if len(args) != 1 {
 		return resp.NewError("wrong number of arguments for 'persist' command")
@@ -273,10 +994,14 @@ var HandlePersistCommand CommandHandler = func(conn net.Conn, args []string, kv
 	key := args[0]
 	didPersist := kv.Persist(key)
 
+	if didPersist {
+		client.instance.notify(notifyGeneric, "persist", key)
+	}
+
 	return resp.NewIntegerFromBool(didPersist)
 }
 
-var HandleMGetCommand CommandHandler = func(conn net.Conn, args []string, kv *store.KVStore) resp.Response {
+var HandleMGetCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
 
 	if len(args) < 1 {
 		return resp.NewError("wrong number of arguments for 'mget' command")
@@ -286,16 +1011,10 @@ var HandleMGetCommand CommandHandler = func(conn net.Conn, args []string, kv *st
 
 	values := kv.MGet(keys)
 
-	responseSlice := make([]resp.Response, len(values))
-
-	for i, value := range values {
-		responseSlice[i] = resp.NewBulkString(value)
-	}
-
-	return resp.NewArray(responseSlice)
+	return newNullableBulkStringArray(values)
 }
 
-var HandleGetDelCommand CommandHandler = func(conn net.Conn, args []string, kv *store.KVStore) resp.Response {
+var HandleGetDelCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
 
 	if len(args) < 1 {
 		return resp.NewError("wrong number of arguments for 'getdel' command")
@@ -303,11 +1022,166 @@ var HandleGetDelCommand CommandHandler = func(conn net.Conn, args []string, kv *
 
 	key := args[0]
 
-	didExist, oldValue := kv.Delete(key)
+	oldValue, didExist, err := kv.GetDel(key)
+
+	if err != nil {
+		return errorResponse(err)
+	}
 
 	if !didExist {
-		return resp.NewNilString()
+		return resp.NewNullBulkString()
 	}
 
+	client.instance.notify(notifyGeneric, "del", key)
+
 	return resp.NewBulkString(oldValue)
 }
+
+// getExArgs are the arguments of GETEX key [EX seconds | PX milliseconds | EXAT timestamp | PXAT timestamp | PERSIST].
+type getExArgs struct {
+	key string
+
+	// the time the key expires at, zero to leave its TTL alone
+	expiry time.Time
+
+	// the TTL of EX and PX, which is jittered, the key expiring after it rather than at expiry
+	ttl time.Duration
+
+	persist bool
+}
+
+func parseGetExArgs(args []string) (getExArgs, resp.Response) {
+	if len(args) < 1 || len(args) > 3 {
+		return getExArgs{}, resp.NewError("wrong number of arguments for 'getex' command")
+	}
+
+	parsed := getExArgs{key: args[0]}
+
+	if len(args) == 1 {
+		return parsed, nil
+	}
+
+	option := strings.ToLower(args[1])
+
+	if option == "persist" {
+		if len(args) != 2 {
+			return getExArgs{}, resp.NewError("syntax error")
+		}
+
+		parsed.persist = true
+		return parsed, nil
+	}
+
+	if len(args) != 3 {
+		return getExArgs{}, resp.NewError("syntax error")
+	}
+
+	value, err := strconv.ParseInt(args[2], 10, 64)
+
+	if err != nil {
+		return getExArgs{}, resp.NewError("value is not an integer or out of range")
+	}
+
+	if value <= 0 {
+		return getExArgs{}, resp.NewError("invalid expire time in 'getex' command")
+	}
+
+	switch option {
+	case "ex":
+		parsed.ttl = time.Duration(value) * time.Second
+	case "px":
+		parsed.ttl = time.Duration(value) * time.Millisecond
+	case "exat":
+		parsed.expiry = time.Unix(value, 0)
+	case "pxat":
+		parsed.expiry = time.UnixMilli(value)
+	default:
+		return getExArgs{}, resp.NewError("syntax error")
+	}
+
+	return parsed, nil
+}
+
+var HandleGetExCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	getEx, errResponse := parseGetExArgs(args)
+
+	if errResponse != nil {
+		return errResponse
+	}
+
+	if getEx.ttl > 0 {
+		getEx.expiry = time.Now().Add(kv.JitteredTTL(getEx.ttl))
+	}
+
+	value, exists, err := kv.GetEx(getEx.key, getEx.expiry, getEx.persist)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if !exists {
+		return resp.NewNullBulkString()
+	}
+
+	switch {
+	case getEx.persist:
+		client.instance.notify(notifyGeneric, "persist", getEx.key)
+	case !getEx.expiry.IsZero():
+		client.instance.notify(notifyGeneric, "expire", getEx.key)
+	}
+
+	return resp.NewBulkString(value)
+}
+
+var HandleObjectCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'object' command")
+	}
+
+	subcommand, key := strings.ToLower(args[0]), args[1]
+
+	switch subcommand {
+	case "encoding":
+		encoding, exists := kv.ObjectEncoding(key)
+
+		if !exists {
+			return errorResponse(store.ErrNoSuchKey)
+		}
+
+		return resp.NewBulkString(encoding)
+
+	case "refcount":
+		// values are never shared between keys
+		if !kv.Has(key) {
+			return errorResponse(store.ErrNoSuchKey)
+		}
+
+		return resp.NewInteger(1)
+
+	case "idletime":
+		idleTime, exists := kv.ObjectIdleTime(key)
+
+		if !exists {
+			return errorResponse(store.ErrNoSuchKey)
+		}
+
+		return resp.NewInteger(int(idleTime.Seconds()))
+
+	case "freq":
+		if policy := kv.EvictionPolicy(); policy != store.AllKeysLFU && policy != store.VolatileLFU {
+			return resp.NewError("An LFU maxkeys-policy is not selected, access frequency not tracked.")
+		}
+
+		freq, exists := kv.ObjectFreq(key)
+
+		if !exists {
+			return errorResponse(store.ErrNoSuchKey)
+		}
+
+		return resp.NewInteger(freq)
+	}
+
+	return resp.NewError(
+		fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'", args[0]),
+	)
+}
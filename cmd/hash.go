@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+var HandleHSetCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	// a key followed by at least one field/value pair
+	if len(args) < 3 || len(args)%2 != 1 {
+		return resp.NewError("wrong number of arguments for 'hset' command")
+	}
+
+	added, err := kv.HSet(args[0], args[1:]...)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	client.instance.notify(notifyHash, "hset", args[0])
+
+	return resp.NewInteger(added)
+}
+
+var HandleHGetCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'hget' command")
+	}
+
+	value, exists, err := kv.HGet(args[0], args[1])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if !exists {
+		return resp.NewNullBulkString()
+	}
+
+	return resp.NewBulkString(value)
+}
+
+var HandleHDelCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 2 {
+		return resp.NewError("wrong number of arguments for 'hdel' command")
+	}
+
+	deleted, err := kv.HDel(args[0], args[1:]...)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if deleted > 0 {
+		client.instance.notify(notifyHash, "hdel", args[0])
+		client.instance.notifyIfDeleted(kv, args[0])
+	}
+
+	return resp.NewInteger(deleted)
+}
+
+var HandleHGetAllCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 1 {
+		return resp.NewError("wrong number of arguments for 'hgetall' command")
+	}
+
+	fieldValues, err := kv.HGetAll(args[0])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return newBulkStringArray(fieldValues)
+}
+
+var HandleHKeysCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 1 {
+		return resp.NewError("wrong number of arguments for 'hkeys' command")
+	}
+
+	fields, err := kv.HKeys(args[0])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return newBulkStringArray(fields)
+}
+
+var HandleHValsCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 1 {
+		return resp.NewError("wrong number of arguments for 'hvals' command")
+	}
+
+	values, err := kv.HVals(args[0])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return newBulkStringArray(values)
+}
+
+var HandleHLenCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 1 {
+		return resp.NewError("wrong number of arguments for 'hlen' command")
+	}
+
+	length, err := kv.HLen(args[0])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return resp.NewInteger(length)
+}
+
+var HandleHExistsCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'hexists' command")
+	}
+
+	exists, err := kv.HExists(args[0], args[1])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return resp.NewIntegerFromBool(exists)
+}
+
+var HandleHMGetCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 2 {
+		return resp.NewError("wrong number of arguments for 'hmget' command")
+	}
+
+	values, err := kv.HMGet(args[0], args[1:]...)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return newNullableBulkStringArray(values)
+}
+
+var HandleHSetNXCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 3 {
+		return resp.NewError("wrong number of arguments for 'hsetnx' command")
+	}
+
+	set, err := kv.HSetNX(args[0], args[1], args[2])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if set {
+		client.instance.notify(notifyHash, "hset", args[0])
+	}
+
+	return resp.NewIntegerFromBool(set)
+}
+
+var HandleHRandFieldCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 1 || len(args) > 3 {
+		return resp.NewError("wrong number of arguments for 'hrandfield' command")
+	}
+
+	// without a count, a single field is replied with on its own rather than in an array
+	if len(args) == 1 {
+		fieldValues, err := kv.HRandField(args[0], 1)
+
+		if err != nil {
+			return errorResponse(err)
+		}
+
+		if len(fieldValues) == 0 {
+			return resp.NewNullBulkString()
+		}
+
+		return resp.NewBulkString(fieldValues[0])
+	}
+
+	count, err := strconv.Atoi(args[1])
+
+	if err != nil {
+		return resp.NewError("value is not an integer or out of range")
+	}
+
+	withValues := false
+
+	if len(args) == 3 {
+		if !strings.EqualFold(args[2], "withvalues") {
+			return resp.NewError("syntax error")
+		}
+
+		withValues = true
+	}
+
+	fieldValues, err := kv.HRandField(args[0], count)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if withValues {
+		return newBulkStringArray(fieldValues)
+	}
+
+	fields := make([]string, 0, len(fieldValues)/2)
+
+	for i := 0; i < len(fieldValues); i += 2 {
+		fields = append(fields, fieldValues[i])
+	}
+
+	return newBulkStringArray(fields)
+}
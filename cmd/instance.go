@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Persistence configures where and how an instance saves its data.
+type Persistence struct {
+	// SnapshotPath is the file SAVE writes snapshots to, loaded on startup if it exists
+	// and the AOF is off. Empty disables snapshots.
+	SnapshotPath string
+
+	// AppendOnly logs every write to the AOF at AppendPath, replayed on startup
+	// instead of loading the snapshot.
+	AppendOnly bool
+	AppendPath string
+
+	// AppendFsync is how often the AOF is flushed to disk.
+	AppendFsync FsyncPolicy
+}
+
+// DefaultPersistence returns the persistence settings used when nothing is configured.
+func DefaultPersistence() Persistence {
+	return Persistence{
+		SnapshotPath: "dump.redig",
+		AppendPath:   "appendonly.aof",
+		AppendFsync:  FsyncEverySec,
+	}
+}
+
+// Instance holds the state shared by every client of a redig instance.
+type Instance struct {
+	broker *Broker
+
+	// logs of the instance, which every client logs through
+	logger *slog.Logger
+
+	persistence Persistence
+
+	// the AOF writes are logged to, nil if it's off
+	aof *appendOnlyFile
+
+	// set while a snapshot is being saved, as only one is saved at a time
+	saving atomic.Bool
+
+	// the unix time of the last successful save, for LASTSAVE.
+	// it starts at the time of startup, like Redis does
+	lastSave atomic.Int64
+
+	// whether the last BGSAVE failed, for INFO
+	lastBgSaveFailed atomic.Bool
+
+	// work running in the background like BGSAVE, which shutdown waits for
+	background sync.WaitGroup
+
+	// closed by SHUTDOWN to stop the server
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+
+	// every command runs under the read side of the lock,
+	// EXEC takes the write side to run a transaction atomically
+	execMutex sync.RWMutex
+
+	// serializes CONFIG SET, so parameters set together are applied together
+	configMutex sync.Mutex
+
+	// held while a write command runs and is propagated, so commands are logged and
+	// replicated in the order they changed the data, even when they change the same key.
+	// writes only take it when there's somewhere to propagate them to
+	writeMutex sync.Mutex
+
+	// replicas connected to the instance, fed every write
+	replicas replicas
+
+	// the link to the leader while the instance is a replica, nil otherwise
+	leader      *leaderLink
+	leaderMutex sync.Mutex
+
+	// identifies the data set, for replicas to tell which leader they synced from
+	replID string
+
+	// identifies the process, for clients to tell a restarted server apart
+	runID string
+
+	// the keyspace events published, a notifyClass set by notify-keyspace-events
+	notifyClasses atomic.Int32
+
+	// clients in MONITOR mode
+	monitors monitors
+
+	// connected clients by id, for CLIENT LIST
+	clients      map[int64]*Client
+	clientsMutex sync.RWMutex
+	lastClientID atomic.Int64
+
+	// stats reported by INFO
+	startTime         time.Time
+	totalConnections  atomic.Int64
+	commandsProcessed atomic.Int64
+	commandStats      commandStatsRegistry
+}
+
+// NewInstance creates the shared state for a new redig instance, saving its data as set by persistence.
+// It logs to logger, or to slog.Default if logger is nil.
+func NewInstance(persistence Persistence, logger *slog.Logger) *Instance {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	instance := &Instance{
+		broker:       NewBroker(),
+		logger:       logger,
+		persistence:  persistence,
+		shutdown:     make(chan struct{}),
+		clients:      make(map[int64]*Client),
+		startTime:    time.Now(),
+		commandStats: newCommandStatsRegistry(),
+		replID:       newReplID(),
+		runID:        newReplID(),
+	}
+
+	// nothing has been saved yet, the data is as of startup
+	instance.lastSave.Store(instance.startTime.Unix())
+
+	return instance
+}
+
+// Logger returns the logger the instance logs to.
+func (i *Instance) Logger() *slog.Logger {
+	return i.logger
+}
+
+// Wait blocks until the work running in the background, like BGSAVE, is done.
+func (i *Instance) Wait() {
+	i.background.Wait()
+}
+
+// Shutdown asks the server to stop, as SHUTDOWN does. Asking twice is a no-op.
+func (i *Instance) Shutdown() {
+	i.shutdownOnce.Do(func() {
+		close(i.shutdown)
+	})
+}
+
+// ShutdownRequested returns a channel closed once the server has been asked to stop.
+func (i *Instance) ShutdownRequested() <-chan struct{} {
+	return i.shutdown
+}
+
+// register adds a newly connected client to the registry, assigning it an id.
+func (i *Instance) register(client *Client) {
+	client.id = i.lastClientID.Add(1)
+
+	i.clientsMutex.Lock()
+	defer i.clientsMutex.Unlock()
+
+	i.clients[client.id] = client
+}
+
+// unregister removes a disconnected client from the registry.
+func (i *Instance) unregister(client *Client) {
+	i.clientsMutex.Lock()
+	defer i.clientsMutex.Unlock()
+
+	delete(i.clients, client.id)
+}
+
+// connectedClients returns every connected client, ordered by id.
+func (i *Instance) connectedClients() []*Client {
+	i.clientsMutex.RLock()
+	defer i.clientsMutex.RUnlock()
+
+	clients := make([]*Client, 0, len(i.clients))
+
+	for _, client := range i.clients {
+		clients = append(clients, client)
+	}
+
+	slices.SortFunc(clients, func(a, b *Client) int {
+		return cmp.Compare(a.id, b.id)
+	})
+
+	return clients
+}
+
+// clientCount returns the number of connected clients.
+func (i *Instance) clientCount() int {
+	i.clientsMutex.RLock()
+	defer i.clientsMutex.RUnlock()
+
+	return len(i.clients)
+}
@@ -0,0 +1,467 @@
+package cmd
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// handlePush is shared by LPUSH, RPUSH, LPUSHX and RPUSHX.
+func handlePush(client *Client, name string, head bool, push func(key string, values ...string) (int, error), args []string) resp.Response {
+	if len(args) < 2 {
+		return resp.NewError("wrong number of arguments for '" + name + "' command")
+	}
+
+	length, err := push(args[0], args[1:]...)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// LPUSHX and RPUSHX push nothing to a missing list
+	if length > 0 {
+		client.instance.notify(notifyList, pushEvent(head), args[0])
+	}
+
+	return resp.NewInteger(length)
+}
+
+// handlePop is shared by LPOP and RPOP.
+// without a count it replies with a single bulk string, with a count it replies with an array.
+func handlePop(client *Client, kv *store.KVStore, name string, pop func(key string, count int) ([]string, error), args []string) resp.Response {
+	if len(args) < 1 || len(args) > 2 {
+		return resp.NewError("wrong number of arguments for '" + name + "' command")
+	}
+
+	key := args[0]
+	count := 1
+
+	if len(args) == 2 {
+		var err error
+		count, err = strconv.Atoi(args[1])
+
+		if err != nil || count < 0 {
+			return resp.NewError("value is out of range, must be positive")
+		}
+	}
+
+	values, err := pop(key, count)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if len(values) > 0 {
+		client.instance.notify(notifyList, name, key)
+		client.instance.notifyIfDeleted(kv, key)
+	}
+
+	if len(args) == 1 {
+		if len(values) == 0 {
+			return resp.NewNullBulkString()
+		}
+
+		return resp.NewBulkString(values[0])
+	}
+
+	if values == nil {
+		return resp.NewNilArray()
+	}
+
+	return newBulkStringArray(values)
+}
+
+var HandleLPushCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handlePush(client, "lpush", true, kv.LPush, args)
+}
+
+var HandleRPushCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handlePush(client, "rpush", false, kv.RPush, args)
+}
+
+var HandleLPushXCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handlePush(client, "lpushx", true, kv.LPushX, args)
+}
+
+var HandleRPushXCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handlePush(client, "rpushx", false, kv.RPushX, args)
+}
+
+var HandleLPopCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handlePop(client, kv, "lpop", kv.LPop, args)
+}
+
+var HandleRPopCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handlePop(client, kv, "rpop", kv.RPop, args)
+}
+
+var HandleLRangeCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 3 {
+		return resp.NewError("wrong number of arguments for 'lrange' command")
+	}
+
+	key := args[0]
+	start, startErr := strconv.Atoi(args[1])
+	stop, stopErr := strconv.Atoi(args[2])
+
+	if startErr != nil || stopErr != nil {
+		return resp.NewError("value is not an integer or out of range")
+	}
+
+	values, err := kv.LRange(key, start, stop)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return newBulkStringArray(values)
+}
+
+var HandleLLenCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 1 {
+		return resp.NewError("wrong number of arguments for 'llen' command")
+	}
+
+	length, err := kv.LLen(args[0])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return resp.NewInteger(length)
+}
+
+var HandleLIndexCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'lindex' command")
+	}
+
+	key := args[0]
+	index, err := strconv.Atoi(args[1])
+
+	if err != nil {
+		return resp.NewError("value is not an integer or out of range")
+	}
+
+	value, exists, err := kv.LIndex(key, index)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if !exists {
+		return resp.NewNullBulkString()
+	}
+
+	return resp.NewBulkString(value)
+}
+
+var HandleLPosCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return resp.NewError("wrong number of arguments for 'lpos' command")
+	}
+
+	key, element := args[0], args[1]
+	rank, count, maxLen := 1, 1, 0
+
+	// without COUNT, the reply is a single index rather than an array
+	withCount := false
+
+	for i := 2; i < len(args); i += 2 {
+		value, err := strconv.Atoi(args[i+1])
+
+		if err != nil {
+			return resp.NewError("value is not an integer or out of range")
+		}
+
+		switch strings.ToLower(args[i]) {
+		case "rank":
+			if value == 0 {
+				return resp.NewError("RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+
+			rank = value
+		case "count":
+			if value < 0 {
+				return resp.NewError("COUNT can't be negative")
+			}
+
+			count, withCount = value, true
+		case "maxlen":
+			if value < 0 {
+				return resp.NewError("MAXLEN can't be negative")
+			}
+
+			maxLen = value
+		default:
+			return resp.NewError("syntax error")
+		}
+	}
+
+	positions, err := kv.LPos(key, element, rank, count, maxLen)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if !withCount {
+		if len(positions) == 0 {
+			return resp.NewNullBulkString()
+		}
+
+		return resp.NewInteger(positions[0])
+	}
+
+	responseSlice := make([]resp.Response, len(positions))
+
+	for i, position := range positions {
+		responseSlice[i] = resp.NewInteger(position)
+	}
+
+	return resp.NewArray(responseSlice)
+}
+
+var HandleLSetCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 3 {
+		return resp.NewError("wrong number of arguments for 'lset' command")
+	}
+
+	key := args[0]
+	index, err := strconv.Atoi(args[1])
+
+	if err != nil {
+		return resp.NewError("value is not an integer or out of range")
+	}
+
+	if err := kv.LSet(key, index, args[2]); err != nil {
+		return errorResponse(err)
+	}
+
+	client.instance.notify(notifyList, "lset", key)
+
+	return resp.NewOKResponse()
+}
+
+var HandleLInsertCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 4 {
+		return resp.NewError("wrong number of arguments for 'linsert' command")
+	}
+
+	key := args[0]
+	pivot := args[2]
+	element := args[3]
+
+	var before bool
+
+	switch strings.ToLower(args[1]) {
+	case "before":
+		before = true
+	case "after":
+		before = false
+	default:
+		return resp.NewError("syntax error")
+	}
+
+	length, err := kv.LInsert(key, before, pivot, element)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// 0 for a missing key, -1 for a missing pivot
+	if length > 0 {
+		client.instance.notify(notifyList, "linsert", key)
+	}
+
+	return resp.NewInteger(length)
+}
+
+var HandleLRemCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 3 {
+		return resp.NewError("wrong number of arguments for 'lrem' command")
+	}
+
+	key := args[0]
+	count, err := strconv.Atoi(args[1])
+
+	if err != nil {
+		return resp.NewError("value is not an integer or out of range")
+	}
+
+	removed, err := kv.LRem(key, count, args[2])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if removed > 0 {
+		client.instance.notify(notifyList, "lrem", key)
+		client.instance.notifyIfDeleted(kv, key)
+	}
+
+	return resp.NewInteger(removed)
+}
+
+var HandleLTrimCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 3 {
+		return resp.NewError("wrong number of arguments for 'ltrim' command")
+	}
+
+	key := args[0]
+	start, startErr := strconv.Atoi(args[1])
+	stop, stopErr := strconv.Atoi(args[2])
+
+	if startErr != nil || stopErr != nil {
+		return resp.NewError("value is not an integer or out of range")
+	}
+
+	existed := kv.Has(key)
+
+	if err := kv.LTrim(key, start, stop); err != nil {
+		return errorResponse(err)
+	}
+
+	if existed {
+		client.instance.notify(notifyList, "ltrim", key)
+		client.instance.notifyIfDeleted(kv, key)
+	}
+
+	return resp.NewOKResponse()
+}
+
+// popEvent names the keyspace event of a pop from the head or the tail of a list.
+func popEvent(head bool) string {
+	if head {
+		return "lpop"
+	}
+
+	return "rpop"
+}
+
+// pushEvent names the keyspace event of a push to the head or the tail of a list.
+func pushEvent(head bool) string {
+	if head {
+		return "lpush"
+	}
+
+	return "rpush"
+}
+
+// parseListEnd parses a LEFT|RIGHT argument, returning true for the head of the list.
+func parseListEnd(arg string) (bool, bool) {
+	switch strings.ToLower(arg) {
+	case "left":
+		return true, true
+	case "right":
+		return false, true
+	}
+
+	return false, false
+}
+
+// handleMove is shared by RPOPLPUSH and LMOVE.
+func handleMove(client *Client, kv *store.KVStore, src, dst string, fromHead, toHead bool) resp.Response {
+	element, moved, err := kv.LMove(src, dst, fromHead, toHead)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if !moved {
+		return resp.NewNullBulkString()
+	}
+
+	client.instance.notify(notifyList, popEvent(fromHead), src)
+	client.instance.notify(notifyList, pushEvent(toHead), dst)
+	client.instance.notifyIfDeleted(kv, src)
+
+	return resp.NewBulkString(element)
+}
+
+var HandleRPopLPushCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'rpoplpush' command")
+	}
+
+	return handleMove(client, kv, args[0], args[1], false, true)
+}
+
+var HandleLMoveCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 4 {
+		return resp.NewError("wrong number of arguments for 'lmove' command")
+	}
+
+	fromHead, fromOk := parseListEnd(args[2])
+	toHead, toOk := parseListEnd(args[3])
+
+	if !fromOk || !toOk {
+		return resp.NewError("syntax error")
+	}
+
+	return handleMove(client, kv, args[0], args[1], fromHead, toHead)
+}
+
+// handleBlockingPop is shared by BLPOP and BRPOP.
+// the last argument is the timeout in seconds, 0 blocks forever.
+func handleBlockingPop(name string, client *Client, args []string, kv *store.KVStore, head bool) resp.Response {
+	if len(args) < 2 {
+		return resp.NewError("wrong number of arguments for '" + name + "' command")
+	}
+
+	keys := args[:len(args)-1]
+	timeout, err := strconv.ParseFloat(args[len(args)-1], 64)
+
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		return resp.NewError("timeout is not a float or out of range")
+	}
+
+	if timeout < 0 {
+		return resp.NewError("timeout is negative")
+	}
+
+	// the wait is abandoned when the client disconnects
+	ctx := client.ctx
+
+	// inside a transaction nobody else can push, so the pop can't wait. A timeout too long
+	// for a time.Duration waits forever, the same as 0, rather than overflowing into the past.
+	if client.executing {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		cancel()
+	} else if timeout > 0 && timeout < math.MaxInt64/1e9 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout*float64(time.Second)))
+		defer cancel()
+	}
+
+	// the replies to the commands before must not wait for the pop
+	client.Flush()
+
+	key, element, popped, err := kv.BPop(ctx, keys, head)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if !popped {
+		return resp.NewNilArray()
+	}
+
+	client.instance.notify(notifyList, popEvent(head), key)
+	client.instance.notifyIfDeleted(kv, key)
+
+	return newBulkStringArray([]string{key, element})
+}
+
+var HandleBLPopCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handleBlockingPop("blpop", client, args, kv, true)
+}
+
+var HandleBRPopCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handleBlockingPop("brpop", client, args, kv, false)
+}
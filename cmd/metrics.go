@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/henilmalaviya/redig/store"
+)
+
+// metricSample is a value of a metric, told apart from the other values of the same metric by its labels.
+type metricSample struct {
+	labels string
+	value  float64
+}
+
+// writeMetric writes a metric along with its help and type lines, in the Prometheus text format.
+// a metric without samples is left out entirely.
+func writeMetric(b *strings.Builder, name string, metricType string, help string, samples ...metricSample) {
+	if len(samples) == 0 {
+		return
+	}
+
+	b.WriteString("# HELP " + name + " " + help + "\n")
+	b.WriteString("# TYPE " + name + " " + metricType + "\n")
+
+	for _, sample := range samples {
+		b.WriteString(name)
+
+		if sample.labels != "" {
+			b.WriteString("{" + sample.labels + "}")
+		}
+
+		b.WriteString(" " + strconv.FormatFloat(sample.value, 'g', -1, 64) + "\n")
+	}
+}
+
+// WriteMetrics writes the counters reported by INFO to w in the Prometheus text format,
+// for the instance to be scraped without an exporter.
+func (i *Instance) WriteMetrics(w io.Writer, kv *store.KVStore) error {
+	var b strings.Builder
+
+	writeMetric(&b, "redig_uptime_seconds", "gauge", "Seconds since the server started.",
+		metricSample{value: time.Since(i.startTime).Seconds()})
+	writeMetric(&b, "redig_connected_clients", "gauge", "Number of connected clients.",
+		metricSample{value: float64(i.clientCount())})
+	writeMetric(&b, "redig_connections_received_total", "counter", "Number of connections accepted.",
+		metricSample{value: float64(i.totalConnections.Load())})
+	writeMetric(&b, "redig_commands_processed_total", "counter", "Number of commands run.",
+		metricSample{value: float64(i.commandsProcessed.Load())})
+
+	var calls, failedCalls, durations []metricSample
+
+	for _, command := range i.commandStats.called() {
+		stats := i.commandStats[command]
+		labels := `cmd="` + command + `"`
+
+		calls = append(calls, metricSample{labels, float64(stats.calls.Load())})
+		failedCalls = append(failedCalls, metricSample{labels, float64(stats.failedCalls.Load())})
+		durations = append(durations, metricSample{labels, float64(stats.usec.Load()) / 1e6})
+	}
+
+	writeMetric(&b, "redig_commands_total", "counter", "Number of calls of each command.", calls...)
+	writeMetric(&b, "redig_commands_failed_total", "counter", "Number of calls of each command which replied with an error.", failedCalls...)
+	writeMetric(&b, "redig_commands_duration_seconds_total", "counter", "Time spent running each command.", durations...)
+
+	// there's a single database, reported as db0 like INFO does
+	writeMetric(&b, "redig_db_keys", "gauge", "Number of keys held by each database.",
+		metricSample{`db="db0"`, float64(kv.KeyCount())})
+	writeMetric(&b, "redig_db_keys_expiring", "gauge", "Number of keys with an expiry in each database.",
+		metricSample{`db="db0"`, float64(kv.ExpiringKeyCount())})
+
+	writeMetric(&b, "redig_expired_keys_total", "counter", "Number of keys removed for having expired.",
+		metricSample{value: float64(kv.ExpiredKeyCount())})
+	writeMetric(&b, "redig_evicted_keys_total", "counter", "Number of keys evicted to make room.",
+		metricSample{value: float64(kv.EvictedKeyCount())})
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,315 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// respClient is a minimal client for talking to another redig or Redis instance,
+// sending commands and reading back their replies.
+type respClient struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	timeout time.Duration
+}
+
+// dialRESP connects to addr, giving up on the connection and on every later exchange after timeout.
+func dialRESP(addr string, timeout time.Duration) (*respClient, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &respClient{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		writer:  bufio.NewWriter(conn),
+		timeout: timeout,
+	}, nil
+}
+
+// send buffers a command, sent along with the others on the next flush.
+func (c *respClient) send(args ...string) {
+	c.writer.WriteString(newBulkStringArray(args).ToString())
+}
+
+// flush sends the buffered commands and reads back count replies, in order.
+func (c *respClient) flush(count int) ([]resp.Response, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+
+	if err := c.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]resp.Response, count)
+
+	for i := range replies {
+		reply, err := resp.Parse(c.reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		replies[i] = reply
+	}
+
+	return replies, nil
+}
+
+func (c *respClient) close() error {
+	return c.conn.Close()
+}
+
+// migrateArgs are the arguments of
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key...].
+type migrateArgs struct {
+	addr    string
+	keys    []string
+	timeout time.Duration
+	copy    bool
+	replace bool
+
+	// the command authenticating with the target, if any
+	auth []string
+}
+
+func parseMigrateArgs(args []string) (migrateArgs, resp.Response) {
+	if len(args) < 5 {
+		return migrateArgs{}, resp.NewError("wrong number of arguments for 'migrate' command")
+	}
+
+	db, dbErr := strconv.Atoi(args[3])
+	timeout, timeoutErr := strconv.Atoi(args[4])
+
+	if dbErr != nil || timeoutErr != nil {
+		return migrateArgs{}, resp.NewError("value is not an integer or out of range")
+	}
+
+	// there's a single database
+	if db != 0 {
+		return migrateArgs{}, resp.NewError("DB index is out of range")
+	}
+
+	// a timeout of 0 or less falls back to a second, like Redis does
+	if timeout <= 0 {
+		timeout = 1000
+	}
+
+	parsed := migrateArgs{
+		addr:    net.JoinHostPort(args[0], args[1]),
+		timeout: time.Duration(timeout) * time.Millisecond,
+	}
+
+	options := args[5:]
+
+	for i := 0; i < len(options); i++ {
+		switch strings.ToLower(options[i]) {
+		case "copy":
+			parsed.copy = true
+		case "replace":
+			parsed.replace = true
+		case "auth":
+			if i+1 >= len(options) {
+				return migrateArgs{}, resp.NewError("syntax error")
+			}
+
+			parsed.auth = []string{"AUTH", options[i+1]}
+			i++
+		case "auth2":
+			if i+2 >= len(options) {
+				return migrateArgs{}, resp.NewError("syntax error")
+			}
+
+			parsed.auth = []string{"AUTH", options[i+1], options[i+2]}
+			i += 2
+		case "keys":
+			// the single key argument must be left empty for the KEYS form
+			if args[2] != "" {
+				return migrateArgs{}, resp.NewError("When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+
+			parsed.keys = options[i+1:]
+			i = len(options)
+		default:
+			return migrateArgs{}, resp.NewError("syntax error")
+		}
+	}
+
+	if parsed.keys == nil {
+		parsed.keys = []string{args[2]}
+	}
+
+	return parsed, nil
+}
+
+// dumpedKey is a key read to be migrated, along with its TTL in milliseconds, 0 for none.
+type dumpedKey struct {
+	key  string
+	data []byte
+	ttl  int64
+}
+
+// dumpKeys serializes the keys to migrate, skipping the missing ones.
+func dumpKeys(kv *store.KVStore, keys []string) []dumpedKey {
+	var dumped []dumpedKey
+
+	for _, key := range keys {
+		expiry, exists := kv.Expiry(key)
+
+		if !exists {
+			continue
+		}
+
+		ttl := int64(0)
+
+		if !expiry.IsZero() {
+			// a key about to expire must not be restored without a TTL
+			ttl = max(time.Until(expiry).Milliseconds(), 1)
+		}
+
+		data, exists := kv.Dump(key)
+
+		if !exists {
+			continue
+		}
+
+		dumped = append(dumped, dumpedKey{key: key, data: data, ttl: ttl})
+	}
+
+	return dumped
+}
+
+// migrate restores the dumped keys on the target, returning an error if any of them couldn't be.
+func migrate(migration migrateArgs, dumped []dumpedKey) error {
+	target, err := dialRESP(migration.addr, migration.timeout)
+
+	if err != nil {
+		return err
+	}
+
+	defer target.close()
+
+	count := len(dumped)
+
+	if migration.auth != nil {
+		target.send(migration.auth...)
+		count++
+	}
+
+	for _, key := range dumped {
+		args := []string{RestoreCommand, key.key, strconv.FormatInt(key.ttl, 10), string(key.data)}
+
+		if migration.replace {
+			args = append(args, "REPLACE")
+		}
+
+		target.send(args...)
+	}
+
+	// the commands are all sent at once, rather than waiting on the target for every key
+	replies, err := target.flush(count)
+
+	if err != nil {
+		return err
+	}
+
+	for _, reply := range replies {
+		if replyErr, isError := reply.(resp.Error); isError {
+			return errors.New("Target instance replied with error: " + replyErr.Code + " " + replyErr.Message)
+		}
+	}
+
+	return nil
+}
+
+// removeMigrated deletes the keys migrated away which haven't changed since they were watched
+// at versions, and propagates it. it takes the locks a write takes in run, which MIGRATE
+// skips so as not to hold every other command off while it waits on the target.
+func removeMigrated(client *Client, kv *store.KVStore, versions map[string]uint64) {
+	instance := client.instance
+
+	// EXEC holds the lock already
+	if !client.executing {
+		instance.execMutex.RLock()
+		defer instance.execMutex.RUnlock()
+	}
+
+	if instance.propagating() {
+		instance.writeMutex.Lock()
+		defer instance.writeMutex.Unlock()
+	}
+
+	deleted := kv.DeleteUnchanged(versions)
+
+	for _, key := range deleted {
+		instance.notify(notifyGeneric, "del", key)
+	}
+
+	if len(deleted) > 0 && instance.propagating() {
+		instance.propagate(append([]string{DelCommand}, deleted...))
+	}
+}
+
+var HandleMigrateCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	migration, errResponse := parseMigrateArgs(args)
+
+	if errResponse != nil {
+		return errResponse
+	}
+
+	// the keys are watched while they're migrated, as they may be written meanwhile:
+	// a key changed since it was dumped is left in place rather than deleted
+	versions := make(map[string]uint64, len(migration.keys))
+
+	for _, key := range migration.keys {
+		if _, watched := versions[key]; !watched {
+			versions[key] = kv.Watch(key)
+		}
+	}
+
+	defer func() {
+		for key := range versions {
+			kv.Unwatch(key)
+		}
+	}()
+
+	dumped := dumpKeys(kv, migration.keys)
+
+	if len(dumped) == 0 {
+		return resp.NewSimpleString("NOKEY")
+	}
+
+	// a failure leaves every key in place, even those the target did restore
+	if err := migrate(migration, dumped); err != nil {
+		var netErr net.Error
+
+		// failing to reach the target is told apart from the target refusing a key
+		if errors.As(err, &netErr) {
+			return resp.NewErrorWithCode("IOERR", fmt.Sprintf("error or timeout talking to the target instance: %s", err.Error()))
+		}
+
+		return resp.NewError(err.Error())
+	}
+
+	if !migration.copy {
+		migrated := make(map[string]uint64, len(dumped))
+
+		for _, key := range dumped {
+			migrated[key.key] = versions[key.key]
+		}
+
+		removeMigrated(client, kv, migrated)
+	}
+
+	return resp.NewOKResponse()
+}
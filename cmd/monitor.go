@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// unmonitoredCommands are admin commands which aren't fed to MONITOR, like in Redis.
+var unmonitoredCommands = map[Command]bool{
+	MonitorCommand:  true,
+	ConfigCommand:   true,
+	ShutdownCommand: true,
+	SaveCommand:     true,
+	BgSaveCommand:   true,
+	ReplConfCommand: true,
+}
+
+// redactedCommands carry secrets, their arguments are never shown to MONITOR.
+var redactedCommands = map[Command]bool{
+	"auth": true,
+}
+
+// monitors holds the clients in MONITOR mode, fed every command run by any client.
+type monitors struct {
+	mutex   sync.RWMutex
+	clients map[*Client]struct{}
+}
+
+func (m *monitors) add(client *Client) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.clients == nil {
+		m.clients = make(map[*Client]struct{})
+	}
+
+	m.clients[client] = struct{}{}
+}
+
+func (m *monitors) remove(client *Client) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.clients, client)
+}
+
+// feed sends command, run by client, to every monitor.
+func (m *monitors) feed(client *Client, command Command, message []string) {
+	if unmonitoredCommands[command] {
+		return
+	}
+
+	// collect the monitors first so a slow one doesn't hold up the others being added or removed
+	m.mutex.RLock()
+
+	if len(m.clients) == 0 {
+		m.mutex.RUnlock()
+		return
+	}
+
+	receivers := make([]*Client, 0, len(m.clients))
+
+	for monitor := range m.clients {
+		receivers = append(receivers, monitor)
+	}
+
+	m.mutex.RUnlock()
+
+	line := resp.NewSimpleString(monitorLine(time.Now(), client, command, message))
+
+	for _, monitor := range receivers {
+		monitor.Push(line)
+	}
+}
+
+// monitorLine formats a command the way Redis MONITOR does:
+// the time, the database and address of the client, then every argument quoted.
+func monitorLine(now time.Time, client *Client, command Command, message []string) string {
+	var line strings.Builder
+
+	fmt.Fprintf(&line, "%d.%06d [0 %s]", now.Unix(), now.Nanosecond()/int(time.Microsecond), client.addr())
+
+	for i, arg := range message {
+		if i > 0 && redactedCommands[command] {
+			arg = "(redacted)"
+		}
+
+		line.WriteString(" " + quoteArg(arg))
+	}
+
+	return line.String()
+}
+
+// quoteArg quotes an argument with the escapes of redis-cli, so the line stays on a single line.
+func quoteArg(arg string) string {
+	var quoted strings.Builder
+
+	quoted.WriteByte('"')
+
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+
+		switch c {
+		case '\\', '"':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		case '\a':
+			quoted.WriteString(`\a`)
+		case '\b':
+			quoted.WriteString(`\b`)
+		default:
+			if c < ' ' || c > '~' {
+				quoted.WriteString(`\x` + strconv.FormatUint(uint64(c)|0x100, 16)[1:])
+				continue
+			}
+
+			quoted.WriteByte(c)
+		}
+	}
+
+	quoted.WriteByte('"')
+
+	return quoted.String()
+}
+
+var HandleMonitorCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 0 {
+		return resp.NewError("wrong number of arguments for 'monitor' command")
+	}
+
+	client.instance.monitors.add(client)
+	client.monitoring.Store(true)
+
+	return resp.NewOKResponse()
+}
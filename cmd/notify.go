@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/henilmalaviya/redig/store"
+)
+
+// notifyClass is a set of flags picking the keyspace events published, as set by notify-keyspace-events.
+type notifyClass int
+
+const (
+	// notifyKeyspace publishes the event to __keyspace@0__:<key>
+	notifyKeyspace notifyClass = 1 << iota
+
+	// notifyKeyevent publishes the key to __keyevent@0__:<event>
+	notifyKeyevent
+
+	// the classes of events, by the commands firing them
+	notifyGeneric
+	notifyString
+	notifyList
+	notifySet
+	notifyHash
+	notifyZSet
+	notifyExpired
+	notifyEvicted
+
+	// notifyAll is every class of event, but neither where they're published to
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet | notifyExpired | notifyEvicted
+)
+
+// notifyFlags maps every flag of notify-keyspace-events to its class, in the order Redis lists them.
+var notifyFlags = []struct {
+	flag  byte
+	class notifyClass
+}{
+	{'g', notifyGeneric},
+	{'$', notifyString},
+	{'l', notifyList},
+	{'s', notifySet},
+	{'h', notifyHash},
+	{'z', notifyZSet},
+	{'x', notifyExpired},
+	{'e', notifyEvicted},
+	{'K', notifyKeyspace},
+	{'E', notifyKeyevent},
+}
+
+// parseNotifyClasses parses the flags of notify-keyspace-events, 'A' standing for every class of event.
+func parseNotifyClasses(flags string) (notifyClass, error) {
+	classes := notifyClass(0)
+
+	for i := 0; i < len(flags); i++ {
+		if flags[i] == 'A' {
+			classes |= notifyAll
+			continue
+		}
+
+		found := false
+
+		for _, f := range notifyFlags {
+			if f.flag == flags[i] {
+				classes |= f.class
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return 0, errors.New("Invalid event class character. Use 'Ag$lshzxeKE'.")
+		}
+	}
+
+	return classes, nil
+}
+
+// String returns the flags of the classes, the way CONFIG GET reports them.
+func (c notifyClass) String() string {
+	var flags strings.Builder
+
+	for _, f := range notifyFlags {
+		if f.class&notifyAll != 0 && c&notifyAll == notifyAll {
+			continue
+		}
+
+		if c&f.class != 0 {
+			flags.WriteByte(f.flag)
+		}
+	}
+
+	if c&notifyAll == notifyAll {
+		return "A" + flags.String()
+	}
+
+	return flags.String()
+}
+
+// notify publishes a keyspace event of class fired on key, if notify-keyspace-events asks for it.
+// It's called from the middle of writes, so it never waits on the subscribers to read the event:
+// a subscriber falling too far behind is disconnected instead, see Client.Push.
+func (i *Instance) notify(class notifyClass, event string, key string) {
+	classes := notifyClass(i.notifyClasses.Load())
+
+	if classes&class == 0 {
+		return
+	}
+
+	if classes&notifyKeyspace != 0 {
+		i.broker.Publish("__keyspace@0__:"+key, event)
+	}
+
+	if classes&notifyKeyevent != 0 {
+		i.broker.Publish("__keyevent@0__:"+event, key)
+	}
+}
+
+// notifyIfDeleted fires a del event for key if it's gone, as happens to a collection once its last element is removed.
+func (i *Instance) notifyIfDeleted(kv *store.KVStore, key string) {
+	if !kv.Has(key) {
+		i.notify(notifyGeneric, "del", key)
+	}
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+var (
+	errSnapshotsDisabled = errors.New("snapshots are disabled")
+	errSaveInProgress    = errors.New("Background save already in progress")
+)
+
+// Start loads the data saved by a previous run into kv, replaying the AOF if it's on and
+// loading the snapshot otherwise, then opens the AOF for logging new writes.
+// Keys expiring in or evicted from kv are published as keyspace events from then on.
+// It must be called before any client connects, and paired with Close.
+func (i *Instance) Start(kv *store.KVStore) error {
+	kv.SetOnExpire(func(key string, value any) {
+		i.notify(notifyExpired, "expired", key)
+	})
+
+	kv.SetOnEvict(func(key string, value any) {
+		i.notify(notifyEvicted, "evicted", key)
+	})
+
+	// evictions are propagated as they happen, or replaying the AOF would bring the keys back
+	// and replicas would keep them. keys are only evicted by writes making room, which hold
+	// the write mutex when propagating, see run
+	kv.SetOnEvicting(func(key string) {
+		if i.propagating() {
+			i.propagate([]string{DelCommand, key})
+		}
+	})
+
+	persistence := i.persistence
+
+	if !persistence.AppendOnly {
+		return i.loadSnapshot(kv)
+	}
+
+	if err := i.replayAppendOnlyFile(persistence.AppendPath, kv); err != nil {
+		return fmt.Errorf("failed to replay the AOF %s: %w", persistence.AppendPath, err)
+	}
+
+	aof, err := openAppendOnlyFile(persistence.AppendPath, persistence.AppendFsync, i.logger)
+
+	if err != nil {
+		return err
+	}
+
+	i.aof = aof
+
+	return nil
+}
+
+// Close cuts the link to the leader if the instance is a replica, waits for the work
+// running in the background and closes the AOF.
+func (i *Instance) Close() error {
+	i.stopReplicating()
+	i.Wait()
+
+	if i.aof == nil {
+		return nil
+	}
+
+	return i.aof.close()
+}
+
+// loadSnapshot loads the snapshot saved by a previous run, if there is one.
+func (i *Instance) loadSnapshot(kv *store.KVStore) error {
+	path := i.persistence.SnapshotPath
+
+	if path == "" {
+		return nil
+	}
+
+	err := kv.LoadSnapshot(path)
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to load snapshot from %s: %w", path, err)
+	}
+
+	i.logger.Info("Loaded snapshot", "path", path, "keys", kv.KeyCount())
+
+	return nil
+}
+
+// save writes checkpoint to the snapshot file, recording the time for LASTSAVE if it succeeds.
+func (i *Instance) save(checkpoint *store.Checkpoint) error {
+	path := i.persistence.SnapshotPath
+
+	if err := checkpoint.Save(path); err != nil {
+		i.logger.Error("Failed to save snapshot", "path", path, "error", err)
+		return err
+	}
+
+	i.lastSave.Store(time.Now().Unix())
+
+	i.logger.Info("Saved snapshot", "path", path)
+
+	return nil
+}
+
+// startSaving claims the right to save a snapshot, which must be given back with stopSaving.
+func (i *Instance) startSaving() error {
+	if i.persistence.SnapshotPath == "" {
+		return errSnapshotsDisabled
+	}
+
+	if !i.saving.CompareAndSwap(false, true) {
+		return errSaveInProgress
+	}
+
+	return nil
+}
+
+func (i *Instance) stopSaving() {
+	i.saving.Store(false)
+}
+
+var HandleSaveCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 0 {
+		return resp.NewError("wrong number of arguments for 'save' command")
+	}
+
+	instance := client.instance
+
+	if err := instance.startSaving(); err != nil {
+		return resp.NewError(err.Error())
+	}
+
+	defer instance.stopSaving()
+
+	// the reply waits for the snapshot to be on disk, like Redis does
+	if err := instance.save(kv.Checkpoint()); err != nil {
+		return resp.NewError(err.Error())
+	}
+
+	return resp.NewOKResponse()
+}
+
+var HandleBgSaveCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 0 {
+		return resp.NewError("wrong number of arguments for 'bgsave' command")
+	}
+
+	instance := client.instance
+
+	if err := instance.startSaving(); err != nil {
+		return resp.NewError(err.Error())
+	}
+
+	// Go can't fork like Redis does, so the store is copied instead. the copy is taken
+	// before replying, so the snapshot holds every write acknowledged before BGSAVE,
+	// and none of those after it.
+	checkpoint := kv.Checkpoint()
+
+	instance.background.Add(1)
+
+	go func() {
+		defer instance.background.Done()
+		defer instance.stopSaving()
+
+		err := instance.save(checkpoint)
+		instance.lastBgSaveFailed.Store(err != nil)
+	}()
+
+	return resp.NewSimpleString("Background saving started")
+}
+
+var HandleLastSaveCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 0 {
+		return resp.NewError("wrong number of arguments for 'lastsave' command")
+	}
+
+	// the unix time of the last successful SAVE or BGSAVE, or of startup if there hasn't been any
+	return resp.NewInteger(int(client.instance.lastSave.Load()))
+}
@@ -0,0 +1,316 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// Broker delivers published messages to the clients subscribed to a channel,
+// or to a pattern matching it.
+type Broker struct {
+	mutex    sync.RWMutex
+	channels map[string]map[*Client]struct{}
+	patterns map[string]map[*Client]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		channels: make(map[string]map[*Client]struct{}),
+		patterns: make(map[string]map[*Client]struct{}),
+	}
+}
+
+// Subscribe adds client to the subscribers of channel.
+func (b *Broker) Subscribe(client *Client, channel string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.channels[channel] == nil {
+		b.channels[channel] = make(map[*Client]struct{})
+	}
+
+	b.channels[channel][client] = struct{}{}
+}
+
+// Unsubscribe removes client from the subscribers of channel.
+func (b *Broker) Unsubscribe(client *Client, channel string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.channels[channel], client)
+
+	// channels without subscribers are dropped so they don't pile up
+	if len(b.channels[channel]) == 0 {
+		delete(b.channels, channel)
+	}
+}
+
+// PSubscribe adds client to the subscribers of pattern.
+func (b *Broker) PSubscribe(client *Client, pattern string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.patterns[pattern] == nil {
+		b.patterns[pattern] = make(map[*Client]struct{})
+	}
+
+	b.patterns[pattern][client] = struct{}{}
+}
+
+// PUnsubscribe removes client from the subscribers of pattern.
+func (b *Broker) PUnsubscribe(client *Client, pattern string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.patterns[pattern], client)
+
+	if len(b.patterns[pattern]) == 0 {
+		delete(b.patterns, pattern)
+	}
+}
+
+// Channels returns the channels with at least one subscriber, optionally filtered by a glob pattern.
+func (b *Broker) Channels(pattern string) []string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	channels := make([]string, 0, len(b.channels))
+
+	for channel := range b.channels {
+		if pattern != "" {
+			if !store.Match(pattern, channel) {
+				continue
+			}
+		}
+
+		channels = append(channels, channel)
+	}
+
+	return channels
+}
+
+// NumSub returns the number of clients subscribed to channel, not counting pattern subscribers.
+func (b *Broker) NumSub(channel string) int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return len(b.channels[channel])
+}
+
+// NumPat returns the number of unique patterns clients are subscribed to.
+func (b *Broker) NumPat() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return len(b.patterns)
+}
+
+// delivery is a message frame bound for a single subscriber.
+type delivery struct {
+	client *Client
+	frame  resp.Response
+}
+
+// Publish sends message to every subscriber of channel and of the patterns matching it,
+// and returns how many deliveries were made. It doesn't wait for the subscribers to read
+// the message, see Client.Push. A client subscribed both to the channel
+// and to matching patterns receives the message once for each of them, like in Redis.
+func (b *Broker) Publish(channel string, message string) int {
+	// collect deliveries first so pushing them doesn't hold up subscribing and unsubscribing
+	b.mutex.RLock()
+
+	deliveries := make([]delivery, 0, len(b.channels[channel]))
+
+	if len(b.channels[channel]) > 0 {
+		frame := newBulkStringArray([]string{"message", channel, message})
+
+		for client := range b.channels[channel] {
+			deliveries = append(deliveries, delivery{client: client, frame: frame})
+		}
+	}
+
+	for pattern, clients := range b.patterns {
+		if !store.Match(pattern, channel) {
+			continue
+		}
+
+		frame := newBulkStringArray([]string{"pmessage", pattern, channel, message})
+
+		for client := range clients {
+			deliveries = append(deliveries, delivery{client: client, frame: frame})
+		}
+	}
+
+	b.mutex.RUnlock()
+
+	// messages are queued rather than written, so a subscriber not reading doesn't hold up the publisher
+	for _, delivery := range deliveries {
+		delivery.client.Push(delivery.frame)
+	}
+
+	return len(deliveries)
+}
+
+// replies is a response made of several RESP frames written back to back,
+// as with SUBSCRIBE which replies once per channel.
+type replies []resp.Response
+
+func (r replies) ToString() string {
+	result := ""
+
+	for _, response := range r {
+		result += response.ToString()
+	}
+
+	return result
+}
+
+// newSubscriptionReply confirms a (un)subscription along with the client's subscription count.
+func newSubscriptionReply(kind string, channel string, count int) resp.Array {
+	return resp.NewArray([]resp.Response{
+		resp.NewBulkString(kind),
+		resp.NewBulkString(channel),
+		resp.NewInteger(count),
+	})
+}
+
+// subscribe subscribes client to names through add, replying once per name with kind and the number of
+// subscriptions. The replies are buffered before the broker knows of any subscription: the messages pushed
+// to the client are written after whatever is buffered, so none can be sent ahead of the confirmations.
+// Inside EXEC the replies are part of its own, so they're returned instead.
+func subscribe(client *Client, kind string, subscriptions map[string]struct{}, add func(client *Client, name string), names []string) resp.Response {
+	response := make(replies, 0, len(names))
+
+	for _, name := range names {
+		subscriptions[name] = struct{}{}
+		response = append(response, newSubscriptionReply(kind, name, client.subscriptionCount()))
+	}
+
+	if !client.executing {
+		// a failed write sticks to the connection, HandleMessage runs into it buffering the empty reply
+		client.bufferReply(response)
+		response = replies{}
+	}
+
+	for _, name := range names {
+		add(client, name)
+	}
+
+	client.subscribed.Store(true)
+
+	return response
+}
+
+var HandleSubscribeCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 1 {
+		return resp.NewError("wrong number of arguments for 'subscribe' command")
+	}
+
+	return subscribe(client, "subscribe", client.channels, client.instance.broker.Subscribe, args)
+}
+
+var HandlePSubscribeCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 1 {
+		return resp.NewError("wrong number of arguments for 'psubscribe' command")
+	}
+
+	return subscribe(client, "psubscribe", client.patterns, client.instance.broker.PSubscribe, args)
+}
+
+// unsubscribe removes the subscriptions of client to names, or to every one of subscriptions without names,
+// replying once per name with kind and the number of subscriptions left. Names the client isn't
+// subscribed to are replied to all the same. The client leaves subscribe mode once it has none left.
+func unsubscribe(client *Client, kind string, subscriptions map[string]struct{}, remove func(client *Client, name string), names []string) resp.Response {
+	if len(names) == 0 {
+		names = slices.Collect(maps.Keys(subscriptions))
+	}
+
+	response := make(replies, 0, len(names))
+
+	for _, name := range names {
+		if _, subscribed := subscriptions[name]; subscribed {
+			remove(client, name)
+			delete(subscriptions, name)
+		}
+
+		response = append(response, newSubscriptionReply(kind, name, client.subscriptionCount()))
+	}
+
+	// with nothing to unsubscribe from, the reply is still sent once, without a name
+	if len(names) == 0 {
+		response = append(response, resp.NewArray([]resp.Response{
+			resp.NewBulkString(kind),
+			resp.NewNullBulkString(),
+			resp.NewInteger(client.subscriptionCount()),
+		}))
+	}
+
+	if client.subscriptionCount() == 0 {
+		client.subscribed.Store(false)
+	}
+
+	return response
+}
+
+var HandleUnsubscribeCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return unsubscribe(client, "unsubscribe", client.channels, client.instance.broker.Unsubscribe, args)
+}
+
+var HandlePUnsubscribeCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return unsubscribe(client, "punsubscribe", client.patterns, client.instance.broker.PUnsubscribe, args)
+}
+
+var HandlePublishCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'publish' command")
+	}
+
+	receivers := client.instance.broker.Publish(args[0], args[1])
+
+	return resp.NewInteger(receivers)
+}
+
+var HandlePubSubCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 1 {
+		return resp.NewError("wrong number of arguments for 'pubsub' command")
+	}
+
+	broker := client.instance.broker
+	subcommand := strings.ToLower(args[0])
+
+	switch {
+	case subcommand == "channels" && len(args) <= 2:
+		pattern := ""
+
+		if len(args) == 2 {
+			pattern = args[1]
+		}
+
+		return newBulkStringArray(broker.Channels(pattern))
+
+	case subcommand == "numsub":
+		responseSlice := make([]resp.Response, 0, len(args[1:])*2)
+
+		for _, channel := range args[1:] {
+			responseSlice = append(responseSlice,
+				resp.NewBulkString(channel),
+				resp.NewInteger(broker.NumSub(channel)),
+			)
+		}
+
+		return resp.NewArray(responseSlice)
+
+	case subcommand == "numpat" && len(args) == 1:
+		return resp.NewInteger(broker.NumPat())
+	}
+
+	return resp.NewError(
+		fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'", args[0]),
+	)
+}
@@ -0,0 +1,647 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+const (
+	// how long a replica waits on its leader to connect
+	leaderDialTimeout = 5 * time.Second
+
+	// how long a replica waits before connecting again to a leader it lost
+	leaderRetryDelay = time.Second
+)
+
+// newReplID returns a random replication id, 40 hex characters like Redis.
+func newReplID() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// replica is the connection of a replica, fed every write of the instance once synced.
+type replica struct {
+	client *Client
+
+	mutex sync.Mutex
+
+	// writes made while the snapshot is on its way, sent right after it,
+	// and their size in bytes, bounded like the writes pushed once online
+	backlog      []resp.Response
+	backlogBytes int
+
+	// set once the snapshot is sent, writes are then pushed to the replica as they're made
+	online bool
+
+	// set once the replica is disconnected for falling behind before it's online
+	dropped bool
+
+	// the replication offset the replica last acknowledged with REPLCONF ACK
+	acked atomic.Int64
+}
+
+// send sends a write to the replica, or holds it back until the snapshot is sent.
+// It never waits on the replica: a replica falling too far behind is disconnected instead,
+// see Client.Push, and syncs all over again once it connects back.
+func (r *replica) send(command resp.Response, size int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.online {
+		r.client.Push(command)
+		return
+	}
+
+	if r.dropped {
+		return
+	}
+
+	if r.backlogBytes+size > maxReplicaPushedBytes {
+		r.client.logger.Warn("Closing connection, too many writes waiting to be sent to the replica", "pending_bytes", r.backlogBytes)
+
+		r.backlog = nil
+		r.dropped = true
+		r.client.kill()
+		return
+	}
+
+	r.backlogBytes += size
+
+	r.backlog = append(r.backlog, command)
+}
+
+// replicas holds the replicas connected to the instance.
+type replicas struct {
+	mutex   sync.RWMutex
+	clients map[*Client]*replica
+
+	// the number of replicas, read by every write without taking the mutex
+	size atomic.Int32
+
+	// the replication offset: how many bytes of writes have been sent to replicas
+	offset atomic.Int64
+
+	// closed on the next acknowledgement from a replica, nil until someone waits for one
+	acks chan struct{}
+}
+
+func (r *replicas) add(added *replica) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.clients == nil {
+		r.clients = make(map[*Client]*replica)
+	}
+
+	r.clients[added.client] = added
+	r.size.Store(int32(len(r.clients)))
+}
+
+func (r *replicas) remove(client *Client) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.clients, client)
+	r.size.Store(int32(len(r.clients)))
+}
+
+func (r *replicas) count() int {
+	return int(r.size.Load())
+}
+
+// feed sends a write to every replica. the caller must hold the write mutex,
+// so that replicas get the writes in the order they were made.
+func (r *replicas) feed(args []string) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if len(r.clients) == 0 {
+		return
+	}
+
+	command := newBulkStringArray(args)
+	size := len(command.ToString())
+	r.offset.Add(int64(size))
+
+	for _, replica := range r.clients {
+		replica.send(command, size)
+	}
+}
+
+// ack records the replication offset acknowledged by the replica connected as client.
+func (r *replicas) ack(client *Client, offset int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	replica, exists := r.clients[client]
+
+	if !exists {
+		return
+	}
+
+	replica.acked.Store(offset)
+
+	if r.acks != nil {
+		close(r.acks)
+		r.acks = nil
+	}
+}
+
+// ackedCount returns how many replicas have acknowledged offset, along with a channel
+// closed once another acknowledgement comes in.
+func (r *replicas) ackedCount(offset int64) (int, <-chan struct{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	count := 0
+
+	for _, replica := range r.clients {
+		if replica.acked.Load() >= offset {
+			count++
+		}
+	}
+
+	if r.acks == nil {
+		r.acks = make(chan struct{})
+	}
+
+	return count, r.acks
+}
+
+// waitForAcks waits until at least count replicas have acknowledged offset or ctx is done,
+// returning how many have.
+func (r *replicas) waitForAcks(ctx context.Context, offset int64, count int) int {
+	for {
+		acked, acks := r.ackedCount(offset)
+
+		if acked >= count {
+			return acked
+		}
+
+		select {
+		case <-ctx.Done():
+			return acked
+		case <-acks:
+		}
+	}
+}
+
+var HandlePSyncCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'psync' command")
+	}
+
+	// EXEC holds every command off, taking the checkpoint below would wait on it forever
+	if client.executing {
+		return resp.NewError("Command not allowed inside a transaction")
+	}
+
+	instance := client.instance
+	r := &replica{client: client}
+
+	// no command may run while the checkpoint is taken and the replica added,
+	// so that every write is either in the snapshot or sent to the replica, never both
+	instance.execMutex.Lock()
+	instance.writeMutex.Lock()
+
+	checkpoint := kv.Checkpoint()
+	offset := instance.replicas.offset.Load()
+	instance.replicas.add(r)
+	client.replicating.Store(true)
+
+	instance.writeMutex.Unlock()
+	instance.execMutex.Unlock()
+
+	var snapshot bytes.Buffer
+
+	if err := checkpoint.Write(&snapshot); err != nil {
+		instance.replicas.remove(client)
+		client.replicating.Store(false)
+
+		return resp.NewError(err.Error())
+	}
+
+	// every data set is sent whole, there's no backlog to resume a sync from.
+	// a redig snapshot isn't an RDB file, so it's sent as a plain bulk string
+	r.mutex.Lock()
+
+	client.bufferReply(replies{
+		resp.NewSimpleString(fmt.Sprintf("FULLRESYNC %s %d", instance.replID, offset)),
+		resp.NewBulkString(snapshot.String()),
+	})
+
+	for _, command := range r.backlog {
+		client.bufferReply(command)
+	}
+
+	r.backlog, r.backlogBytes = nil, 0
+	r.online = true
+
+	r.mutex.Unlock()
+
+	client.Flush()
+
+	client.logger.Info("Replica synced", "keys", kv.KeyCount(), "snapshot_bytes", snapshot.Len())
+
+	// everything's been sent already
+	return replies{}
+}
+
+// leaderLink is the connection of a replica to its leader, kept up until REPLICAOF NO ONE.
+type leaderLink struct {
+	addr string
+
+	// cancel cuts the link, which closes stopped once it's gone
+	cancel  context.CancelFunc
+	stopped chan struct{}
+
+	// set once synced, while writes stream in
+	up atomic.Bool
+
+	// the replication offset of the writes applied so far
+	offset atomic.Int64
+}
+
+// replicate makes the instance a replica of the leader at addr, replacing the link to any
+// previous leader. Its data is replaced by the leader's once synced.
+func (i *Instance) replicate(addr string, kv *store.KVStore) {
+	i.stopReplicating()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	link := &leaderLink{
+		addr:    addr,
+		cancel:  cancel,
+		stopped: make(chan struct{}),
+	}
+
+	i.leaderMutex.Lock()
+	i.leader = link
+	i.leaderMutex.Unlock()
+
+	go i.runLeaderLink(ctx, link, kv)
+
+	i.logger.Info("Replicating", "leader", addr)
+}
+
+// stopReplicating cuts the link to the leader if there is one, waiting for it to be gone.
+// The data synced so far is kept.
+func (i *Instance) stopReplicating() {
+	i.leaderMutex.Lock()
+	link := i.leader
+	i.leader = nil
+	i.leaderMutex.Unlock()
+
+	if link == nil {
+		return
+	}
+
+	link.cancel()
+	<-link.stopped
+
+	i.logger.Info("Stopped replicating", "leader", link.addr)
+}
+
+// currentLeader returns the link to the leader, nil unless the instance is a replica.
+func (i *Instance) currentLeader() *leaderLink {
+	i.leaderMutex.Lock()
+	defer i.leaderMutex.Unlock()
+
+	return i.leader
+}
+
+// runLeaderLink syncs with the leader and applies its writes, connecting again
+// whenever the link is lost, until ctx is cancelled.
+func (i *Instance) runLeaderLink(ctx context.Context, link *leaderLink, kv *store.KVStore) {
+	defer close(link.stopped)
+
+	for {
+		err := i.syncWithLeader(ctx, link, kv)
+		link.up.Store(false)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		i.logger.Warn("Lost the link to the leader, connecting again", "leader", link.addr, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(leaderRetryDelay):
+		}
+	}
+}
+
+// syncWithLeader asks the leader for its data, loads it in place of kv's, then applies
+// the writes the leader streams until the connection is lost.
+func (i *Instance) syncWithLeader(ctx context.Context, link *leaderLink, kv *store.KVStore) error {
+	dialer := net.Dialer{Timeout: leaderDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", link.addr)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	// closing the connection is the only way to interrupt a pending read
+	stopReading := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stopReading()
+
+	if _, err := conn.Write([]byte(newBulkStringArray([]string{PSyncCommand, "?", "-1"}).ToString())); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+
+	reply, err := resp.Parse(reader)
+
+	if err != nil {
+		return err
+	}
+
+	status, ok := reply.(resp.SimpleString)
+
+	if !ok || !strings.HasPrefix(status.Value, "FULLRESYNC ") {
+		return fmt.Errorf("unexpected reply to PSYNC: %q", reply.ToString())
+	}
+
+	// FULLRESYNC <replid> <offset>, the offset the snapshot was taken at
+	fields := strings.Fields(status.Value)
+
+	if len(fields) != 3 {
+		return fmt.Errorf("unexpected reply to PSYNC: %q", reply.ToString())
+	}
+
+	offset, err := strconv.ParseInt(fields[2], 10, 64)
+
+	if err != nil {
+		return fmt.Errorf("unexpected reply to PSYNC: %q", reply.ToString())
+	}
+
+	reply, err = resp.Parse(reader)
+
+	if err != nil {
+		return err
+	}
+
+	snapshot, ok := reply.(resp.BulkString)
+
+	if !ok {
+		return fmt.Errorf("unexpected snapshot: %q", reply.ToString())
+	}
+
+	if err := i.loadLeaderSnapshot(kv, snapshot.Value); err != nil {
+		return err
+	}
+
+	link.offset.Store(offset)
+	link.up.Store(true)
+	i.logger.Info("Synced with the leader", "leader", link.addr, "keys", kv.KeyCount())
+
+	// the leader learns right away where the replica is at
+	if err := sendAck(conn, offset); err != nil {
+		return err
+	}
+
+	client := newReplayClient(i)
+
+	for {
+		reply, err := resp.Parse(reader)
+
+		if err != nil {
+			return err
+		}
+
+		args, ok := commandArgs(reply)
+
+		if !ok {
+			return fmt.Errorf("unexpected command: %q", reply.ToString())
+		}
+
+		command := strings.ToLower(args[0])
+
+		// the offset acknowledged doesn't count the REPLCONF GETACK itself, like Redis
+		if command == ReplConfCommand && len(args) == 3 && strings.EqualFold(args[1], "getack") {
+			if err := sendAck(conn, link.offset.Load()); err != nil {
+				return err
+			}
+		} else if handler, exists := handlers[command]; exists {
+			// run like any other command, so the write reaches the AOF and replicas of this instance too
+			execute(client, command, handler, args[1:], kv)
+		} else {
+			i.logger.Warn("Skipping unknown command from the leader", "command", args[0])
+		}
+
+		link.offset.Add(int64(len(reply.ToString())))
+	}
+}
+
+// sendAck tells the leader the replication offset of the writes applied so far.
+func sendAck(conn net.Conn, offset int64) error {
+	_, err := conn.Write([]byte(newBulkStringArray([]string{ReplConfCommand, "ACK", strconv.FormatInt(offset, 10)}).ToString()))
+	return err
+}
+
+// loadLeaderSnapshot replaces the data in kv with the snapshot sent by the leader.
+// the AOF isn't rewritten, it only gets the writes streamed after the snapshot.
+func (i *Instance) loadLeaderSnapshot(kv *store.KVStore, snapshot string) error {
+	// no command may see the data halfway replaced
+	i.execMutex.Lock()
+	defer i.execMutex.Unlock()
+
+	kv.FlushAll()
+
+	return kv.Load(strings.NewReader(snapshot))
+}
+
+// commandArgs returns the arguments of a command sent as an array of bulk strings.
+func commandArgs(command resp.Response) ([]string, bool) {
+	array, ok := command.(resp.Array)
+
+	if !ok || len(array.Elements) == 0 {
+		return nil, false
+	}
+
+	args := make([]string, len(array.Elements))
+
+	for i, element := range array.Elements {
+		arg, ok := element.(resp.BulkString)
+
+		if !ok {
+			return nil, false
+		}
+
+		args[i] = arg.Value
+	}
+
+	return args, true
+}
+
+// REPLICAOF starts the link to the leader, which runs the leader's writes through handlers:
+// registering it along with the other handlers would make their initialization depend on itself.
+func init() {
+	handlers[ReplicaOfCommand] = HandleReplicaOfCommand
+	handlers[SlaveOfCommand] = HandleReplicaOfCommand
+}
+
+var HandleReplicaOfCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'replicaof' command")
+	}
+
+	// the link applies the leader's writes under the transaction lock, held by EXEC
+	if client.executing {
+		return resp.NewError("Command not allowed inside a transaction")
+	}
+
+	instance := client.instance
+
+	if strings.EqualFold(args[0], "no") && strings.EqualFold(args[1], "one") {
+		instance.stopReplicating()
+		return resp.NewOKResponse()
+	}
+
+	port, err := strconv.Atoi(args[1])
+
+	if err != nil {
+		return resp.NewError("value is not an integer or out of range")
+	}
+
+	if port < 0 || port > 65535 {
+		return resp.NewError("Invalid master port")
+	}
+
+	addr := net.JoinHostPort(args[0], args[1])
+
+	if leader := instance.currentLeader(); leader != nil && leader.addr == addr {
+		return resp.NewSimpleString("OK Already connected to specified master")
+	}
+
+	instance.replicate(addr, kv)
+
+	return resp.NewOKResponse()
+}
+
+var HandleReplConfCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return resp.NewError("wrong number of arguments for 'replconf' command")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "ack":
+		offset, err := strconv.ParseInt(args[1], 10, 64)
+
+		if err != nil {
+			return resp.NewError("value is not an integer or out of range")
+		}
+
+		client.instance.replicas.ack(client, offset)
+
+		// replicas aren't replied to, their connection only carries writes the other way
+		return replies{}
+
+	case "getack":
+		// only means something coming from the leader, see syncWithLeader
+		return replies{}
+
+	case "listening-port", "ip-address", "capa":
+		return resp.NewOKResponse()
+	}
+
+	return resp.NewError(fmt.Sprintf("Unrecognized REPLCONF option: %s", args[0]))
+}
+
+// replicationFields reports the role of the instance for INFO, along with its leader or replicas.
+func replicationFields(instance *Instance) []infoField {
+	leader := instance.currentLeader()
+
+	if leader == nil {
+		fields := []infoField{
+			{"role", "master"},
+			{"connected_slaves", strconv.Itoa(instance.replicas.count())},
+		}
+
+		fields = append(fields, replicaFields(instance)...)
+
+		return append(fields,
+			infoField{"master_replid", instance.replID},
+			infoField{"master_repl_offset", strconv.FormatInt(instance.replicas.offset.Load(), 10)},
+		)
+	}
+
+	host, port, _ := net.SplitHostPort(leader.addr)
+	status := "down"
+
+	if leader.up.Load() {
+		status = "up"
+	}
+
+	return []infoField{
+		{"role", "slave"},
+		{"master_host", host},
+		{"master_port", port},
+		{"master_link_status", status},
+		{"slave_repl_offset", strconv.FormatInt(leader.offset.Load(), 10)},
+		{"connected_slaves", strconv.Itoa(instance.replicas.count())},
+		{"master_replid", instance.replID},
+	}
+}
+
+// replicaFields reports a slave<n> line per replica, with the offset it last acknowledged.
+func replicaFields(instance *Instance) []infoField {
+	instance.replicas.mutex.RLock()
+	defer instance.replicas.mutex.RUnlock()
+
+	connected := make([]*replica, 0, len(instance.replicas.clients))
+
+	for _, replica := range instance.replicas.clients {
+		connected = append(connected, replica)
+	}
+
+	slices.SortFunc(connected, func(a, b *replica) int {
+		return cmp.Compare(a.client.id, b.client.id)
+	})
+
+	fields := make([]infoField, len(connected))
+
+	for n, replica := range connected {
+		host, port, _ := net.SplitHostPort(replica.client.addr())
+		state := "wait_bgsave"
+
+		replica.mutex.Lock()
+
+		if replica.online {
+			state = "online"
+		}
+
+		replica.mutex.Unlock()
+
+		fields[n] = infoField{
+			"slave" + strconv.Itoa(n),
+			fmt.Sprintf("ip=%s,port=%s,state=%s,offset=%d", host, port, state, replica.acked.Load()),
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// defaultScanCount is how many elements a scan returns per call unless told otherwise, like Redis.
+const defaultScanCount = 10
+
+// scanArgs are the arguments shared by HSCAN, SSCAN and ZSCAN: key cursor [MATCH pattern] [COUNT count].
+type scanArgs struct {
+	key     string
+	cursor  uint64
+	pattern string
+	count   int
+}
+
+func parseScanArgs(name string, args []string) (scanArgs, resp.Response) {
+	if len(args) < 2 {
+		return scanArgs{}, resp.NewError("wrong number of arguments for '" + name + "' command")
+	}
+
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+
+	if err != nil {
+		return scanArgs{}, resp.NewError("invalid cursor")
+	}
+
+	parsed := scanArgs{key: args[0], cursor: cursor, pattern: "*", count: defaultScanCount}
+
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return scanArgs{}, resp.NewError("syntax error")
+		}
+
+		switch {
+		case strings.EqualFold(args[i], "match"):
+			parsed.pattern = args[i+1]
+		case strings.EqualFold(args[i], "count"):
+			count, err := strconv.Atoi(args[i+1])
+
+			if err != nil {
+				return scanArgs{}, resp.NewError("value is not an integer or out of range")
+			}
+
+			if count < 1 {
+				return scanArgs{}, resp.NewError("syntax error")
+			}
+
+			parsed.count = count
+		default:
+			return scanArgs{}, resp.NewError("syntax error")
+		}
+	}
+
+	return parsed, nil
+}
+
+// newScanResponse replies with the cursor to carry on from and the elements of this call.
+func newScanResponse(next uint64, elements []string) resp.Array {
+	return resp.NewArray([]resp.Response{
+		resp.NewBulkString(strconv.FormatUint(next, 10)),
+		newBulkStringArray(elements),
+	})
+}
+
+var HandleHScanCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	scan, errResponse := parseScanArgs("hscan", args)
+
+	if errResponse != nil {
+		return errResponse
+	}
+
+	fieldValues, next, err := kv.HScan(scan.key, scan.cursor, scan.count)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// like Redis, fields are matched once picked, so a call may return none while the scan goes on
+	matched := make([]string, 0, len(fieldValues))
+
+	for i := 0; i < len(fieldValues); i += 2 {
+		if store.Match(scan.pattern, fieldValues[i]) {
+			matched = append(matched, fieldValues[i], fieldValues[i+1])
+		}
+	}
+
+	return newScanResponse(next, matched)
+}
+
+var HandleSScanCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	scan, errResponse := parseScanArgs("sscan", args)
+
+	if errResponse != nil {
+		return errResponse
+	}
+
+	members, next, err := kv.SScan(scan.key, scan.cursor, scan.count)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	matched := make([]string, 0, len(members))
+
+	for _, member := range members {
+		if store.Match(scan.pattern, member) {
+			matched = append(matched, member)
+		}
+	}
+
+	return newScanResponse(next, matched)
+}
+
+var HandleZScanCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	scan, errResponse := parseScanArgs("zscan", args)
+
+	if errResponse != nil {
+		return errResponse
+	}
+
+	members, next, err := kv.ZScan(scan.key, scan.cursor, scan.count)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	matched := make([]string, 0, len(members)*2)
+
+	for _, member := range members {
+		if store.Match(scan.pattern, member.Member) {
+			matched = append(matched, member.Member, formatScore(member.Score))
+		}
+	}
+
+	return newScanResponse(next, matched)
+}
@@ -0,0 +1,350 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+var HandleTimeCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 0 {
+		return resp.NewError("wrong number of arguments for 'time' command")
+	}
+
+	now := time.Now()
+
+	// the unix time in seconds and the microseconds elapsed within that second
+	return newBulkStringArray([]string{
+		strconv.FormatInt(now.Unix(), 10),
+		strconv.Itoa(now.Nanosecond() / int(time.Microsecond)),
+	})
+}
+
+// infoField is a single "name:value" line of INFO.
+type infoField struct {
+	name  string
+	value string
+}
+
+// redisVersion is the version of Redis reported to clients, some of which only use
+// the commands of the versions they expect.
+const redisVersion = "7.2.0"
+
+// infoSection is a "# Name" block of INFO.
+type infoSection struct {
+	name   string
+	fields []infoField
+
+	// left out of the default sections, only reported when asked for or with "all"
+	extra bool
+}
+
+// infoSections gathers every section reported by INFO, in the order Redis reports them.
+func infoSections(client *Client, kv *store.KVStore) []infoSection {
+	instance := client.instance
+	uptime := time.Since(instance.startTime)
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	return []infoSection{
+		{name: "Server", fields: []infoField{
+			{"redis_version", redisVersion},
+			{"redis_mode", "standalone"},
+			{"process_id", strconv.Itoa(os.Getpid())},
+			{"run_id", instance.runID},
+			{"uptime_in_seconds", strconv.Itoa(int(uptime.Seconds()))},
+			{"uptime_in_days", strconv.Itoa(int(uptime.Hours() / 24))},
+		}},
+		{name: "Clients", fields: []infoField{
+			{"connected_clients", strconv.Itoa(instance.clientCount())},
+		}},
+		{name: "Memory", fields: []infoField{
+			// the heap of the whole process, as the store doesn't account for the size of its values
+			{"used_memory", strconv.FormatUint(memStats.HeapAlloc, 10)},
+		}},
+		{name: "Persistence", fields: []infoField{
+			{"rdb_bgsave_in_progress", saveInProgress(instance)},
+			{"rdb_last_save_time", strconv.FormatInt(instance.lastSave.Load(), 10)},
+			{"rdb_last_bgsave_status", bgSaveStatus(instance)},
+		}},
+		{name: "Stats", fields: []infoField{
+			{"total_connections_received", strconv.FormatInt(instance.totalConnections.Load(), 10)},
+			{"total_commands_processed", strconv.FormatInt(instance.commandsProcessed.Load(), 10)},
+			{"expired_keys", strconv.Itoa(kv.ExpiredKeyCount())},
+			{"evicted_keys", strconv.Itoa(kv.EvictedKeyCount())},
+		}},
+		{name: "Replication", fields: replicationFields(instance)},
+		{name: "Commandstats", fields: commandStatsFields(instance.commandStats), extra: true},
+		{name: "Latencystats", fields: latencyStatsFields(instance.commandStats), extra: true},
+		{name: "Keyspace", fields: keyspaceFields(kv)},
+	}
+}
+
+// saveInProgress reports 1 while a snapshot is being saved, 0 otherwise.
+func saveInProgress(instance *Instance) string {
+	if instance.saving.Load() {
+		return "1"
+	}
+
+	return "0"
+}
+
+// bgSaveStatus reports how the last BGSAVE went, "ok" if there hasn't been any.
+func bgSaveStatus(instance *Instance) string {
+	if instance.lastBgSaveFailed.Load() {
+		return "err"
+	}
+
+	return "ok"
+}
+
+// keyspaceFields reports the keys of the single database, or nothing if it's empty like Redis does.
+func keyspaceFields(kv *store.KVStore) []infoField {
+	keys := kv.KeyCount()
+
+	if keys == 0 {
+		return nil
+	}
+
+	return []infoField{
+		{"db0", fmt.Sprintf("keys=%d,expires=%d", keys, kv.ExpiringKeyCount())},
+	}
+}
+
+var HandleInfoCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) > 1 {
+		return resp.NewError("wrong number of arguments for 'info' command")
+	}
+
+	section := "default"
+
+	if len(args) == 1 {
+		section = strings.ToLower(args[0])
+	}
+
+	defaultSections := section == "default"
+	everySection := section == "all" || section == "everything"
+
+	var info strings.Builder
+
+	for _, s := range infoSections(client, kv) {
+		if !everySection && !(defaultSections && !s.extra) && strings.ToLower(s.name) != section {
+			continue
+		}
+
+		if info.Len() > 0 {
+			info.WriteString(resp.CRLF)
+		}
+
+		info.WriteString("# " + s.name + resp.CRLF)
+
+		for _, field := range s.fields {
+			info.WriteString(field.name + ":" + field.value + resp.CRLF)
+		}
+	}
+
+	return resp.NewBulkString(info.String())
+}
+
+var HandleMemoryCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 1 {
+		return resp.NewError("wrong number of arguments for 'memory' command")
+	}
+
+	if strings.ToLower(args[0]) != "usage" || (len(args) != 2 && len(args) != 4) {
+		return resp.NewError(
+			fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'", args[0]),
+		)
+	}
+
+	key := args[1]
+	samples := store.DefaultMemorySamples
+
+	if len(args) == 4 {
+		if strings.ToLower(args[2]) != "samples" {
+			return resp.NewError("syntax error")
+		}
+
+		var err error
+		samples, err = strconv.Atoi(args[3])
+
+		if err != nil || samples < 0 {
+			return resp.NewError("value is not an integer or out of range")
+		}
+	}
+
+	usage, exists := kv.MemoryUsage(key, samples)
+
+	if !exists {
+		return resp.NewNullBulkString()
+	}
+
+	return resp.NewInteger(usage)
+}
+
+// newKeySummary reports the number of keys of every type and with a TTL,
+// as DEBUG KEYSUMMARY does, in the format of INFO.
+func newKeySummary(kv *store.KVStore) resp.Response {
+	counts := kv.TypeCounts()
+
+	var summary strings.Builder
+
+	for _, name := range []string{"string", "list", "hash", "set", "zset", "expires"} {
+		summary.WriteString(name + ":" + strconv.Itoa(counts[name]) + resp.CRLF)
+	}
+
+	return resp.NewBulkString(summary.String())
+}
+
+var HandleDebugCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 1 {
+		return resp.NewError("wrong number of arguments for 'debug' command")
+	}
+
+	subcommand := strings.ToLower(args[0])
+
+	if subcommand == "keysummary" && len(args) == 1 {
+		return newKeySummary(kv)
+	}
+
+	if subcommand != "object" || len(args) != 2 {
+		return resp.NewError(
+			fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'", args[0]),
+		)
+	}
+
+	debug, exists := kv.DebugObject(args[1])
+
+	if !exists {
+		return errorResponse(store.ErrNoSuchKey)
+	}
+
+	// the LRU clock of Redis counts seconds on 24 bits
+	lru := time.Now().Add(-debug.IdleTime).Unix() & (1<<24 - 1)
+
+	line := fmt.Sprintf(
+		"Value at:0x%x refcount:1 encoding:%s serializedlength:%d lru:%d lru_seconds_idle:%d",
+		debug.Address, debug.Encoding, debug.SerializedLength, lru, int64(debug.IdleTime.Seconds()),
+	)
+
+	if debug.ListNodes > 0 {
+		line += fmt.Sprintf(
+			" ql_nodes:%d ql_avg_node:%.2f ql_listpack_max:-2 ql_compressed:0",
+			debug.ListNodes, float64(debug.Length)/float64(debug.ListNodes),
+		)
+	}
+
+	// a string has no elements to count
+	if debug.Length > 0 {
+		line += fmt.Sprintf(" length:%d", debug.Length)
+	}
+
+	return resp.NewSimpleString(line)
+}
+
+var HandleWaitCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'wait' command")
+	}
+
+	numReplicas, err := strconv.Atoi(args[0])
+
+	if err != nil {
+		return resp.NewError("value is not an integer or out of range")
+	}
+
+	timeout, err := strconv.Atoi(args[1])
+
+	if err != nil {
+		return resp.NewError("value is not an integer or out of range")
+	}
+
+	if timeout < 0 {
+		return resp.NewError("timeout is negative")
+	}
+
+	instance := client.instance
+
+	if instance.currentLeader() != nil {
+		return resp.NewError("WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated.")
+	}
+
+	// every write made so far, not only the client's own
+	offset := instance.replicas.offset.Load()
+	acked, _ := instance.replicas.ackedCount(offset)
+
+	// inside a transaction the replicas can't be waited on
+	if acked >= numReplicas || client.executing {
+		return resp.NewInteger(acked)
+	}
+
+	// ask the replicas where they're at rather than waiting for them to tell
+	instance.writeMutex.Lock()
+	instance.replicas.feed([]string{ReplConfCommand, "GETACK", "*"})
+	instance.writeMutex.Unlock()
+
+	// the wait is abandoned when the client disconnects, a timeout of 0 waiting for good
+	ctx := client.ctx
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+		defer cancel()
+	}
+
+	// the replies to the commands before must not wait for the replicas
+	client.Flush()
+
+	return resp.NewInteger(instance.replicas.waitForAcks(ctx, offset, numReplicas))
+}
+
+var HandleShutdownCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) > 1 {
+		return resp.NewError("wrong number of arguments for 'shutdown' command")
+	}
+
+	instance := client.instance
+
+	// a snapshot is saved by default whenever snapshots are on
+	save := instance.persistence.SnapshotPath != ""
+
+	if len(args) == 1 {
+		switch strings.ToLower(args[0]) {
+		case "save":
+			save = true
+		case "nosave":
+			save = false
+		default:
+			return resp.NewError("syntax error")
+		}
+	}
+
+	// the server keeps running if the data can't be saved, so it isn't lost
+	if save {
+		if err := instance.startSaving(); err != nil {
+			client.logger.Error("Can't save before shutting down", "error", err)
+			return resp.NewError("Errors trying to SHUTDOWN. Check logs.")
+		}
+
+		err := instance.save(kv.Checkpoint())
+		instance.stopSaving()
+
+		if err != nil {
+			return resp.NewError("Errors trying to SHUTDOWN. Check logs.")
+		}
+	}
+
+	instance.Shutdown()
+
+	client.closeAfterReply = true
+
+	return resp.NewOKResponse()
+}
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+var HandleSAddCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 2 {
+		return resp.NewError("wrong number of arguments for 'sadd' command")
+	}
+
+	added, err := kv.SAdd(args[0], args[1:]...)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if added > 0 {
+		client.instance.notify(notifySet, "sadd", args[0])
+	}
+
+	return resp.NewInteger(added)
+}
+
+var HandleSRemCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 2 {
+		return resp.NewError("wrong number of arguments for 'srem' command")
+	}
+
+	removed, err := kv.SRem(args[0], args[1:]...)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if removed > 0 {
+		client.instance.notify(notifySet, "srem", args[0])
+		client.instance.notifyIfDeleted(kv, args[0])
+	}
+
+	return resp.NewInteger(removed)
+}
+
+var HandleSMembersCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 1 {
+		return resp.NewError("wrong number of arguments for 'smembers' command")
+	}
+
+	members, err := kv.SMembers(args[0])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return newBulkStringArray(members)
+}
+
+var HandleSIsMemberCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'sismember' command")
+	}
+
+	isMember, err := kv.SIsMember(args[0], args[1])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return resp.NewIntegerFromBool(isMember)
+}
+
+var HandleSCardCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 1 {
+		return resp.NewError("wrong number of arguments for 'scard' command")
+	}
+
+	cardinality, err := kv.SCard(args[0])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return resp.NewInteger(cardinality)
+}
+
+// handleSetCombine is shared by SINTER, SUNION and SDIFF.
+func handleSetCombine(name string, combine func(keys ...string) ([]string, error), args []string) resp.Response {
+	if len(args) < 1 {
+		return resp.NewError("wrong number of arguments for '" + name + "' command")
+	}
+
+	members, err := combine(args...)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return newBulkStringArray(members)
+}
+
+// handleSetCombineStore is shared by SINTERSTORE, SUNIONSTORE and SDIFFSTORE.
+func handleSetCombineStore(client *Client, kv *store.KVStore, name string, combineStore func(dst string, keys ...string) (int, error), args []string) resp.Response {
+	if len(args) < 2 {
+		return resp.NewError("wrong number of arguments for '" + name + "' command")
+	}
+
+	existed := kv.Has(args[0])
+	cardinality, err := combineStore(args[0], args[1:]...)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// an empty result deletes the destination rather than storing an empty set
+	switch {
+	case cardinality > 0:
+		client.instance.notify(notifySet, name, args[0])
+	case existed:
+		client.instance.notify(notifyGeneric, "del", args[0])
+	}
+
+	return resp.NewInteger(cardinality)
+}
+
+var HandleSInterCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handleSetCombine("sinter", kv.SInter, args)
+}
+
+var HandleSUnionCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handleSetCombine("sunion", kv.SUnion, args)
+}
+
+var HandleSDiffCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handleSetCombine("sdiff", kv.SDiff, args)
+}
+
+var HandleSInterStoreCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handleSetCombineStore(client, kv, "sinterstore", kv.SInterStore, args)
+}
+
+var HandleSUnionStoreCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handleSetCombineStore(client, kv, "sunionstore", kv.SUnionStore, args)
+}
+
+var HandleSDiffStoreCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handleSetCombineStore(client, kv, "sdiffstore", kv.SDiffStore, args)
+}
+
+var HandleSMIsMemberCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 2 {
+		return resp.NewError("wrong number of arguments for 'smismember' command")
+	}
+
+	isMember, err := kv.SMIsMember(args[0], args[1:]...)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	responseSlice := make([]resp.Response, len(isMember))
+
+	for i, member := range isMember {
+		responseSlice[i] = resp.NewIntegerFromBool(member)
+	}
+
+	return resp.NewArray(responseSlice)
+}
+
+var HandleSMoveCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 3 {
+		return resp.NewError("wrong number of arguments for 'smove' command")
+	}
+
+	moved, err := kv.SMove(args[0], args[1], args[2])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if moved {
+		client.instance.notify(notifySet, "srem", args[0])
+		client.instance.notify(notifySet, "sadd", args[1])
+		client.instance.notifyIfDeleted(kv, args[0])
+	}
+
+	return resp.NewIntegerFromBool(moved)
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// sortArgs are the options of SORT key [LIMIT offset count] [ASC|DESC] [ALPHA].
+type sortArgs struct {
+	alpha      bool
+	descending bool
+
+	// the window of sorted elements replied with, a negative count meaning all of them from offset
+	offset int
+	count  int
+}
+
+func parseSortArgs(args []string) (sortArgs, resp.Response) {
+	parsed := sortArgs{count: -1}
+
+	for i := 0; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "alpha"):
+			parsed.alpha = true
+		case strings.EqualFold(args[i], "asc"):
+			parsed.descending = false
+		case strings.EqualFold(args[i], "desc"):
+			parsed.descending = true
+		case strings.EqualFold(args[i], "limit") && i+2 < len(args):
+			var offsetErr, countErr error
+			parsed.offset, offsetErr = strconv.Atoi(args[i+1])
+			parsed.count, countErr = strconv.Atoi(args[i+2])
+
+			if offsetErr != nil || countErr != nil {
+				return sortArgs{}, resp.NewError("value is not an integer or out of range")
+			}
+
+			i += 2
+		default:
+			return sortArgs{}, resp.NewError("syntax error")
+		}
+	}
+
+	return parsed, nil
+}
+
+// sortElements sorts elements in place, by their numeric value unless alpha is set.
+// elements of equal value are ordered by their bytes, so that the members of a set always
+// come out the same way. it returns false if an element isn't a number and alpha isn't set.
+func sortElements(elements []string, alpha bool, descending bool) bool {
+	direction := 1
+
+	if descending {
+		direction = -1
+	}
+
+	if alpha {
+		slices.SortFunc(elements, func(a, b string) int {
+			return direction * strings.Compare(a, b)
+		})
+
+		return true
+	}
+
+	scores := make(map[string]float64, len(elements))
+
+	for _, element := range elements {
+		score, err := strconv.ParseFloat(element, 64)
+
+		if err != nil || math.IsNaN(score) {
+			return false
+		}
+
+		scores[element] = score
+	}
+
+	slices.SortFunc(elements, func(a, b string) int {
+		if c := cmp.Compare(scores[a], scores[b]); c != 0 {
+			return direction * c
+		}
+
+		return direction * strings.Compare(a, b)
+	})
+
+	return true
+}
+
+var HandleSortCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 1 {
+		return resp.NewError("wrong number of arguments for 'sort' command")
+	}
+
+	options, errResponse := parseSortArgs(args[1:])
+
+	if errResponse != nil {
+		return errResponse
+	}
+
+	// a copy, the list or set itself is left as is
+	elements, err := kv.Elements(args[0])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if !sortElements(elements, options.alpha, options.descending) {
+		return resp.NewError("One or more scores can't be converted into double")
+	}
+
+	from := min(max(options.offset, 0), len(elements))
+	to := len(elements)
+
+	if options.count >= 0 {
+		to = min(from+options.count, len(elements))
+	}
+
+	return newBulkStringArray(elements[from:to])
+}
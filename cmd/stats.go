@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"math/bits"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets is the number of buckets of a latency histogram, bucket i counting the calls
+// which took less than 2^i microseconds but at least 2^(i-1). the last one, past 6 days,
+// counts any call slower than that.
+const latencyBuckets = 40
+
+// commandStats records the calls of a single command, updated atomically by every client running it.
+type commandStats struct {
+	calls       atomic.Int64
+	failedCalls atomic.Int64
+	usec        atomic.Int64
+	latency     [latencyBuckets]atomic.Int64
+}
+
+// record accounts for a call which took duration, failed if it replied with an error.
+func (s *commandStats) record(duration time.Duration, failed bool) {
+	usec := duration.Microseconds()
+
+	s.calls.Add(1)
+	s.usec.Add(usec)
+	s.latency[min(bits.Len64(uint64(usec)), latencyBuckets-1)].Add(1)
+
+	if failed {
+		s.failedCalls.Add(1)
+	}
+}
+
+// percentile returns an upper bound of the latency in microseconds p percent of the calls stayed under.
+func (s *commandStats) percentile(p float64) int64 {
+	calls := s.calls.Load()
+	target := int64(float64(calls) * p / 100)
+	seen := int64(0)
+
+	for i := range s.latency {
+		seen += s.latency[i].Load()
+
+		if seen > target || seen == calls {
+			return 1 << i
+		}
+	}
+
+	return 1 << (latencyBuckets - 1)
+}
+
+// commandStatsRegistry holds the stats of every command. It's filled once with every known
+// command, so that recording a call never has to lock the map itself.
+type commandStatsRegistry map[Command]*commandStats
+
+func newCommandStatsRegistry() commandStatsRegistry {
+	registry := make(commandStatsRegistry, len(handlers))
+
+	for command := range handlers {
+		registry[command] = &commandStats{}
+	}
+
+	return registry
+}
+
+// called returns the commands which have been called at least once, sorted by name.
+func (r commandStatsRegistry) called() []Command {
+	var commands []Command
+
+	for command, stats := range r {
+		if stats.calls.Load() > 0 {
+			commands = append(commands, command)
+		}
+	}
+
+	slices.Sort(commands)
+
+	return commands
+}
+
+// commandStatsFields reports the calls and the time spent in every command called so far.
+func commandStatsFields(registry commandStatsRegistry) []infoField {
+	var fields []infoField
+
+	for _, command := range registry.called() {
+		stats := registry[command]
+		calls, usec := stats.calls.Load(), stats.usec.Load()
+
+		fields = append(fields, infoField{
+			"cmdstat_" + command,
+			fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,failed_calls=%d",
+				calls, usec, float64(usec)/float64(calls), stats.failedCalls.Load()),
+		})
+	}
+
+	return fields
+}
+
+// latencyStatsFields reports the latency percentiles of every command called so far.
+func latencyStatsFields(registry commandStatsRegistry) []infoField {
+	var fields []infoField
+
+	for _, command := range registry.called() {
+		stats := registry[command]
+
+		fields = append(fields, infoField{
+			"latency_percentiles_usec_" + command,
+			"p50=" + strconv.FormatInt(stats.percentile(50), 10) +
+				",p99=" + strconv.FormatInt(stats.percentile(99), 10) +
+				",p99.9=" + strconv.FormatInt(stats.percentile(99.9), 10),
+		})
+	}
+
+	return fields
+}
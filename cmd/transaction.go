@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// queuedCommand is a command queued between MULTI and EXEC.
+type queuedCommand struct {
+	command Command
+	handler CommandHandler
+	args    []string
+}
+
+// unwatchAll forgets every key watched by the client.
+func (c *Client) unwatchAll(kv *store.KVStore) {
+	for key := range c.watched {
+		kv.Unwatch(key)
+	}
+
+	clear(c.watched)
+}
+
+// watchedKeyChanged reports whether any watched key changed since it was watched.
+func (c *Client) watchedKeyChanged(kv *store.KVStore) bool {
+	for key, version := range c.watched {
+		if kv.Version(key) != version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resetTransaction leaves transaction mode, dropping any queued command.
+func (c *Client) resetTransaction() {
+	c.inMulti = false
+	c.multiFailed = false
+	c.queued = nil
+}
+
+var HandleMultiCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 0 {
+		return resp.NewError("wrong number of arguments for 'multi' command")
+	}
+
+	if client.inMulti {
+		return resp.NewError("MULTI calls can not be nested")
+	}
+
+	client.inMulti = true
+
+	return resp.NewOKResponse()
+}
+
+var HandleExecCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 0 {
+		return resp.NewError("wrong number of arguments for 'exec' command")
+	}
+
+	if !client.inMulti {
+		return resp.NewError("EXEC without MULTI")
+	}
+
+	queued, failed := client.queued, client.multiFailed
+	client.resetTransaction()
+
+	// an aborted transaction unwatches the keys all the same
+	if failed {
+		client.unwatchAll(kv)
+		return resp.NewExecAbortError()
+	}
+
+	// hold every other command off until the whole transaction has run
+	client.instance.execMutex.Lock()
+	defer client.instance.execMutex.Unlock()
+
+	// nothing can change the watched keys anymore, so checking them once is enough
+	changed := client.watchedKeyChanged(kv)
+	client.unwatchAll(kv)
+
+	if changed {
+		return resp.NewNilArray()
+	}
+
+	client.executing = true
+	defer func() { client.executing = false }()
+
+	responseSlice := make([]resp.Response, len(queued))
+
+	for i, command := range queued {
+		client.instance.monitors.feed(client, command.command, append([]string{command.command}, command.args...))
+		responseSlice[i] = run(client, command.command, command.handler, command.args, kv)
+	}
+
+	return resp.NewArray(responseSlice)
+}
+
+var HandleDiscardCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 0 {
+		return resp.NewError("wrong number of arguments for 'discard' command")
+	}
+
+	if !client.inMulti {
+		return resp.NewError("DISCARD without MULTI")
+	}
+
+	client.resetTransaction()
+	client.unwatchAll(kv)
+
+	return resp.NewOKResponse()
+}
+
+var HandleWatchCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 1 {
+		return resp.NewError("wrong number of arguments for 'watch' command")
+	}
+
+	if client.inMulti {
+		return resp.NewError("WATCH inside MULTI is not allowed")
+	}
+
+	for _, key := range args {
+		// watching a key twice keeps the version from the first time
+		if _, watched := client.watched[key]; watched {
+			continue
+		}
+
+		client.watched[key] = kv.Watch(key)
+	}
+
+	return resp.NewOKResponse()
+}
+
+var HandleUnwatchCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 0 {
+		return resp.NewError("wrong number of arguments for 'unwatch' command")
+	}
+
+	client.unwatchAll(kv)
+
+	return resp.NewOKResponse()
+}
@@ -0,0 +1,339 @@
+package cmd
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// parseScore parses a sorted set score, accepting inf, +inf and -inf but not NaN.
+func parseScore(arg string) (float64, bool) {
+	score, err := strconv.ParseFloat(arg, 64)
+
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+
+	return score, true
+}
+
+// formatScore formats a score the way Redis replies with it.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+var HandleZAddCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	// a key followed by at least one score/member pair
+	if len(args) < 3 || len(args)%2 != 1 {
+		return resp.NewError("wrong number of arguments for 'zadd' command")
+	}
+
+	key := args[0]
+	members := make([]store.ZMember, 0, len(args)/2)
+
+	for i := 1; i < len(args); i += 2 {
+		score, ok := parseScore(args[i])
+
+		if !ok {
+			return resp.NewError("value is not a valid float")
+		}
+
+		members = append(members, store.ZMember{Member: args[i+1], Score: score})
+	}
+
+	added, err := kv.ZAdd(key, members...)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	client.instance.notify(notifyZSet, "zadd", key)
+
+	return resp.NewInteger(added)
+}
+
+var HandleZScoreCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'zscore' command")
+	}
+
+	score, exists, err := kv.ZScore(args[0], args[1])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if !exists {
+		return resp.NewNullBulkString()
+	}
+
+	return resp.NewBulkString(formatScore(score))
+}
+
+var HandleZCardCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 1 {
+		return resp.NewError("wrong number of arguments for 'zcard' command")
+	}
+
+	cardinality, err := kv.ZCard(args[0])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return resp.NewInteger(cardinality)
+}
+
+var HandleZRemCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 2 {
+		return resp.NewError("wrong number of arguments for 'zrem' command")
+	}
+
+	removed, err := kv.ZRem(args[0], args[1:]...)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if removed > 0 {
+		client.instance.notify(notifyZSet, "zrem", args[0])
+		client.instance.notifyIfDeleted(kv, args[0])
+	}
+
+	return resp.NewInteger(removed)
+}
+
+// newZMemberArray replies with the members, interleaved with their scores if withScores is set.
+func newZMemberArray(members []store.ZMember, withScores bool) resp.Array {
+	responseSlice := make([]resp.Response, 0, len(members)*2)
+
+	for _, member := range members {
+		responseSlice = append(responseSlice, resp.NewBulkString(member.Member))
+
+		if withScores {
+			responseSlice = append(responseSlice, resp.NewBulkString(formatScore(member.Score)))
+		}
+	}
+
+	return resp.NewArray(responseSlice)
+}
+
+// handleZRange is shared by ZRANGE and ZREVRANGE.
+// ZRANGE also accepts REV, while both accept WITHSCORES.
+func handleZRange(name string, args []string, kv *store.KVStore, rev bool, allowRev bool) resp.Response {
+	if len(args) < 3 {
+		return resp.NewError("wrong number of arguments for '" + name + "' command")
+	}
+
+	key := args[0]
+	start, startErr := strconv.Atoi(args[1])
+	stop, stopErr := strconv.Atoi(args[2])
+
+	if startErr != nil || stopErr != nil {
+		return resp.NewError("value is not an integer or out of range")
+	}
+
+	withScores := false
+
+	for _, option := range args[3:] {
+		switch {
+		case strings.EqualFold(option, "withscores"):
+			withScores = true
+		case allowRev && strings.EqualFold(option, "rev"):
+			rev = true
+		default:
+			return resp.NewError("syntax error")
+		}
+	}
+
+	members, err := kv.ZRange(key, start, stop, rev)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return newZMemberArray(members, withScores)
+}
+
+var HandleZRangeCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handleZRange("zrange", args, kv, false, true)
+}
+
+var HandleZRevRangeCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handleZRange("zrevrange", args, kv, true, false)
+}
+
+// parseScoreBound parses a score range bound such as 5, (5, -inf or +inf.
+func parseScoreBound(arg string) (store.ScoreBound, bool) {
+	bound := store.ScoreBound{}
+
+	if strings.HasPrefix(arg, "(") {
+		bound.Exclusive = true
+		arg = arg[1:]
+	}
+
+	score, ok := parseScore(arg)
+	bound.Score = score
+
+	return bound, ok
+}
+
+// parseScoreRange parses the min and max arguments of a score range.
+func parseScoreRange(minArg, maxArg string) (store.ScoreBound, store.ScoreBound, bool) {
+	min, minOk := parseScoreBound(minArg)
+	max, maxOk := parseScoreBound(maxArg)
+
+	return min, max, minOk && maxOk
+}
+
+var HandleZRangeByScoreCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) < 3 {
+		return resp.NewError("wrong number of arguments for 'zrangebyscore' command")
+	}
+
+	key := args[0]
+	min, max, ok := parseScoreRange(args[1], args[2])
+
+	if !ok {
+		return resp.NewError("min or max is not a float")
+	}
+
+	withScores := false
+	offset, count := 0, -1
+
+	for i := 3; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "withscores"):
+			withScores = true
+		case strings.EqualFold(args[i], "limit") && i+2 < len(args):
+			var offsetErr, countErr error
+			offset, offsetErr = strconv.Atoi(args[i+1])
+			count, countErr = strconv.Atoi(args[i+2])
+
+			if offsetErr != nil || countErr != nil {
+				return resp.NewError("value is not an integer or out of range")
+			}
+
+			i += 2
+		default:
+			return resp.NewError("syntax error")
+		}
+	}
+
+	members, err := kv.ZRangeByScore(key, min, max, offset, count)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return newZMemberArray(members, withScores)
+}
+
+var HandleZCountCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 3 {
+		return resp.NewError("wrong number of arguments for 'zcount' command")
+	}
+
+	min, max, ok := parseScoreRange(args[1], args[2])
+
+	if !ok {
+		return resp.NewError("min or max is not a float")
+	}
+
+	count, err := kv.ZCount(args[0], min, max)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return resp.NewInteger(count)
+}
+
+var HandleZIncrByCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 3 {
+		return resp.NewError("wrong number of arguments for 'zincrby' command")
+	}
+
+	delta, ok := parseScore(args[1])
+
+	if !ok {
+		return resp.NewError("value is not a valid float")
+	}
+
+	score, err := kv.ZIncrBy(args[0], delta, args[2])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	client.instance.notify(notifyZSet, "zincr", args[0])
+
+	return resp.NewBulkString(formatScore(score))
+}
+
+var HandleZRankCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	if len(args) != 2 {
+		return resp.NewError("wrong number of arguments for 'zrank' command")
+	}
+
+	rank, exists, err := kv.ZRank(args[0], args[1])
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if !exists {
+		return resp.NewNullBulkString()
+	}
+
+	return resp.NewInteger(rank)
+}
+
+// handleZPop is shared by ZPOPMIN and ZPOPMAX.
+func handleZPop(client *Client, name string, args []string, kv *store.KVStore, max bool) resp.Response {
+	if len(args) < 1 || len(args) > 2 {
+		return resp.NewError("wrong number of arguments for '" + name + "' command")
+	}
+
+	count := 1
+
+	if len(args) == 2 {
+		var err error
+		count, err = strconv.Atoi(args[1])
+
+		if err != nil || count < 0 {
+			return resp.NewError("value is out of range, must be positive")
+		}
+	}
+
+	members, err := kv.ZPop(args[0], count, max)
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if len(members) > 0 {
+		client.instance.notify(notifyZSet, name, args[0])
+		client.instance.notifyIfDeleted(kv, args[0])
+	}
+
+	return newZMemberArray(members, true)
+}
+
+var HandleZPopMinCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handleZPop(client, "zpopmin", args, kv, false)
+}
+
+var HandleZPopMaxCommand CommandHandler = func(client *Client, args []string, kv *store.KVStore) resp.Response {
+	return handleZPop(client, "zpopmax", args, kv, true)
+}
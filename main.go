@@ -1,25 +1,171 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
+	"github.com/henilmalaviya/redig/cmd"
 	"github.com/henilmalaviya/redig/server"
 	"github.com/henilmalaviya/redig/store"
 )
 
+// loadConfig reads the server, persistence, store and logging settings from the command line flags,
+// falling back to the environment and then to the defaults.
+func loadConfig() (server.Config, cmd.Persistence, store.Options, *slog.Logger) {
+	config := server.DefaultConfig()
+	persistence := cmd.DefaultPersistence()
+
+	addr := flag.String("addr", "", "address to listen on, e.g. 127.0.0.1:6379, or none to only listen on -unixsocket (env REDIG_ADDR)")
+	port := flag.Int("port", 0, "port to listen on, on every interface")
+	unixSocket := flag.String("unixsocket", "", "path of a unix socket to listen on as well")
+	maxClients := flag.Int("maxclients", 0, "most clients connected at once, 0 for no limit")
+	idleTimeout := flag.Duration("idle-timeout", 0, "close connections idle for that long, e.g. 5m, 0 to keep them open")
+	tcpKeepAlive := flag.Duration("tcp-keepalive", config.TCPKeepAlive, "how often to probe idle TCP connections for a peer which went away, 0 to disable it")
+	tcpNoDelay := flag.Bool("tcp-nodelay", config.TCPNoDelay, "send replies straight away rather than batching them into fewer packets")
+	metricsAddr := flag.String("metrics-addr", "", "address to serve Prometheus metrics on at /metrics, e.g. :9121, empty to disable them")
+	maxKeys := flag.Int("maxkeys", 0, "most keys to hold before evicting, 0 for no limit")
+	policy := flag.String("maxkeys-policy", store.NoEviction.String(), "keys to evict once maxkeys is reached: noeviction, allkeys-lru, allkeys-random, volatile-ttl, allkeys-lfu or volatile-lfu")
+	ttlJitter := flag.Duration("ttl-jitter", 0, "most time added at random to the TTLs set by EXPIRE and GETEX EX/PX, so keys given one at once don't all expire together, 0 for none")
+	snapshotPath := flag.String("dbfilename", persistence.SnapshotPath, "file to save snapshots to and load on startup, empty to disable them")
+	appendOnly := flag.Bool("appendonly", false, "log every write to the AOF, replayed on startup instead of loading the snapshot")
+	appendPath := flag.String("appendfilename", persistence.AppendPath, "file to log writes to with -appendonly")
+	appendFsync := flag.String("appendfsync", persistence.AppendFsync.String(), "how often the AOF is flushed to disk: always, everysec or no")
+
+	logLevel := flag.String("loglevel", "info", "least severe messages to log: debug, info, warn or error. debug logs every command along with its arguments")
+
+	logFormat := flag.String("logformat", "text", "format of the logs: text, or json to ship them to a log aggregator")
+
+	flag.Parse()
+
+	var level slog.Level
+
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		log.Fatalf("Unknown log level: %s\n", *logLevel)
+	}
+
+	logger, err := newLogger(*logFormat, level)
+
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	switch {
+	case *addr != "":
+		config.Addr = *addr
+	case *port != 0:
+		config.Addr = ":" + strconv.Itoa(*port)
+	case os.Getenv("REDIG_ADDR") != "":
+		config.Addr = os.Getenv("REDIG_ADDR")
+	}
+
+	if config.Addr == server.NoAddr {
+		config.Addr = ""
+	}
+
+	config.UnixSocket = *unixSocket
+	config.IdleTimeout = *idleTimeout
+	config.TCPKeepAlive = *tcpKeepAlive
+	config.TCPNoDelay = *tcpNoDelay
+	config.MaxClients = *maxClients
+	config.MetricsAddr = *metricsAddr
+
+	persistence.SnapshotPath = *snapshotPath
+	persistence.AppendOnly = *appendOnly
+	persistence.AppendPath = *appendPath
+
+	fsyncPolicy, ok := cmd.ParseFsyncPolicy(*appendFsync)
+
+	if !ok {
+		log.Fatalf("Unknown fsync policy: %s\n", *appendFsync)
+	}
+
+	persistence.AppendFsync = fsyncPolicy
+
+	evictionPolicy, ok := store.ParseEvictionPolicy(*policy)
+
+	if !ok {
+		log.Fatalf("Unknown eviction policy: %s\n", *policy)
+	}
+
+	options := store.Options{
+		MaxKeys:        *maxKeys,
+		EvictionPolicy: evictionPolicy,
+		TTLJitter:      *ttlJitter,
+	}
+
+	return config, persistence, options, logger
+}
+
+// newLogger returns a logger writing records of level and above to stderr in format.
+func newLogger(format string, level slog.Level) (*slog.Logger, error) {
+	switch format {
+	case "text":
+		// the default logger goes through the log package, which keeps the format logs always had
+		slog.SetLogLoggerLevel(level)
+		return slog.Default(), nil
+
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})), nil
+	}
+
+	return nil, fmt.Errorf("Unknown log format: %s", format)
+}
+
 func main() {
-	var kv = store.NewKVStore()
+	// SIGINT and SIGTERM stop the server gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
 
-	listener, err := server.NewTCPListener()
+	config, persistence, options, logger := loadConfig()
+
+	var kv = store.New(options)
+	defer kv.Close()
+
+	instance := cmd.NewInstance(persistence, logger)
+
+	// a corrupt snapshot or AOF stops the server rather than starting it without its data
+	if err := instance.Start(kv); err != nil {
+		log.Fatalf("Failed to load data: %s\n", err.Error())
+	}
+
+	defer func() {
+		if err := instance.Close(); err != nil {
+			logger.Error("Failed to close the AOF", "error", err)
+		}
+	}()
+
+	// an empty address serves the unix socket only
+	listeners, err := server.Listen(config)
 
 	if err != nil {
-		log.Fatalf("Failed to create TCP listener: %s\n", err.Error())
+		log.Fatalln(err.Error())
 	}
 
-	defer (*listener).Close()
+	for _, listener := range listeners {
+		defer (*listener).Close()
+	}
+
+	if config.MetricsAddr != "" {
+		metricsListener, err := net.Listen("tcp", config.MetricsAddr)
+
+		if err != nil {
+			log.Fatalf("Failed to create metrics listener: %s\n", err.Error())
+		}
+
+		go server.ServeMetrics(ctx, metricsListener, kv, instance)
+	}
 
-	server.ListenAndAcceptIncomingConnections(listener, kv)
+	server.ListenAndAcceptIncomingConnections(ctx, listeners, kv, instance, config)
 
+	logger.Info("Server stopped")
 }
@@ -0,0 +1,161 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrProtocol is returned by Parse and ParseRequest for input which isn't valid RESP.
+var ErrProtocol = errors.New("Protocol error")
+
+// maxPreallocatedElements bounds the room made upfront for the elements of an array.
+const maxPreallocatedElements = 1024
+
+// Parse decodes a single RESP value from r into the matching Response type,
+// so that ToString on the result gives back the same bytes.
+// Nil bulk strings and nil arrays decode to NullBulkString and a nil Array.
+func Parse(r *bufio.Reader) (Response, error) {
+	line, err := readLine(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if line == "" {
+		return nil, fmt.Errorf("%w: empty line", ErrProtocol)
+	}
+
+	prefix, payload := line[:1], line[1:]
+
+	switch prefix {
+	case SimpleStringPrefix:
+		return NewSimpleString(payload), nil
+
+	case ErrorPrefix:
+		return parseError(payload), nil
+
+	case IntegerPrefix:
+		i, err := strconv.Atoi(payload)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid integer %q", ErrProtocol, payload)
+		}
+
+		return NewInteger(i), nil
+
+	case BulkStringPrefix:
+		return parseBulkString(r, payload)
+
+	case ArrayPrefix:
+		return parseArray(r, payload)
+
+	case NullPrefix:
+		return NewNull(), nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown type %q", ErrProtocol, prefix)
+}
+
+// readLine reads a line terminated by CRLF, without the terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+
+	if err != nil {
+		if errors.Is(err, io.EOF) && line != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+
+		return "", err
+	}
+
+	if !strings.HasSuffix(line, CRLF) {
+		return "", fmt.Errorf("%w: line not terminated by CRLF", ErrProtocol)
+	}
+
+	return strings.TrimSuffix(line, CRLF), nil
+}
+
+// parseLength parses the length of a bulk string or array, -1 meaning nil.
+// the lengths come from peers which may be broken or hostile, so they're bounded
+// by maxLength like those of requests.
+func parseLength(payload string, maxLength int) (int, error) {
+	length, err := strconv.Atoi(payload)
+
+	if err != nil || length < -1 || length > maxLength {
+		return 0, fmt.Errorf("%w: invalid length %q", ErrProtocol, payload)
+	}
+
+	return length, nil
+}
+
+// parseError splits an error into its code and message.
+func parseError(payload string) Response {
+	code, message, _ := strings.Cut(payload, " ")
+	return NewErrorWithCode(code, message)
+}
+
+func parseBulkString(r *bufio.Reader, payload string) (Response, error) {
+	length, err := parseLength(payload, maxBulkLength)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if length == -1 {
+		return NewNullBulkString(), nil
+	}
+
+	// the value itself may contain CRLF, so it is read by length. the buffer grows as the data
+	// comes in rather than being allocated upfront, which a length alone would be enough for
+	var data bytes.Buffer
+
+	if _, err := io.CopyN(&data, r, int64(length+len(CRLF))); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	if string(data.Bytes()[length:]) != CRLF {
+		return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+	}
+
+	return NewBulkString(string(data.Bytes()[:length])), nil
+}
+
+func parseArray(r *bufio.Reader, payload string) (Response, error) {
+	length, err := parseLength(payload, maxMultiBulkLength)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if length == -1 {
+		return NewNilArray(), nil
+	}
+
+	// like bulk strings, the elements are only allocated as they come in
+	elements := make([]Response, 0, min(length, maxPreallocatedElements))
+
+	for range length {
+		element, err := Parse(r)
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.ErrUnexpectedEOF
+			}
+
+			return nil, err
+		}
+
+		elements = append(elements, element)
+	}
+
+	return NewArray(elements), nil
+}
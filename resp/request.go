@@ -0,0 +1,240 @@
+package resp
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxInlineSize bounds an inline request still waiting for its newline.
+	maxInlineSize = 64 * 1024
+
+	// maxBulkLength bounds a single argument of a RESP request.
+	maxBulkLength = 512 * 1024 * 1024
+
+	// maxMultiBulkLength bounds the number of arguments of a RESP request.
+	maxMultiBulkLength = 1024 * 1024
+)
+
+// ErrUnbalancedQuotes is returned for an inline request with a quote left open.
+var ErrUnbalancedQuotes = fmt.Errorf("%w: unbalanced quotes in request", ErrProtocol)
+
+// ParseRequest parses the first request in buf, either an array of bulk strings as sent
+// by client libraries or an inline command as typed in a telnet session.
+// It returns the arguments and how many bytes of buf the request took, or 0 if buf
+// doesn't hold a whole request yet. An empty line takes bytes but has no arguments.
+func ParseRequest(buf []byte) ([]string, int, error) {
+	if len(buf) == 0 {
+		return nil, 0, nil
+	}
+
+	if string(buf[:1]) == ArrayPrefix {
+		return parseMultiBulkRequest(buf)
+	}
+
+	return parseInlineRequest(buf)
+}
+
+// nextLine returns the line at the start of buf without its CRLF,
+// and how many bytes it took along with the CRLF. ok is false if the line isn't complete.
+func nextLine(buf []byte) (line string, n int, ok bool) {
+	i := bytes.Index(buf, []byte(CRLF))
+
+	if i == -1 {
+		return "", 0, false
+	}
+
+	return string(buf[:i]), i + len(CRLF), true
+}
+
+func parseInlineRequest(buf []byte) ([]string, int, error) {
+	i := bytes.IndexByte(buf, '\n')
+
+	if i == -1 {
+		if len(buf) > maxInlineSize {
+			return nil, 0, fmt.Errorf("%w: too big inline request", ErrProtocol)
+		}
+
+		return nil, 0, nil
+	}
+
+	line := strings.TrimSuffix(string(buf[:i]), "\r")
+	args, err := SplitArgs(line)
+
+	return args, i + 1, err
+}
+
+func parseMultiBulkRequest(buf []byte) ([]string, int, error) {
+	line, pos, ok := nextLine(buf)
+
+	if !ok {
+		return nil, 0, nil
+	}
+
+	count, err := strconv.Atoi(line[1:])
+
+	if err != nil || count > maxMultiBulkLength {
+		return nil, 0, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+
+	if count <= 0 {
+		return nil, pos, nil
+	}
+
+	args := make([]string, 0, count)
+
+	for range count {
+		line, n, ok := nextLine(buf[pos:])
+
+		if !ok {
+			return nil, 0, nil
+		}
+
+		if line == "" {
+			return nil, 0, fmt.Errorf("%w: expected '$', got an empty line", ErrProtocol)
+		}
+
+		if !strings.HasPrefix(line, BulkStringPrefix) {
+			return nil, 0, fmt.Errorf("%w: expected '$', got '%c'", ErrProtocol, line[0])
+		}
+
+		length, err := strconv.Atoi(line[1:])
+
+		if err != nil || length < 0 || length > maxBulkLength {
+			return nil, 0, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+		}
+
+		pos += n
+
+		// the argument is read by length, as it may contain CRLF itself
+		if len(buf)-pos < length+len(CRLF) {
+			return nil, 0, nil
+		}
+
+		if string(buf[pos+length:pos+length+len(CRLF)]) != CRLF {
+			return nil, 0, fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+		}
+
+		args = append(args, string(buf[pos:pos+length]))
+		pos += length + len(CRLF)
+	}
+
+	return args, pos, nil
+}
+
+// SplitArgs splits an inline command into arguments the way Redis does. Arguments are
+// separated by whitespace and may be quoted: double quotes understand escapes like
+// \n, \t and \xff, single quotes only \'. A closing quote must end the argument.
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+
+	i := 0
+
+	for {
+		// skip the whitespace before the next argument
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg strings.Builder
+
+		inDoubleQuotes, inSingleQuotes, done := false, false, false
+
+		for !done {
+			if i == len(line) {
+				if inDoubleQuotes || inSingleQuotes {
+					return nil, ErrUnbalancedQuotes
+				}
+
+				break
+			}
+
+			c := line[i]
+
+			switch {
+			case inDoubleQuotes:
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]):
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					arg.WriteByte(byte(b))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					arg.WriteByte(unescape(line[i]))
+				case c == '"':
+					// the closing quote must be followed by whitespace or nothing at all
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+
+					done = true
+				default:
+					arg.WriteByte(c)
+				}
+
+			case inSingleQuotes:
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					arg.WriteByte('\'')
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+
+					done = true
+				default:
+					arg.WriteByte(c)
+				}
+
+			default:
+				switch {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inDoubleQuotes = true
+				case c == '\'':
+					inSingleQuotes = true
+				default:
+					arg.WriteByte(c)
+				}
+			}
+
+			i++
+		}
+
+		args = append(args, arg.String())
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// unescape returns the character a backslash escape inside double quotes stands for.
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+
+	return c
+}
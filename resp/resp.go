@@ -2,13 +2,16 @@ package resp
 
 import "strconv"
 
+// DefaultErrorCode is the code of errors which don't have a more specific one.
+const DefaultErrorCode = "ERR"
+
 const (
 	SimpleStringPrefix = "+"
 	ErrorPrefix        = "-"
-	ErrorFullPrefix    = ErrorPrefix + "ERR" + " "
 	BulkStringPrefix   = "$"
 	IntegerPrefix      = ":"
 	ArrayPrefix        = "*"
+	NullPrefix         = "_"
 	CRLF               = "\r\n"
 )
 
@@ -16,6 +19,12 @@ type Response interface {
 	ToString() string
 }
 
+// IsError reports whether r is an error reply, whatever its code.
+func IsError(r Response) bool {
+	_, isError := r.(Error)
+	return isError
+}
+
 type SimpleString struct {
 	Value string
 }
@@ -32,16 +41,55 @@ func NewOKResponse() SimpleString {
 	return NewSimpleString("OK")
 }
 
+// Error is an error reply, made of a code like ERR or WRONGTYPE and a message.
 type Error struct {
+	Code    string
 	Message string
 }
 
 func (e Error) ToString() string {
-	return ErrorFullPrefix + e.Message + CRLF
+	// an error may be a bare code, without a message to separate from it
+	if e.Message == "" {
+		return ErrorPrefix + e.Code + CRLF
+	}
+
+	return ErrorPrefix + e.Code + " " + e.Message + CRLF
 }
 
+// NewError returns an error reply with the generic ERR code.
 func NewError(s string) Error {
-	return Error{Message: s}
+	return NewErrorWithCode(DefaultErrorCode, s)
+}
+
+// NewErrorWithCode returns an error reply with a code of its own, like WRONGTYPE or MOVED,
+// which clients use to tell errors apart.
+func NewErrorWithCode(code string, message string) Error {
+	return Error{Code: code, Message: message}
+}
+
+// NewWrongTypeError returns the error for an operation against a key holding the wrong kind of value.
+func NewWrongTypeError() Error {
+	return NewErrorWithCode("WRONGTYPE", "Operation against a key holding the wrong kind of value")
+}
+
+// NewExecAbortError returns the error of EXEC for a transaction discarded because of errors while queuing.
+func NewExecAbortError() Error {
+	return NewErrorWithCode("EXECABORT", "Transaction discarded because of previous errors.")
+}
+
+// NewBusyKeyError returns the error for a key which can't be created as it exists already.
+func NewBusyKeyError() Error {
+	return NewErrorWithCode("BUSYKEY", "Target key name already exists.")
+}
+
+// NewReadOnlyError returns the error for a write sent to a replica, which only takes writes from its leader.
+func NewReadOnlyError() Error {
+	return NewErrorWithCode("READONLY", "You can't write against a read only replica.")
+}
+
+// NewOOMError returns the error for a write rejected because the store is full and can't evict any key.
+func NewOOMError() Error {
+	return NewErrorWithCode("OOM", "command not allowed when used memory > 'maxmemory'.")
 }
 
 type Integer struct {
@@ -68,10 +116,6 @@ type BulkString struct {
 }
 
 func (b BulkString) ToString() string {
-	if b.Value == "" {
-		return BulkStringPrefix + "-1" + CRLF
-	}
-
 	return BulkStringPrefix + strconv.Itoa(len(b.Value)) + CRLF + b.Value + CRLF
 }
 
@@ -79,8 +123,26 @@ func NewBulkString(s string) BulkString {
 	return BulkString{Value: s}
 }
 
-func NewNilString() BulkString {
-	return NewBulkString("")
+// NullBulkString is the nil bulk string returned for a missing value, as opposed to an empty one.
+type NullBulkString struct{}
+
+func (n NullBulkString) ToString() string {
+	return BulkStringPrefix + "-1" + CRLF
+}
+
+func NewNullBulkString() NullBulkString {
+	return NullBulkString{}
+}
+
+// Null is the RESP3 null, which replaces the nil bulk string and nil array for clients speaking RESP3.
+type Null struct{}
+
+func (n Null) ToString() string {
+	return NullPrefix + CRLF
+}
+
+func NewNull() Null {
+	return Null{}
 }
 
 type Array struct {
@@ -88,6 +150,10 @@ type Array struct {
 }
 
 func (a Array) ToString() string {
+	if a.Elements == nil {
+		return ArrayPrefix + "-1" + CRLF
+	}
+
 	result := ArrayPrefix + strconv.Itoa(len(a.Elements)) + CRLF
 
 	for _, element := range a.Elements {
@@ -100,3 +166,7 @@ func (a Array) ToString() string {
 func NewArray(elements []Response) Array {
 	return Array{Elements: elements}
 }
+
+func NewNilArray() Array {
+	return NewArray(nil)
+}
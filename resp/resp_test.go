@@ -0,0 +1,258 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	responses := []Response{
+		NewSimpleString("OK"),
+		NewError("ERR something"),
+		NewInteger(-42),
+		NewBulkString("a\r\nb"),
+		NewBulkString(""),
+		NewNullBulkString(),
+		NewArray([]Response{NewInteger(1), NewArray([]Response{NewBulkString("x")}), NewNullBulkString()}),
+		NewArray(nil),
+		NewNilArray(),
+	}
+
+	for _, response := range responses {
+		encoded := response.ToString()
+
+		got, err := Parse(bufio.NewReader(strings.NewReader(encoded)))
+
+		if err != nil {
+			t.Errorf("Parse(%q): %v", encoded, err)
+			continue
+		}
+
+		if got.ToString() != encoded {
+			t.Errorf("Parse(%q) round-tripped to %q", encoded, got.ToString())
+		}
+	}
+}
+
+// randomResponse returns a random response, arrays nesting others up to depth levels deep.
+func randomResponse(r *rand.Rand, depth int) Response {
+	// printable text, as simple strings and errors can't hold CRLF
+	text := func() string {
+		b := make([]byte, r.IntN(8))
+		for i := range b {
+			b[i] = byte('a' + r.IntN(26))
+		}
+		return string(b)
+	}
+
+	kinds := 7
+
+	if depth == 0 {
+		kinds = 6
+	}
+
+	switch r.IntN(kinds) {
+	case 0:
+		return NewSimpleString(text())
+	case 1:
+		return NewError("ERR " + text())
+	case 2:
+		return NewInteger(r.IntN(2000) - 1000)
+	case 3:
+		// bulk strings hold any bytes, CRLF included
+		b := make([]byte, r.IntN(16))
+		for i := range b {
+			b[i] = byte(r.IntN(256))
+		}
+		return NewBulkString(string(b))
+	case 4:
+		return NewNullBulkString()
+	case 5:
+		return NewNull()
+	}
+
+	elements := make([]Response, r.IntN(4))
+
+	for i := range elements {
+		elements[i] = randomResponse(r, depth-1)
+	}
+
+	return NewArray(elements)
+}
+
+func TestParseRandom(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+
+	for range 1000 {
+		encoded := randomResponse(r, 3).ToString()
+
+		got, err := Parse(bufio.NewReader(strings.NewReader(encoded)))
+
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", encoded, err)
+		}
+
+		if got.ToString() != encoded {
+			t.Fatalf("Parse(%q) round-tripped to %q", encoded, got.ToString())
+		}
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  string
+	}{
+		{"unknown type", "?x\r\n"},
+		{"invalid bulk length", "$x\r\n"},
+		{"negative bulk length", "$-2\r\n"},
+		{"too long bulk", "$536870913\r\n"},
+		{"unterminated bulk", "$2\r\nabcd\r\n"},
+		{"invalid array length", "*x\r\n"},
+		{"too long array", "*1048577\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(bufio.NewReader(strings.NewReader(test.buf)))
+
+			if !errors.Is(err, ErrProtocol) {
+				t.Errorf("got %v, want a protocol error", err)
+			}
+		})
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	for _, buf := range []string{"$5\r\nab", "*2\r\n:1\r\n", "$1000000\r\nab\r\n"} {
+		if _, err := Parse(bufio.NewReader(strings.NewReader(buf))); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Parse(%q): got %v, want io.ErrUnexpectedEOF", buf, err)
+		}
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  string
+		args []string
+		n    int
+	}{
+		{"multibulk", "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n", []string{"GET", "key"}, 22},
+		{"argument with CRLF", "*1\r\n$4\r\na\r\nb\r\n", []string{"a\r\nb"}, 14},
+		{"inline", "SET key \"a b\"\r\n", []string{"SET", "key", "a b"}, 15},
+		{"empty multibulk", "*0\r\n", nil, 4},
+		{"incomplete header", "*2\r", nil, 0},
+		{"incomplete argument", "*2\r\n$3\r\nGET\r\n", nil, 0},
+		{"short payload", "*1\r\n$5\r\nab\r\n", nil, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args, n, err := ParseRequest([]byte(test.buf))
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !slices.Equal(args, test.args) || n != test.n {
+				t.Errorf("got %q, %d, want %q, %d", args, n, test.args, test.n)
+			}
+		})
+	}
+}
+
+func TestParseRequestMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  string
+	}{
+		{"empty bulk header", "*1\r\n\r\n"},
+		{"missing $", "*1\r\n3\r\nGET\r\n"},
+		{"negative bulk length", "*1\r\n$-1\r\n"},
+		{"invalid bulk length", "*1\r\n$x\r\n"},
+		{"too long bulk", "*1\r\n$536870913\r\n"},
+		{"invalid multibulk length", "*x\r\n"},
+		{"too many arguments", "*1048577\r\n"},
+		{"unterminated payload", "*1\r\n$2\r\nabcd\r\n"},
+		{"unbalanced quotes", "GET \"key\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := ParseRequest([]byte(test.buf))
+
+			if !errors.Is(err, ErrProtocol) {
+				t.Errorf("got %v, want a protocol error", err)
+			}
+		})
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		args []string
+	}{
+		{`SET key value`, []string{"SET", "key", "value"}},
+		{"  SET \t key  ", []string{"SET", "key"}},
+		{`SET key "hello world"`, []string{"SET", "key", "hello world"}},
+		{`SET key 'hello world'`, []string{"SET", "key", "hello world"}},
+		{`SET "my key" plain 'single' "double"`, []string{"SET", "my key", "plain", "single", "double"}},
+		{`ECHO "say \"hi\""`, []string{"ECHO", `say "hi"`}},
+		{`ECHO 'it\'s'`, []string{"ECHO", "it's"}},
+		{`ECHO 'no \n escapes'`, []string{"ECHO", `no \n escapes`}},
+		{`ECHO "a\tb\n\x41"`, []string{"ECHO", "a\tb\nA"}},
+		{`ECHO ""`, []string{"ECHO", ""}},
+		{`ECHO mid"quote"`, []string{"ECHO", "midquote"}},
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		args, err := SplitArgs(test.line)
+
+		if err != nil {
+			t.Errorf("SplitArgs(%q): %v", test.line, err)
+			continue
+		}
+
+		if !slices.Equal(args, test.args) {
+			t.Errorf("SplitArgs(%q): got %q, want %q", test.line, args, test.args)
+		}
+	}
+
+	for _, line := range []string{`ECHO "open`, `ECHO 'open`, `ECHO "closed"glued`, `ECHO 'closed'glued`} {
+		if _, err := SplitArgs(line); !errors.Is(err, ErrUnbalancedQuotes) {
+			t.Errorf("SplitArgs(%q): got %v, want ErrUnbalancedQuotes", line, err)
+		}
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	tests := []struct {
+		err  Error
+		want string
+	}{
+		{NewError("something failed"), "-ERR something failed\r\n"},
+		{NewErrorWithCode("WRONGTYPE", "Operation against a key holding the wrong kind of value"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{NewErrorWithCode("NOAUTH", ""), "-NOAUTH\r\n"},
+	}
+
+	for _, test := range tests {
+		if got := test.err.ToString(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+
+		// the code is parsed back apart from the message
+		parsed, err := Parse(bufio.NewReader(strings.NewReader(test.want)))
+
+		if err != nil || parsed != test.err {
+			t.Errorf("Parse(%q): got %#v, %v, want %#v", test.want, parsed, err, test.err)
+		}
+	}
+}
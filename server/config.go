@@ -0,0 +1,52 @@
+package server
+
+import "time"
+
+// DefaultAddr is the address redig listens on unless configured otherwise.
+const DefaultAddr = ":4001"
+
+// NoAddr given as the address on the command line turns TCP off, to serve the unix socket only.
+const NoAddr = "none"
+
+// DefaultTCPKeepAlive is how often idle TCP connections are probed unless configured otherwise, like Redis.
+const DefaultTCPKeepAlive = 300 * time.Second
+
+// Config holds the server settings.
+type Config struct {
+	// Addr is the TCP address to listen on, either ":port" for every interface
+	// or "host:port" to bind a specific one. ":0" picks a free port.
+	// Empty turns TCP off, which only makes sense along with UnixSocket.
+	Addr string
+
+	// UnixSocket is the path of a unix socket to listen on as well, if set.
+	UnixSocket string
+
+	// IdleTimeout closes connections which haven't sent a command for that long.
+	// Blocked, subscribed and monitoring clients are waiting on the server and never idle. Zero disables it.
+	IdleTimeout time.Duration
+
+	// MaxClients caps how many clients can be connected at once, further
+	// connections being turned away with an error. Zero means no limit.
+	MaxClients int
+
+	// MetricsAddr is the TCP address to serve Prometheus metrics over HTTP on, at /metrics.
+	// Empty, the default, serves no metrics.
+	MetricsAddr string
+
+	// TCPKeepAlive is how often an idle TCP connection is probed, so a peer which went away
+	// without closing it is noticed. Zero disables the probes.
+	TCPKeepAlive time.Duration
+
+	// TCPNoDelay sends replies straight away rather than waiting to fill a packet,
+	// which lowers latency at the price of more packets.
+	TCPNoDelay bool
+}
+
+// DefaultConfig returns the settings used when nothing is configured.
+func DefaultConfig() Config {
+	return Config{
+		Addr:         DefaultAddr,
+		TCPKeepAlive: DefaultTCPKeepAlive,
+		TCPNoDelay:   true,
+	}
+}
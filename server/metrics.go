@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/henilmalaviya/redig/cmd"
+	"github.com/henilmalaviya/redig/store"
+)
+
+// NewMetricsHandler returns a handler serving the metrics of instance in the Prometheus text format.
+func NewMetricsHandler(kv *store.KVStore, instance *cmd.Instance) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		if err := instance.WriteMetrics(w, kv); err != nil {
+			instance.Logger().Warn("Failed to write metrics", "remote_addr", r.RemoteAddr, "error", err)
+		}
+	})
+}
+
+// ServeMetrics serves the metrics of instance at /metrics over HTTP on listener, until ctx is cancelled.
+func ServeMetrics(ctx context.Context, listener net.Listener, kv *store.KVStore, instance *cmd.Instance) {
+	logger := instance.Logger()
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", NewMetricsHandler(kv, instance))
+
+	server := &http.Server{Handler: mux}
+
+	stopServing := context.AfterFunc(ctx, func() {
+		server.Close()
+	})
+	defer stopServing()
+
+	logger.Info("Serving metrics", "addr", listener.Addr().String())
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("Failed to serve metrics", "error", err)
+	}
+}
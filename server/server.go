@@ -1,61 +1,372 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"iter"
+	"log/slog"
 	"net"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/henilmalaviya/redig/cmd"
+	"github.com/henilmalaviya/redig/resp"
 	"github.com/henilmalaviya/redig/store"
 )
 
-func NewTCPListener() (*net.Listener, error) {
-	listener, err := net.Listen("tcp", ":4001")
+// Listen opens the listeners config asks for: TCP unless Addr is empty, and the unix socket if set.
+// It fails if that leaves nothing to listen on.
+func Listen(config Config) ([]*net.Listener, error) {
+	var listeners []*net.Listener
+
+	if config.Addr != "" {
+		listener, err := NewTCPListener(config)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to create TCP listener: %w", err)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	if config.UnixSocket != "" {
+		listener, err := NewUnixListener(config.UnixSocket)
+
+		if err != nil {
+			for _, listener := range listeners {
+				(*listener).Close()
+			}
+
+			return nil, fmt.Errorf("failed to create unix socket listener: %w", err)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("nothing to listen on, set an address or a unix socket")
+	}
+
+	return listeners, nil
+}
+
+func NewTCPListener(config Config) (*net.Listener, error) {
+	listener, err := net.Listen("tcp", config.Addr)
 
 	if err != nil {
 		return nil, err
 	}
 
-	log.Println("Listening on TCP server")
+	return &listener, nil
+}
+
+// NewUnixListener listens on a unix socket at path, removing the socket left behind
+// by a previous run if there is one. The socket file is removed again once the listener is closed.
+func NewUnixListener(path string) (*net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+
+	if err != nil {
+		return nil, err
+	}
 
 	return &listener, nil
 }
 
-func ListenAndAcceptIncomingConnections(listener *net.Listener, kv *store.KVStore) {
-	for {
-		conn, err := (*listener).Accept()
+// messageQueueSize bounds how many reads can be queued up behind a command still being handled.
+const messageQueueSize = 64
 
-		if err != nil {
-			log.Println("Error accepting TCP connection")
-			continue
+// ListenAndAcceptIncomingConnections serves connections on every listener until ctx is cancelled.
+// It then stops accepting, closes the listeners and returns once every open
+// connection has finished handling the commands it already received.
+// SHUTDOWN stops the server the same way as cancelling ctx.
+// instance must have been started beforehand, see cmd.Instance.Start.
+func ListenAndAcceptIncomingConnections(ctx context.Context, listeners []*net.Listener, kv *store.KVStore, instance *cmd.Instance, config Config) {
+	logger := instance.Logger()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-instance.ShutdownRequested():
+			logger.Info("Shutdown requested by a client")
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
+
+	var connections sync.WaitGroup
+
+	// a counting semaphore holding a slot per connected client, nil when there's no limit
+	var slots chan struct{}
+
+	if config.MaxClients > 0 {
+		slots = make(chan struct{}, config.MaxClients)
+	}
+
+	var accepting sync.WaitGroup
+
+	for _, listener := range listeners {
+		accepting.Add(1)
+
+		go func() {
+			defer accepting.Done()
+
+			logger.Info("Listening", "network", (*listener).Addr().Network(), "addr", (*listener).Addr().String())
+
+			for conn := range acceptConnections(ctx, listener, logger) {
+				logger.Debug("Connection accepted", "remote_addr", conn.RemoteAddr().String())
+
+				if !acquireSlot(slots) {
+					logger.Warn("Rejecting connection, max number of clients reached", "remote_addr", conn.RemoteAddr().String())
+
+					conn.Write([]byte(resp.NewError("max number of clients reached").ToString()))
+					conn.Close()
+					continue
+				}
+
+				connections.Add(1)
+
+				go func() {
+					defer connections.Done()
+					defer releaseSlot(slots)
+					handleConnection(ctx, conn, kv, instance, config)
+				}()
+			}
+		}()
+	}
+
+	accepting.Wait()
+
+	logger.Info("Stopped accepting connections, waiting for open ones to finish")
+
+	connections.Wait()
+
+	logger.Info("Waiting for background work to finish")
+
+	instance.Wait()
+}
 
-		log.Printf("Connection accepted from %s\n", conn.RemoteAddr().String())
+// acceptConnections yields the connections accepted by listener until ctx is cancelled,
+// closing the listener then.
+func acceptConnections(ctx context.Context, listener *net.Listener, logger *slog.Logger) iter.Seq[net.Conn] {
+	return func(yield func(net.Conn) bool) {
+		// closing the listener is the only way to interrupt a pending Accept
+		stopListening := context.AfterFunc(ctx, func() {
+			(*listener).Close()
+		})
+		defer stopListening()
 
-		go handleConnection(conn, kv)
+		for {
+			conn, err := (*listener).Accept()
+
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+
+				logger.Error("Error accepting connection", "addr", (*listener).Addr().String(), "error", err)
+				continue
+			}
+
+			if !yield(conn) {
+				return
+			}
+		}
 	}
 }
 
-func handleConnection(conn net.Conn, kv *store.KVStore) {
-	defer conn.Close()
+// acquireSlot takes a slot for a new connection, returning false if they're all taken.
+func acquireSlot(slots chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseSlot frees the slot of a closed connection.
+func releaseSlot(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
+
+// readBufferSize is how much is read from a connection at once.
+const readBufferSize = 16 * 1024
 
-	buffer := make([]byte, 1024)
+// message is a command read from a connection, or the protocol error which ended the reading.
+type message struct {
+	args []string
+	err  error
+}
+
+// readMessages reads from the connection until it's closed, queueing every command for handleConnection.
+// once the connection is gone, it cancels the client's context and closes messages.
+func readMessages(ctx context.Context, conn net.Conn, client *cmd.Client, idleTimeout time.Duration, messages chan<- message, cancel context.CancelFunc) {
+	defer close(messages)
+	defer cancel()
+
+	buffer := make([]byte, readBufferSize)
+
+	// what's been read but doesn't make a whole command yet
+	var pending []byte
 
 	for {
-		len, err := conn.Read(buffer)
+		if idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(idleTimeout))
+
+			// the shutdown deadline may have been set just before, which must not be pushed back
+			if ctx.Err() != nil {
+				return
+			}
+		}
+
+		n, err := conn.Read(buffer)
 
 		if err != nil {
-			if err == io.EOF {
-				log.Printf("Connection closed from %s\n", conn.RemoteAddr().String())
+			var netErr net.Error
+
+			switch {
+			case err == io.EOF:
+				client.Logger().Debug("Connection closed")
+			case ctx.Err() != nil:
+				// the server is shutting down
+			case errors.As(err, &netErr) && netErr.Timeout():
+				// a client waiting on the server has nothing to send, it's not idle
+				if !client.Idle() {
+					continue
+				}
+
+				client.Logger().Debug("Closing idle connection")
+			default:
+				client.Logger().Warn("Error reading from connection", "error", err)
+			}
+
+			return
+		}
+
+		pending = append(pending, buffer[:n]...)
+
+		// a single read may hold several pipelined commands, and the end of one may be in the next read
+		for {
+			args, n, err := resp.ParseRequest(pending)
+
+			if err != nil {
+				// there's no telling where the next command starts, the connection can't go on
+				messages <- message{err: err}
+				return
+			}
+
+			if n == 0 {
 				break
 			}
 
-			log.Printf("Error reading from TCP connection: %s\n", err.Error())
-			break
+			pending = pending[n:]
+
+			if args != nil {
+				messages <- message{args: args}
+			}
 		}
 
-		go cmd.HandleMessage(conn, string(buffer[:len]), kv)
+		// let go of the commands handled, rather than growing the buffer forever
+		if len(pending) == 0 {
+			pending = nil
+		}
+	}
+}
 
+// configureTCP applies the TCP settings of config to conn, leaving connections of other kinds,
+// like those of a unix socket, as they are.
+func configureTCP(conn net.Conn, config Config) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+
+	if !ok {
+		return nil
+	}
+
+	if err := tcpConn.SetNoDelay(config.TCPNoDelay); err != nil {
+		return err
+	}
+
+	if config.TCPKeepAlive <= 0 {
+		return tcpConn.SetKeepAlive(false)
+	}
+
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		return err
+	}
+
+	return tcpConn.SetKeepAlivePeriod(config.TCPKeepAlive)
+}
+
+func handleConnection(ctx context.Context, conn net.Conn, kv *store.KVStore, instance *cmd.Instance, config Config) {
+	defer conn.Close()
+
+	// the client's context also ends with the server,
+	// which releases blocked commands on shutdown
+	clientCtx, cancel := context.WithCancel(ctx)
+
+	client := cmd.NewClient(clientCtx, conn, instance)
+	defer client.Close(kv)
+
+	// the connection works without the settings, only less well
+	if err := configureTCP(conn, config); err != nil {
+		client.Logger().Warn("Failed to configure TCP connection", "error", err)
 	}
 
+	// on shutdown, interrupt the pending read so the connection ends
+	// once the commands already received are handled
+	stopReading := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stopReading()
+
+	// messages are handled one at a time and in order, while reading carries on
+	// in the background so a disconnect is noticed even if a command blocks
+	messages := make(chan message, messageQueueSize)
+	go readMessages(ctx, conn, client, config.IdleTimeout, messages, cancel)
+
+	for message := range messages {
+		if message.err != nil {
+			client.Logger().Warn("Closing connection", "error", message.err)
+
+			client.Write(resp.NewError(message.err.Error()))
+			return
+		}
+
+		err := cmd.HandleMessage(client, message.args, kv)
+
+		// replies are sent together once the commands read so far are all handled
+		if err == nil && len(messages) == 0 {
+			err = client.Flush()
+		}
+
+		// the client is gone or no longer reading, replying to more commands is pointless
+		if err != nil {
+			client.Logger().Debug("Closing connection, error writing to it", "error", err)
+
+			conn.Close()
+
+			// the reader stops once the connection is closed, dropping what it still queues
+			for range messages {
+			}
+
+			return
+		}
+	}
 }
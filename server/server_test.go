@@ -0,0 +1,707 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/henilmalaviya/redig/cmd"
+	"github.com/henilmalaviya/redig/resp"
+	"github.com/henilmalaviya/redig/store"
+)
+
+const testTimeout = 5 * time.Second
+
+// testServer is a store served by ListenAndAcceptIncomingConnections.
+type testServer struct {
+	listeners []*net.Listener
+	kv        *store.KVStore
+	instance  *cmd.Instance
+
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// serveTest serves a store on the listeners config asks for, until the test is done.
+func serveTest(t *testing.T, config Config) *testServer {
+	t.Helper()
+
+	listeners, err := Listen(config)
+
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	server := &testServer{
+		listeners: listeners,
+		kv:        store.NewKVStore(),
+		instance:  cmd.NewInstance(cmd.Persistence{}, slog.New(slog.NewTextHandler(io.Discard, nil))),
+		stopped:   make(chan struct{}),
+	}
+
+	if err := server.instance.Start(server.kv); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	var ctx context.Context
+	ctx, server.cancel = context.WithCancel(context.Background())
+
+	go func() {
+		defer close(server.stopped)
+		ListenAndAcceptIncomingConnections(ctx, listeners, server.kv, server.instance, config)
+	}()
+
+	t.Cleanup(func() {
+		server.stop(t)
+		server.instance.Close()
+		server.kv.Close()
+	})
+
+	return server
+}
+
+// stop cancels the server's context and waits for it to return.
+func (s *testServer) stop(t *testing.T) {
+	t.Helper()
+
+	s.cancel()
+
+	select {
+	case <-s.stopped:
+	case <-time.After(testTimeout):
+		t.Fatalf("the server didn't stop in time")
+	}
+}
+
+// dialTest connects to listener, the connection being closed once the test is done.
+func dialTest(t *testing.T, listener *net.Listener) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	addr := (*listener).Addr()
+	conn, err := net.DialTimeout(addr.Network(), addr.String(), testTimeout)
+
+	if err != nil {
+		t.Fatalf("dial %s: %v", addr, err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(testTimeout))
+
+	return conn, bufio.NewReader(conn)
+}
+
+// expectLine sends request and checks the first line of the reply is want.
+func expectLine(t *testing.T, conn net.Conn, reader *bufio.Reader, request, want string) {
+	t.Helper()
+
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if line, err := reader.ReadString('\n'); err != nil || line != want+"\r\n" {
+		t.Errorf("%q: got %q, %v, want %q", request, line, err, want)
+	}
+}
+
+func TestListenUnixOnly(t *testing.T) {
+	config := DefaultConfig()
+	config.Addr = ""
+	config.UnixSocket = filepath.Join(t.TempDir(), "redig.sock")
+
+	listeners := serveTest(t, config).listeners
+
+	if len(listeners) != 1 || (*listeners[0]).Addr().Network() != "unix" {
+		t.Fatalf("got %d listeners, want the unix socket only", len(listeners))
+	}
+
+	conn, reader := dialTest(t, listeners[0])
+
+	expectLine(t, conn, reader, "SET key value\r\n", "+OK")
+	expectLine(t, conn, reader, "GET key\r\n", "$5")
+}
+
+func TestListenNothing(t *testing.T) {
+	config := DefaultConfig()
+	config.Addr = ""
+
+	if _, err := Listen(config); err == nil {
+		t.Errorf("Listen succeeded with nothing to listen on")
+	}
+}
+
+func TestListenOnEphemeralPort(t *testing.T) {
+	config := DefaultConfig()
+	config.Addr = "127.0.0.1:0"
+
+	listeners := serveTest(t, config).listeners
+	addr := (*listeners[0]).Addr().(*net.TCPAddr)
+
+	if addr.Port == 0 {
+		t.Fatalf("the listener didn't pick a port")
+	}
+
+	conn, reader := dialTest(t, listeners[0])
+
+	expectLine(t, conn, reader, "PING\r\n", "+PONG")
+}
+
+func TestShutdown(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	config := DefaultConfig()
+	config.Addr = "127.0.0.1:0"
+
+	server := serveTest(t, config)
+	conn, reader := dialTest(t, server.listeners[0])
+
+	expectLine(t, conn, reader, "PING\r\n", "+PONG")
+
+	server.stop(t)
+
+	// the idle connection was closed, and no more are accepted
+	if _, err := reader.ReadByte(); err == nil {
+		t.Errorf("the connection is still open")
+	}
+
+	addr := (*server.listeners[0]).Addr()
+
+	if conn, err := net.Dial(addr.Network(), addr.String()); err == nil {
+		conn.Close()
+		t.Errorf("the server still accepts connections")
+	}
+
+	// nothing is left running once the store is closed, the GC included
+	server.instance.Close()
+	server.kv.Close()
+
+	for deadline := time.Now().Add(testTimeout); runtime.NumGoroutine() > goroutines; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running", runtime.NumGoroutine()-goroutines)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQuit(t *testing.T) {
+	config := DefaultConfig()
+	config.Addr = "127.0.0.1:0"
+
+	server := serveTest(t, config)
+	conn, reader := dialTest(t, server.listeners[0])
+
+	// the command pipelined after QUIT isn't run
+	expectLine(t, conn, reader, "QUIT\r\nSET key value\r\n", "+OK")
+
+	if line, err := reader.ReadString('\n'); err != io.EOF {
+		t.Errorf("got %q, %v after QUIT, want the connection closed", line, err)
+	}
+
+	if server.kv.Has("key") {
+		t.Errorf("the command sent after QUIT was run")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.Addr = "127.0.0.1:0"
+	config.IdleTimeout = 100 * time.Millisecond
+
+	server := serveTest(t, config)
+	idle, idleReader := dialTest(t, server.listeners[0])
+	active, activeReader := dialTest(t, server.listeners[0])
+
+	expectLine(t, idle, idleReader, "PING\r\n", "+PONG")
+
+	// the active connection sends commands more often than the timeout for several times its length
+	for range 10 {
+		expectLine(t, active, activeReader, "PING\r\n", "+PONG")
+		time.Sleep(config.IdleTimeout / 4)
+	}
+
+	if _, err := idleReader.ReadByte(); err != io.EOF {
+		t.Errorf("got %v reading the idle connection, want it closed", err)
+	}
+
+	expectLine(t, active, activeReader, "PING\r\n", "+PONG")
+}
+
+func TestMaxClients(t *testing.T) {
+	config := DefaultConfig()
+	config.Addr = "127.0.0.1:0"
+	config.MaxClients = 1
+
+	server := serveTest(t, config)
+	first, firstReader := dialTest(t, server.listeners[0])
+
+	expectLine(t, first, firstReader, "PING\r\n", "+PONG")
+
+	rejected, rejectedReader := dialTest(t, server.listeners[0])
+	expectLine(t, rejected, rejectedReader, "PING\r\n", "-ERR max number of clients reached")
+
+	// the slot is freed once the first client is gone, which the server notices in its own time
+	first.Close()
+
+	for deadline := time.Now().Add(testTimeout); ; time.Sleep(10 * time.Millisecond) {
+		conn, reader := dialTest(t, server.listeners[0])
+		conn.Write([]byte("PING\r\n"))
+
+		if line, _ := reader.ReadString('\n'); line == "+PONG\r\n" {
+			break
+		}
+
+		conn.Close()
+
+		if time.Now().After(deadline) {
+			t.Fatalf("no client could connect after the first one left")
+		}
+	}
+}
+
+func TestShutdownCommand(t *testing.T) {
+	config := DefaultConfig()
+	config.Addr = "127.0.0.1:0"
+
+	server := serveTest(t, config)
+	conn, reader := dialTest(t, server.listeners[0])
+
+	if _, err := conn.Write([]byte("SHUTDOWN NOSAVE\r\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	select {
+	case <-server.stopped:
+	case <-time.After(testTimeout):
+		t.Fatalf("SHUTDOWN didn't stop the server")
+	}
+
+	// the connection is closed once SHUTDOWN is replied to
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Errorf("got %v reading the connection after SHUTDOWN, want it closed", err)
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	config := DefaultConfig()
+	config.Addr = "127.0.0.1:0"
+
+	server := serveTest(t, config)
+	conn, reader := dialTest(t, server.listeners[0])
+
+	expectLine(t, conn, reader, "SET key value\r\n", "+OK")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ServeMetrics(ctx, listener, server.kv, server.instance)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	client := &http.Client{Timeout: testTimeout}
+	response, err := client.Get("http://" + listener.Addr().String() + "/metrics")
+
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics: got %s, %v", response.Status, err)
+	}
+
+	if got := response.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("got Content-Type %q, want text", got)
+	}
+
+	for _, name := range []string{"redig_uptime_seconds", "redig_connected_clients", "redig_commands_total", "redig_db_keys", "redig_expired_keys_total"} {
+		if !strings.Contains(string(body), "\n# TYPE "+name+" ") {
+			t.Errorf("metric %s is missing", name)
+		}
+	}
+}
+
+// handleTest handles conn the way the server handles the connections it accepts, serving a store of its own.
+// handled is closed once handleConnection returns, which the test waits for before it's done.
+func handleTest(tb testing.TB, conn net.Conn) (kv *store.KVStore, handled <-chan struct{}) {
+	tb.Helper()
+
+	kv = store.NewKVStore()
+	instance := cmd.NewInstance(cmd.Persistence{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	if err := instance.Start(kv); err != nil {
+		tb.Fatalf("Start: %v", err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		handleConnection(context.Background(), conn, kv, instance, DefaultConfig())
+	}()
+
+	tb.Cleanup(func() {
+		conn.Close()
+		<-done
+
+		instance.Close()
+		kv.Close()
+	})
+
+	return kv, done
+}
+
+// countingConn counts the writes made to a connection.
+type countingConn struct {
+	net.Conn
+	writes *atomic.Int64
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+func TestPipelinedRepliesFlushedTogether(t *testing.T) {
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { peer.Close() })
+
+	writes := new(atomic.Int64)
+	handleTest(t, countingConn{conn, writes})
+
+	peer.SetDeadline(time.Now().Add(testTimeout))
+	reader := bufio.NewReader(peer)
+
+	expect := func(want string) {
+		t.Helper()
+
+		if line, err := reader.ReadString('\n'); err != nil || line != want+"\r\n" {
+			t.Fatalf("got %q, %v, want %q", line, err, want)
+		}
+	}
+
+	// a command on its own is replied to straight away
+	peer.Write([]byte("PING\r\n"))
+	expect("+PONG")
+
+	if got := writes.Load(); got != 1 {
+		t.Errorf("got %d writes for a single command, want 1", got)
+	}
+
+	// BLPOP holds the batch up until all of it is read, so the replies to every command of it go out together
+	const batch = 50
+
+	peer.Write([]byte("BLPOP missing 0.1\r\n" + strings.Repeat("SET key value\r\n", batch)))
+	expect("*-1")
+
+	for range batch {
+		expect("+OK")
+	}
+
+	if got := writes.Load() - 1; got != 1 {
+		t.Errorf("got %d writes for a pipelined batch, want 1", got)
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	const commands = 10_000
+
+	set := []byte("SET key value\r\n")
+
+	for _, pipelined := range []bool{false, true} {
+		b.Run("pipelined="+strconv.FormatBool(pipelined), func(b *testing.B) {
+			conn, peer := net.Pipe()
+			b.Cleanup(func() { peer.Close() })
+
+			writes := new(atomic.Int64)
+			handleTest(b, countingConn{conn, writes})
+
+			reader := bufio.NewReader(peer)
+			batch := bytes.Repeat(set, commands)
+
+			b.ResetTimer()
+
+			for range b.N {
+				// the replies are read while the batch is still being sent, as a client would
+				if pipelined {
+					go peer.Write(batch)
+				}
+
+				for range commands {
+					if !pipelined {
+						peer.Write(set)
+					}
+
+					if _, err := reader.ReadString('\n'); err != nil {
+						b.Fatalf("read: %v", err)
+					}
+				}
+			}
+
+			b.ReportMetric(float64(writes.Load())/float64(b.N*commands), "writes/cmd")
+		})
+	}
+}
+
+// writeFailingConn is a connection the client stopped reading from: writing to it fails.
+type writeFailingConn struct {
+	net.Conn
+}
+
+func (c writeFailingConn) Write([]byte) (int, error) {
+	return 0, syscall.EPIPE
+}
+
+func TestWriteFailureClosesConnection(t *testing.T) {
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { peer.Close() })
+
+	kv, handled := handleTest(t, writeFailingConn{conn})
+
+	// the client keeps sending commands, but their replies can't reach it
+	peer.SetDeadline(time.Now().Add(testTimeout))
+
+	if _, err := peer.Write([]byte("SET key value\r\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	select {
+	case <-handled:
+	case <-time.After(testTimeout):
+		t.Fatalf("the connection is still handled after failing to write to it")
+	}
+
+	if _, err := peer.Write([]byte("SET other value\r\n")); err == nil {
+		t.Errorf("the connection is still open")
+	}
+
+	if !kv.Has("key") || kv.Has("other") {
+		t.Errorf("got keys %q, want the command run before the failed reply only", kv.Keys())
+	}
+}
+
+func TestConcurrentPublishers(t *testing.T) {
+	const publishers, messages, pings = 8, 50, 50
+
+	config := DefaultConfig()
+	config.Addr = "127.0.0.1:0"
+
+	server := serveTest(t, config)
+	subscriber, subscriberReader := dialTest(t, server.listeners[0])
+
+	if _, err := subscriber.Write([]byte("SUBSCRIBE news\r\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if _, err := resp.Parse(subscriberReader); err != nil {
+		t.Fatalf("SUBSCRIBE: %v", err)
+	}
+
+	conns := make([]net.Conn, publishers)
+	readers := make([]*bufio.Reader, publishers)
+
+	for i := range conns {
+		conns[i], readers[i] = dialTest(t, server.listeners[0])
+	}
+
+	// large messages, so that a frame takes several writes to send
+	payload := strings.Repeat("x", 16*1024)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, publishers+1)
+
+	for i := range conns {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range messages {
+				message := resp.NewBulkString(fmt.Sprintf("%d-%d-%s", i, j, payload))
+				command := resp.NewArray([]resp.Response{resp.NewBulkString("PUBLISH"), resp.NewBulkString("news"), message})
+
+				if _, err := conns[i].Write([]byte(command.ToString())); err != nil {
+					errs <- err
+					return
+				}
+
+				if line, err := readers[i].ReadString('\n'); err != nil || line != ":1\r\n" {
+					errs <- fmt.Errorf("PUBLISH: got %q, %v", line, err)
+					return
+				}
+			}
+		}()
+	}
+
+	// replies to the subscriber's own commands are written along with the messages pushed to it
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for range pings {
+			if _, err := subscriber.Write([]byte("PING\r\n")); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	seen := make(map[string]bool)
+	pongs := 0
+
+	for range publishers*messages + pings {
+		frame, err := resp.Parse(subscriberReader)
+
+		if err != nil {
+			t.Fatalf("the subscriber's stream doesn't parse: %v", err)
+		}
+
+		array, ok := frame.(resp.Array)
+
+		if !ok || len(array.Elements) == 0 {
+			t.Fatalf("got %.100q, want a message or a pong", frame.ToString())
+		}
+
+		switch kind := array.Elements[0].(resp.BulkString).Value; kind {
+		case "message":
+			message := array.Elements[2].(resp.BulkString).Value
+			id, ok := strings.CutSuffix(message, "-"+payload)
+
+			if !ok || seen[id] {
+				t.Fatalf("got message %.100q, mangled or repeated", message)
+			}
+
+			seen[id] = true
+		case "pong":
+			pongs++
+		default:
+			t.Fatalf("got %.100q, want a message or a pong", frame.ToString())
+		}
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if len(seen) != publishers*messages || pongs != pings {
+		t.Errorf("got %d messages and %d pongs, want %d and %d", len(seen), pongs, publishers*messages, pings)
+	}
+}
+
+// tcpOption reads a socket option of conn back as an integer.
+func tcpOption(t *testing.T, conn *net.TCPConn, level, option int) int {
+	t.Helper()
+
+	raw, err := conn.SyscallConn()
+
+	if err != nil {
+		t.Fatalf("SyscallConn: %v", err)
+	}
+
+	var value int
+	var optErr error
+
+	raw.Control(func(fd uintptr) {
+		value, optErr = syscall.GetsockoptInt(int(fd), level, option)
+	})
+
+	if optErr != nil {
+		t.Fatalf("getsockopt: %v", optErr)
+	}
+
+	return value
+}
+
+func TestConfigureTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	defer listener.Close()
+
+	tests := []struct {
+		noDelay   bool
+		keepAlive time.Duration
+	}{
+		{true, DefaultTCPKeepAlive},
+		{false, 0},
+	}
+
+	for _, test := range tests {
+		client, err := net.Dial("tcp", listener.Addr().String())
+
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+
+		defer client.Close()
+
+		conn, err := listener.Accept()
+
+		if err != nil {
+			t.Fatalf("Accept: %v", err)
+		}
+
+		defer conn.Close()
+
+		config := DefaultConfig()
+		config.TCPNoDelay, config.TCPKeepAlive = test.noDelay, test.keepAlive
+
+		if err := configureTCP(conn, config); err != nil {
+			t.Fatalf("configureTCP: %v", err)
+		}
+
+		tcpConn := conn.(*net.TCPConn)
+
+		if got := tcpOption(t, tcpConn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY) != 0; got != test.noDelay {
+			t.Errorf("got TCP_NODELAY %v, want %v", got, test.noDelay)
+		}
+
+		if got, want := tcpOption(t, tcpConn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE) != 0, test.keepAlive > 0; got != want {
+			t.Errorf("got SO_KEEPALIVE %v, want %v", got, want)
+		}
+	}
+
+	// connections of other kinds are left as they are
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	if err := configureTCP(conn, DefaultConfig()); err != nil {
+		t.Errorf("configureTCP on a pipe: %v", err)
+	}
+}
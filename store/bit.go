@@ -0,0 +1,252 @@
+package store
+
+import "math/bits"
+
+// bitAt returns the bit at offset of str, bits being counted from the most significant one
+// of the first byte like Redis does, 0 past its end.
+func bitAt(str string, offset int) int {
+	index := offset / 8
+
+	if index >= len(str) {
+		return 0
+	}
+
+	return int(str[index]>>(7-offset%8)) & 1
+}
+
+// SetBit sets the bit at offset of a string to bit, either 0 or 1, and returns the bit it replaces.
+// The string is grown with zero bytes to hold the offset, a missing key being an empty string.
+func (s *KVStore) SetBit(key string, offset int, bit int) (int, error) {
+	if err := s.reserve(key); err != nil {
+		return 0, err
+	}
+
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, StringKind)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		v = newStringValue("")
+	}
+
+	old := bitAt(v.str, offset)
+
+	data := []byte(v.str)
+
+	if index := offset / 8; index >= len(data) {
+		data = append(data, make([]byte, index-len(data)+1)...)
+	}
+
+	mask := byte(1) << (7 - offset%8)
+
+	if bit == 1 {
+		data[offset/8] |= mask
+	} else {
+		data[offset/8] &^= mask
+	}
+
+	v.str = string(data)
+	sh.put(key, v)
+	sh.touch(key)
+
+	return old, nil
+}
+
+// GetBit returns the bit at offset of a string, 0 past its end or if the key doesn't exist.
+func (s *KVStore) GetBit(key string, offset int) (int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, StringKind)
+
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	return bitAt(v.str, offset), nil
+}
+
+// BitCount counts the bits set in the bytes of a string between start and stop, both inclusive.
+// Negative indices count from the end of the string, out of range ones are clamped.
+func (s *KVStore) BitCount(key string, start, stop int) (int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, StringKind)
+
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	from, to, ok := normalizeRange(start, stop, len(v.str))
+
+	if !ok {
+		return 0, nil
+	}
+
+	count := 0
+
+	for i := from; i < to; i++ {
+		count += bits.OnesCount8(v.str[i])
+	}
+
+	return count, nil
+}
+
+// BitPos returns the position of the first bit set to bit, either 0 or 1, in the bytes of a string
+// between start and stop, both inclusive, or -1 if there's none. Negative indices count from the end
+// of the string, out of range ones are clamped. Unless bounded is set, the string is taken to go on
+// with zero bits, so a 0 bit is found right past its end if it has none.
+// A missing key is an empty string.
+func (s *KVStore) BitPos(key string, bit int, start, stop int, bounded bool) (int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, StringKind)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		if bit == 1 {
+			return -1, nil
+		}
+
+		return 0, nil
+	}
+
+	from, to, ok := normalizeRange(start, stop, len(v.str))
+
+	if !ok {
+		return -1, nil
+	}
+
+	for i := from; i < to; i++ {
+		b := v.str[i]
+
+		// looking for a 0 bit is looking for a 1 bit in the inverted byte
+		if bit == 0 {
+			b = ^b
+		}
+
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b), nil
+		}
+	}
+
+	if bit == 0 && !bounded {
+		return to * 8, nil
+	}
+
+	return -1, nil
+}
+
+type bitOperation int
+
+const (
+	bitAnd bitOperation = iota
+	bitOr
+	bitXor
+	bitNot
+)
+
+// bitOp stores the result of op over the strings at keys into dst, replacing whatever dst held,
+// and returns its length. The strings are padded with zero bytes to the length of the longest one,
+// a missing key being an empty string. dst is deleted if the result is empty.
+func (s *KVStore) bitOp(op bitOperation, dst string, keys []string) (int, error) {
+	if err := s.reserve(dst); err != nil {
+		return 0, err
+	}
+
+	// lock takes the shards of dst and all source keys in a consistent order
+	unlock := s.lock(append([]string{dst}, keys...)...)
+	defer unlock()
+
+	strs := make([]string, len(keys))
+	length := 0
+
+	for i, key := range keys {
+		v, exists, err := s.shardFor(key).lookupOfKind(key, StringKind)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if exists {
+			strs[i] = v.str
+			length = max(length, len(v.str))
+		}
+	}
+
+	result := make([]byte, length)
+
+	for i := range result {
+		result[i] = byteAt(strs[0], i)
+
+		if op == bitNot {
+			result[i] = ^result[i]
+		}
+
+		for _, str := range strs[1:] {
+			switch op {
+			case bitAnd:
+				result[i] &= byteAt(str, i)
+			case bitOr:
+				result[i] |= byteAt(str, i)
+			case bitXor:
+				result[i] ^= byteAt(str, i)
+			}
+		}
+	}
+
+	dstShard := s.shardFor(dst)
+	dstShard.remove(dst)
+	dstShard.touch(dst)
+
+	if length == 0 {
+		return 0, nil
+	}
+
+	dstShard.put(dst, newStringValue(string(result)))
+
+	return length, nil
+}
+
+// byteAt returns the byte at index of str, 0 past its end.
+func byteAt(str string, index int) byte {
+	if index >= len(str) {
+		return 0
+	}
+
+	return str[index]
+}
+
+// BitOpAnd stores the bitwise AND of the strings at keys into dst and returns its length.
+func (s *KVStore) BitOpAnd(dst string, keys ...string) (int, error) {
+	return s.bitOp(bitAnd, dst, keys)
+}
+
+// BitOpOr stores the bitwise OR of the strings at keys into dst and returns its length.
+func (s *KVStore) BitOpOr(dst string, keys ...string) (int, error) {
+	return s.bitOp(bitOr, dst, keys)
+}
+
+// BitOpXor stores the bitwise XOR of the strings at keys into dst and returns its length.
+func (s *KVStore) BitOpXor(dst string, keys ...string) (int, error) {
+	return s.bitOp(bitXor, dst, keys)
+}
+
+// BitOpNot stores the bitwise NOT of the string at key into dst and returns its length.
+func (s *KVStore) BitOpNot(dst string, key string) (int, error) {
+	return s.bitOp(bitNot, dst, []string{key})
+}
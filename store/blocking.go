@@ -0,0 +1,98 @@
+package store
+
+import "context"
+
+// addWaiter registers ready to be signalled whenever a value is pushed to any of keys.
+// the caller must hold the full lock of the shards holding keys.
+func (s *KVStore) addWaiter(keys []string, ready chan struct{}) {
+	for _, key := range keys {
+		sh := s.shardFor(key)
+
+		if sh.waiters[key] == nil {
+			sh.waiters[key] = make(map[chan struct{}]struct{})
+		}
+
+		sh.waiters[key][ready] = struct{}{}
+	}
+}
+
+// removeWaiter unregisters a waiter added by addWaiter.
+// the caller must hold the full lock of the shards holding keys.
+func (s *KVStore) removeWaiter(keys []string, ready chan struct{}) {
+	for _, key := range keys {
+		sh := s.shardFor(key)
+		delete(sh.waiters[key], ready)
+
+		if len(sh.waiters[key]) == 0 {
+			delete(sh.waiters, key)
+		}
+	}
+}
+
+// signalWaiters wakes every client blocked on key.
+// the caller must hold the full lock.
+func (sh *shard) signalWaiters(key string) {
+	for ready := range sh.waiters[key] {
+		// ready is buffered, a pending signal is as good as a new one
+		select {
+		case ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// BPop pops an element from the head or tail of the first non-empty list among keys,
+// blocking until one is pushed or ctx is done. It returns the key the element was
+// popped from, and false if ctx was done before anything could be popped.
+func (s *KVStore) BPop(ctx context.Context, keys []string, head bool) (string, string, bool, error) {
+	ready := make(chan struct{}, 1)
+
+	for {
+		unlock := s.lock(keys...)
+
+		for _, key := range keys {
+			sh := s.shardFor(key)
+			v, exists, err := sh.getOfKind(key, ListKind)
+
+			if err != nil {
+				s.removeWaiter(keys, ready)
+				unlock()
+				return "", "", false, err
+			}
+
+			if !exists {
+				continue
+			}
+
+			var element string
+
+			if head {
+				element = v.list.popFront()
+			} else {
+				element = v.list.popBack()
+			}
+
+			sh.touch(key)
+			sh.deleteIfEmpty(key, v)
+			s.removeWaiter(keys, ready)
+			unlock()
+
+			return key, element, true, nil
+		}
+
+		// nothing to pop yet, wait for a push to any of the keys.
+		// another waiter may get to the element first, in which case we wait again
+		s.addWaiter(keys, ready)
+		unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			unlock := s.lock(keys...)
+			s.removeWaiter(keys, ready)
+			unlock()
+
+			return "", "", false, nil
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// how long waiting on a contended lock first sleeps before trying again, doubling up to maxLockBackoff
+	minLockBackoff = 10 * time.Microsecond
+	maxLockBackoff = 5 * time.Millisecond
+)
+
+// acquireContext takes a lock with tryLock, trying again with a growing backoff until it's free
+// or ctx is done, in which case it returns ctx.Err() without holding the lock.
+// sync.RWMutex can't be waited on along with a channel, hence the polling. a waiting writer
+// holds new readers off while polling doesn't, so the variants taking a context give way
+// to the plain ones under heavy contention.
+func acquireContext(ctx context.Context, tryLock func() bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if tryLock() {
+		return nil
+	}
+
+	backoff := minLockBackoff
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if tryLock() {
+			return nil
+		}
+
+		backoff = min(backoff*2, maxLockBackoff)
+		timer.Reset(backoff)
+	}
+}
+
+// lockContext takes the full lock of the shard, unless ctx is done first.
+func (sh *shard) lockContext(ctx context.Context) error {
+	return acquireContext(ctx, sh.mutex.TryLock)
+}
+
+// rlockContext takes the read lock of the shard, unless ctx is done first.
+func (sh *shard) rlockContext(ctx context.Context) error {
+	return acquireContext(ctx, sh.mutex.TryRLock)
+}
+
+// GetCtx is Get, giving up with ctx.Err() if ctx is done before the key's shard can be read.
+func (s *KVStore) GetCtx(ctx context.Context, key string) (string, bool, error) {
+	sh := s.shardFor(key)
+
+	if err := sh.rlockContext(ctx); err != nil {
+		return "", false, err
+	}
+
+	defer sh.mutex.RUnlock()
+
+	// an expired key is left for the GC to collect, so as to only take the read lock
+	v, exists := sh.lookup(key)
+
+	if !exists {
+		return "", false, nil
+	}
+
+	if v.kind != StringKind {
+		return "", false, ErrWrongType
+	}
+
+	sh.lru.touch(v)
+
+	return v.str, true, nil
+}
+
+// SetCtx is Set, giving up with ctx.Err() if ctx is done before the key's shard can be written.
+// Keys evicted to make room for the key stay evicted even if it then gives up.
+func (s *KVStore) SetCtx(ctx context.Context, key string, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := s.reserve(key); err != nil {
+		return err
+	}
+
+	sh := s.shardFor(key)
+
+	if err := sh.lockContext(ctx); err != nil {
+		return err
+	}
+
+	defer sh.mutex.Unlock()
+
+	sh.expireIfNeeded(key)
+
+	sh.remove(key)
+	sh.put(key, newStringValue(value))
+	sh.touch(key)
+
+	return nil
+}
+
+// DeleteCtx is Delete, giving up with ctx.Err() if ctx is done before the key's shard can be written.
+func (s *KVStore) DeleteCtx(ctx context.Context, key string) (bool, error) {
+	sh := s.shardFor(key)
+
+	if err := sh.lockContext(ctx); err != nil {
+		return false, err
+	}
+
+	defer sh.mutex.Unlock()
+
+	if sh.expireIfNeeded(key) || !sh.remove(key) {
+		return false, nil
+	}
+
+	sh.touch(key)
+	return true, nil
+}
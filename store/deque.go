@@ -0,0 +1,103 @@
+package store
+
+// deque holds the elements of a list. Room is kept before the first element as well as after
+// the last, so pushing to and popping from either end are amortised O(1), like appending to a slice.
+type deque struct {
+	// the elements are buf[head:], buf[:head] being free room for pushes to the head
+	buf  []string
+	head int
+}
+
+// minDequeRoom is the least room made before the elements when a push to the head runs out of it.
+const minDequeRoom = 8
+
+// elements returns the elements from head to tail. The slice shares its memory with the deque,
+// so setting an element sets it in the list, and it is only valid until the next push or pop.
+func (d *deque) elements() []string {
+	return d.buf[d.head:]
+}
+
+func (d *deque) len() int {
+	return len(d.buf) - d.head
+}
+
+// at returns the element at index i, which must be within the list.
+func (d *deque) at(i int) string {
+	return d.buf[d.head+i]
+}
+
+// set replaces the elements with elements, which the deque takes ownership of.
+func (d *deque) set(elements []string) {
+	d.buf, d.head = elements, 0
+}
+
+// pushFront inserts values at the head one after another, so the last one ends up first.
+func (d *deque) pushFront(values ...string) {
+	if d.head < len(values) {
+		d.grow(len(values))
+	}
+
+	for _, value := range values {
+		d.head--
+		d.buf[d.head] = value
+	}
+}
+
+// grow moves the elements to a new buffer with room for at least n before them. The room is at
+// least as large as the list, so that it doubles along with it and pushes stay amortised O(1).
+func (d *deque) grow(n int) {
+	room := max(n, d.len(), minDequeRoom)
+	buf := make([]string, room+d.len())
+	copy(buf[room:], d.elements())
+
+	d.buf, d.head = buf, room
+}
+
+// pushBack appends values at the tail.
+func (d *deque) pushBack(values ...string) {
+	// once most of the buffer is room left by pops from the head, the elements are moved back
+	// to its start rather than carrying that room over to a larger buffer
+	if len(d.buf)+len(values) > cap(d.buf) && d.head > d.len() {
+		n := copy(d.buf, d.elements())
+		clear(d.buf[n:])
+		d.buf, d.head = d.buf[:n], 0
+	}
+
+	d.buf = append(d.buf, values...)
+}
+
+// popFront removes and returns the element at the head, which must exist.
+func (d *deque) popFront() string {
+	element := d.buf[d.head]
+
+	// the popped slot is cleared so the buffer doesn't keep the string alive
+	d.buf[d.head] = ""
+	d.head++
+
+	return element
+}
+
+// popBack removes and returns the element at the tail, which must exist.
+func (d *deque) popBack() string {
+	last := len(d.buf) - 1
+	element := d.buf[last]
+
+	d.buf[last] = ""
+	d.buf = d.buf[:last]
+
+	return element
+}
+
+// insert inserts element at index i, between 0 and the length of the list.
+func (d *deque) insert(i int, element string) {
+	// the room before the head is used when inserting there, so LInsert before the first
+	// element doesn't shift the whole list
+	if i == 0 {
+		d.pushFront(element)
+		return
+	}
+
+	d.buf = append(d.buf, "")
+	copy(d.buf[d.head+i+1:], d.buf[d.head+i:])
+	d.buf[d.head+i] = element
+}
@@ -0,0 +1,20 @@
+// Package store provides an in-memory key-value store with expiration and background cleanup,
+// holding strings, lists, hashes, sets and sorted sets like Redis does.
+//
+// It backs the redig server but doesn't depend on it, and can be embedded on its own:
+//
+//	kv := store.New(store.Options{MaxKeys: 10_000, EvictionPolicy: store.AllKeysLRU})
+//	defer kv.Close()
+//
+//	kv.Set("session:42", "alice")
+//	kv.ExpireAfter("session:42", 30*time.Minute)
+//
+//	if name, ok, err := kv.Get("session:42"); err == nil && ok {
+//		fmt.Println("hello", name)
+//	}
+//
+// A missing key is reported by an ok result of false rather than by an error, so it's told
+// apart from a key holding an empty string. Errors are kept for operations which can't be
+// carried out, like ErrWrongType for a key holding another kind of value.
+// Expired keys are never returned, whether or not the GC has removed them yet.
+package store
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+)
+
+// Dump serializes the value of key, for Restore to recreate it in this store or another one.
+// ok is false if the key doesn't exist. The expiry of the key isn't part of it.
+//
+// The value is encoded like in a snapshot: its kind followed by its payload,
+// then the snapshot version and a CRC32 of everything before it.
+func (s *KVStore) Dump(key string) ([]byte, bool) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists := sh.lookup(key)
+
+	if !exists {
+		return nil, false
+	}
+
+	var buf bytes.Buffer
+
+	e := newSnapshotEncoder(&buf)
+	e.writeByte(byte(v.kind))
+	e.writeValue(v)
+	e.writeByte(snapshotVersion)
+
+	// writing to a buffer can't fail
+	e.writeChecksum()
+
+	return buf.Bytes(), true
+}
+
+// Restore recreates key from data serialized by Dump, expiring at expiry unless it's the zero time.
+// It returns ErrBusyKey if the key exists and replace isn't set, and ErrBadDump if data is
+// corrupt or of another version. An expiry in the past deletes the key rather than restoring it.
+func (s *KVStore) Restore(key string, data []byte, expiry time.Time, replace bool) error {
+	v, err := undump(data)
+
+	if err != nil {
+		return err
+	}
+
+	if err := s.reserve(key); err != nil {
+		return err
+	}
+
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	sh.expireIfNeeded(key)
+
+	if _, exists := sh.store[key]; exists && !replace {
+		return ErrBusyKey
+	}
+
+	sh.remove(key)
+	sh.touch(key)
+
+	if !expiry.IsZero() && expiry.Before(time.Now()) {
+		return nil
+	}
+
+	sh.put(key, v)
+
+	if !expiry.IsZero() {
+		s.setExpiry(key, expiry)
+	}
+
+	sh.signalWaiters(key)
+
+	return nil
+}
+
+// undump decodes a value serialized by Dump.
+func undump(data []byte) (*value, error) {
+	// at least a kind, the version and the checksum
+	if len(data) < 1+1+4 {
+		return nil, ErrBadDump
+	}
+
+	payload, sum := data[:len(data)-4], data[len(data)-4:]
+
+	if payload[len(payload)-1] != snapshotVersion || crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(sum) {
+		return nil, ErrBadDump
+	}
+
+	payload = payload[:len(payload)-1]
+	d := &snapshotDecoder{r: bufio.NewReader(bytes.NewReader(payload)), crc: crc32.NewIEEE()}
+
+	kind, err := d.ReadByte()
+
+	if err != nil {
+		return nil, ErrBadDump
+	}
+
+	v, err := d.readValue(Kind(kind))
+
+	if err != nil {
+		return nil, ErrBadDump
+	}
+
+	// the value must take up the whole payload
+	if _, err := d.ReadByte(); err == nil {
+		return nil, ErrBadDump
+	}
+
+	return v, nil
+}
@@ -0,0 +1,234 @@
+package store
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// EvictionPolicy decides which keys are evicted to make room once the store is full.
+type EvictionPolicy int
+
+const (
+	// NoEviction rejects writes adding a key to a full store with ErrOutOfMemory.
+	NoEviction EvictionPolicy = iota
+
+	// AllKeysLRU evicts the least recently used key.
+	AllKeysLRU
+
+	// AllKeysRandom evicts a random key.
+	AllKeysRandom
+
+	// VolatileTTL evicts the key closest to expiring among those with a TTL,
+	// falling back to NoEviction if none has one.
+	VolatileTTL
+
+	// AllKeysLFU evicts the least frequently used key.
+	AllKeysLFU
+
+	// VolatileLFU evicts the least frequently used key among those with a TTL,
+	// falling back to NoEviction if none has one.
+	VolatileLFU
+)
+
+// evictionSamples is how many keys are looked at to pick one to evict when a policy
+// approximates its choice, like Redis' maxmemory-samples.
+const evictionSamples = 5
+
+// String returns the name Redis uses for the policy in its maxmemory-policy setting.
+func (p EvictionPolicy) String() string {
+	switch p {
+	case NoEviction:
+		return "noeviction"
+	case AllKeysLRU:
+		return "allkeys-lru"
+	case AllKeysRandom:
+		return "allkeys-random"
+	case VolatileTTL:
+		return "volatile-ttl"
+	case AllKeysLFU:
+		return "allkeys-lfu"
+	case VolatileLFU:
+		return "volatile-lfu"
+	}
+
+	return "unknown"
+}
+
+// ParseEvictionPolicy returns the policy with the given name, as returned by String.
+func ParseEvictionPolicy(name string) (EvictionPolicy, bool) {
+	for _, p := range []EvictionPolicy{NoEviction, AllKeysLRU, AllKeysRandom, VolatileTTL, AllKeysLFU, VolatileLFU} {
+		if p.String() == name {
+			return p, true
+		}
+	}
+
+	return NoEviction, false
+}
+
+// SetMaxKeys changes how many keys the store holds before evicting some, 0 meaning no limit.
+// It is safe to call while the store is in use, though keys past a lowered limit
+// are only evicted as new ones are set.
+func (s *KVStore) SetMaxKeys(maxKeys int) {
+	s.maxKeys.Store(int64(maxKeys))
+}
+
+// MaxKeys returns how many keys the store holds before evicting some, 0 meaning no limit.
+func (s *KVStore) MaxKeys() int {
+	return int(s.maxKeys.Load())
+}
+
+// SetEvictionPolicy changes which keys are evicted once the store is full.
+// It is safe to call while the store is in use.
+func (s *KVStore) SetEvictionPolicy(policy EvictionPolicy) {
+	s.evictionPolicy.Store(int32(policy))
+}
+
+// EvictionPolicy returns which keys are evicted once the store is full.
+func (s *KVStore) EvictionPolicy() EvictionPolicy {
+	return EvictionPolicy(s.evictionPolicy.Load())
+}
+
+// reserve makes room for key before it is written, evicting keys if the store is full.
+// It returns ErrOutOfMemory if the store is full and nothing can be evicted.
+// every write which may create a key must go through it before taking the key's lock.
+// concurrent writers may each take the last free slot, so the limit can be overshot slightly.
+func (s *KVStore) reserve(key string) error {
+	maxKeys := s.MaxKeys()
+
+	if maxKeys <= 0 {
+		return nil
+	}
+
+	// replacing a value doesn't take up another key
+	if s.Has(key) {
+		return nil
+	}
+
+	for s.KeyCount() >= maxKeys {
+		if !s.evictOne() {
+			return ErrOutOfMemory
+		}
+	}
+
+	return nil
+}
+
+// evictOne evicts a single key according to the eviction policy,
+// returning false if the policy doesn't allow it or the store is empty.
+func (s *KVStore) evictOne() bool {
+	switch s.EvictionPolicy() {
+	case AllKeysLRU:
+		return s.evictLRU()
+	case AllKeysRandom:
+		return s.evictRandom()
+	case VolatileTTL:
+		return s.evictVolatileTTL()
+	case AllKeysLFU:
+		return s.evictLFU(false)
+	case VolatileLFU:
+		return s.evictLFU(true)
+	}
+
+	return false
+}
+
+// evictLRU evicts the least recently used key of the whole store,
+// comparing the oldest key of every shard.
+func (s *KVStore) evictLRU() bool {
+	var victim *shard
+	var victimKey string
+	var victimAccessed uint64
+
+	for _, sh := range s.shards {
+		key, accessed, ok := sh.lru.oldest()
+
+		if ok && (victim == nil || accessed < victimAccessed) {
+			victim, victimKey, victimAccessed = sh, key, accessed
+		}
+	}
+
+	if victim == nil {
+		return false
+	}
+
+	victim.mutex.Lock()
+	defer victim.mutex.Unlock()
+
+	// the key may have been removed or replaced since it was picked, which makes room just as well
+	if victim.evict(victimKey) {
+		s.evictedCount.Add(1)
+	}
+
+	return true
+}
+
+// evictRandom evicts a random key, from the first non-empty shard in a random order.
+func (s *KVStore) evictRandom() bool {
+	for _, i := range rand.Perm(len(s.shards)) {
+		sh := s.shards[i]
+		sh.mutex.Lock()
+
+		// map iteration starts at a random key
+		for key := range sh.store {
+			sh.evict(key)
+			sh.mutex.Unlock()
+
+			s.evictedCount.Add(1)
+
+			return true
+		}
+
+		sh.mutex.Unlock()
+	}
+
+	return false
+}
+
+// evictVolatileTTL evicts the key closest to expiring out of a sample of keys with a TTL,
+// taken from shards in a random order, rather than looking through every one of them.
+func (s *KVStore) evictVolatileTTL() bool {
+	var victim *shard
+	var victimKey string
+	var victimExpiry time.Time
+
+	sampled := 0
+
+	for _, i := range rand.Perm(len(s.shards)) {
+		sh := s.shards[i]
+		sh.mutex.RLock()
+
+		// map iteration starts at a random key
+		for key, expiry := range sh.expiries {
+			if victim == nil || expiry.Before(victimExpiry) {
+				victim, victimKey, victimExpiry = sh, key, expiry
+			}
+
+			sampled++
+
+			if sampled == evictionSamples {
+				break
+			}
+		}
+
+		sh.mutex.RUnlock()
+
+		if sampled == evictionSamples {
+			break
+		}
+	}
+
+	// no key has a TTL, there is nothing this policy may evict
+	if victim == nil {
+		return false
+	}
+
+	victim.mutex.Lock()
+	defer victim.mutex.Unlock()
+
+	// the key may have been removed since it was sampled, which makes room just as well
+	if victim.evict(victimKey) {
+		s.evictedCount.Add(1)
+	}
+
+	return true
+}
@@ -0,0 +1,67 @@
+package store_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/henilmalaviya/redig/store"
+)
+
+func Example() {
+	kv := store.New(store.Options{MaxKeys: 10_000, EvictionPolicy: store.AllKeysLRU})
+	defer kv.Close()
+
+	kv.Set("session:42", "alice")
+	kv.ExpireAfter("session:42", 30*time.Minute)
+
+	if name, ok, err := kv.Get("session:42"); err == nil && ok {
+		fmt.Println("hello", name)
+	}
+
+	// Output: hello alice
+}
+
+func ExampleKVStore_Get() {
+	kv := store.New(store.Options{})
+	defer kv.Close()
+
+	kv.Set("empty", "")
+	kv.LPush("list", "x")
+
+	// an empty string and a missing key are told apart by ok, errors are for values of another kind
+	for _, key := range []string{"empty", "missing", "list"} {
+		value, ok, err := kv.Get(key)
+		fmt.Printf("%s: %q %v %v\n", key, value, ok, err)
+	}
+
+	// Output:
+	// empty: "" true <nil>
+	// missing: "" false <nil>
+	// list: "" false WRONGTYPE Operation against a key holding the wrong kind of value
+}
+
+func ExampleKVStore_Atomic() {
+	kv := store.New(store.Options{})
+	defer kv.Close()
+
+	kv.Set("from", "10")
+	kv.Set("to", "0")
+
+	// both keys change at once, no other client seeing one without the other
+	kv.Atomic(func(tx *store.Txn) {
+		if _, err := tx.Add("from", -3); err != nil {
+			return
+		}
+
+		tx.Add("to", 3)
+	})
+
+	from, _, _ := kv.Get("from")
+	to, _, _ := kv.Get("to")
+	fmt.Println(from, to)
+
+	// Output: 7 3
+}
+
+// the server is written against Store, so the store can be swapped for another implementation
+var _ store.Store = (*store.KVStore)(nil)
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"container/heap"
+	"math/rand/v2"
+	"time"
+)
+
+// expiryEntry records when a key is due to expire.
+type expiryEntry struct {
+	key    string
+	expiry time.Time
+
+	// position of the entry in the heap, so it can be moved or removed when the expiry changes
+	index int
+}
+
+// expiryHeap is a min-heap of expiry entries, the soonest to expire on top.
+// a key has a single entry, updated in place when its expiry changes and
+// removed when it goes away, so the heap never holds more entries than keys.
+type expiryHeap []*expiryEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiry.Before(h[j].expiry) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	entry := x.(*expiryEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// setExpiry sets when a key expires and schedules it for the GC.
+// the caller must hold the full lock of the key's shard.
+func (s *KVStore) setExpiry(key string, expiry time.Time) {
+	sh := s.shardFor(key)
+	sh.expiries[key] = expiry
+
+	// without a GC routine nothing would ever pop the heap
+	if s.GCInterval() <= 0 {
+		return
+	}
+
+	entry, scheduled := sh.expiryEntries[key]
+
+	if scheduled {
+		entry.expiry = expiry
+		heap.Fix(&sh.expiryHeap, entry.index)
+	} else {
+		entry = &expiryEntry{key: key, expiry: expiry}
+		sh.expiryEntries[key] = entry
+		heap.Push(&sh.expiryHeap, entry)
+	}
+
+	// if the key is now the first to expire in its shard, the GC may be sleeping for too long
+	if entry.index == 0 {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// clearExpiry removes a key's expiry along with its entry in the heap, returning false if it had none.
+// the caller must hold the full lock.
+func (sh *shard) clearExpiry(key string) bool {
+	if _, hasExpiry := sh.expiries[key]; !hasExpiry {
+		return false
+	}
+
+	delete(sh.expiries, key)
+
+	if entry, scheduled := sh.expiryEntries[key]; scheduled {
+		heap.Remove(&sh.expiryHeap, entry.index)
+		delete(sh.expiryEntries, key)
+	}
+
+	return true
+}
+
+// collectExpired deletes every key whose expiry has passed, going through the heap
+// of each shard so that only keys which are actually due are looked at.
+func (s *KVStore) collectExpired() {
+	for _, sh := range s.shards {
+		sh.collectExpired()
+	}
+}
+
+func (sh *shard) collectExpired() {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	now := time.Now()
+
+	for len(sh.expiryHeap) > 0 && sh.expiryHeap[0].expiry.Before(now) {
+		// expiring the key removes its entry
+		if !sh.expireIfNeeded(sh.expiryHeap[0].key) {
+			// the entry went out of sync with the key, which would leave it on top forever
+			entry := heap.Pop(&sh.expiryHeap).(*expiryEntry)
+			delete(sh.expiryEntries, entry.key)
+		}
+	}
+}
+
+// nextGCDelay returns how long the GC can sleep: until the next key is due in any shard,
+// but no sooner than gcInterval after its last run so expirations are batched.
+func (s *KVStore) nextGCDelay(lastRun time.Time) time.Duration {
+	interval := s.GCInterval()
+	earliest := time.Until(lastRun.Add(interval))
+
+	// with nothing scheduled, check back after an interval
+	delay := max(earliest, interval)
+
+	for _, sh := range s.shards {
+		sh.mutex.RLock()
+
+		if len(sh.expiryHeap) > 0 {
+			delay = min(delay, max(earliest, time.Until(sh.expiryHeap[0].expiry)))
+		}
+
+		sh.mutex.RUnlock()
+	}
+
+	return delay
+}
+
+// TTLJitter returns the most time added at random to TTLs set relative to now, 0 if none is.
+func (s *KVStore) TTLJitter() time.Duration {
+	return time.Duration(s.ttlJitter.Load())
+}
+
+// SetTTLJitter changes the most time added at random to TTLs set relative to now, such as by
+// ExpireAfter, so that keys given the same TTL at once don't all expire together. 0 adds none.
+// It is safe to call while the store is in use.
+func (s *KVStore) SetTTLJitter(jitter time.Duration) {
+	s.ttlJitter.Store(int64(max(jitter, 0)))
+}
+
+// JitteredTTL returns ttl with a random jitter of up to TTLJitter added.
+// A TTL which isn't positive expires the key straight away, and is left as is.
+func (s *KVStore) JitteredTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return ttl
+	}
+
+	return addJitter(ttl, s.TTLJitter())
+}
+
+// addJitter returns a random TTL in [base, base+jitter).
+func addJitter(base, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return base
+	}
+
+	return base + rand.N(jitter)
+}
+
+// SetWithTTLJitter sets a string value which expires after a random TTL in [base, base+jitter),
+// replacing whatever value and TTL the key had.
+func (s *KVStore) SetWithTTLJitter(key string, value string, base, jitter time.Duration) error {
+	if err := s.reserve(key); err != nil {
+		return err
+	}
+
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	sh.remove(key)
+	sh.put(key, newStringValue(value))
+	s.setExpiry(key, time.Now().Add(addJitter(base, jitter)))
+	sh.touch(key)
+
+	return nil
+}
+
+// GCInterval returns how often the background GC looks for expired keys, 0 or less if it is disabled.
+func (s *KVStore) GCInterval() time.Duration {
+	return time.Duration(s.gcInterval.Load())
+}
+
+// SetGCInterval changes how often the background GC looks for expired keys, taking effect
+// on its next wake up. It returns ErrGCDisabled if the store was created without a
+// background GC, which can't be turned on or off while the store is running.
+func (s *KVStore) SetGCInterval(interval time.Duration) error {
+	if interval <= 0 || s.GCInterval() <= 0 {
+		return ErrGCDisabled
+	}
+
+	s.gcInterval.Store(int64(interval))
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
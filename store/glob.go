@@ -0,0 +1,102 @@
+package store
+
+import "strings"
+
+// Match reports whether s matches the glob-style pattern, with the semantics Redis uses
+// for KEYS and PSUBSCRIBE rather than those of file paths:
+//
+//   - * matches any sequence of bytes, / included
+//   - ? matches any single byte
+//   - [abc] matches one of the bytes listed, [a-c] a range of them and [^abc] any other byte
+//   - \ escapes the byte following it, so \* matches a literal *
+//
+// Unlike filepath.Match, there's no such thing as a malformed pattern:
+// an unclosed [ takes the rest of the pattern as its class.
+func Match(pattern, s string) bool {
+	for len(pattern) > 0 && len(s) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+
+			// a trailing star matches whatever is left
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for ; len(s) > 0; s = s[1:] {
+				if Match(pattern[1:], s) {
+					return true
+				}
+			}
+
+			return false
+
+		case '?':
+			s = s[1:]
+
+		case '[':
+			pattern = pattern[1:]
+
+			negated := len(pattern) > 0 && pattern[0] == '^'
+
+			if negated {
+				pattern = pattern[1:]
+			}
+
+			matched := false
+
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					pattern = pattern[1:]
+					matched = matched || pattern[0] == s[0]
+
+				case len(pattern) >= 3 && pattern[1] == '-':
+					start, end := pattern[0], pattern[2]
+
+					if start > end {
+						start, end = end, start
+					}
+
+					pattern = pattern[2:]
+					matched = matched || (start <= s[0] && s[0] <= end)
+
+				default:
+					matched = matched || pattern[0] == s[0]
+				}
+
+				pattern = pattern[1:]
+			}
+
+			if matched == negated {
+				return false
+			}
+
+			s = s[1:]
+
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+
+			fallthrough
+
+		default:
+			if pattern[0] != s[0] {
+				return false
+			}
+
+			s = s[1:]
+		}
+
+		// step over the byte just matched, or the ] closing a class
+		if len(pattern) > 0 {
+			pattern = pattern[1:]
+		}
+	}
+
+	// stars left at the end of the pattern match the empty rest of s
+	return len(s) == 0 && strings.TrimLeft(pattern, "*") == ""
+}
@@ -0,0 +1,298 @@
+package store
+
+import "math/rand/v2"
+
+// HSet sets fields of a hash from alternating field/value pairs, creating it if missing.
+// It returns the number of fields that were newly added rather than updated.
+func (s *KVStore) HSet(key string, fieldValues ...string) (int, error) {
+	if err := s.reserve(key); err != nil {
+		return 0, err
+	}
+
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, HashKind)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		v = newHashValue()
+		sh.put(key, v)
+	}
+
+	added := 0
+
+	for i := 0; i+1 < len(fieldValues); i += 2 {
+		field, fieldValue := fieldValues[i], fieldValues[i+1]
+
+		if _, exists := v.hash[field]; !exists {
+			added++
+		}
+
+		v.hash[field] = fieldValue
+	}
+
+	sh.touch(key)
+
+	return added, nil
+}
+
+// HGet returns the value of a field in a hash.
+// The boolean is false if either the key or the field doesn't exist.
+func (s *KVStore) HGet(key string, field string) (string, bool, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, HashKind)
+
+	if err != nil || !exists {
+		return "", false, err
+	}
+
+	fieldValue, exists := v.hash[field]
+	return fieldValue, exists, nil
+}
+
+// HDel removes fields from a hash and returns how many existed.
+// The key is deleted once its last field is removed.
+func (s *KVStore) HDel(key string, fields ...string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, HashKind)
+
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	deleted := 0
+
+	for _, field := range fields {
+		if _, exists := v.hash[field]; exists {
+			delete(v.hash, field)
+			deleted++
+		}
+	}
+
+	if deleted > 0 {
+		sh.touch(key)
+	}
+
+	sh.deleteIfEmpty(key, v)
+
+	return deleted, nil
+}
+
+// HGetAll returns all fields of a hash and their values as alternating field/value pairs.
+// A missing key is an empty hash.
+func (s *KVStore) HGetAll(key string) ([]string, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, HashKind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return []string{}, nil
+	}
+
+	fieldValues := make([]string, 0, len(v.hash)*2)
+
+	for field, fieldValue := range v.hash {
+		fieldValues = append(fieldValues, field, fieldValue)
+	}
+
+	return fieldValues, nil
+}
+
+// HKeys returns the field names of a hash, empty if the key doesn't exist.
+func (s *KVStore) HKeys(key string) ([]string, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, HashKind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return []string{}, nil
+	}
+
+	fields := make([]string, 0, len(v.hash))
+
+	for field := range v.hash {
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// HVals returns the values of a hash, empty if the key doesn't exist.
+func (s *KVStore) HVals(key string) ([]string, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, HashKind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return []string{}, nil
+	}
+
+	values := make([]string, 0, len(v.hash))
+
+	for _, fieldValue := range v.hash {
+		values = append(values, fieldValue)
+	}
+
+	return values, nil
+}
+
+// HLen returns the number of fields in a hash, 0 if the key doesn't exist.
+func (s *KVStore) HLen(key string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, HashKind)
+
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	return len(v.hash), nil
+}
+
+// HExists reports whether a field exists in a hash.
+func (s *KVStore) HExists(key string, field string) (bool, error) {
+	_, exists, err := s.HGet(key, field)
+	return exists, err
+}
+
+// HMGet returns the values of several fields in a hash.
+// Missing fields, or every field of a missing key, are returned as nil.
+func (s *KVStore) HMGet(key string, fields ...string) ([]*string, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, HashKind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]*string, len(fields))
+
+	if !exists {
+		return values, nil
+	}
+
+	for i, field := range fields {
+		if fieldValue, ok := v.hash[field]; ok {
+			values[i] = &fieldValue
+		}
+	}
+
+	return values, nil
+}
+
+// HSetNX sets a field in a hash only if it doesn't exist yet, creating the hash if missing.
+// It returns true if the field was set.
+func (s *KVStore) HSetNX(key string, field string, fieldValue string) (bool, error) {
+	if err := s.reserve(key); err != nil {
+		return false, err
+	}
+
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, HashKind)
+
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		v = newHashValue()
+		sh.put(key, v)
+	}
+
+	if _, exists := v.hash[field]; exists {
+		return false, nil
+	}
+
+	v.hash[field] = fieldValue
+	sh.touch(key)
+
+	return true, nil
+}
+
+// HRandField returns random fields of a hash as alternating field/value pairs.
+// A positive count returns that many distinct fields, or all of them if the hash holds fewer,
+// while a negative one returns exactly -count fields which may repeat.
+// A missing key is an empty hash.
+func (s *KVStore) HRandField(key string, count int) ([]string, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, HashKind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists || count == 0 {
+		return []string{}, nil
+	}
+
+	// maps can't be indexed, so the fields are gathered first to pick from
+	fields := make([]string, 0, len(v.hash))
+
+	for field := range v.hash {
+		fields = append(fields, field)
+	}
+
+	var picked []string
+
+	if count < 0 {
+		picked = make([]string, -count)
+
+		for i := range picked {
+			picked[i] = fields[rand.IntN(len(fields))]
+		}
+	} else {
+		rand.Shuffle(len(fields), func(i, j int) {
+			fields[i], fields[j] = fields[j], fields[i]
+		})
+
+		picked = fields[:min(count, len(fields))]
+	}
+
+	fieldValues := make([]string, 0, len(picked)*2)
+
+	for _, field := range picked {
+		fieldValues = append(fieldValues, field, v.hash[field])
+	}
+
+	return fieldValues, nil
+}
@@ -0,0 +1,170 @@
+package store
+
+import "sync"
+
+// maxPendingHookCalls is how many calls to the OnExpire and OnEvict hooks may wait to be made,
+// past which further ones are dropped.
+const maxPendingHookCalls = 65_536
+
+// hooks calls the functions set to be told about keys removed by the store itself.
+// keys are removed under the lock of their shard, so the calls are queued and made by
+// a routine of their own once the lock is released: a hook may then use the store freely.
+type hooks struct {
+	onExpire func(key string, value any)
+	onEvict  func(key string, value any)
+	mutex    sync.Mutex
+
+	// called right away rather than queued, see SetOnEvicting
+	onEvicting func(key string)
+
+	// calls waiting to be made, in the order the keys were removed, at most limit of them
+	pending []func()
+	limit   int
+
+	// set once the store is closed, after which calls are dropped
+	closed bool
+
+	// signals the routine that calls are pending
+	wake chan struct{}
+}
+
+func newHooks() *hooks {
+	return &hooks{wake: make(chan struct{}, 1), limit: maxPendingHookCalls}
+}
+
+// enqueue queues a call for the routine. it never blocks, as the caller holds a shard lock
+// which the call may well need: once limit calls are pending, the call is dropped instead.
+func (h *hooks) enqueue(call func()) {
+	h.mutex.Lock()
+
+	if h.closed || len(h.pending) >= h.limit {
+		h.mutex.Unlock()
+		return
+	}
+
+	h.pending = append(h.pending, call)
+	h.mutex.Unlock()
+
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// expired queues a call to the OnExpire hook for the key v was removed from, if there is one.
+func (h *hooks) expired(key string, v *value) {
+	h.mutex.Lock()
+	onExpire := h.onExpire
+	h.mutex.Unlock()
+
+	if onExpire != nil {
+		h.enqueue(func() { onExpire(key, v.export()) })
+	}
+}
+
+// evicted queues a call to the OnEvict hook for the key v was removed from, if there is one,
+// and calls the OnEvicting hook straight away.
+func (h *hooks) evicted(key string, v *value) {
+	h.mutex.Lock()
+	onEvict, onEvicting := h.onEvict, h.onEvicting
+	h.mutex.Unlock()
+
+	if onEvicting != nil {
+		onEvicting(key)
+	}
+
+	if onEvict != nil {
+		h.enqueue(func() { onEvict(key, v.export()) })
+	}
+}
+
+// export returns the data of a value removed from the store, for a hook to look at.
+// nothing else refers to the value anymore, so its data is handed over as is rather than copied.
+func (v *value) export() any {
+	switch v.kind {
+	case ListKind:
+		return v.list.elements()
+	case HashKind:
+		return v.hash
+	case SetKind:
+		return v.set
+	case ZSetKind:
+		return v.zset.sorted
+	}
+
+	return v.str
+}
+
+// run makes the pending calls until done is closed, making the calls still pending then before returning.
+func (h *hooks) run(done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	for {
+		select {
+		case <-done:
+			h.mutex.Lock()
+			h.closed = true
+			h.mutex.Unlock()
+
+			h.drain()
+			return
+		case <-h.wake:
+			h.drain()
+		}
+	}
+}
+
+// drain makes every pending call.
+func (h *hooks) drain() {
+	h.mutex.Lock()
+	calls := h.pending
+	h.pending = nil
+	h.mutex.Unlock()
+
+	for _, call := range calls {
+		call()
+	}
+}
+
+// SetOnExpire sets a function called with every key removed for having expired,
+// whether by the background GC or lazily as it's accessed, and the value it held. nil removes it.
+//
+// The value is a string, a []string for a list, a map[string]string for a hash,
+// a map[string]struct{} for a set or a []ZMember ordered by score for a sorted set,
+// which the function may keep.
+//
+// Hooks are called from a goroutine of the store's own, once the key is removed and
+// without any lock held, so they may use the store. Calls are made one at a time,
+// in the order the keys were removed: a slow hook delays the calls after it.
+//
+// Removing a key never waits for its hook. A hook slower than keys are removed falls behind, with
+// the calls piling up in a queue: once maxPendingHookCalls are waiting, further calls are dropped
+// until the hook catches up, rather than holding up the writes and the GC removing keys.
+func (s *KVStore) SetOnExpire(fn func(key string, value any)) {
+	s.hooks.mutex.Lock()
+	defer s.hooks.mutex.Unlock()
+
+	s.hooks.onExpire = fn
+}
+
+// SetOnEvict sets a function called with every key evicted to make room once the store
+// holds MaxKeys keys, and the value it held. nil removes it.
+// It's called the same way as the function set by SetOnExpire.
+func (s *KVStore) SetOnEvict(fn func(key string, value any)) {
+	s.hooks.mutex.Lock()
+	defer s.hooks.mutex.Unlock()
+
+	s.hooks.onEvict = fn
+}
+
+// SetOnEvicting sets a function called with every key evicted, like the function set by SetOnEvict,
+// but right away: it's called by the write making room, and runs under the lock of the key's shard.
+// Every other client of the shard waits on it, so it must be quick, and it must not use the store,
+// which would deadlock. In return it sees an eviction before any later write to the key,
+// which makes it fit to log evictions along with writes. Its calls are never dropped. nil removes it.
+func (s *KVStore) SetOnEvicting(fn func(key string)) {
+	s.hooks.mutex.Lock()
+	defer s.hooks.mutex.Unlock()
+
+	s.hooks.onEvicting = fn
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	// the counter of a new value, so it isn't evicted before it has a chance to be accessed again
+	lfuInitialFreq = 5
+
+	// how much harder every increment of the counter gets, like Redis' lfu-log-factor
+	lfuLogFactor = 10
+
+	// how long the counter of a value left alone takes to go down by one, like Redis' lfu-decay-time
+	lfuDecayPeriod = time.Minute
+)
+
+// incrementFreq counts an access to v in its logarithmic counter: the higher it is,
+// the less likely an access is to increment it, so that 255 is reached after about a million accesses.
+// the counter is decayed first for the time v was left alone.
+// the caller must hold the mutex of the LRU list of v.
+func incrementFreq(v *value) {
+	freq := decayedFreq(v)
+
+	if freq < 255 {
+		base := float64(max(freq-lfuInitialFreq, 0))
+
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			freq++
+		}
+	}
+
+	v.freq = uint8(freq)
+}
+
+// decayedFreq returns the counter of v, less one for every period it was left alone.
+// the caller must hold the mutex of the LRU list of v.
+func decayedFreq(v *value) int {
+	periods := int(time.Since(v.accessedAt) / lfuDecayPeriod)
+
+	return max(int(v.freq)-periods, 0)
+}
+
+// frequency returns how often v is accessed, as a logarithmic counter from 0 to 255.
+func (l *lruList) frequency(v *value) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return decayedFreq(v)
+}
+
+// evictLFU evicts the least frequently used key out of a sample of keys, taken from shards
+// in a random order, only sampling keys with a TTL if volatile is set.
+func (s *KVStore) evictLFU(volatile bool) bool {
+	var victim *shard
+	var victimKey string
+	victimFreq := 0
+
+	sampled := 0
+
+	for _, i := range rand.Perm(len(s.shards)) {
+		sh := s.shards[i]
+		sh.mutex.RLock()
+
+		// map iteration starts at a random key
+		for key, v := range sh.store {
+			if _, expires := sh.expiries[key]; volatile && !expires {
+				continue
+			}
+
+			freq := sh.lru.frequency(v)
+
+			if victim == nil || freq < victimFreq {
+				victim, victimKey, victimFreq = sh, key, freq
+			}
+
+			sampled++
+
+			if sampled == evictionSamples {
+				break
+			}
+		}
+
+		sh.mutex.RUnlock()
+
+		if sampled == evictionSamples {
+			break
+		}
+	}
+
+	// there is no key this policy may evict
+	if victim == nil {
+		return false
+	}
+
+	victim.mutex.Lock()
+	defer victim.mutex.Unlock()
+
+	// the key may have been removed since it was sampled, which makes room just as well
+	if victim.evict(victimKey) {
+		s.evictedCount.Add(1)
+	}
+
+	return true
+}
@@ -0,0 +1,458 @@
+package store
+
+import "slices"
+
+// push adds values to the head or tail of a list, creating it if missing unless onlyExisting is set.
+func (s *KVStore) push(key string, values []string, head bool, onlyExisting bool) (int, error) {
+	if !onlyExisting {
+		if err := s.reserve(key); err != nil {
+			return 0, err
+		}
+	}
+
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, ListKind)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		if onlyExisting {
+			return 0, nil
+		}
+
+		v = newListValue()
+		sh.put(key, v)
+	}
+
+	if head {
+		v.list.pushFront(values...)
+	} else {
+		v.list.pushBack(values...)
+	}
+
+	sh.touch(key)
+	sh.signalWaiters(key)
+
+	return v.list.len(), nil
+}
+
+// LPush inserts values at the head of a list and returns its new length.
+func (s *KVStore) LPush(key string, values ...string) (int, error) {
+	return s.push(key, values, true, false)
+}
+
+// RPush appends values to the tail of a list and returns its new length.
+func (s *KVStore) RPush(key string, values ...string) (int, error) {
+	return s.push(key, values, false, false)
+}
+
+// LPushX inserts values at the head of a list only if it exists, returning its new length,
+// 0 if there's no list to push to.
+func (s *KVStore) LPushX(key string, values ...string) (int, error) {
+	return s.push(key, values, true, true)
+}
+
+// RPushX appends values to the tail of a list only if it exists, returning its new length,
+// 0 if there's no list to push to.
+func (s *KVStore) RPushX(key string, values ...string) (int, error) {
+	return s.push(key, values, false, true)
+}
+
+// pop removes up to count values from the head or tail of a list.
+// the list is deleted once it becomes empty.
+func (s *KVStore) pop(key string, count int, head bool) ([]string, error) {
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, ListKind)
+
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	count = min(count, v.list.len())
+	popped := make([]string, count)
+
+	// values are returned in the order they were popped
+	for i := range popped {
+		if head {
+			popped[i] = v.list.popFront()
+		} else {
+			popped[i] = v.list.popBack()
+		}
+	}
+
+	if count > 0 {
+		sh.touch(key)
+	}
+
+	sh.deleteIfEmpty(key, v)
+
+	return popped, nil
+}
+
+// LPop removes and returns up to count values from the head of a list.
+// It returns nil if the key doesn't exist.
+func (s *KVStore) LPop(key string, count int) ([]string, error) {
+	return s.pop(key, count, true)
+}
+
+// RPop removes and returns up to count values from the tail of a list.
+// It returns nil if the key doesn't exist.
+func (s *KVStore) RPop(key string, count int) ([]string, error) {
+	return s.pop(key, count, false)
+}
+
+// normalizeRange converts inclusive start and stop indices, which may be negative
+// to count from the end, into a half-open range clamped to length.
+// ok is false when the range is empty.
+func normalizeRange(start, stop, length int) (int, int, bool) {
+	if start < 0 {
+		start += length
+	}
+
+	if stop < 0 {
+		stop += length
+	}
+
+	start = max(start, 0)
+	stop = min(stop, length-1)
+
+	if start > stop {
+		return 0, 0, false
+	}
+
+	return start, stop + 1, true
+}
+
+// LRange returns the elements of a list between start and stop, both inclusive.
+// Out of range indices are clamped, a missing key is an empty list.
+func (s *KVStore) LRange(key string, start, stop int) ([]string, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, ListKind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return []string{}, nil
+	}
+
+	from, to, ok := normalizeRange(start, stop, v.list.len())
+
+	if !ok {
+		return []string{}, nil
+	}
+
+	values := make([]string, to-from)
+	copy(values, v.list.elements()[from:to])
+
+	return values, nil
+}
+
+// LLen returns the length of a list, 0 if the key doesn't exist.
+func (s *KVStore) LLen(key string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, ListKind)
+
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	return v.list.len(), nil
+}
+
+// normalizeIndex converts an index, which may be negative to count from the end,
+// into a position in a list of length. ok is false if it falls outside the list.
+func normalizeIndex(index, length int) (int, bool) {
+	if index < 0 {
+		index += length
+	}
+
+	if index < 0 || index >= length {
+		return 0, false
+	}
+
+	return index, true
+}
+
+// LIndex returns the element at index in a list.
+// The boolean is false if the key doesn't exist or the index is out of range.
+func (s *KVStore) LIndex(key string, index int) (string, bool, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, ListKind)
+
+	if err != nil || !exists {
+		return "", false, err
+	}
+
+	i, ok := normalizeIndex(index, v.list.len())
+
+	if !ok {
+		return "", false, nil
+	}
+
+	return v.list.at(i), true, nil
+}
+
+// LPos returns the indexes of the elements of a list equal to element, skipping the first rank-1 matches.
+// A negative rank searches from the tail, -1 being the last match. At most count indexes are returned,
+// 0 meaning every match, and at most maxLen elements are compared, 0 meaning the whole list.
+func (s *KVStore) LPos(key string, element string, rank int, count int, maxLen int) ([]int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, ListKind)
+
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	elements := v.list.elements()
+	start, step := 0, 1
+
+	if rank < 0 {
+		start, step, rank = len(elements)-1, -1, -rank
+	}
+
+	var positions []int
+
+	for i, compared := start, 0; i >= 0 && i < len(elements); i, compared = i+step, compared+1 {
+		if maxLen > 0 && compared == maxLen {
+			break
+		}
+
+		if elements[i] != element {
+			continue
+		}
+
+		// matches before the rank-th are skipped
+		if rank > 1 {
+			rank--
+			continue
+		}
+
+		positions = append(positions, i)
+
+		if count > 0 && len(positions) == count {
+			break
+		}
+	}
+
+	return positions, nil
+}
+
+// LSet replaces the element at index in a list.
+// It returns ErrNoSuchKey if the key doesn't exist and ErrIndexOutOfRange if the index is out of range.
+func (s *KVStore) LSet(key string, index int, element string) error {
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, ListKind)
+
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrNoSuchKey
+	}
+
+	i, ok := normalizeIndex(index, v.list.len())
+
+	if !ok {
+		return ErrIndexOutOfRange
+	}
+
+	v.list.elements()[i] = element
+	sh.touch(key)
+
+	return nil
+}
+
+// LInsert inserts element before or after the first occurrence of pivot in a list.
+// It returns the new length, 0 if the key doesn't exist or -1 if pivot wasn't found.
+func (s *KVStore) LInsert(key string, before bool, pivot, element string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, ListKind)
+
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	for i, current := range v.list.elements() {
+		if current != pivot {
+			continue
+		}
+
+		if !before {
+			i++
+		}
+
+		v.list.insert(i, element)
+		sh.touch(key)
+
+		return v.list.len(), nil
+	}
+
+	return -1, nil
+}
+
+// LRem removes occurrences of element from a list and returns how many were removed.
+// A positive count removes up to count occurrences from head to tail, a negative
+// count removes up to -count occurrences from tail to head and 0 removes all of them.
+func (s *KVStore) LRem(key string, count int, element string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, ListKind)
+
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+
+	elements := v.list.elements()
+	removed := 0
+	kept := make([]string, 0, len(elements))
+
+	if count >= 0 {
+		for _, current := range elements {
+			if current == element && (limit == 0 || removed < limit) {
+				removed++
+				continue
+			}
+
+			kept = append(kept, current)
+		}
+	} else {
+		// walk from the tail, then restore the original order
+		for i := len(elements) - 1; i >= 0; i-- {
+			if elements[i] == element && removed < limit {
+				removed++
+				continue
+			}
+
+			kept = append(kept, elements[i])
+		}
+
+		slices.Reverse(kept)
+	}
+
+	v.list.set(kept)
+
+	if removed > 0 {
+		sh.touch(key)
+	}
+
+	sh.deleteIfEmpty(key, v)
+
+	return removed, nil
+}
+
+// LTrim trims a list to the elements between start and stop, both inclusive.
+// The key is deleted if the resulting range is empty.
+func (s *KVStore) LTrim(key string, start, stop int) error {
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, ListKind)
+
+	if err != nil || !exists {
+		return err
+	}
+
+	from, to, ok := normalizeRange(start, stop, v.list.len())
+
+	if !ok {
+		v.list.set(nil)
+	} else {
+		v.list.set(slices.Clone(v.list.elements()[from:to]))
+	}
+
+	sh.touch(key)
+	sh.deleteIfEmpty(key, v)
+
+	return nil
+}
+
+// LMove atomically pops an element from one end of src and pushes it to one end of dst,
+// returning the moved element. The boolean is false if src doesn't exist.
+// src and dst may be the same key, which rotates the list.
+func (s *KVStore) LMove(src, dst string, fromHead, toHead bool) (string, bool, error) {
+	if err := s.reserve(dst); err != nil {
+		return "", false, err
+	}
+
+	// lock takes the shards of both keys in a consistent order
+	unlock := s.lock(src, dst)
+	defer unlock()
+
+	srcShard, dstShard := s.shardFor(src), s.shardFor(dst)
+
+	srcValue, exists, err := srcShard.getOfKind(src, ListKind)
+
+	if err != nil || !exists {
+		return "", false, err
+	}
+
+	// dst is checked before anything is popped, so a wrong type leaves src untouched
+	dstValue, dstExists, err := dstShard.getOfKind(dst, ListKind)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	var element string
+
+	if fromHead {
+		element = srcValue.list.popFront()
+	} else {
+		element = srcValue.list.popBack()
+	}
+
+	if !dstExists {
+		dstValue = newListValue()
+		dstShard.put(dst, dstValue)
+	}
+
+	if toHead {
+		dstValue.list.pushFront(element)
+	} else {
+		dstValue.list.pushBack(element)
+	}
+
+	srcShard.touch(src)
+	dstShard.touch(dst)
+	srcShard.deleteIfEmpty(src, srcValue)
+	dstShard.signalWaiters(dst)
+
+	return element, true, nil
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// accessClock orders accesses across every shard, so the least recently used key
+// of the whole store can be found by comparing the oldest key of each shard.
+var accessClock atomic.Uint64
+
+// lruList links the values of a shard from the most to the least recently used.
+// it has a lock of its own so that reads, which only hold the read lock of the shard,
+// can still record an access.
+type lruList struct {
+	mutex      sync.Mutex
+	head, tail *value
+}
+
+// pushFront links v as the most recently used value.
+// the caller must hold l.mutex.
+func (l *lruList) pushFront(v *value) {
+	v.accessed = accessClock.Add(1)
+	v.accessedAt = time.Now()
+	v.prev = nil
+	v.next = l.head
+
+	if l.head != nil {
+		l.head.prev = v
+	} else {
+		l.tail = v
+	}
+
+	l.head = v
+}
+
+// unlink takes v out of the list.
+// the caller must hold l.mutex.
+func (l *lruList) unlink(v *value) {
+	if v.prev != nil {
+		v.prev.next = v.next
+	} else {
+		l.head = v.next
+	}
+
+	if v.next != nil {
+		v.next.prev = v.prev
+	} else {
+		l.tail = v.prev
+	}
+
+	v.prev, v.next = nil, nil
+}
+
+// add links a value which was just stored.
+func (l *lruList) add(v *value) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	v.freq = lfuInitialFreq
+	l.pushFront(v)
+}
+
+// remove unlinks a value which is no longer stored.
+func (l *lruList) remove(v *value) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.unlink(v)
+}
+
+// touch records an access to v, making it the most recently used value.
+func (l *lruList) touch(v *value) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	incrementFreq(v)
+
+	l.unlink(v)
+	l.pushFront(v)
+}
+
+// oldest returns the key of the least recently used value and when it was last accessed.
+func (l *lruList) oldest() (string, uint64, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.tail == nil {
+		return "", 0, false
+	}
+
+	return l.tail.key, l.tail.accessed, true
+}
+
+// idleTime returns how long ago v was last accessed.
+func (l *lruList) idleTime(v *value) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return time.Since(v.accessedAt)
+}
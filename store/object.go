@@ -0,0 +1,338 @@
+package store
+
+import (
+	"bytes"
+	"iter"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// thresholds below which Redis keeps a value in a compact encoding
+const (
+	// strings up to that length are allocated along with their header
+	embstrMaxLength = 44
+
+	// collections with at most that many entries, none longer than listpackMaxValue, are listpacks
+	listpackMaxEntries = 128
+	listpackMaxValue   = 64
+
+	// sets of integers with at most that many members are intsets
+	intsetMaxEntries = 512
+)
+
+// encoding returns the name Redis would give to the encoding of the value.
+// redig doesn't encode values differently, but clients and tools look at it to size things up.
+func (v *value) encoding() string {
+	switch v.kind {
+	case StringKind:
+		if isCanonicalInt(v.str) {
+			return "int"
+		}
+
+		if len(v.str) <= embstrMaxLength {
+			return "embstr"
+		}
+
+		return "raw"
+
+	case ListKind:
+		if fitsListpack(v.list.elements()) {
+			return "listpack"
+		}
+
+		return "quicklist"
+
+	case HashKind:
+		values := make([]string, 0, len(v.hash)*2)
+
+		for field, fieldValue := range v.hash {
+			values = append(values, field, fieldValue)
+		}
+
+		if len(v.hash) <= listpackMaxEntries && fitsListpack(values) {
+			return "listpack"
+		}
+
+		return "hashtable"
+
+	case SetKind:
+		if isIntset(v.set) {
+			return "intset"
+		}
+
+		members := make([]string, 0, len(v.set))
+
+		for member := range v.set {
+			members = append(members, member)
+		}
+
+		if fitsListpack(members) {
+			return "listpack"
+		}
+
+		return "hashtable"
+
+	case ZSetKind:
+		members := make([]string, len(v.zset.sorted))
+
+		for i, member := range v.zset.sorted {
+			members[i] = member.Member
+		}
+
+		if fitsListpack(members) {
+			return "listpack"
+		}
+
+		return "skiplist"
+	}
+
+	return ""
+}
+
+// fitsListpack reports whether values are few and short enough to be kept in a listpack.
+func fitsListpack(values []string) bool {
+	if len(values) > listpackMaxEntries {
+		return false
+	}
+
+	for _, value := range values {
+		if len(value) > listpackMaxValue {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isIntset reports whether a set is small enough and holds only integers, to be kept in an intset.
+func isIntset(set map[string]struct{}) bool {
+	if len(set) > intsetMaxEntries {
+		return false
+	}
+
+	for member := range set {
+		if !isCanonicalInt(member) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isCanonicalInt reports whether s is a 64-bit integer written the way Redis would format it,
+// with no sign or leading zeros to lose by storing it as a number.
+func isCanonicalInt(s string) bool {
+	i, err := strconv.ParseInt(s, 10, 64)
+	return err == nil && strconv.FormatInt(i, 10) == s
+}
+
+// ObjectEncoding returns the name Redis would give to the encoding of the value at key,
+// as reported by OBJECT ENCODING. It doesn't count as an access to the key.
+func (s *KVStore) ObjectEncoding(key string) (string, bool) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists := sh.lookup(key)
+
+	if !exists {
+		return "", false
+	}
+
+	return v.encoding(), true
+}
+
+// ObjectIdleTime returns how long ago the key was last read or written,
+// as reported by OBJECT IDLETIME. It doesn't count as an access to the key.
+func (s *KVStore) ObjectIdleTime(key string) (time.Duration, bool) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists := sh.lookup(key)
+
+	if !exists {
+		return 0, false
+	}
+
+	return sh.lru.idleTime(v), true
+}
+
+// ObjectFreq returns how often the key is read or written, as a logarithmic counter
+// from 0 to 255 reported by OBJECT FREQ. It doesn't count as an access to the key.
+func (s *KVStore) ObjectFreq(key string) (int, bool) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists := sh.lookup(key)
+
+	if !exists {
+		return 0, false
+	}
+
+	return sh.lru.frequency(v), true
+}
+
+// ObjectDebug describes the value of a key, as reported by DEBUG OBJECT.
+type ObjectDebug struct {
+	// Address is where the value lives in memory, only good for telling values apart
+	Address uintptr
+
+	// Encoding is the encoding Redis would give the value, see ObjectEncoding
+	Encoding string
+
+	// SerializedLength is the length of the value as written to a snapshot
+	SerializedLength int
+
+	// Length is the number of elements of a collection, 0 for a string
+	Length int
+
+	// ListNodes is the number of quicklist nodes Redis would split a list into, 0 for other values
+	ListNodes int
+
+	// IdleTime is how long ago the key was last read or written
+	IdleTime time.Duration
+}
+
+// DebugObject describes the value at key, as reported by DEBUG OBJECT.
+// It doesn't count as an access to the key.
+func (s *KVStore) DebugObject(key string) (ObjectDebug, bool) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists := sh.lookup(key)
+
+	if !exists {
+		return ObjectDebug{}, false
+	}
+
+	var serialized bytes.Buffer
+
+	// writing to a buffer can't fail
+	e := newSnapshotEncoder(&serialized)
+	e.writeValue(v)
+	e.w.Flush()
+
+	debug := ObjectDebug{
+		Address:          uintptr(unsafe.Pointer(v)),
+		Encoding:         v.encoding(),
+		SerializedLength: serialized.Len(),
+		Length:           v.len(),
+		IdleTime:         sh.lru.idleTime(v),
+	}
+
+	// a node holds up to a listpack's worth of elements
+	if v.kind == ListKind {
+		debug.ListNodes = max((v.list.len()+listpackMaxEntries-1)/listpackMaxEntries, 1)
+	}
+
+	return debug, true
+}
+
+// rough sizes of the structures holding a value, for MemoryUsage
+const (
+	// a string header, pointing at its bytes
+	stringHeaderSize = int(unsafe.Sizeof(""))
+
+	// a map entry, including its share of the buckets
+	mapEntrySize = 48
+
+	// a value, with the LRU links
+	valueSize = int(unsafe.Sizeof(value{}))
+
+	// a sorted set member, in the ordered slice
+	zmemberSize = int(unsafe.Sizeof(ZMember{}))
+)
+
+// DefaultMemorySamples is how many elements of a collection MemoryUsage looks at unless told otherwise.
+const DefaultMemorySamples = 5
+
+// size estimates the bytes taken by the value. The size of collections is worked out
+// from that of samples of their elements, or of all of them if samples is 0.
+func (v *value) size(samples int) int {
+	size := valueSize
+
+	// sampleSize extrapolates the size of count elements from the total size of the first sampled ones
+	sampleSize := func(count int, elementSizes iter.Seq[int]) int {
+		total, sampled := 0, 0
+
+		for elementSize := range elementSizes {
+			total += elementSize
+			sampled++
+
+			if sampled == samples {
+				break
+			}
+		}
+
+		if sampled == 0 {
+			return 0
+		}
+
+		return total * count / sampled
+	}
+
+	switch v.kind {
+	case StringKind:
+		size += len(v.str)
+
+	case ListKind:
+		size += sampleSize(v.list.len(), func(yield func(int) bool) {
+			for _, element := range v.list.elements() {
+				if !yield(stringHeaderSize + len(element)) {
+					return
+				}
+			}
+		})
+
+	case HashKind:
+		size += sampleSize(len(v.hash), func(yield func(int) bool) {
+			for field, fieldValue := range v.hash {
+				if !yield(mapEntrySize + 2*stringHeaderSize + len(field) + len(fieldValue)) {
+					return
+				}
+			}
+		})
+
+	case SetKind:
+		size += sampleSize(len(v.set), func(yield func(int) bool) {
+			for member := range v.set {
+				if !yield(mapEntrySize + stringHeaderSize + len(member)) {
+					return
+				}
+			}
+		})
+
+	case ZSetKind:
+		// every member is both in the map of scores and in the ordered slice, sharing its bytes
+		size += sampleSize(len(v.zset.sorted), func(yield func(int) bool) {
+			for _, member := range v.zset.sorted {
+				if !yield(mapEntrySize + stringHeaderSize + 8 + zmemberSize + len(member.Member)) {
+					return
+				}
+			}
+		})
+	}
+
+	return size
+}
+
+// MemoryUsage estimates the bytes taken by a key and its value, as reported by MEMORY USAGE.
+// The size of a collection is extrapolated from that of samples of its elements,
+// all of them being counted if samples is 0. It doesn't count as an access to the key.
+func (s *KVStore) MemoryUsage(key string, samples int) (int, bool) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists := sh.lookup(key)
+
+	if !exists {
+		return 0, false
+	}
+
+	return mapEntrySize + stringHeaderSize + len(key) + v.size(samples), true
+}
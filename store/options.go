@@ -0,0 +1,92 @@
+package store
+
+import "time"
+
+// DefaultGCInterval is how often the background GC runs unless configured otherwise.
+const DefaultGCInterval = 1 * time.Second
+
+// Options configures a KVStore created by New. The zero value of every field picks its default.
+type Options struct {
+	// ShardCount is how many shards the keyspace is split into, DefaultShardCount if zero.
+	ShardCount int
+
+	// GCInterval is how often the background GC looks for expired keys, DefaultGCInterval if zero.
+	// A negative interval disables it, leaving expired keys to be collected lazily when accessed.
+	GCInterval time.Duration
+
+	// MaxKeys limits how many keys the store holds, zero meaning no limit.
+	MaxKeys int
+
+	// EvictionPolicy picks the keys evicted once MaxKeys is reached, NoEviction by default.
+	EvictionPolicy EvictionPolicy
+
+	// TTLJitter is the most time added at random to TTLs set relative to now, zero adding none.
+	TTLJitter time.Duration
+}
+
+// New spins up a store configured by options and starts its GC.
+// The store must be closed once done with, to stop the GC.
+func New(options Options) *KVStore {
+	var opts []Option
+
+	if options.ShardCount != 0 {
+		opts = append(opts, WithShardCount(options.ShardCount))
+	}
+
+	if options.GCInterval != 0 {
+		opts = append(opts, WithGCInterval(options.GCInterval))
+	}
+
+	opts = append(opts,
+		WithMaxKeys(options.MaxKeys),
+		WithEvictionPolicy(options.EvictionPolicy),
+		WithTTLJitter(options.TTLJitter),
+	)
+
+	return NewKVStore(opts...)
+}
+
+// Option tweaks a KVStore created by NewKVStore.
+type Option func(*KVStore)
+
+// WithGCInterval sets how often the background GC looks for expired keys.
+// A zero or negative interval disables it, leaving expired keys to be
+// collected lazily when they are accessed.
+func WithGCInterval(interval time.Duration) Option {
+	return func(s *KVStore) {
+		s.gcInterval.Store(int64(interval))
+	}
+}
+
+// WithShardCount sets how many shards the keyspace is split into.
+// More shards means less lock contention between clients working on different keys.
+// A count below 1 is treated as 1, which puts every key behind a single lock.
+func WithShardCount(count int) Option {
+	return func(s *KVStore) {
+		s.shards = make([]*shard, max(count, 1))
+	}
+}
+
+// WithMaxKeys limits how many keys the store holds, evicting keys according to
+// the eviction policy when a new one is set past the limit. Zero means no limit.
+func WithMaxKeys(maxKeys int) Option {
+	return func(s *KVStore) {
+		s.maxKeys.Store(int64(maxKeys))
+	}
+}
+
+// WithEvictionPolicy sets which keys are evicted once the store holds WithMaxKeys keys.
+// The default, NoEviction, rejects new keys instead.
+func WithEvictionPolicy(policy EvictionPolicy) Option {
+	return func(s *KVStore) {
+		s.evictionPolicy.Store(int32(policy))
+	}
+}
+
+// WithTTLJitter adds a random jitter of up to jitter to TTLs set relative to now,
+// so that keys given the same TTL at once don't all expire together.
+func WithTTLJitter(jitter time.Duration) Option {
+	return func(s *KVStore) {
+		s.ttlJitter.Store(int64(max(jitter, 0)))
+	}
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"cmp"
+	"hash/maphash"
+	"iter"
+	"maps"
+	"slices"
+)
+
+// scanned is an element of a collection along with its position in a scan.
+type scanned struct {
+	position uint64
+	name     string
+}
+
+// scanPosition places an element of a collection in the order scans go through it.
+// elements are ordered by a hash of their name rather than by the name itself, so the
+// cursor handed back to the client is a plain number, and never 0, which ends a scan.
+func (s *KVStore) scanPosition(name string) uint64 {
+	return maphash.String(s.seed, name) | 1
+}
+
+// scanNames picks the next elements of a scan among names, starting from cursor:
+// about count of them, the elements sharing a position being never split across calls.
+// it returns the cursor to carry on from, 0 once every element has been returned.
+// as the order only depends on the names, an element there for the whole scan is returned
+// exactly once however the collection changes in between, like Redis guarantees.
+func (s *KVStore) scanNames(names iter.Seq[string], cursor uint64, count int) ([]string, uint64) {
+	var pending []scanned
+
+	for name := range names {
+		if position := s.scanPosition(name); position >= cursor {
+			pending = append(pending, scanned{position, name})
+		}
+	}
+
+	slices.SortFunc(pending, func(a, b scanned) int {
+		return cmp.Compare(a.position, b.position)
+	})
+
+	end := min(max(count, 1), len(pending))
+
+	for end < len(pending) && pending[end].position == pending[end-1].position {
+		end++
+	}
+
+	picked := make([]string, end)
+
+	for i := range picked {
+		picked[i] = pending[i].name
+	}
+
+	if end == len(pending) {
+		return picked, 0
+	}
+
+	return picked, pending[end].position
+}
+
+// HScan returns the next fields of a hash from cursor as alternating field/value pairs,
+// about count of them, along with the cursor to pass next, 0 once the scan is over.
+// A scan starts at cursor 0. A missing key is an empty hash.
+func (s *KVStore) HScan(key string, cursor uint64, count int) ([]string, uint64, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, HashKind)
+
+	if err != nil || !exists {
+		return []string{}, 0, err
+	}
+
+	fields, next := s.scanNames(maps.Keys(v.hash), cursor, count)
+
+	fieldValues := make([]string, 0, len(fields)*2)
+
+	for _, field := range fields {
+		fieldValues = append(fieldValues, field, v.hash[field])
+	}
+
+	return fieldValues, next, nil
+}
+
+// SScan returns the next members of a set from cursor, the way HScan does for a hash.
+func (s *KVStore) SScan(key string, cursor uint64, count int) ([]string, uint64, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, SetKind)
+
+	if err != nil || !exists {
+		return []string{}, 0, err
+	}
+
+	members, next := s.scanNames(maps.Keys(v.set), cursor, count)
+
+	return members, next, nil
+}
+
+// ZScan returns the next members of a sorted set from cursor along with their scores,
+// the way HScan does for a hash. They come in no particular order, not by score.
+func (s *KVStore) ZScan(key string, cursor uint64, count int) ([]ZMember, uint64, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, ZSetKind)
+
+	if err != nil || !exists {
+		return []ZMember{}, 0, err
+	}
+
+	names, next := s.scanNames(maps.Keys(v.zset.scores), cursor, count)
+
+	members := make([]ZMember, len(names))
+
+	for i, name := range names {
+		members[i] = ZMember{Member: name, Score: v.zset.scores[name]}
+	}
+
+	return members, next, nil
+}
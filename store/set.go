@@ -0,0 +1,349 @@
+package store
+
+// SAdd adds members to a set, creating it if missing.
+// It returns the number of members that weren't already in the set.
+func (s *KVStore) SAdd(key string, members ...string) (int, error) {
+	if err := s.reserve(key); err != nil {
+		return 0, err
+	}
+
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, SetKind)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		v = newSetValue()
+		sh.put(key, v)
+	}
+
+	added := 0
+
+	for _, member := range members {
+		if _, exists := v.set[member]; !exists {
+			v.set[member] = struct{}{}
+			added++
+		}
+	}
+
+	if added > 0 {
+		sh.touch(key)
+	}
+
+	return added, nil
+}
+
+// SRem removes members from a set and returns how many were in it.
+// The key is deleted once its last member is removed.
+func (s *KVStore) SRem(key string, members ...string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, SetKind)
+
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	removed := 0
+
+	for _, member := range members {
+		if _, exists := v.set[member]; exists {
+			delete(v.set, member)
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		sh.touch(key)
+	}
+
+	sh.deleteIfEmpty(key, v)
+
+	return removed, nil
+}
+
+// SMembers returns the members of a set in no particular order, empty if the key doesn't exist.
+func (s *KVStore) SMembers(key string) ([]string, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, SetKind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return []string{}, nil
+	}
+
+	return setMembers(v.set), nil
+}
+
+// SIsMember reports whether member is in a set.
+func (s *KVStore) SIsMember(key string, member string) (bool, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, SetKind)
+
+	if err != nil || !exists {
+		return false, err
+	}
+
+	_, isMember := v.set[member]
+	return isMember, nil
+}
+
+// SCard returns the number of members in a set, 0 if the key doesn't exist.
+func (s *KVStore) SCard(key string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, SetKind)
+
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	return len(v.set), nil
+}
+
+// setOperation is one of the operations combining several sets.
+type setOperation int
+
+const (
+	setInter setOperation = iota
+	setUnion
+	setDiff
+)
+
+// combineSets applies op to the sets stored at keys, a missing key being an empty set.
+// the caller must hold at least the read lock of the shards holding keys.
+func (s *KVStore) combineSets(op setOperation, keys []string) (map[string]struct{}, error) {
+	sets := make([]map[string]struct{}, len(keys))
+
+	for i, key := range keys {
+		v, exists, err := s.shardFor(key).lookupOfKind(key, SetKind)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			sets[i] = v.set
+		}
+	}
+
+	result := make(map[string]struct{})
+
+	switch op {
+	case setInter:
+		for member := range sets[0] {
+			inAll := true
+
+			for _, other := range sets[1:] {
+				if _, exists := other[member]; !exists {
+					inAll = false
+					break
+				}
+			}
+
+			if inAll {
+				result[member] = struct{}{}
+			}
+		}
+	case setUnion:
+		for _, set := range sets {
+			for member := range set {
+				result[member] = struct{}{}
+			}
+		}
+	case setDiff:
+		// members of the first set which are in none of the others
+		for member := range sets[0] {
+			result[member] = struct{}{}
+		}
+
+		for _, other := range sets[1:] {
+			for member := range other {
+				delete(result, member)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// setMembers lists the members of a set in no particular order.
+func setMembers(set map[string]struct{}) []string {
+	members := make([]string, 0, len(set))
+
+	for member := range set {
+		members = append(members, member)
+	}
+
+	return members
+}
+
+// combine returns the members resulting from op over the sets at keys.
+func (s *KVStore) combine(op setOperation, keys []string) ([]string, error) {
+	unlock := s.rlock(keys...)
+	defer unlock()
+
+	result, err := s.combineSets(op, keys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return setMembers(result), nil
+}
+
+// combineStore stores the result of op over the sets at keys into dst, replacing
+// whatever dst held, and returns its cardinality. dst is deleted if the result is empty.
+func (s *KVStore) combineStore(op setOperation, dst string, keys []string) (int, error) {
+	if err := s.reserve(dst); err != nil {
+		return 0, err
+	}
+
+	// lock takes the shards of dst and all source keys in a consistent order
+	unlock := s.lock(append([]string{dst}, keys...)...)
+	defer unlock()
+
+	result, err := s.combineSets(op, keys)
+
+	if err != nil {
+		return 0, err
+	}
+
+	dstShard := s.shardFor(dst)
+	dstShard.remove(dst)
+	dstShard.touch(dst)
+
+	if len(result) == 0 {
+		return 0, nil
+	}
+
+	dstShard.put(dst, &value{kind: SetKind, set: result})
+
+	return len(result), nil
+}
+
+// SInter returns the members present in every set at keys.
+func (s *KVStore) SInter(keys ...string) ([]string, error) {
+	return s.combine(setInter, keys)
+}
+
+// SUnion returns the members present in any set at keys.
+func (s *KVStore) SUnion(keys ...string) ([]string, error) {
+	return s.combine(setUnion, keys)
+}
+
+// SDiff returns the members of the first set at keys which are in none of the others.
+func (s *KVStore) SDiff(keys ...string) ([]string, error) {
+	return s.combine(setDiff, keys)
+}
+
+// SInterStore stores the intersection of the sets at keys into dst and returns its cardinality.
+func (s *KVStore) SInterStore(dst string, keys ...string) (int, error) {
+	return s.combineStore(setInter, dst, keys)
+}
+
+// SUnionStore stores the union of the sets at keys into dst and returns its cardinality.
+func (s *KVStore) SUnionStore(dst string, keys ...string) (int, error) {
+	return s.combineStore(setUnion, dst, keys)
+}
+
+// SDiffStore stores the difference of the sets at keys into dst and returns its cardinality.
+func (s *KVStore) SDiffStore(dst string, keys ...string) (int, error) {
+	return s.combineStore(setDiff, dst, keys)
+}
+
+// SMIsMember reports, for each of members, whether it is in a set.
+func (s *KVStore) SMIsMember(key string, members ...string) ([]bool, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, SetKind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	isMember := make([]bool, len(members))
+
+	if !exists {
+		return isMember, nil
+	}
+
+	for i, member := range members {
+		_, isMember[i] = v.set[member]
+	}
+
+	return isMember, nil
+}
+
+// SMove atomically moves member from the set at src to the set at dst, creating dst if missing.
+// It returns false if member isn't in src. src is deleted once its last member is moved out.
+func (s *KVStore) SMove(src, dst string, member string) (bool, error) {
+	if err := s.reserve(dst); err != nil {
+		return false, err
+	}
+
+	// lock takes the shards of both keys in a consistent order
+	unlock := s.lock(src, dst)
+	defer unlock()
+
+	srcShard, dstShard := s.shardFor(src), s.shardFor(dst)
+
+	srcValue, exists, err := srcShard.getOfKind(src, SetKind)
+
+	if err != nil {
+		return false, err
+	}
+
+	// dst is checked before anything is moved, so a wrong type leaves src untouched
+	dstValue, dstExists, err := dstShard.getOfKind(dst, SetKind)
+
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		return false, nil
+	}
+
+	if _, isMember := srcValue.set[member]; !isMember {
+		return false, nil
+	}
+
+	if src == dst {
+		return true, nil
+	}
+
+	if !dstExists {
+		dstValue = newSetValue()
+		dstShard.put(dst, dstValue)
+	}
+
+	delete(srcValue.set, member)
+	dstValue.set[member] = struct{}{}
+
+	srcShard.touch(src)
+	dstShard.touch(dst)
+	srcShard.deleteIfEmpty(src, srcValue)
+
+	return true, nil
+}
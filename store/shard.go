@@ -0,0 +1,225 @@
+package store
+
+import (
+	"hash/maphash"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultShardCount is how many shards the keyspace is split into unless configured otherwise.
+const DefaultShardCount = 16
+
+// shard holds part of the keyspace behind its own lock,
+// so that clients working on keys in different shards don't wait on each other.
+type shard struct {
+	store    map[string]*value
+	mutex    sync.RWMutex
+	expiries map[string]time.Time
+
+	// clients blocked on a list key, signalled when something is pushed to it
+	waiters map[string]map[chan struct{}]struct{}
+
+	// keys watched for changes by WATCH
+	watched map[string]*watchedKey
+
+	// expiries ordered by time, for the GC to find the keys due to expire
+	expiryHeap expiryHeap
+
+	// the heap entry of each key scheduled for the GC, see clearExpiry
+	expiryEntries map[string]*expiryEntry
+
+	// values ordered by last access, for eviction
+	lru lruList
+
+	// number of keys held, readable without the lock to check the store is within maxKeys
+	keyCount atomic.Int64
+
+	// number of keys removed for having expired, whether by the GC or lazily
+	expiredCount atomic.Int64
+
+	// shared by every shard of the store
+	hooks *hooks
+}
+
+func newShard(hooks *hooks) *shard {
+	return &shard{
+		hooks:         hooks,
+		store:         make(map[string]*value),
+		expiries:      make(map[string]time.Time),
+		expiryEntries: make(map[string]*expiryEntry),
+		waiters:       make(map[string]map[chan struct{}]struct{}),
+		watched:       make(map[string]*watchedKey),
+	}
+}
+
+// shardIndex returns the index of the shard a key belongs to.
+func (s *KVStore) shardIndex(key string) int {
+	return int(maphash.String(s.seed, key) % uint64(len(s.shards)))
+}
+
+// shardFor returns the shard a key belongs to.
+func (s *KVStore) shardFor(key string) *shard {
+	return s.shards[s.shardIndex(key)]
+}
+
+// shardsFor returns the shards holding keys, each once and ordered by index.
+// locking them in that order keeps two operations on several keys from deadlocking.
+func (s *KVStore) shardsFor(keys []string) []*shard {
+	indexes := make([]int, len(keys))
+
+	for i, key := range keys {
+		indexes[i] = s.shardIndex(key)
+	}
+
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+
+	shards := make([]*shard, len(indexes))
+
+	for i, index := range indexes {
+		shards[i] = s.shards[index]
+	}
+
+	return shards
+}
+
+// lock takes the full lock of every shard holding one of keys,
+// and returns a function to release them.
+func (s *KVStore) lock(keys ...string) func() {
+	shards := s.shardsFor(keys)
+
+	for _, sh := range shards {
+		sh.mutex.Lock()
+	}
+
+	return func() {
+		for _, sh := range shards {
+			sh.mutex.Unlock()
+		}
+	}
+}
+
+// rlock is the read-only counterpart of lock.
+func (s *KVStore) rlock(keys ...string) func() {
+	shards := s.shardsFor(keys)
+
+	for _, sh := range shards {
+		sh.mutex.RLock()
+	}
+
+	return func() {
+		for _, sh := range shards {
+			sh.mutex.RUnlock()
+		}
+	}
+}
+
+// lookup returns the value of a key if it exists and is not expired.
+// the caller must hold at least the read lock.
+func (sh *shard) lookup(key string) (*value, bool) {
+	v, exists := sh.store[key]
+
+	if !exists {
+		return nil, false
+	}
+
+	// an expired key which GC hasn't reached yet is treated as non-existent
+	if expiry, hasExpiry := sh.expiries[key]; hasExpiry && expiry.Before(time.Now()) {
+		return nil, false
+	}
+
+	return v, true
+}
+
+// put stores v at key, replacing whatever value it held.
+// the caller must hold the full lock.
+func (sh *shard) put(key string, v *value) {
+	if old, exists := sh.store[key]; exists {
+		sh.lru.remove(old)
+	} else {
+		sh.keyCount.Add(1)
+	}
+
+	v.key = key
+	sh.store[key] = v
+	sh.lru.add(v)
+}
+
+// remove deletes key along with its expiry, returning false if it doesn't exist.
+// the caller must hold the full lock.
+func (sh *shard) remove(key string) bool {
+	v, exists := sh.store[key]
+
+	if !exists {
+		return false
+	}
+
+	delete(sh.store, key)
+	sh.clearExpiry(key)
+	sh.lru.remove(v)
+	sh.keyCount.Add(-1)
+
+	return true
+}
+
+// evict deletes a key to make room, returning false if it doesn't exist.
+// the caller must hold the full lock.
+func (sh *shard) evict(key string) bool {
+	v, exists := sh.store[key]
+
+	if !exists {
+		return false
+	}
+
+	sh.remove(key)
+	sh.touch(key)
+	sh.hooks.evicted(key, v)
+
+	return true
+}
+
+// expireIfNeeded deletes a key if it is expired.
+// the caller must hold the full lock.
+func (sh *shard) expireIfNeeded(key string) bool {
+	if expiry, hasExpiry := sh.expiries[key]; hasExpiry && expiry.Before(time.Now()) {
+		v := sh.store[key]
+
+		sh.remove(key)
+		sh.touch(key)
+		sh.expiredCount.Add(1)
+		sh.hooks.expired(key, v)
+		return true
+	}
+
+	return false
+}
+
+// lockAll takes the full lock of every shard, in index order,
+// and returns a function to release them.
+func (s *KVStore) lockAll() func() {
+	for _, sh := range s.shards {
+		sh.mutex.Lock()
+	}
+
+	return func() {
+		for _, sh := range s.shards {
+			sh.mutex.Unlock()
+		}
+	}
+}
+
+// rlockAll takes the read lock of every shard, in index order,
+// and returns a function to release them.
+func (s *KVStore) rlockAll() func() {
+	for _, sh := range s.shards {
+		sh.mutex.RLock()
+	}
+
+	return func() {
+		for _, sh := range s.shards {
+			sh.mutex.RUnlock()
+		}
+	}
+}
@@ -0,0 +1,524 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotMagic starts every snapshot, followed by snapshotVersion.
+const snapshotMagic = "REDIG"
+
+// snapshotVersion is bumped whenever the format changes, older versions being refused on Load.
+const snapshotVersion byte = 1
+
+// snapshotEOF marks the end of the entries, followed by a CRC32 of everything before it.
+const snapshotEOF byte = 0xff
+
+// ErrBadSnapshot is returned by Load for a snapshot which is corrupt or of an unknown version.
+var ErrBadSnapshot = errors.New("bad snapshot")
+
+// snapshotEntry is a key as written to a snapshot.
+type snapshotEntry struct {
+	key string
+	v   *value
+
+	// the zero time if the key has no expiry
+	expiry time.Time
+}
+
+// Snapshot writes every key along with its value and expiry to w, in a versioned binary format
+// read back by Load. It's a shorthand for taking a Checkpoint and writing it.
+// Expiries are written as absolute times, so the keys expire on schedule wherever they're loaded.
+//
+// The format is the magic "REDIG" and a version byte, then for every key its kind,
+// its expiry in unix milliseconds (0 for none), its name and its value, and finally
+// an EOF byte and a CRC32 of everything before it. Strings and counts are uvarint prefixed.
+func (s *KVStore) Snapshot(w io.Writer) error {
+	return s.Checkpoint().Write(w)
+}
+
+// Checkpoint is a copy of the whole store at a point in time, which can be written
+// as a snapshot while the store carries on changing.
+type Checkpoint struct {
+	entries []snapshotEntry
+}
+
+// Checkpoint copies every key along with its value and expiry. Writes wait until
+// the copy is done, so it's a consistent point in time, but not for it to be written.
+func (s *KVStore) Checkpoint() *Checkpoint {
+	unlock := s.rlockAll()
+	defer unlock()
+
+	entries := make([]snapshotEntry, 0, s.KeyCount())
+
+	for _, sh := range s.shards {
+		for key, v := range sh.store {
+			// an expired key which GC hasn't reached yet is already gone
+			if _, exists := sh.lookup(key); !exists {
+				continue
+			}
+
+			entries = append(entries, snapshotEntry{key: key, v: v.clone(), expiry: sh.expiries[key]})
+		}
+	}
+
+	return &Checkpoint{entries: entries}
+}
+
+// Write writes the checkpoint to w as a snapshot, see Snapshot for the format.
+func (c *Checkpoint) Write(w io.Writer) error {
+	e := newSnapshotEncoder(w)
+	e.writeHeader()
+
+	for _, entry := range c.entries {
+		e.writeEntry(entry)
+	}
+
+	return e.finish()
+}
+
+// Save writes the checkpoint as a snapshot to the file at path. The snapshot is written to
+// a temporary file first and renamed over path once complete, so a crash halfway through
+// never leaves a truncated snapshot behind.
+func (c *Checkpoint) Save(path string) error {
+	return writeFileAtomically(path, c.Write)
+}
+
+// Load reads a snapshot written by Snapshot into the store. Keys in the snapshot replace
+// those of the same name, others are left alone. Keys which have expired since the snapshot
+// was taken are dropped. Nothing is loaded if the snapshot turns out to be corrupt,
+// in which case it returns ErrBadSnapshot.
+// Loading ignores the key limit, as the keys were all held before.
+func (s *KVStore) Load(r io.Reader) error {
+	entries, err := readSnapshot(r)
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, entry := range entries {
+		if !entry.expiry.IsZero() && entry.expiry.Before(now) {
+			continue
+		}
+
+		s.restore(entry)
+	}
+
+	return nil
+}
+
+// restore stores a key read from a snapshot.
+func (s *KVStore) restore(entry snapshotEntry) {
+	sh := s.shardFor(entry.key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	// the expiry of the key being replaced must not carry over
+	sh.remove(entry.key)
+	sh.put(entry.key, entry.v)
+
+	if !entry.expiry.IsZero() {
+		s.setExpiry(entry.key, entry.expiry)
+	}
+
+	sh.touch(entry.key)
+	sh.signalWaiters(entry.key)
+}
+
+// SaveSnapshot writes a snapshot of the store to the file at path, see Checkpoint.Save.
+func (s *KVStore) SaveSnapshot(path string) error {
+	return s.Checkpoint().Save(path)
+}
+
+// LoadSnapshot loads the snapshot saved at path. If there's no such file
+// it returns an error satisfying errors.Is(err, fs.ErrNotExist).
+func (s *KVStore) LoadSnapshot(path string) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return s.Load(file)
+}
+
+// writeFileAtomically has write fill a temporary file next to path, then renames it to path.
+func writeFileAtomically(path string, write func(io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+
+	if err != nil {
+		return err
+	}
+
+	// a no-op once the file has been renamed
+	defer os.Remove(file.Name())
+
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	// the data must be on disk before the rename makes it the snapshot
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// snapshotEncoder writes a snapshot, keeping the first error and a checksum of what's been written.
+type snapshotEncoder struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	err error
+}
+
+func newSnapshotEncoder(w io.Writer) *snapshotEncoder {
+	crc := crc32.NewIEEE()
+
+	return &snapshotEncoder{
+		w:   bufio.NewWriter(io.MultiWriter(w, crc)),
+		crc: crc,
+	}
+}
+
+func (e *snapshotEncoder) write(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+}
+
+func (e *snapshotEncoder) writeByte(b byte) {
+	e.write([]byte{b})
+}
+
+func (e *snapshotEncoder) writeUvarint(x uint64) {
+	e.write(binary.AppendUvarint(nil, x))
+}
+
+func (e *snapshotEncoder) writeUint64(x uint64) {
+	e.write(binary.BigEndian.AppendUint64(nil, x))
+}
+
+func (e *snapshotEncoder) writeString(s string) {
+	e.writeUvarint(uint64(len(s)))
+	e.write([]byte(s))
+}
+
+func (e *snapshotEncoder) writeHeader() {
+	e.write([]byte(snapshotMagic))
+	e.writeByte(snapshotVersion)
+}
+
+func (e *snapshotEncoder) writeEntry(entry snapshotEntry) {
+	e.writeByte(byte(entry.v.kind))
+
+	if entry.expiry.IsZero() {
+		e.writeUint64(0)
+	} else {
+		e.writeUint64(uint64(entry.expiry.UnixMilli()))
+	}
+
+	e.writeString(entry.key)
+	e.writeValue(entry.v)
+}
+
+// writeValue writes the payload of a value, without its kind.
+func (e *snapshotEncoder) writeValue(v *value) {
+	switch v.kind {
+	case StringKind:
+		e.writeString(v.str)
+
+	case ListKind:
+		e.writeUvarint(uint64(v.list.len()))
+
+		for _, element := range v.list.elements() {
+			e.writeString(element)
+		}
+
+	case HashKind:
+		e.writeUvarint(uint64(len(v.hash)))
+
+		for field, fieldValue := range v.hash {
+			e.writeString(field)
+			e.writeString(fieldValue)
+		}
+
+	case SetKind:
+		e.writeUvarint(uint64(len(v.set)))
+
+		for member := range v.set {
+			e.writeString(member)
+		}
+
+	case ZSetKind:
+		e.writeUvarint(uint64(len(v.zset.sorted)))
+
+		for _, member := range v.zset.sorted {
+			e.writeString(member.Member)
+			e.writeUint64(math.Float64bits(member.Score))
+		}
+	}
+}
+
+// finish writes the EOF marker and the checksum, and flushes everything to the underlying writer.
+func (e *snapshotEncoder) finish() error {
+	e.writeByte(snapshotEOF)
+	return e.writeChecksum()
+}
+
+// writeChecksum writes the checksum of everything written so far, and flushes everything to the underlying writer.
+func (e *snapshotEncoder) writeChecksum() error {
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+
+	if e.err != nil {
+		return e.err
+	}
+
+	// the checksum itself isn't part of what it covers
+	_, err := e.w.Write(binary.BigEndian.AppendUint32(nil, e.crc.Sum32()))
+
+	if err != nil {
+		return err
+	}
+
+	return e.w.Flush()
+}
+
+// snapshotDecoder reads a snapshot, keeping a checksum of what's been read.
+type snapshotDecoder struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (d *snapshotDecoder) ReadByte() (byte, error) {
+	b, err := d.r.ReadByte()
+
+	if err != nil {
+		return 0, err
+	}
+
+	d.crc.Write([]byte{b})
+	return b, nil
+}
+
+func (d *snapshotDecoder) read(n uint64) ([]byte, error) {
+	if n > math.MaxInt64 {
+		return nil, errors.New("invalid length")
+	}
+
+	// the buffer grows as the data comes in, so a corrupt length can't make us allocate more than is there
+	var buf bytes.Buffer
+
+	if _, err := io.CopyN(&buf, d.r, int64(n)); err != nil {
+		return nil, err
+	}
+
+	d.crc.Write(buf.Bytes())
+	return buf.Bytes(), nil
+}
+
+func (d *snapshotDecoder) readUvarint() (uint64, error) {
+	return binary.ReadUvarint(d)
+}
+
+func (d *snapshotDecoder) readUint64() (uint64, error) {
+	b, err := d.read(8)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (d *snapshotDecoder) readString() (string, error) {
+	length, err := d.readUvarint()
+
+	if err != nil {
+		return "", err
+	}
+
+	b, err := d.read(length)
+	return string(b), err
+}
+
+// readSnapshot reads and checks a whole snapshot, returning its entries.
+func readSnapshot(r io.Reader) ([]snapshotEntry, error) {
+	d := &snapshotDecoder{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+
+	entries, err := d.readEntries()
+
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, fmt.Errorf("%w: %w", ErrBadSnapshot, err)
+	}
+
+	return entries, nil
+}
+
+func (d *snapshotDecoder) readEntries() ([]snapshotEntry, error) {
+	header, err := d.read(uint64(len(snapshotMagic)) + 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errors.New("not a snapshot")
+	}
+
+	if version := header[len(snapshotMagic)]; version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+
+	var entries []snapshotEntry
+
+	for {
+		kind, err := d.ReadByte()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if kind == snapshotEOF {
+			break
+		}
+
+		entry, err := d.readEntry(Kind(kind))
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	// the checksum is read straight off the reader, as it isn't part of what it covers
+	sum := d.crc.Sum32()
+	b := make([]byte, 4)
+
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(b) != sum {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	return entries, nil
+}
+
+func (d *snapshotDecoder) readEntry(kind Kind) (snapshotEntry, error) {
+	var entry snapshotEntry
+
+	expiry, err := d.readUint64()
+
+	if err != nil {
+		return entry, err
+	}
+
+	if expiry != 0 {
+		entry.expiry = time.UnixMilli(int64(expiry))
+	}
+
+	if entry.key, err = d.readString(); err != nil {
+		return entry, err
+	}
+
+	entry.v, err = d.readValue(kind)
+	return entry, err
+}
+
+func (d *snapshotDecoder) readValue(kind Kind) (*value, error) {
+	if kind == StringKind {
+		str, err := d.readString()
+		return newStringValue(str), err
+	}
+
+	var v *value
+
+	switch kind {
+	case ListKind:
+		v = newListValue()
+	case HashKind:
+		v = newHashValue()
+	case SetKind:
+		v = newSetValue()
+	case ZSetKind:
+		v = newZSetValue()
+	default:
+		return nil, fmt.Errorf("unknown kind %d", kind)
+	}
+
+	count, err := d.readUvarint()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for range count {
+		element, err := d.readString()
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case ListKind:
+			v.list.pushBack(element)
+
+		case HashKind:
+			fieldValue, err := d.readString()
+
+			if err != nil {
+				return nil, err
+			}
+
+			v.hash[element] = fieldValue
+
+		case SetKind:
+			v.set[element] = struct{}{}
+
+		case ZSetKind:
+			bits, err := d.readUint64()
+
+			if err != nil {
+				return nil, err
+			}
+
+			v.zset.add(element, math.Float64frombits(bits))
+		}
+	}
+
+	// Redis never keeps empty collections around, a snapshot holding one is corrupt
+	if v.len() == 0 {
+		return nil, errors.New("empty collection")
+	}
+
+	return v, nil
+}
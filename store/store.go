@@ -1,225 +1,662 @@
-// Package store provides an in-memory key-value store with expiration and background cleanup.
-
 package store
 
 import (
+	"hash/maphash"
+	"maps"
+	"math"
+	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Store is the core of the key-value API: string values with an optional expiry.
+// KVStore implements it, along with lists, hashes, sets and sorted sets.
+// Every method is safe to call from several goroutines.
+type Store interface {
+	// Set sets key to value, replacing whatever it held and dropping its expiry.
+	Set(key string, value string) error
+
+	// Get returns the value of key. ok is false if the key is missing or expired,
+	// which tells it apart from a key holding an empty string.
+	Get(key string) (value string, ok bool, err error)
+
+	// GetDel deletes key and returns the value it held, like Get.
+	GetDel(key string) (value string, ok bool, err error)
+
+	// Has reports whether key exists, whatever kind of value it holds.
+	Has(key string) bool
+
+	// Delete deletes key, returning false if it didn't exist.
+	Delete(key string) bool
+
+	// Add adds x to the integer held by key, starting from 0 if it doesn't exist.
+	Add(key string, x int64) (int64, error)
+
+	// Keys returns every key which hasn't expired.
+	Keys() []string
+
+	// ExpireAfter sets key to expire after ttl, returning false if it doesn't exist.
+	ExpireAfter(key string, ttl time.Duration) bool
+
+	// ExpireAt sets key to expire at expiry, returning false if it doesn't exist.
+	ExpireAt(key string, expiry time.Time) bool
+
+	// Expiry returns when key expires, the zero time if it never does. ok is false if the key doesn't exist.
+	Expiry(key string) (expiry time.Time, ok bool)
+
+	// Persist drops the expiry of key, returning false if it doesn't exist or has no expiry.
+	Persist(key string) bool
+
+	// Close stops the background work of the store.
+	Close()
+}
+
+var _ Store = (*KVStore)(nil)
+
 // KVStore is a thread-safe key-value store with expiration and GC.
+// The keyspace is split into shards, each behind its own lock.
 type KVStore struct {
-	store    map[string]string
-	mutex    sync.RWMutex
-	expiries map[string]time.Time
+	shards []*shard
 
-	// this defines the frequency of GC routine
-	gcInterval time.Duration
-}
+	// seeds the hash picking the shard of a key
+	seed maphash.Seed
 
-// runGCRoutine cleans up expired keys in the background every gcInterval
-func runGCRoutine(store *KVStore) {
-	for {
-		// acquire read lock to collect expired keys
-		// instead of acquiring full lock and checking every iteration
-		// this specific operation is what we call RFCL (Read First, Check Later)
-		// the operation is meant to simplify the dead-lock situations and reduce the full-lock duration
-
-		store.mutex.RLock()
-		now := time.Now()
-		expiredKeys := make([]string, 0, len(store.expiries))
-		for key, expiry := range store.expiries {
-			if expiry.Before(now) {
-				expiredKeys = append(expiredKeys, key)
-			}
-		}
+	// the most keys the store holds before evicting some, or 0 for no limit
+	maxKeys atomic.Int64
 
-		store.mutex.RUnlock()
+	// picks which keys are evicted once maxKeys is reached,
+	// an EvictionPolicy which can be changed while clients are writing
+	evictionPolicy atomic.Int32
 
-		// if any expired keys were found, acquire full lock and delete them
-		if len(expiredKeys) > 0 {
-			store.mutex.Lock()
+	// number of keys evicted so far
+	evictedCount atomic.Int64
 
-			now := time.Now()
+	// this defines the frequency of GC routine, a time.Duration which can be changed while it runs
+	gcInterval atomic.Int64
 
-			for _, key := range expiredKeys {
-				// Recheck avoids race where key’s expiry changes mid-flight.
-				if expiry, exists := store.expiries[key]; exists && expiry.Before(now) {
-					delete(store.store, key)
-					delete(store.expiries, key)
-				}
-			}
+	// the most time added at random to TTLs set relative to now, a time.Duration
+	ttlJitter atomic.Int64
+
+	// signals the GC routine that a key is due sooner than it planned to wake up
+	wake chan struct{}
+
+	// functions told about keys the store removes, called by a routine of their own
+	hooks *hooks
+
+	// done is closed to stop the GC and hooks routines, which close stopped and hooksStopped once returned
+	done         chan struct{}
+	stopped      chan struct{}
+	hooksStopped chan struct{}
+	closeOnce    sync.Once
+}
+
+// runGCRoutine cleans up expired keys in the background until the store is closed.
+// it sleeps until the next key is due to expire, running at most once every gcInterval.
+func runGCRoutine(store *KVStore) {
+	defer close(store.stopped)
+
+	timer := time.NewTimer(store.GCInterval())
+	defer timer.Stop()
 
-			store.mutex.Unlock()
+	lastRun := time.Now()
+
+	for {
+		select {
+		case <-store.done:
+			return
+		case <-store.wake:
+			// a key is due sooner than the current wait, work it out again
+		case <-timer.C:
+			store.collectExpired()
+			lastRun = time.Now()
 		}
 
-		time.Sleep(store.gcInterval)
+		timer.Reset(store.nextGCDelay(lastRun))
 	}
 }
 
-// NewKVStore spins up a store and starts GC with a 1-second interval.
-func NewKVStore() *KVStore {
+// NewKVStore spins up a store and starts GC, with a 1-second interval unless set by WithGCInterval.
+// The keyspace is split into DefaultShardCount shards unless set by WithShardCount.
+func NewKVStore(options ...Option) *KVStore {
 	store := &KVStore{
-		store:      make(map[string]string),
-		expiries:   make(map[string]time.Time),
-		gcInterval: 1 * time.Second,
+		shards:       make([]*shard, DefaultShardCount),
+		seed:         maphash.MakeSeed(),
+		hooks:        newHooks(),
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		hooksStopped: make(chan struct{}),
+		wake:         make(chan struct{}, 1),
 	}
 
-	go runGCRoutine(store)
+	store.gcInterval.Store(int64(DefaultGCInterval))
+
+	for _, option := range options {
+		option(store)
+	}
+
+	for i := range store.shards {
+		store.shards[i] = newShard(store.hooks)
+	}
+
+	go store.hooks.run(store.done, store.hooksStopped)
+
+	if store.GCInterval() > 0 {
+		go runGCRoutine(store)
+	} else {
+		// there is no routine to wait for on Close
+		close(store.stopped)
+	}
 
 	return store
 }
 
-// Set sets a key-value pair into the store.
-func (s *KVStore) Set(key string, value string) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// Close stops the background GC and waits for it to return, along with the calls to hooks
+// like OnExpire still pending. Expired keys are still collected lazily afterwards,
+// though hooks aren't called anymore. Closing twice is a no-op.
+func (s *KVStore) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+
+	<-s.stopped
+	<-s.hooksStopped
+}
+
+// Set sets a key-value pair into the store, replacing any value of another kind and dropping
+// the key's expiry, like Redis' SET.
+// It returns ErrOutOfMemory if the store is full and its eviction policy doesn't allow making room.
+func (s *KVStore) Set(key string, value string) error {
+	if err := s.reserve(key); err != nil {
+		return err
+	}
+
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	// an expired key counts as expired rather than replaced
+	sh.expireIfNeeded(key)
 
-	s.store[key] = value
+	// removing the old value drops its expiry along with it
+	sh.remove(key)
+	sh.put(key, newStringValue(value))
+	sh.touch(key)
+
+	return nil
 }
 
-// Has checks if a key’s alive and not expired.
+// Has checks if a key’s alive and not expired, whatever kind of value it holds.
 func (s *KVStore) Has(key string) bool {
-	// the reason we don't lock here is because we use Get call which internally handles the lock
-	// and because Get already tells us if the key is alive or not
-	// we just fetch the exists boolean returned by Get
-	_, exists := s.Get(key)
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	_, exists := sh.lookup(key)
 	return exists
 }
 
 // Get grabs a value if the key’s there and not expired.
-func (s *KVStore) Get(key string) (string, bool) {
+// ok is false for a missing key, so an empty string is told apart from no value at all.
+// It returns ErrWrongType if the key holds a non-string value.
+func (s *KVStore) Get(key string) (string, bool, error) {
 
 	// lazy expiration check
 	// every-time Get is called, we first check if the key is expired
 	// if the key is expired, treat the key as non-existent
 	if s.GC(key) {
-		return "", false
+		return "", false, nil
+	}
+
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists := sh.lookup(key)
+
+	if !exists {
+		return "", false, nil
+	}
+
+	if v.kind != StringKind {
+		return "", false, ErrWrongType
+	}
+
+	sh.lru.touch(v)
+
+	return v.str, true, nil
+}
+
+// Delete wipes a key if it exists and not expired, whatever kind of value it holds.
+func (s *KVStore) Delete(key string) bool {
+	s.GC(key)
+
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	if !sh.remove(key) {
+		return false
+	}
+
+	sh.touch(key)
+	return true
+}
+
+// DeleteMany wipes every key which exists and isn't expired, returning the keys deleted.
+// The keys are all deleted at once, under the lock of every shard holding one of them.
+func (s *KVStore) DeleteMany(keys []string) []string {
+	unlock := s.lock(keys...)
+	defer unlock()
+
+	var deleted []string
+
+	for _, key := range keys {
+		sh := s.shardFor(key)
+
+		// an expired key is collected rather than counted as deleted
+		if sh.expireIfNeeded(key) || !sh.remove(key) {
+			continue
+		}
+
+		sh.touch(key)
+		deleted = append(deleted, key)
+	}
+
+	return deleted
+}
+
+// DeleteUnchanged deletes every key of versions which is still at the version Watch returned for it,
+// returning the keys deleted. Like DeleteMany, the keys are all deleted at once.
+func (s *KVStore) DeleteUnchanged(versions map[string]uint64) []string {
+	keys := slices.Collect(maps.Keys(versions))
+
+	unlock := s.lock(keys...)
+	defer unlock()
+
+	var deleted []string
+
+	for _, key := range keys {
+		sh := s.shardFor(key)
+
+		// expiring counts as a change, as for WATCH
+		if sh.expireIfNeeded(key) {
+			continue
+		}
+
+		if w, watched := sh.watched[key]; !watched || w.version != versions[key] {
+			continue
+		}
+
+		if !sh.remove(key) {
+			continue
+		}
+
+		sh.touch(key)
+		deleted = append(deleted, key)
+	}
+
+	return deleted
+}
+
+// FlushAll wipes every key, one shard after another.
+func (s *KVStore) FlushAll() {
+	for _, sh := range s.shards {
+		sh.mutex.Lock()
+
+		for key := range sh.store {
+			sh.remove(key)
+			sh.touch(key)
+		}
+
+		sh.mutex.Unlock()
+	}
+}
+
+// GetDel wipes a string key and returns its value before deletion.
+// It returns ErrWrongType and leaves the key alone if it holds a non-string value.
+func (s *KVStore) GetDel(key string) (string, bool, error) {
+	s.GC(key)
+
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists := sh.store[key]
+
+	if !exists {
+		return "", false, nil
 	}
 
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	if v.kind != StringKind {
+		return "", false, ErrWrongType
+	}
 
-	value, exists := s.store[key]
-	return value, exists
+	sh.remove(key)
+	sh.touch(key)
+	return v.str, true, nil
 }
 
-// Delete wipes a key if it exists and not expired
-// it returns the value of the key before deletion
-func (s *KVStore) Delete(key string) (bool, string) {
-	if !s.Has(key) {
-		return false, ""
+// GetEx returns the value of a string key like Get, and sets the time it expires at
+// unless expiry is zero. persist removes its TTL instead.
+// It returns ErrWrongType and leaves the key alone if it holds a non-string value.
+func (s *KVStore) GetEx(key string, expiry time.Time, persist bool) (string, bool, error) {
+	s.GC(key)
+
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists := sh.lookup(key)
+
+	if !exists {
+		return "", false, nil
+	}
+
+	if v.kind != StringKind {
+		return "", false, ErrWrongType
 	}
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	sh.lru.touch(v)
 
-	value := s.store[key]
+	switch {
+	case persist:
+		if sh.clearExpiry(key) {
+			sh.touch(key)
+		}
+	case !expiry.IsZero():
+		s.setExpiry(key, expiry)
+		sh.touch(key)
+	}
 
-	delete(s.store, key)
-	delete(s.expiries, key)
-	return true, value
+	return v.str, true, nil
 }
 
 // Add tweaks a numeric value by x, starts at 0 if key’s new.
-func (s *KVStore) Add(key string, x int) (int, error) {
+// It returns ErrOverflow and leaves the value alone if the result doesn't fit in an int64.
+func (s *KVStore) Add(key string, x int64) (int64, error) {
 
 	s.GC(key)
 
+	if err := s.reserve(key); err != nil {
+		return 0, err
+	}
+
 	// acquire full lock for atomic operation
 	// if we acquired read lock until the increment operation,
 	// there is a potential race condition
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
 
-	value, exists := s.store[key]
+	return sh.add(key, x)
+}
+
+// add tweaks the numeric value of key by x, see KVStore.Add.
+// the caller must hold the full lock.
+func (sh *shard) add(key string, x int64) (int64, error) {
+	v, exists := sh.store[key]
 
 	if !exists {
-		value = "0"
+		v = newStringValue("0")
 	}
 
-	i, err := strconv.Atoi(value)
+	if v.kind != StringKind {
+		return 0, ErrWrongType
+	}
 
-	// string to int conversion can fail, if the value is not an integer
-	if err != nil {
-		return 0, err
+	// like Redis, only an integer written the way it would format it is one:
+	// "007", " 5", "5 " or "+5" aren't, rather than being read as 5 or 7
+	if !isCanonicalInt(v.str) {
+		return 0, ErrNotInteger
+	}
+
+	i, _ := strconv.ParseInt(v.str, 10, 64)
+
+	// adding a positive x can only wrap past the maximum, a negative one past the minimum
+	if (x > 0 && i > math.MaxInt64-x) || (x < 0 && i < math.MinInt64-x) {
+		return 0, ErrOverflow
 	}
 
 	i += x
 
-	s.store[key] = strconv.Itoa(i)
+	v.str = strconv.FormatInt(i, 10)
+	sh.put(key, v)
+	sh.touch(key)
 
 	return i, nil
 }
 
 // Incr bumps a value by 1.
-func (s *KVStore) Incr(key string) (int, error) {
+func (s *KVStore) Incr(key string) (int64, error) {
 	return s.Add(key, 1)
 }
 
 // Decr drops a value by 1.
-func (s *KVStore) Decr(key string) (int, error) {
+func (s *KVStore) Decr(key string) (int64, error) {
 	return s.Add(key, -1)
 }
 
-// Keys lists all non-expired keys.
+// keysBatchSize is how many keys of a shard KeysMatching goes through before letting writes to it in.
+const keysBatchSize = 1024
+
+// Keys lists all non-expired keys, the way KeysMatching does.
 func (s *KVStore) Keys() []string {
-	// we are performing RFCL here, read above in runGCRoutine
-	s.mutex.RLock()
+	return s.KeysMatching("*", 0)
+}
 
-	keys := make([]string, 0, len(s.store))
-	for key := range s.store {
-		keys = append(keys, key)
+// KeysMatching lists the non-expired keys matching the glob pattern, at most count of them unless count is 0.
+//
+// A scan of the whole keyspace can take a while, so it never holds up writes for long: shards are
+// gathered one after another, and a shard's read lock is dropped every keysBatchSize keys for the
+// writes waiting on it. A write then waits for a batch at most, however many keys there are.
+// The price is that the keys aren't those of a single point in time: a key written or deleted while
+// the scan runs may or may not be listed, although a key which is there throughout is listed once.
+// Range gives a consistent view instead, holding up every write until it's done.
+func (s *KVStore) KeysMatching(pattern string, count int) []string {
+	var keys []string
+
+	if count == 0 {
+		keys = make([]string, 0, s.KeyCount())
 	}
 
-	s.mutex.RUnlock()
+	for _, sh := range s.shards {
+		if count > 0 && len(keys) >= count {
+			break
+		}
 
-	validKeys := make([]string, 0, len(keys))
+		keys = sh.appendKeys(keys, pattern, count)
+	}
 
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	return keys
+}
 
-	for _, key := range keys {
+// Range calls fn with every non-expired key and its value, until fn returns false, without copying
+// the keys like Keys does. The value of a key holding anything but a string is passed as empty.
+//
+// The keys are those of a single point in time: every shard is read locked until Range returns,
+// holding up every write to the store meanwhile. fn must then be quick, and must not use the store.
+func (s *KVStore) Range(fn func(key, value string) bool) {
+	unlock := s.rlockAll()
+	defer unlock()
+
+	now := time.Now()
+
+	for _, sh := range s.shards {
+		for key, v := range sh.store {
+			if expiry, hasExpiry := sh.expiries[key]; hasExpiry && expiry.Before(now) {
+				continue
+			}
+
+			value := ""
+
+			if v.kind == StringKind {
+				value = v.str
+			}
+
+			if !fn(key, value) {
+				return
+			}
+		}
+	}
+}
 
-		// if the key is expired and gets deleted, skip it
-		if s.GC(key) {
+// appendKeys appends the non-expired keys of the shard matching pattern to keys, until there are count of them.
+func (sh *shard) appendKeys(keys []string, pattern string, count int) []string {
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	// the clock is read once a batch rather than for every key, to keep the lock as short as can be
+	now := time.Now()
+	batched := 0
+
+	for key := range sh.store {
+		if count > 0 && len(keys) >= count {
+			break
+		}
+
+		// a map can be written between the steps of a range over it: keys added meanwhile
+		// may or may not come up, and keys deleted before they're reached don't
+		if batched++; batched == keysBatchSize {
+			sh.mutex.RUnlock()
+			sh.mutex.RLock()
+
+			batched = 0
+			now = time.Now()
+		}
+
+		// if the key is expired, skip it and leave the deletion to GC
+		if expiry, hasExpiry := sh.expiries[key]; hasExpiry && expiry.Before(now) {
 			continue
 		}
 
-		validKeys = append(validKeys, key)
+		if pattern != "*" && !Match(pattern, key) {
+			continue
+		}
+
+		keys = append(keys, key)
 	}
 
-	return validKeys
+	return keys
 }
 
-// Expire sets a TTL on a key, bails if key’s gone or expired.
+// KeyCount returns the number of keys held by the store,
+// including expired ones which haven't been collected yet.
+func (s *KVStore) KeyCount() int {
+	count := int64(0)
+
+	for _, sh := range s.shards {
+		count += sh.keyCount.Load()
+	}
+
+	return int(count)
+}
+
+// ExpiredKeyCount returns the number of keys removed for having expired since the store was created.
+func (s *KVStore) ExpiredKeyCount() int {
+	count := int64(0)
+
+	for _, sh := range s.shards {
+		count += sh.expiredCount.Load()
+	}
+
+	return int(count)
+}
+
+// EvictedKeyCount returns the number of keys evicted to make room since the store was created.
+func (s *KVStore) EvictedKeyCount() int {
+	return int(s.evictedCount.Load())
+}
+
+// ExpiringKeyCount returns the number of keys with an expiry set.
+func (s *KVStore) ExpiringKeyCount() int {
+	count := 0
+
+	for _, sh := range s.shards {
+		sh.mutex.RLock()
+		count += len(sh.expiries)
+		sh.mutex.RUnlock()
+	}
+
+	return count
+}
+
+// TypeCounts returns the number of keys holding every kind of value, by the name TYPE
+// reports for it, along with the number of keys with a TTL under "expires", in a single pass.
+// Expired keys which the GC hasn't collected yet aren't counted.
+func (s *KVStore) TypeCounts() map[string]int {
+	counts := map[string]int{"expires": 0}
+
+	for _, kind := range []Kind{StringKind, ListKind, HashKind, SetKind, ZSetKind} {
+		counts[kind.String()] = 0
+	}
+
+	for _, sh := range s.shards {
+		sh.mutex.RLock()
+
+		for key := range sh.store {
+			v, exists := sh.lookup(key)
+
+			if !exists {
+				continue
+			}
+
+			counts[v.kind.String()]++
+
+			if _, expires := sh.expiries[key]; expires {
+				counts["expires"]++
+			}
+		}
+
+		sh.mutex.RUnlock()
+	}
+
+	return counts
+}
+
+// Expire sets a TTL in seconds on a key, bails if key’s gone or expired.
 func (s *KVStore) Expire(key string, ttl int) bool {
+	return s.ExpireAfter(key, time.Duration(ttl)*time.Second)
+}
+
+// ExpireAfter sets a TTL on a key, down to the nanosecond, bails if key’s gone or expired.
+// The TTL is jittered as set by SetTTLJitter.
+func (s *KVStore) ExpireAfter(key string, ttl time.Duration) bool {
+	return s.ExpireAt(key, time.Now().Add(s.JitteredTTL(ttl)))
+}
+
+// ExpireAt sets the time a key expires at, bails if key’s gone or expired.
+// A time in the past expires the key straight away.
+func (s *KVStore) ExpireAt(key string, expiry time.Time) bool {
 	// collect before setting expiry
 	s.GC(key)
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
 
 	// if the key doesn’t exist, bail
-	if _, exists := s.store[key]; !exists {
+	if _, exists := sh.store[key]; !exists {
 		return false
 	}
 
-	s.expiries[key] = time.Now().Add(time.Duration(ttl) * time.Second)
+	s.setExpiry(key, expiry)
+	sh.touch(key)
 	return true
 }
 
 // TTL shows seconds left for a key: -2 if non-existent/expired, -1 if exists but no expiry.
 func (s *KVStore) TTL(key string) int {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
 
-	if _, exists := s.store[key]; !exists {
+	if _, exists := sh.store[key]; !exists {
 		return -2
 	}
 
-	expiry, hasExpiry := s.expiries[key]
+	expiry, hasExpiry := sh.expiries[key]
 
 	if !hasExpiry {
 		return -1
@@ -234,84 +671,118 @@ func (s *KVStore) TTL(key string) int {
 	return ttl
 }
 
+// Expiry returns the time a key expires at, the zero time if it has no expiry.
+// ok is false if the key doesn't exist or has expired.
+func (s *KVStore) Expiry(key string) (time.Time, bool) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	if _, exists := sh.lookup(key); !exists {
+		return time.Time{}, false
+	}
+
+	return sh.expiries[key], true
+}
+
 // Persist yanks a key’s expiration if it’s still good.
 func (s *KVStore) Persist(key string) bool {
 
 	s.GC(key)
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
 
 	// key doesn't exist
-	if _, exists := s.store[key]; !exists {
+	if _, exists := sh.store[key]; !exists {
 		return false
 	}
 
 	// key exists but doesn't have expiry
-	if _, hasExpiry := s.expiries[key]; !hasExpiry {
+	if !sh.clearExpiry(key) {
 		return false
 	}
 
-	// key and expiry both exists
-	delete(s.expiries, key)
+	sh.touch(key)
 
 	return true
 }
 
-// MGet returns array of values for multiple keys
-func (s *KVStore) MGet(keys []string) []string {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+// MGet returns array of values for multiple keys, nil for keys without a string value
+func (s *KVStore) MGet(keys []string) []*string {
+	unlock := s.rlock(keys...)
+	defer unlock()
 
-	values := make([]string, len(keys))
+	values := make([]*string, len(keys))
 
 	for i, key := range keys {
-		// check for key expiry
-		if s.GC(key) {
-			// set empty string for expired key
-			values[i] = ""
+		sh := s.shardFor(key)
+		v, exists := sh.lookup(key)
+
+		// leave nil for missing or expired keys,
+		// and for keys holding a non-string value, like Redis does
+		if !exists || v.kind != StringKind {
 			continue
 		}
 
-		value := s.store[key]
+		sh.lru.touch(v)
+		str := v.str
+		values[i] = &str
+	}
+
+	return values
+}
+
+// Elements returns a copy of the elements of a list, in order, or of the members of a set,
+// in no particular order. A missing key has no elements.
+// It returns ErrWrongType if the key holds neither a list nor a set.
+func (s *KVStore) Elements(key string) ([]string, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
 
-		// NOTE: missing keys are not explicitly handled because,
-		// the value is empty string if the key does not exist
+	v, exists := sh.lookup(key)
 
-		values[i] = value
+	if !exists {
+		return []string{}, nil
 	}
 
-	return values
+	switch v.kind {
+	case ListKind:
+		sh.lru.touch(v)
+		return slices.Clone(v.list.elements()), nil
+	case SetKind:
+		sh.lru.touch(v)
+		return setMembers(v.set), nil
+	}
+
+	return nil, ErrWrongType
 }
 
 // GC attempts to delete a key if it’s expired.
 // Returns true if the key was deleted, false otherwise.
 func (s *KVStore) GC(key string) bool {
-	s.mutex.RLock()
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
 
-	expiry, hasExpiry := s.expiries[key]
+	expiry, hasExpiry := sh.expiries[key]
 
 	if !hasExpiry {
-		s.mutex.RUnlock()
+		sh.mutex.RUnlock()
 		return false
 	}
 
 	if !expiry.Before(time.Now()) {
-		s.mutex.RUnlock()
+		sh.mutex.RUnlock()
 		return false
 	}
 
-	s.mutex.RUnlock()
+	sh.mutex.RUnlock()
 
 	// get lazy full-lock to finally delete the key
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	if expiry, hasExpiry := s.expiries[key]; hasExpiry && expiry.Before(time.Now()) {
-		delete(s.store, key)
-		delete(s.expiries, key)
-		return true
-	}
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
 
-	return false
+	return sh.expireIfNeeded(key)
 }
@@ -0,0 +1,1241 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestStore returns a store closed once the test is done.
+func newTestStore(t *testing.T, options ...Option) *KVStore {
+	t.Helper()
+
+	s := NewKVStore(options...)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// mustSet sets keys to their own name, failing the test if any can't be.
+func mustSet(t *testing.T, s *KVStore, keys ...string) {
+	t.Helper()
+
+	for _, key := range keys {
+		if err := s.Set(key, key); err != nil {
+			t.Fatalf("Set(%q): %v", key, err)
+		}
+	}
+}
+
+func TestEvictLRU(t *testing.T) {
+	s := newTestStore(t, WithMaxKeys(3), WithEvictionPolicy(AllKeysLRU))
+
+	mustSet(t, s, "a", "b", "c")
+
+	// a is used again, which leaves b the least recently used
+	s.Get("a")
+	mustSet(t, s, "d")
+
+	if s.Has("b") {
+		t.Errorf("b wasn't evicted")
+	}
+
+	for _, key := range []string{"a", "c", "d"} {
+		if !s.Has(key) {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+
+	// replacing a key doesn't make room for it
+	mustSet(t, s, "c")
+	mustSet(t, s, "e")
+
+	if s.Has("a") || !s.Has("c") {
+		t.Errorf("got keys %q, want a evicted rather than c", s.Keys())
+	}
+
+	if got := s.EvictedKeyCount(); got != 2 {
+		t.Errorf("got %d evicted keys, want 2", got)
+	}
+}
+
+func TestEvictRandom(t *testing.T) {
+	s := newTestStore(t, WithMaxKeys(3), WithEvictionPolicy(AllKeysRandom))
+
+	mustSet(t, s, "a", "b", "c", "d", "e")
+
+	if got := s.KeyCount(); got != 3 {
+		t.Errorf("got %d keys, want 3", got)
+	}
+
+	if !s.Has("e") {
+		t.Errorf("the key just set was evicted")
+	}
+}
+
+func TestNoEviction(t *testing.T) {
+	s := newTestStore(t, WithMaxKeys(3))
+
+	mustSet(t, s, "a", "b")
+
+	if _, err := s.SAdd("set", "x"); err != nil {
+		t.Fatalf("SAdd: %v", err)
+	}
+
+	writes := map[string]func() error{
+		"Set":         func() error { return s.Set("new", "x") },
+		"Incr":        func() error { _, err := s.Incr("new"); return err },
+		"LPush":       func() error { _, err := s.LPush("new", "x"); return err },
+		"RPush":       func() error { _, err := s.RPush("new", "x"); return err },
+		"HSet":        func() error { _, err := s.HSet("new", "f", "x"); return err },
+		"HSetNX":      func() error { _, err := s.HSetNX("new", "f", "x"); return err },
+		"SAdd":        func() error { _, err := s.SAdd("new", "x"); return err },
+		"SMove":       func() error { _, err := s.SMove("set", "new", "x"); return err },
+		"SUnionStore": func() error { _, err := s.SUnionStore("new", "set"); return err },
+		"ZAdd":        func() error { _, err := s.ZAdd("new", ZMember{Member: "x", Score: 1}); return err },
+		"ZIncrBy":     func() error { _, err := s.ZIncrBy("new", 1, "x"); return err },
+		"Txn.Set": func() (err error) {
+			s.Atomic(func(tx *Txn) { err = tx.Set("new", "x") })
+			return err
+		},
+		"Txn.Add": func() (err error) {
+			s.Atomic(func(tx *Txn) { _, err = tx.Add("new", 1) })
+			return err
+		},
+	}
+
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrOutOfMemory) {
+			t.Errorf("%s: got %v, want ErrOutOfMemory", name, err)
+		}
+	}
+
+	if got := s.KeyCount(); got != 3 {
+		t.Errorf("got %d keys, want 3", got)
+	}
+
+	// existing keys can still be written
+	if _, err := s.SAdd("set", "y"); err != nil {
+		t.Errorf("SAdd to an existing set: %v", err)
+	}
+
+	mustSet(t, s, "a")
+
+	// as can new ones, once there's room
+	s.Delete("a")
+
+	if _, err := s.LPush("list", "x"); err != nil {
+		t.Errorf("LPush with room for the list: %v", err)
+	}
+
+	if _, _, err := s.LMove("list", "moved", true, true); !errors.Is(err, ErrOutOfMemory) {
+		t.Errorf("LMove: got %v, want ErrOutOfMemory", err)
+	}
+
+	if got, _ := s.LRange("list", 0, -1); !slices.Equal(got, []string{"x"}) {
+		t.Errorf("LMove failing changed the list to %q", got)
+	}
+}
+
+func TestDeleteUnchanged(t *testing.T) {
+	s := newTestStore(t)
+
+	mustSet(t, s, "a", "b")
+
+	versions := map[string]uint64{"a": s.Watch("a"), "b": s.Watch("b"), "missing": s.Watch("missing")}
+
+	mustSet(t, s, "b")
+
+	if deleted := s.DeleteUnchanged(versions); !slices.Equal(deleted, []string{"a"}) {
+		t.Errorf("got %q deleted, want a", deleted)
+	}
+
+	if !s.Has("b") {
+		t.Errorf("b was deleted although it changed")
+	}
+}
+
+func TestSetDropsExpiry(t *testing.T) {
+	s := newTestStore(t)
+
+	sets := map[string]func(key string) error{
+		"Set":    func(key string) error { return s.Set(key, "v") },
+		"SetCtx": func(key string) error { return s.SetCtx(context.Background(), key, "v") },
+		"Txn.Set": func(key string) (err error) {
+			s.Atomic(func(tx *Txn) { err = tx.Set(key, "v") })
+			return err
+		},
+	}
+
+	for name, set := range sets {
+		mustSet(t, s, name)
+		s.ExpireAfter(name, time.Hour)
+
+		if err := set(name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if ttl := s.TTL(name); ttl != -1 {
+			t.Errorf("%s: got a TTL of %d, want none", name, ttl)
+		}
+	}
+}
+
+// scheduledExpiries returns how many entries the GC's heaps hold across every shard.
+func scheduledExpiries(s *KVStore) int {
+	count := 0
+
+	for _, sh := range s.shards {
+		sh.mutex.RLock()
+		count += len(sh.expiryHeap)
+		sh.mutex.RUnlock()
+	}
+
+	return count
+}
+
+func TestExpiryHeapStaysBounded(t *testing.T) {
+	s := newTestStore(t)
+
+	mustSet(t, s, "a", "b", "c")
+
+	for i := range 100 {
+		s.ExpireAfter("a", time.Hour+time.Duration(i)*time.Second)
+		s.ExpireAfter("b", time.Hour-time.Duration(i)*time.Second)
+		s.ExpireAfter("c", time.Hour)
+	}
+
+	if got := scheduledExpiries(s); got != 3 {
+		t.Errorf("got %d scheduled expiries after re-arming, want 3", got)
+	}
+
+	s.Persist("a")
+	s.Delete("b")
+
+	if got := scheduledExpiries(s); got != 1 {
+		t.Errorf("got %d scheduled expiries after PERSIST and DEL, want 1", got)
+	}
+
+	// the remaining entry is still collected once due
+	s.ExpireAfter("c", time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	s.collectExpired()
+
+	if s.Has("c") || scheduledExpiries(s) != 0 {
+		t.Errorf("c wasn't collected, %d scheduled expiries left", scheduledExpiries(s))
+	}
+}
+
+// BenchmarkReExpire re-arms the TTL of a million keys over and over,
+// which shouldn't grow the GC's heaps past one entry per key.
+func BenchmarkReExpire(b *testing.B) {
+	s := NewKVStore()
+	defer s.Close()
+
+	const keyCount = 1_000_000
+
+	keys := make([]string, keyCount)
+
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		s.Set(keys[i], "v")
+	}
+
+	b.ResetTimer()
+
+	for i := range b.N {
+		s.ExpireAfter(keys[i%keyCount], time.Hour+time.Duration(i%100)*time.Second)
+	}
+
+	b.ReportMetric(float64(scheduledExpiries(s)), "scheduled")
+}
+
+// BenchmarkCollectExpired times a GC run over a million keys, a hundred of which have TTLs
+// none of which is due yet, the common case. A scan of every expiry is the baseline.
+func BenchmarkCollectExpired(b *testing.B) {
+	s := NewKVStore(WithGCInterval(0))
+	defer s.Close()
+
+	for i := range 1_000_000 {
+		key := strconv.Itoa(i)
+		s.Set(key, "v")
+
+		if i%10_000 == 0 {
+			s.ExpireAfter(key, time.Hour)
+		}
+	}
+
+	b.Run("heap", func(b *testing.B) {
+		for range b.N {
+			s.collectExpired()
+		}
+	})
+
+	b.Run("scan", func(b *testing.B) {
+		for range b.N {
+			for _, sh := range s.shards {
+				sh.mutex.Lock()
+				now := time.Now()
+
+				for key, expiry := range sh.expiries {
+					if expiry.Before(now) {
+						sh.expireIfNeeded(key)
+					}
+				}
+
+				sh.mutex.Unlock()
+			}
+		}
+	})
+}
+
+func TestKeysFromEveryShard(t *testing.T) {
+	s := newTestStore(t)
+
+	keys := make([]string, 1000)
+
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+
+	mustSet(t, s, keys...)
+
+	// the keys are spread over every shard, all of which must be gathered from
+	for i, sh := range s.shards {
+		if len(sh.store) == 0 {
+			t.Fatalf("shard %d holds none of the keys", i)
+		}
+	}
+
+	if got := sorted(s.Keys()); !slices.Equal(got, sorted(keys)) {
+		t.Errorf("got keys %q, want every key set", got)
+	}
+
+	for i, value := range s.MGet(keys) {
+		if value == nil || *value != keys[i] {
+			t.Errorf("MGet: got %v for %s", value, keys[i])
+		}
+	}
+
+	if got := s.KeyCount(); got != len(keys) {
+		t.Errorf("got a key count of %d, want %d", got, len(keys))
+	}
+}
+
+func TestKeysWhileWriting(t *testing.T) {
+	// a single shard holding several batches of keys, so the scan drops its lock along the way
+	s := newTestStore(t, WithShardCount(1))
+
+	keys := make([]string, 10*keysBatchSize)
+
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+
+	mustSet(t, s, keys...)
+
+	done := make(chan struct{})
+	written := make(chan struct{})
+
+	// keys come and go meanwhile, the ones set throughout must be listed once each all the same
+	go func() {
+		defer close(written)
+
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				s.Set("churn"+strconv.Itoa(i), "v")
+				s.Delete("churn" + strconv.Itoa(i-100))
+			}
+		}
+	}()
+
+	for range 10 {
+		got := slices.DeleteFunc(s.Keys(), func(key string) bool { return strings.HasPrefix(key, "churn") })
+
+		if !slices.Equal(sorted(got), sorted(keys)) {
+			t.Errorf("got %d keys, want each of the %d set once", len(got), len(keys))
+		}
+	}
+
+	close(done)
+	<-written
+}
+
+func TestKeysMatching(t *testing.T) {
+	s := newTestStore(t)
+
+	mustSet(t, s, "hello", "hallo", "hillo", "a/b")
+
+	if got := sorted(s.KeysMatching("h[ae]llo", 0)); !slices.Equal(got, []string{"hallo", "hello"}) {
+		t.Errorf("got %q, want hallo and hello", got)
+	}
+
+	// COUNT stops at that many keys, whichever they are
+	for count := 1; count <= 4; count++ {
+		got := s.KeysMatching("h*", count)
+
+		if want := min(count, 3); len(got) != want || slices.Contains(got, "a/b") {
+			t.Errorf("count %d: got %q, want %d keys starting with h", count, got, want)
+		}
+	}
+}
+
+// BenchmarkKeys lists a million keys of a single shard while another goroutine writes to it,
+// reporting the longest a write waited. Range, which holds the lock throughout, is the baseline.
+func BenchmarkKeys(b *testing.B) {
+	scans := map[string]func(s *KVStore){
+		"Keys":  func(s *KVStore) { s.Keys() },
+		"Range": func(s *KVStore) { s.Range(func(key, value string) bool { return true }) },
+	}
+
+	for _, name := range []string{"Keys", "Range"} {
+		b.Run(name, func(b *testing.B) {
+			s := NewKVStore(WithShardCount(1))
+			defer s.Close()
+
+			for i := range 1_000_000 {
+				s.Set(strconv.Itoa(i), "v")
+			}
+
+			done := make(chan struct{})
+			longest := make(chan time.Duration)
+
+			go func() {
+				var wait time.Duration
+
+				for {
+					select {
+					case <-done:
+						longest <- wait
+						return
+					default:
+						start := time.Now()
+						s.Set("written", "v")
+						wait = max(wait, time.Since(start))
+					}
+				}
+			}()
+
+			b.ResetTimer()
+
+			for range b.N {
+				scans[name](s)
+			}
+
+			b.StopTimer()
+			close(done)
+
+			b.ReportMetric(float64(<-longest), "ns/longest-write")
+		})
+	}
+}
+
+func BenchmarkParallelSet(b *testing.B) {
+	for _, shards := range []int{1, DefaultShardCount} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			s := NewKVStore(WithShardCount(shards))
+			defer s.Close()
+
+			var goroutines atomic.Int64
+
+			b.RunParallel(func(pb *testing.PB) {
+				// every goroutine sets keys of its own
+				prefix := strconv.FormatInt(goroutines.Add(1), 10) + ":"
+
+				for i := 0; pb.Next(); i++ {
+					s.Set(prefix+strconv.Itoa(i%1024), "v")
+				}
+			})
+		})
+	}
+}
+
+func TestListPushPop(t *testing.T) {
+	s := newTestStore(t)
+
+	expect := func(want ...string) {
+		t.Helper()
+
+		if got, _ := s.LRange("list", 0, -1); !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	s.LPush("list", "c", "b", "a")
+	s.RPush("list", "d", "e")
+	expect("a", "b", "c", "d", "e")
+
+	s.LInsert("list", true, "a", "first")
+	s.LInsert("list", false, "c", "middle")
+	s.LSet("list", -1, "last")
+	expect("first", "a", "b", "c", "middle", "d", "last")
+
+	if got, _ := s.LPop("list", 2); !slices.Equal(got, []string{"first", "a"}) {
+		t.Errorf("LPop got %q", got)
+	}
+
+	if got, _ := s.RPop("list", 2); !slices.Equal(got, []string{"last", "d"}) {
+		t.Errorf("RPop got %q", got)
+	}
+
+	expect("b", "c", "middle")
+
+	s.LPush("list", "x")
+	s.LTrim("list", 1, -2)
+	expect("b", "c")
+
+	if index, _, _ := s.LIndex("list", 1); index != "c" {
+		t.Errorf("LIndex got %q, want c", index)
+	}
+
+	if length, _ := s.LLen("list"); length != 2 {
+		t.Errorf("LLen got %d, want 2", length)
+	}
+}
+
+func TestListAsQueue(t *testing.T) {
+	// pushes at one end and pops at the other shouldn't grow the list's memory
+	for _, fromHead := range []bool{true, false} {
+		var d deque
+
+		for i := range 100_000 {
+			if fromHead {
+				d.pushBack(strconv.Itoa(i))
+			} else {
+				d.pushFront(strconv.Itoa(i))
+			}
+
+			if d.len() > 10 {
+				var element string
+
+				if fromHead {
+					element = d.popFront()
+				} else {
+					element = d.popBack()
+				}
+
+				if want := strconv.Itoa(i - 10); element != want {
+					t.Fatalf("popped %q, want %q", element, want)
+				}
+			}
+		}
+
+		if cap(d.buf) > 100 {
+			t.Errorf("a queue of 10 elements holds a buffer of %d", cap(d.buf))
+		}
+	}
+}
+
+func BenchmarkLPush(b *testing.B) {
+	s := NewKVStore()
+	defer s.Close()
+
+	for range b.N {
+		s.LPush("list", "v")
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	s := newTestStore(t)
+
+	mustSet(t, s, "string")
+	s.LPush("list", "x")
+	s.HSet("hash", "f", "x")
+	s.SAdd("set", "x")
+	s.ZAdd("zset", ZMember{Member: "x", Score: 1})
+
+	ops := map[string]func(key string) error{
+		"Get":   func(key string) error { _, _, err := s.Get(key); return err },
+		"Incr":  func(key string) error { _, err := s.Incr(key); return err },
+		"LPush": func(key string) error { _, err := s.LPush(key, "y"); return err },
+		"HSet":  func(key string) error { _, err := s.HSet(key, "f", "y"); return err },
+		"SAdd":  func(key string) error { _, err := s.SAdd(key, "y"); return err },
+		"ZAdd":  func(key string) error { _, err := s.ZAdd(key, ZMember{Member: "y", Score: 2}); return err },
+	}
+
+	// which key each operation works on, every other key holding the wrong type for it
+	owns := map[string]string{"Get": "string", "Incr": "string", "LPush": "list", "HSet": "hash", "SAdd": "set", "ZAdd": "zset"}
+
+	for name, op := range ops {
+		for _, key := range []string{"string", "list", "hash", "set", "zset"} {
+			if key == owns[name] {
+				continue
+			}
+
+			if err := op(key); !errors.Is(err, ErrWrongType) {
+				t.Errorf("%s on %s: got %v, want ErrWrongType", name, key, err)
+			}
+		}
+	}
+
+	// the rejected writes left every key as it was
+	if got, _ := s.LRange("list", 0, -1); !slices.Equal(got, []string{"x"}) {
+		t.Errorf("the list changed to %q", got)
+	}
+
+	if got, _, _ := s.Get("string"); got != "string" {
+		t.Errorf("the string changed to %q", got)
+	}
+}
+
+func TestCloseStopsGC(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	for range 10 {
+		NewKVStore(WithGCInterval(time.Millisecond)).Close()
+	}
+
+	if leaked := runtime.NumGoroutine() - goroutines; leaked > 0 {
+		t.Errorf("%d goroutines leaked by closed stores", leaked)
+	}
+}
+
+func TestGCInterval(t *testing.T) {
+	s := newTestStore(t, WithGCInterval(time.Millisecond))
+
+	mustSet(t, s, "key")
+	s.ExpireAfter("key", 5*time.Millisecond)
+
+	// the GC removes the key without it being accessed
+	for deadline := time.Now().Add(time.Second); s.KeyCount() > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the GC didn't remove the expired key")
+		}
+	}
+
+	if got := s.ExpiredKeyCount(); got != 1 {
+		t.Errorf("got %d expired keys, want 1", got)
+	}
+}
+
+func TestGCDisabled(t *testing.T) {
+	s := newTestStore(t, WithGCInterval(0))
+
+	mustSet(t, s, "key")
+	s.ExpireAfter("key", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	// nothing removes the key until it's accessed
+	if got := s.KeyCount(); got != 1 {
+		t.Errorf("got %d keys before accessing the expired one, want 1", got)
+	}
+
+	if _, exists, _ := s.Get("key"); exists {
+		t.Errorf("the expired key was returned")
+	}
+
+	if got := s.KeyCount(); got != 0 {
+		t.Errorf("got %d keys after accessing the expired one, want 0", got)
+	}
+
+	if err := s.SetGCInterval(time.Second); !errors.Is(err, ErrGCDisabled) {
+		t.Errorf("SetGCInterval: got %v, want ErrGCDisabled", err)
+	}
+}
+
+func TestEvictVolatileTTL(t *testing.T) {
+	s := newTestStore(t, WithMaxKeys(4), WithEvictionPolicy(VolatileTTL))
+
+	mustSet(t, s, "persistent", "later", "soonest", "latest")
+	s.ExpireAfter("later", time.Hour)
+	s.ExpireAfter("soonest", time.Minute)
+	s.ExpireAfter("latest", 2*time.Hour)
+
+	// with fewer keys with a TTL than are sampled, the one closest to expiring is always picked
+	mustSet(t, s, "new")
+
+	if s.Has("soonest") {
+		t.Errorf("got keys %q, want soonest evicted", s.Keys())
+	}
+
+	s.Persist("later")
+	s.Persist("latest")
+
+	// keys without a TTL are never evicted
+	if err := s.Set("newer", "x"); !errors.Is(err, ErrOutOfMemory) {
+		t.Errorf("Set without a key with a TTL: got %v, want ErrOutOfMemory", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	s := newTestStore(t)
+
+	mustSet(t, s, "string")
+	s.ExpireAfter("string", time.Hour)
+	s.RPush("list", "a", "b")
+	s.HSet("hash", "f", "v")
+	s.SAdd("set", "m")
+	s.ZAdd("zset", ZMember{Member: "m", Score: 1.5})
+	mustSet(t, s, "expired")
+	s.ExpireAfter("expired", time.Millisecond)
+
+	path := t.TempDir() + "/dump.redig"
+
+	if err := s.SaveSnapshot(path); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	loaded := newTestStore(t)
+
+	if err := loaded.LoadSnapshot(path); err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+
+	if got, want := loaded.Keys(), []string{"hash", "list", "set", "string", "zset"}; !slices.Equal(sorted(got), want) {
+		t.Errorf("got keys %q, want %q", got, want)
+	}
+
+	if got, _ := loaded.LRange("list", 0, -1); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("got list %q", got)
+	}
+
+	if got, _, _ := loaded.HGet("hash", "f"); got != "v" {
+		t.Errorf("got hash field %q", got)
+	}
+
+	if got, _ := loaded.SIsMember("set", "m"); !got {
+		t.Errorf("the set member is missing")
+	}
+
+	if got, _, _ := loaded.ZScore("zset", "m"); got != 1.5 {
+		t.Errorf("got score %v", got)
+	}
+
+	// expiries are absolute, so the TTL carries on from when the snapshot was taken
+	want, _ := s.Expiry("string")
+
+	if got, _ := loaded.Expiry("string"); !got.Equal(want.Truncate(time.Millisecond)) {
+		t.Errorf("got expiry %v, want %v", got, want)
+	}
+}
+
+// sorted returns values sorted, for comparing lists in no particular order.
+func sorted(values []string) []string {
+	values = slices.Clone(values)
+	slices.Sort(values)
+
+	return values
+}
+
+func TestCheckpoint(t *testing.T) {
+	s := newTestStore(t)
+
+	s.RPush("list", "a")
+	mustSet(t, s, "string")
+
+	checkpoint := s.Checkpoint()
+
+	// writes made while the checkpoint is being written don't change it
+	var buf bytes.Buffer
+	written := make(chan error)
+
+	go func() {
+		written <- checkpoint.Write(&buf)
+	}()
+
+	for i := range 1000 {
+		s.RPush("list", strconv.Itoa(i))
+		s.Set("string", strconv.Itoa(i))
+		s.Set("new"+strconv.Itoa(i), "x")
+	}
+
+	if err := <-written; err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	loaded := newTestStore(t)
+
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if got := sorted(loaded.Keys()); !slices.Equal(got, []string{"list", "string"}) {
+		t.Errorf("got keys %q, want those at the checkpoint", got)
+	}
+
+	if got, _ := loaded.LRange("list", 0, -1); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("got list %q, want it as at the checkpoint", got)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matches []string
+		misses  []string
+	}{
+		{"h?llo", []string{"hello", "hallo", "hxllo"}, []string{"hllo", "heello"}},
+		{"h*llo", []string{"hllo", "hello", "heeeello"}, []string{"hell", "ahello"}},
+		{"h[ae]llo", []string{"hello", "hallo"}, []string{"hillo", "hllo"}},
+		{"h[^e]llo", []string{"hallo", "hbllo"}, []string{"hello"}},
+		{"h[a-b]llo", []string{"hallo", "hbllo"}, []string{"hcllo"}},
+		{`h\*llo`, []string{"h*llo"}, []string{"hello"}},
+		{"*", []string{"", "a/b/c", "user:1"}, nil},
+		{"user:*", []string{"user:", "user:1/2"}, []string{"users:1"}},
+	}
+
+	for _, test := range tests {
+		for _, s := range test.matches {
+			if !Match(test.pattern, s) {
+				t.Errorf("%q doesn't match %q", test.pattern, s)
+			}
+		}
+
+		for _, s := range test.misses {
+			if Match(test.pattern, s) {
+				t.Errorf("%q matches %q", test.pattern, s)
+			}
+		}
+	}
+}
+
+func TestContextCancelled(t *testing.T) {
+	s := newTestStore(t)
+	mustSet(t, s, "key")
+
+	// a context done before the call gives up straight away, even on a free lock
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := s.GetCtx(cancelled, "key"); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetCtx: got %v, want context.Canceled", err)
+	}
+
+	// a context done while waiting on a held lock gives up rather than blocking
+	locked, release := make(chan struct{}), make(chan struct{})
+	released := make(chan struct{})
+
+	go func() {
+		defer close(released)
+
+		s.Atomic(func(*Txn) {
+			close(locked)
+			<-release
+		})
+	}()
+
+	<-locked
+
+	calls := map[string]func(ctx context.Context) error{
+		"GetCtx": func(ctx context.Context) error {
+			_, _, err := s.GetCtx(ctx, "key")
+			return err
+		},
+		"SetCtx": func(ctx context.Context) error { return s.SetCtx(ctx, "key", "value") },
+		"DeleteCtx": func(ctx context.Context) error {
+			_, err := s.DeleteCtx(ctx, "key")
+			return err
+		},
+	}
+
+	for name, call := range calls {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := call(ctx)
+		cancel()
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: got %v while the lock was held, want context.DeadlineExceeded", name, err)
+		}
+	}
+
+	close(release)
+	<-released
+
+	// nothing was changed by the calls that gave up
+	if got, _, _ := s.GetCtx(context.Background(), "key"); got != "key" {
+		t.Errorf("got %q, want the value left as it was", got)
+	}
+}
+
+// removal is a call to a hook.
+type removal struct {
+	hook  string
+	key   string
+	value any
+}
+
+func TestHooks(t *testing.T) {
+	s := newTestStore(t, WithMaxKeys(2), WithEvictionPolicy(AllKeysLRU), WithGCInterval(time.Millisecond))
+	removals := make(chan removal, 10)
+
+	s.SetOnExpire(func(key string, value any) {
+		// hooks are called without a lock held, so they may use the store
+		if s.Has(key) {
+			t.Errorf("%s is still in the store as its OnExpire hook is called", key)
+		}
+
+		removals <- removal{"expire", key, value}
+	})
+
+	s.SetOnEvict(func(key string, value any) { removals <- removal{"evict", key, value} })
+
+	next := func() removal {
+		select {
+		case r := <-removals:
+			return r
+		case <-time.After(time.Second):
+			t.Fatalf("no hook was called")
+			return removal{}
+		}
+	}
+
+	// eviction, of the least recently used key
+	mustSet(t, s, "a", "b", "c")
+
+	if got := next(); got != (removal{"evict", "a", "a"}) {
+		t.Errorf("got %+v, want a evicted", got)
+	}
+
+	// expiry, collected by the GC
+	s.ExpireAfter("b", time.Millisecond)
+
+	if got := next(); got != (removal{"expire", "b", "b"}) {
+		t.Errorf("got %+v, want b expired by the GC", got)
+	}
+
+	// expiry, collected lazily as the key is accessed, the GC being too slow to get there first
+	if err := s.SetGCInterval(time.Hour); err != nil {
+		t.Fatalf("SetGCInterval: %v", err)
+	}
+
+	s.LPush("list", "x")
+	s.ExpireAfter("list", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if popped, err := s.LPop("list", 1); err != nil || len(popped) > 0 {
+		t.Fatalf("LPop: got %q, %v from an expired list", popped, err)
+	}
+
+	if got := next(); got.hook != "expire" || got.key != "list" || !slices.Equal(got.value.([]string), []string{"x"}) {
+		t.Errorf("got %+v, want list expired as it was accessed", got)
+	}
+
+	// a key deleted by a client isn't removed by the store itself, and unset hooks aren't called
+	s.SetOnExpire(nil)
+	mustSet(t, s, "d")
+	s.Delete("c")
+	s.ExpireAfter("d", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	s.Get("d")
+
+	select {
+	case r := <-removals:
+		t.Errorf("got %+v, want no more hook calls", r)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestHookCallsDroppedOnceTooManyPending(t *testing.T) {
+	s := newTestStore(t, WithGCInterval(0))
+	s.hooks.limit = 10
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls atomic.Int64
+
+	s.SetOnExpire(func(key string, value any) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+	})
+
+	keys := make([]string, 30)
+
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+
+	mustSet(t, s, keys...)
+
+	for _, key := range keys {
+		s.ExpireAfter(key, time.Millisecond)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	// the first call holds up the rest, and only limit of them can wait behind it
+	s.Get(keys[0])
+	<-started
+
+	for _, key := range keys[1:] {
+		if _, ok, _ := s.Get(key); ok {
+			t.Fatalf("%s didn't expire", key)
+		}
+	}
+
+	close(release)
+
+	time.Sleep(20 * time.Millisecond)
+
+	if got := calls.Load(); got != 11 {
+		t.Errorf("got %d calls, want the first and the 10 pending ones", got)
+	}
+}
+
+func TestAddOverflow(t *testing.T) {
+	s := newTestStore(t)
+
+	tests := []struct {
+		value string
+		x     int64
+	}{
+		{strconv.FormatInt(math.MaxInt64, 10), 1},
+		{strconv.FormatInt(math.MaxInt64-5, 10), 10},
+		{strconv.FormatInt(math.MinInt64, 10), -1},
+		{"-1", math.MinInt64},
+		{"1", math.MaxInt64},
+	}
+
+	for _, test := range tests {
+		s.Set("key", test.value)
+
+		if got, err := s.Add("key", test.x); !errors.Is(err, ErrOverflow) {
+			t.Errorf("%s + %d: got %d, %v, want ErrOverflow", test.value, test.x, got, err)
+		}
+
+		if got, _, _ := s.Get("key"); got != test.value {
+			t.Errorf("%s + %d: the value changed to %s", test.value, test.x, got)
+		}
+	}
+
+	// the bounds themselves can be reached
+	s.Set("key", strconv.FormatInt(math.MaxInt64-1, 10))
+
+	if got, err := s.Add("key", 1); got != math.MaxInt64 || err != nil {
+		t.Errorf("got %d, %v, want MaxInt64", got, err)
+	}
+
+	// a value too large for an int64 isn't an integer at all
+	s.Set("key", "9223372036854775808")
+
+	if _, err := s.Add("key", -1); !errors.Is(err, ErrNotInteger) {
+		t.Errorf("got %v adding to a value past MaxInt64, want ErrNotInteger", err)
+	}
+}
+
+func TestAddNonCanonical(t *testing.T) {
+	s := newTestStore(t)
+
+	for _, value := range []string{"007", " 5", "5 ", "+5", "-0", "", "5.0", "0x5"} {
+		s.Set("key", value)
+
+		if got, err := s.Add("key", 1); !errors.Is(err, ErrNotInteger) {
+			t.Errorf("%q: got %d, %v, want ErrNotInteger", value, got, err)
+		}
+	}
+
+	for _, value := range []string{"0", "5", "-5", "10"} {
+		s.Set("key", value)
+		want, _ := strconv.ParseInt(value, 10, 64)
+
+		if got, err := s.Add("key", 1); got != want+1 || err != nil {
+			t.Errorf("%q: got %d, %v, want %d", value, got, err, want+1)
+		}
+	}
+}
+
+func TestEvictLFU(t *testing.T) {
+	s := newTestStore(t, WithMaxKeys(2), WithEvictionPolicy(AllKeysLFU))
+
+	mustSet(t, s, "hot", "cold")
+
+	// the counter only goes up now and then past its initial value, so it takes many reads to be sure it does
+	for range 1000 {
+		s.Get("hot")
+	}
+
+	hot, _ := s.ObjectFreq("hot")
+	cold, _ := s.ObjectFreq("cold")
+
+	if hot <= cold {
+		t.Fatalf("got a frequency of %d for the key read and %d for the other, want it higher", hot, cold)
+	}
+
+	// cold was used less recently, but also less often
+	s.Get("cold")
+	mustSet(t, s, "new")
+
+	if !s.Has("hot") || s.Has("cold") {
+		t.Errorf("got keys %q, want cold evicted rather than hot", s.Keys())
+	}
+}
+
+func TestTypeCounts(t *testing.T) {
+	s := newTestStore(t, WithGCInterval(0))
+
+	mustSet(t, s, "a", "b", "c")
+	s.LPush("list", "x")
+	s.HSet("hash", "field", "value")
+	s.HSet("hash2", "field", "value")
+	s.SAdd("set", "member")
+	s.ZAdd("zset", ZMember{Member: "member", Score: 1})
+
+	s.ExpireAfter("a", time.Hour)
+	s.ExpireAfter("hash", time.Hour)
+
+	// an expired key is no longer counted, even before it's collected
+	s.ExpireAfter("b", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	want := map[string]int{"string": 2, "list": 1, "hash": 2, "set": 1, "zset": 1, "expires": 2}
+
+	if got := s.TypeCounts(); !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTTLJitter(t *testing.T) {
+	const base, jitter = time.Hour, time.Minute
+
+	s := newTestStore(t, WithTTLJitter(jitter))
+	expiries := make(map[time.Time]bool)
+
+	before := time.Now()
+
+	for i := range 100 {
+		key := strconv.Itoa(i)
+
+		// both a jittered SET and a TTL set while the store adds jitter of its own
+		if i%2 == 0 {
+			s.SetWithTTLJitter(key, "value", base, jitter)
+		} else {
+			mustSet(t, s, key)
+			s.ExpireAfter(key, base)
+		}
+
+		expiry, _ := s.Expiry(key)
+		expiries[expiry] = true
+	}
+
+	after := time.Now()
+
+	for expiry := range expiries {
+		if expiry.Before(before.Add(base)) || !expiry.Before(after.Add(base+jitter)) {
+			t.Errorf("got an expiry %v past the base TTL, want less than %v", expiry.Sub(before), base+jitter)
+		}
+	}
+
+	// the odds of two keys picking the same nanosecond are negligible
+	if len(expiries) != 100 {
+		t.Errorf("got %d distinct expiries for 100 keys", len(expiries))
+	}
+
+	s.SetTTLJitter(0)
+	mustSet(t, s, "exact")
+	s.ExpireAfter("exact", base)
+
+	if expiry, _ := s.Expiry("exact"); expiry.Before(after.Add(base)) || expiry.After(time.Now().Add(base)) {
+		t.Errorf("got an expiry %v from now without jitter, want %v", time.Until(expiry), base)
+	}
+}
+
+func TestAtomicTransfer(t *testing.T) {
+	const workers, transfers, total = 8, 200, 10_000
+
+	s := newTestStore(t)
+	s.Set("from", strconv.Itoa(total))
+	s.Set("to", "0")
+
+	// balance reads a key as a number from within Atomic
+	balance := func(tx *Txn, key string) int {
+		value, _, _ := tx.Get(key)
+		n, _ := strconv.Atoi(value)
+
+		return n
+	}
+
+	var wg sync.WaitGroup
+	var torn atomic.Int32
+
+	for range workers {
+		wg.Add(2)
+
+		// a read, then writes depending on it, to two keys which nobody sees halfway
+		go func() {
+			defer wg.Done()
+
+			for range transfers {
+				s.Atomic(func(tx *Txn) {
+					if from := balance(tx, "from"); from > 0 {
+						tx.Set("from", strconv.Itoa(from-1))
+						tx.Set("to", strconv.Itoa(balance(tx, "to")+1))
+					}
+				})
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			for range transfers {
+				s.Atomic(func(tx *Txn) {
+					if balance(tx, "from")+balance(tx, "to") != total {
+						torn.Add(1)
+					}
+				})
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if n := torn.Load(); n > 0 {
+		t.Errorf("the keys were seen halfway through a transfer %d times", n)
+	}
+
+	from, _, _ := s.Get("from")
+	to, _, _ := s.Get("to")
+
+	if want := strconv.Itoa(total - workers*transfers); from != want || to != strconv.Itoa(workers*transfers) {
+		t.Errorf("got from = %s and to = %s, want %s and %d: transfers were lost", from, to, want, workers*transfers)
+	}
+}
+
+func TestRange(t *testing.T) {
+	s := newTestStore(t, WithGCInterval(0))
+
+	mustSet(t, s, "a", "b", "c", "expired")
+	s.LPush("list", "x")
+	s.ExpireAfter("expired", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	// expired keys are skipped even though the GC left them there, other kinds come with no value
+	seen := make(map[string]string)
+
+	s.Range(func(key, value string) bool {
+		seen[key] = value
+		return true
+	})
+
+	if want := map[string]string{"a": "a", "b": "b", "c": "c", "list": ""}; !maps.Equal(seen, want) {
+		t.Errorf("got %v, want %v", seen, want)
+	}
+
+	// returning false stops the iteration there
+	calls := 0
+
+	s.Range(func(key, value string) bool {
+		calls++
+		return calls < 2
+	})
+
+	if calls != 2 {
+		t.Errorf("got %d calls, want Range to stop after the second", calls)
+	}
+}
@@ -0,0 +1,124 @@
+package store
+
+import "time"
+
+// Txn gives access to the store from within a function run by Atomic, which holds every lock of the store.
+// It's only valid until the function returns.
+type Txn struct {
+	s *KVStore
+}
+
+// Atomic runs fn while holding the lock of every shard, so the operations it makes through tx
+// happen at once: no other client sees the store between them, nor changes it.
+//
+// Only the methods of tx may be used from within fn. The methods of the store take the locks
+// Atomic already holds, and would deadlock. fn should be quick, as it holds up every other client.
+//
+// Atomic is for programs using the store as a library, and must not be used on a store served by
+// package cmd: its writes go straight to the store, which the server knows nothing of, so they're
+// neither appended to the AOF nor sent to replicas, and no keyspace event is published for them.
+func (s *KVStore) Atomic(fn func(tx *Txn)) {
+	unlock := s.lockAll()
+	defer unlock()
+
+	fn(&Txn{s: s})
+}
+
+// Get is KVStore.Get from within Atomic.
+func (tx *Txn) Get(key string) (string, bool, error) {
+	sh := tx.s.shardFor(key)
+	sh.expireIfNeeded(key)
+
+	v, exists := sh.lookup(key)
+
+	if !exists {
+		return "", false, nil
+	}
+
+	if v.kind != StringKind {
+		return "", false, ErrWrongType
+	}
+
+	sh.lru.touch(v)
+
+	return v.str, true, nil
+}
+
+// Set is KVStore.Set from within Atomic. As no key can be evicted while Atomic holds the locks,
+// setting a new key in a store holding MaxKeys keys returns ErrOutOfMemory, whatever the eviction policy.
+func (tx *Txn) Set(key string, value string) error {
+	sh := tx.s.shardFor(key)
+	sh.expireIfNeeded(key)
+
+	if err := tx.reserve(sh, key); err != nil {
+		return err
+	}
+
+	sh.remove(key)
+	sh.put(key, newStringValue(value))
+	sh.touch(key)
+
+	return nil
+}
+
+// Delete is KVStore.Delete from within Atomic.
+func (tx *Txn) Delete(key string) bool {
+	sh := tx.s.shardFor(key)
+
+	if sh.expireIfNeeded(key) || !sh.remove(key) {
+		return false
+	}
+
+	sh.touch(key)
+	return true
+}
+
+// Has is KVStore.Has from within Atomic.
+func (tx *Txn) Has(key string) bool {
+	_, exists := tx.s.shardFor(key).lookup(key)
+	return exists
+}
+
+// Add is KVStore.Add from within Atomic, which like Set can't make room for a new key.
+func (tx *Txn) Add(key string, x int64) (int64, error) {
+	sh := tx.s.shardFor(key)
+	sh.expireIfNeeded(key)
+
+	if err := tx.reserve(sh, key); err != nil {
+		return 0, err
+	}
+
+	return sh.add(key, x)
+}
+
+// reserve checks there's room for key, in sh, before it's written. keys can't be evicted
+// from within Atomic, so there's only room in a full store if the key exists already.
+func (tx *Txn) reserve(sh *shard, key string) error {
+	if _, exists := sh.store[key]; exists {
+		return nil
+	}
+
+	if maxKeys := tx.s.MaxKeys(); maxKeys > 0 && tx.s.KeyCount() >= maxKeys {
+		return ErrOutOfMemory
+	}
+
+	return nil
+}
+
+// ExpireAfter is KVStore.ExpireAfter from within Atomic.
+func (tx *Txn) ExpireAfter(key string, ttl time.Duration) bool {
+	sh := tx.s.shardFor(key)
+
+	if sh.expireIfNeeded(key) {
+		return false
+	}
+
+	if _, exists := sh.store[key]; !exists {
+		return false
+	}
+
+	tx.s.setExpiry(key, time.Now().Add(tx.s.JitteredTTL(ttl)))
+	sh.touch(key)
+
+	return true
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Kind identifies the type of data held by a key.
+type Kind int
+
+const (
+	StringKind Kind = iota
+	ListKind
+	HashKind
+	SetKind
+	ZSetKind
+)
+
+// String returns the name Redis uses for the kind, as reported by TYPE.
+func (k Kind) String() string {
+	switch k {
+	case StringKind:
+		return "string"
+	case ListKind:
+		return "list"
+	case HashKind:
+		return "hash"
+	case SetKind:
+		return "set"
+	case ZSetKind:
+		return "zset"
+	}
+
+	return "none"
+}
+
+var (
+	// ErrWrongType is returned when an operation is run against a key holding a different kind of value.
+	ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+	// ErrNotInteger is returned when a numeric operation is run against a value that isn't an integer.
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+
+	// ErrOverflow is returned when incrementing an integer would take it past the range of an int64.
+	ErrOverflow = errors.New("increment or decrement would overflow")
+
+	// ErrScoreNaN is returned when incrementing a sorted set score would produce NaN.
+	ErrScoreNaN = errors.New("resulting score is not a number (NaN)")
+
+	// ErrNoSuchKey is returned when an operation requires a key that doesn't exist.
+	ErrNoSuchKey = errors.New("no such key")
+
+	// ErrIndexOutOfRange is returned when a list index falls outside the list.
+	ErrIndexOutOfRange = errors.New("index out of range")
+
+	// ErrBusyKey is returned by Restore for a key which exists already, unless asked to replace it.
+	ErrBusyKey = errors.New("BUSYKEY Target key name already exists.")
+
+	// ErrBadDump is returned by Restore for data which wasn't made by Dump, or was corrupted since.
+	ErrBadDump = errors.New("DUMP payload version or checksum are wrong")
+
+	// ErrOutOfMemory is returned when a write would add a key to a full store which can't evict any.
+	ErrOutOfMemory = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+	// ErrGCDisabled is returned when trying to turn the background GC on or off while the store is running.
+	ErrGCDisabled = errors.New("the background GC can't be turned on or off while the store is running")
+)
+
+// value is a single entry in the store, tagged with the kind of data it holds.
+// only the payload field matching kind is meaningful.
+type value struct {
+	kind Kind
+	str  string
+	list deque
+	hash map[string]string
+	set  map[string]struct{}
+	zset *sortedSet
+
+	// links the value into the LRU list of its shard
+	key        string
+	prev, next *value
+	accessed   uint64
+	accessedAt time.Time
+
+	// how often the value is accessed, for LFU eviction, guarded like the LRU links
+	freq uint8
+}
+
+func newStringValue(s string) *value {
+	return &value{kind: StringKind, str: s}
+}
+
+func newListValue() *value {
+	return &value{kind: ListKind}
+}
+
+func newHashValue() *value {
+	return &value{kind: HashKind, hash: make(map[string]string)}
+}
+
+func newSetValue() *value {
+	return &value{kind: SetKind, set: make(map[string]struct{})}
+}
+
+func newZSetValue() *value {
+	return &value{kind: ZSetKind, zset: newSortedSet()}
+}
+
+// clone returns a deep copy of the value, which doesn't change along with it.
+// the copy isn't linked into any LRU list.
+func (v *value) clone() *value {
+	c := &value{kind: v.kind, str: v.str}
+
+	switch v.kind {
+	case ListKind:
+		c.list.set(slices.Clone(v.list.elements()))
+	case HashKind:
+		c.hash = maps.Clone(v.hash)
+	case SetKind:
+		c.set = maps.Clone(v.set)
+	case ZSetKind:
+		c.zset = &sortedSet{
+			scores: maps.Clone(v.zset.scores),
+			sorted: slices.Clone(v.zset.sorted),
+		}
+	}
+
+	return c
+}
+
+// len returns the number of elements in a collection value.
+func (v *value) len() int {
+	switch v.kind {
+	case ListKind:
+		return v.list.len()
+	case HashKind:
+		return len(v.hash)
+	case SetKind:
+		return len(v.set)
+	case ZSetKind:
+		return len(v.zset.scores)
+	}
+
+	return 0
+}
+
+// getOfKind returns the value stored at key if it holds the given kind.
+// It returns ErrWrongType for a value of another kind.
+// the caller must hold the full lock.
+func (sh *shard) getOfKind(key string, kind Kind) (*value, bool, error) {
+	sh.expireIfNeeded(key)
+
+	v, exists := sh.store[key]
+
+	if !exists {
+		return nil, false, nil
+	}
+
+	if v.kind != kind {
+		return nil, false, ErrWrongType
+	}
+
+	sh.lru.touch(v)
+
+	return v, true, nil
+}
+
+// lookupOfKind is the read-only counterpart of getOfKind.
+// the caller must hold at least the read lock.
+func (sh *shard) lookupOfKind(key string, kind Kind) (*value, bool, error) {
+	v, exists := sh.lookup(key)
+
+	if !exists {
+		return nil, false, nil
+	}
+
+	if v.kind != kind {
+		return nil, false, ErrWrongType
+	}
+
+	sh.lru.touch(v)
+
+	return v, true, nil
+}
+
+// deleteIfEmpty deletes a collection once its last element has been removed,
+// as Redis never keeps empty collections around.
+// the caller must hold the full lock.
+func (sh *shard) deleteIfEmpty(key string, v *value) {
+	if v.len() == 0 {
+		sh.remove(key)
+	}
+}
@@ -0,0 +1,74 @@
+package store
+
+// watchedKey tracks the clients watching a key and a version bumped every time it changes.
+type watchedKey struct {
+	watchers int
+	version  uint64
+}
+
+// touch records a change to key, for WATCH to notice.
+// the caller must hold the full lock.
+func (sh *shard) touch(key string) {
+	if w, watched := sh.watched[key]; watched {
+		w.version++
+	}
+}
+
+// Watch starts tracking changes to key and returns its current version.
+// Every call must be paired with a call to Unwatch.
+func (s *KVStore) Watch(key string) uint64 {
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	// an expired key is gone as far as the watcher is concerned,
+	// so it must not count as a change once it's collected
+	sh.expireIfNeeded(key)
+
+	w, watched := sh.watched[key]
+
+	if !watched {
+		w = &watchedKey{}
+		sh.watched[key] = w
+	}
+
+	w.watchers++
+
+	return w.version
+}
+
+// Unwatch stops tracking changes to key for one watcher.
+func (s *KVStore) Unwatch(key string) {
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	w, watched := sh.watched[key]
+
+	if !watched {
+		return
+	}
+
+	w.watchers--
+
+	// versions are only kept while someone is watching
+	if w.watchers == 0 {
+		delete(sh.watched, key)
+	}
+}
+
+// Version returns the current version of a watched key, to compare with the one returned by Watch.
+func (s *KVStore) Version(key string) uint64 {
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	// a key expiring while watched counts as a change
+	sh.expireIfNeeded(key)
+
+	if w, watched := sh.watched[key]; watched {
+		return w.version
+	}
+
+	return 0
+}
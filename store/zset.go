@@ -0,0 +1,401 @@
+package store
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// ZMember is a member of a sorted set along with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// compareZMembers orders members by score, ties broken by lexical member order like Redis.
+func compareZMembers(a, b ZMember) int {
+	if c := cmp.Compare(a.Score, b.Score); c != 0 {
+		return c
+	}
+
+	return cmp.Compare(a.Member, b.Member)
+}
+
+// sortedSet keeps members both indexed by name for score lookups
+// and ordered by score for range queries.
+type sortedSet struct {
+	scores map[string]float64
+	sorted []ZMember
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{scores: make(map[string]float64)}
+}
+
+// add inserts member or updates its score. It returns true if member is new.
+func (z *sortedSet) add(member string, score float64) bool {
+	oldScore, exists := z.scores[member]
+
+	if exists {
+		if oldScore == score {
+			return false
+		}
+
+		z.remove(member)
+	}
+
+	z.scores[member] = score
+
+	entry := ZMember{Member: member, Score: score}
+	i, _ := slices.BinarySearchFunc(z.sorted, entry, compareZMembers)
+	z.sorted = slices.Insert(z.sorted, i, entry)
+
+	return !exists
+}
+
+// remove deletes member. It returns false if member isn't in the set.
+func (z *sortedSet) remove(member string) bool {
+	score, exists := z.scores[member]
+
+	if !exists {
+		return false
+	}
+
+	delete(z.scores, member)
+
+	i, found := slices.BinarySearchFunc(z.sorted, ZMember{Member: member, Score: score}, compareZMembers)
+	if found {
+		z.sorted = slices.Delete(z.sorted, i, i+1)
+	}
+
+	return true
+}
+
+// ZAdd adds members to a sorted set or updates the score of existing ones, creating it if missing.
+// It returns the number of members that were newly added.
+func (s *KVStore) ZAdd(key string, members ...ZMember) (int, error) {
+	if err := s.reserve(key); err != nil {
+		return 0, err
+	}
+
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, ZSetKind)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		v = newZSetValue()
+		sh.put(key, v)
+	}
+
+	added := 0
+
+	for _, member := range members {
+		if v.zset.add(member.Member, member.Score) {
+			added++
+		}
+	}
+
+	sh.touch(key)
+
+	return added, nil
+}
+
+// ZScore returns the score of member in a sorted set.
+// The boolean is false if either the key or the member doesn't exist.
+func (s *KVStore) ZScore(key string, member string) (float64, bool, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, ZSetKind)
+
+	if err != nil || !exists {
+		return 0, false, err
+	}
+
+	score, exists := v.zset.scores[member]
+	return score, exists, nil
+}
+
+// ZCard returns the number of members in a sorted set, 0 if the key doesn't exist.
+func (s *KVStore) ZCard(key string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, ZSetKind)
+
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	return len(v.zset.scores), nil
+}
+
+// ZRem removes members from a sorted set and returns how many were in it.
+// The key is deleted once its last member is removed.
+func (s *KVStore) ZRem(key string, members ...string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, ZSetKind)
+
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	removed := 0
+
+	for _, member := range members {
+		if v.zset.remove(member) {
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		sh.touch(key)
+	}
+
+	sh.deleteIfEmpty(key, v)
+
+	return removed, nil
+}
+
+// ZRange returns the members of a sorted set between the start and stop ranks, both inclusive,
+// in ascending score order or descending if rev is set. Ranks may be negative to count from
+// the end and are clamped to the set, a missing key is an empty set.
+func (s *KVStore) ZRange(key string, start, stop int, rev bool) ([]ZMember, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, ZSetKind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return []ZMember{}, nil
+	}
+
+	from, to, ok := normalizeRange(start, stop, len(v.zset.sorted))
+
+	if !ok {
+		return []ZMember{}, nil
+	}
+
+	members := make([]ZMember, 0, to-from)
+
+	for i := from; i < to; i++ {
+		if rev {
+			members = append(members, v.zset.sorted[len(v.zset.sorted)-1-i])
+		} else {
+			members = append(members, v.zset.sorted[i])
+		}
+	}
+
+	return members, nil
+}
+
+// ScoreBound is one end of a score range, which may exclude the bound itself.
+type ScoreBound struct {
+	Score     float64
+	Exclusive bool
+}
+
+// aboveMin reports whether score lies above the lower bound b.
+func (b ScoreBound) aboveMin(score float64) bool {
+	if b.Exclusive {
+		return score > b.Score
+	}
+
+	return score >= b.Score
+}
+
+// belowMax reports whether score lies below the upper bound b.
+func (b ScoreBound) belowMax(score float64) bool {
+	if b.Exclusive {
+		return score < b.Score
+	}
+
+	return score <= b.Score
+}
+
+// membersByScore returns the members with a score between min and max in ascending order.
+func (z *sortedSet) membersByScore(min, max ScoreBound) []ZMember {
+	// members are sorted by score, so the range starts at the first member above min
+	from, _ := slices.BinarySearchFunc(z.sorted, min, func(member ZMember, bound ScoreBound) int {
+		if bound.aboveMin(member.Score) {
+			return 1
+		}
+
+		return -1
+	})
+
+	to := from
+	for to < len(z.sorted) && max.belowMax(z.sorted[to].Score) {
+		to++
+	}
+
+	return z.sorted[from:to]
+}
+
+// ZRangeByScore returns the members of a sorted set with a score between min and max,
+// in ascending score order. offset and count select a window of the result,
+// a negative count returning every member past offset.
+func (s *KVStore) ZRangeByScore(key string, min, max ScoreBound, offset, count int) ([]ZMember, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, ZSetKind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return []ZMember{}, nil
+	}
+
+	inRange := v.zset.membersByScore(min, max)
+
+	if offset < 0 || offset >= len(inRange) {
+		return []ZMember{}, nil
+	}
+
+	inRange = inRange[offset:]
+
+	if count >= 0 && count < len(inRange) {
+		inRange = inRange[:count]
+	}
+
+	return slices.Clone(inRange), nil
+}
+
+// ZCount returns the number of members of a sorted set with a score between min and max.
+func (s *KVStore) ZCount(key string, min, max ScoreBound) (int, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, ZSetKind)
+
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	return len(v.zset.membersByScore(min, max)), nil
+}
+
+// ZIncrBy adds delta to the score of member in a sorted set and returns the new score.
+// A missing member is added with delta as its score, creating the set if missing.
+func (s *KVStore) ZIncrBy(key string, delta float64, member string) (float64, error) {
+	if err := s.reserve(key); err != nil {
+		return 0, err
+	}
+
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, ZSetKind)
+
+	if err != nil {
+		return 0, err
+	}
+
+	score := delta
+
+	if exists {
+		score += v.zset.scores[member]
+	}
+
+	// inf + -inf is the only way to get there
+	if math.IsNaN(score) {
+		return 0, ErrScoreNaN
+	}
+
+	if !exists {
+		v = newZSetValue()
+		sh.put(key, v)
+	}
+
+	v.zset.add(member, score)
+	sh.touch(key)
+
+	return score, nil
+}
+
+// ZRank returns the 0-based rank of member in a sorted set, ordered by ascending score.
+// The boolean is false if either the key or the member doesn't exist.
+func (s *KVStore) ZRank(key string, member string) (int, bool, error) {
+	sh := s.shardFor(key)
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	v, exists, err := sh.lookupOfKind(key, ZSetKind)
+
+	if err != nil || !exists {
+		return 0, false, err
+	}
+
+	score, exists := v.zset.scores[member]
+
+	if !exists {
+		return 0, false, nil
+	}
+
+	rank, _ := slices.BinarySearchFunc(v.zset.sorted, ZMember{Member: member, Score: score}, compareZMembers)
+
+	return rank, true, nil
+}
+
+// ZPop removes and returns up to count members with the lowest scores from a sorted set,
+// or the highest scores if max is set. The key is deleted once its last member is popped.
+func (s *KVStore) ZPop(key string, count int, max bool) ([]ZMember, error) {
+	sh := s.shardFor(key)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	v, exists, err := sh.getOfKind(key, ZSetKind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return []ZMember{}, nil
+	}
+
+	count = min(count, len(v.zset.sorted))
+	popped := make([]ZMember, count)
+
+	for i := range count {
+		if max {
+			popped[i] = v.zset.sorted[len(v.zset.sorted)-1-i]
+		} else {
+			popped[i] = v.zset.sorted[i]
+		}
+	}
+
+	for _, member := range popped {
+		v.zset.remove(member.Member)
+	}
+
+	if count > 0 {
+		sh.touch(key)
+	}
+
+	sh.deleteIfEmpty(key, v)
+
+	return popped, nil
+}